@@ -2,12 +2,15 @@ module github.com/nandasatria/sample-metric-generator
 
 go 1.22.3
 
+require (
+	github.com/elastic/go-elasticsearch/v8 v8.17.0
+	github.com/joho/godotenv v1.5.1
+)
+
 require (
 	github.com/elastic/elastic-transport-go/v8 v8.6.0 // indirect
-	github.com/elastic/go-elasticsearch/v8 v8.17.0 // indirect
 	github.com/go-logr/logr v1.4.2 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/oschwald/geoip2-golang v1.11.0 // indirect
 	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
 	go.opentelemetry.io/otel v1.28.0 // indirect
@@ -1,253 +1,230 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
-	"log"
-	"math"
-	"math/rand"
+	"flag"
+	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
-	"sync"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/elastic/go-elasticsearch/v8/esapi"
-	"github.com/joho/godotenv"
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/generate"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
 )
 
-type ServerConfig struct {
-	ID        string
-	Hostname  string
-	IPAddress string
-	Location  struct {
-		Country   string
-		City      string
-		Latitude  float64
-		Longitude float64
-	}
-}
-
-type MetricData struct {
-	Timestamp   time.Time `json:"@timestamp"`
-	ServerID    string    `json:"server_id"`
-	Hostname    string    `json:"hostname"`
-	IPAddress   string    `json:"ip_address"`
-	Country     string    `json:"country"`
-	City        string    `json:"city"`
-	Latitude    float64   `json:"latitude"`
-	Longitude   float64   `json:"longitude"`
-	CPUUsage    float64   `json:"cpu_usage"`
-	MemoryUsage float64   `json:"memory_usage"`
-	DiskUsage   float64   `json:"disk_usage"`
-}
+// version is the generator's version, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
 
-type MetricGenerator struct {
-	servers       []ServerConfig
-	esClient      *elasticsearch.Client
-	metricTracker map[string]MetricData
-	esIndex       string
-	rnd           *rand.Rand // Add a local random number generator
-	mu            sync.Mutex
-}
+func main() {
+	// Load configuration
+	config := config.Load()
 
-func loadConfiguration() (int, string, string, string, string) {
-	// Load .env file
-	err := godotenv.Load()
+	// Configure structured logging
+	logger, err := newLogger(os.Stderr, config.LogLevel, config.LogFormat)
 	if err != nil {
-		log.Println("Warning: No .env file found")
+		fatal("Error configuring logging", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	// Flags without a command belong to run, e.g. "main --dry-run"
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		runGenerator(config, args)
+	case "alias":
+		err = sink.AliasCommand(config, args)
+	case "snapshot":
+		err = sink.SnapshotCommand(config, args)
+	case "export":
+		err = sink.ExportCommand(config, args)
+	case "bench-formats":
+		err = generate.BenchFormatsCommand(config, args)
+	case "replay-dlq":
+		err = sink.ReplayDLQCommand(config, args)
+	case "reap":
+		err = sink.ReapCommand(config, args)
+	case "fleet":
+		err = fleet.Command(config, args)
+	case "truth":
+		err = generate.TruthCommand(config, args)
+	case "replay":
+		err = generate.ReplayCommand(config, args)
+	case "spike":
+		err = generate.SpikeCommand(config, args)
+	default:
+		fatal("Unknown command (want run, alias, snapshot, export, bench-formats, replay-dlq, reap, fleet, truth, replay or spike)", "command", command)
 	}
-
-	// Get environment variables
-	serverCount, _ := strconv.Atoi(os.Getenv("SERVER_COUNT"))
-	if serverCount == 0 {
-		serverCount = 100
-	}
-
-	esServer := os.Getenv("ES_SERVER")
-	if esServer == "" {
-		esServer = "http://localhost:9200"
-	}
-
-	esUsername := os.Getenv("ES_USERNAME")
-	esPassword := os.Getenv("ES_PASSWORD")
-	esIndex := os.Getenv("ES_INDEX")
-	if esIndex == "" {
-		esIndex = "server-metrics"
+	if err != nil {
+		fatal("Command failed", "command", command, "error", err)
 	}
-
-	return serverCount, esServer, esUsername, esPassword, esIndex
 }
 
-func generateRandomServers(count int, rnd *rand.Rand) []ServerConfig {
-	locations := []struct {
-		Country   string
-		City      string
-		Latitude  float64
-		Longitude float64
-	}{
-		{"United States", "New York", 40.7128, -74.0060},
-		{"United States", "Los Angeles", 34.0522, -118.2437},
-		{"United Kingdom", "London", 51.5074, -0.1278},
-		{"Germany", "Berlin", 52.5200, 13.4050},
-		{"Japan", "Tokyo", 35.6762, 139.6503},
-	}
-
-	servers := make([]ServerConfig, count)
-	for i := 0; i < count; i++ {
-		loc := locations[rnd.Intn(len(locations))]
-
-		servers[i] = ServerConfig{
-			ID: fmt.Sprintf("server-%03d", i+1),
-			Hostname: fmt.Sprintf("%s-host-%03d",
-				[]string{"web", "db", "app", "cache", "worker"}[rnd.Intn(5)],
-				i+1),
-			IPAddress: fmt.Sprintf("10.%d.%d.%d",
-				rnd.Intn(256),
-				rnd.Intn(256),
-				rnd.Intn(256)),
-			Location: struct {
-				Country   string
-				City      string
-				Latitude  float64
-				Longitude float64
-			}{
-				Country:   loc.Country,
-				City:      loc.City,
-				Latitude:  loc.Latitude + (rnd.Float64()*0.5 - 0.25),
-				Longitude: loc.Longitude + (rnd.Float64()*0.5 - 0.25),
-			},
+func runGenerator(config config.Config, args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	dryRunFlag := fs.Bool("dry-run", false, "generate documents without contacting any backend and print a summary")
+	ticks := fs.Int("ticks", 1, "number of ticks to generate with -dry-run")
+	printDocs := fs.Bool("print", false, "print each document as NDJSON to stdout with -dry-run")
+	startAt := fs.String("start", "", "simulate time from this RFC 3339 timestamp with -dry-run, advancing one interval per tick")
+	duration := fs.Duration("duration", 0, "stop after this long (0 runs until interrupted)")
+	maxDocs := fs.Int("max-docs", 0, "stop after generating this many documents (0 means no limit)")
+	benchmark := fs.Bool("benchmark", false, "generate and send as fast as the sinks accept for -duration (default 1m), then print a throughput report")
+	chaos := fs.Bool("chaos", config.Chaos, "schedule random incidents, on average every CHAOS_MEAN_INTERVAL")
+	fs.Parse(args)
+	config.Chaos = *chaos
+
+	if *benchmark {
+		if *dryRunFlag {
+			fatal("-benchmark measures delivery and can't be combined with -dry-run")
+		}
+		if *duration == 0 && *maxDocs == 0 {
+			*duration = time.Minute
 		}
 	}
 
-	return servers
-}
-
-func (mg *MetricGenerator) generateConsistentServerMetric(server ServerConfig) MetricData {
-	mg.mu.Lock()
-	defer mg.mu.Unlock()
-
-	prevMetric, exists := mg.metricTracker[server.ID]
-
-	var cpuUsage, memoryUsage, diskUsage float64
-
-	if exists {
-		cpuBase := prevMetric.CPUUsage
-		memBase := prevMetric.MemoryUsage
-		diskBase := prevMetric.DiskUsage
-
-		cpuUsage = math.Max(0, math.Min(100,
-			cpuBase+(mg.rnd.Float64()*10-5)+
-				math.Sin(float64(time.Now().Unix()/60))*5))
-
-		memoryUsage = math.Max(0, math.Min(100,
-			memBase+(mg.rnd.Float64()*8-4)+
-				math.Cos(float64(time.Now().Unix()/120))*3))
-
-		diskUsage = math.Max(0, math.Min(100,
-			diskBase+(mg.rnd.Float64()*6-3)+
-				math.Tan(float64(time.Now().Unix()/180))*2))
-	} else {
-		cpuUsage = 10 + mg.rnd.Float64()*40
-		memoryUsage = 20 + mg.rnd.Float64()*50
-		diskUsage = 5 + mg.rnd.Float64()*30
-	}
-
-	metric := MetricData{
-		Timestamp:   time.Now().UTC(),
-		ServerID:    server.ID,
-		Hostname:    server.Hostname,
-		IPAddress:   server.IPAddress,
-		Country:     server.Location.Country,
-		City:        server.Location.City,
-		Latitude:    server.Location.Latitude,
-		Longitude:   server.Location.Longitude,
-		CPUUsage:    roundFloat(cpuUsage, 2),
-		MemoryUsage: roundFloat(memoryUsage, 2),
-		DiskUsage:   roundFloat(diskUsage, 2),
-	}
-
-	mg.metricTracker[server.ID] = metric
-	return metric
-}
-
-func (mg *MetricGenerator) sendMetricToElasticsearch(metric MetricData) {
-	jsonMetric, err := json.Marshal(metric)
+	// Parse the per-type delivery classes
+	classes, err := sink.ParseDeliveryClasses(config.DeliveryClasses)
 	if err != nil {
-		log.Printf("Error marshaling metric: %v", err)
-		return
+		fatal("Error configuring delivery classes", "error", err)
 	}
-
-	req := esapi.IndexRequest{
-		Index:      mg.esIndex,
-		DocumentID: fmt.Sprintf("%s-%d", metric.ServerID, time.Now().Unix()),
-		Body:       bytes.NewReader(jsonMetric),
+	sinks, err := sink.ParseSinkList(config.Sink)
+	if err != nil {
+		fatal("Error configuring sinks", "error", err)
 	}
 
-	_, err = req.Do(context.Background(), mg.esClient)
+	// Seed the fleet and every server's series; log the seed so the run
+	// can be reproduced with SEED
+	slog.Info("Using seed", "seed", config.Seed)
+	servers, err := fleet.Build(config)
 	if err != nil {
-		log.Printf("Error indexing metric: %v", err)
+		fatal("Error building fleet", "error", err)
 	}
-}
 
-func (mg *MetricGenerator) GenerateConsistentMetrics() {
-	for {
-		var wg sync.WaitGroup
+	delivery := &sink.FanOut{}
+	generator, err := generate.NewMetricGenerator(config, servers, delivery)
+	if err != nil {
+		fatal("Error configuring generator", "error", err)
+	}
 
-		for _, server := range mg.servers {
-			wg.Add(1)
-			go func(srv ServerConfig) {
-				defer wg.Done()
+	if *dryRunFlag {
+		// Only count what would be sent, shaped by the first sink's
+		// transformations
+		dryRun := sink.NewDryRunSink(*printDocs)
+		preview, err := sink.WithTransforms(dryRun, sink.Transforms(config, sinks[0]))
+		if err != nil {
+			fatal("Error configuring sink transforms", "sink", sinks[0], "error", err)
+		}
+		delivery.Add("dry-run", sink.NewDispatcher(preview, classes), nil)
 
-				metric := mg.generateConsistentServerMetric(srv)
-				mg.sendMetricToElasticsearch(metric)
-			}(server)
+		var start time.Time
+		if *startAt != "" {
+			if start, err = time.Parse(time.RFC3339, *startAt); err != nil {
+				fatal("Error parsing -start", "error", err)
+			}
+		}
+		generator.GenerateTicks(*ticks, *maxDocs, start)
+		delivery.FlushAll(context.Background())
+		if err := dryRun.WriteSummary(os.Stderr, len(servers)); err != nil {
+			fatal("Error writing dry-run summary", "error", err)
 		}
+		return
+	}
 
-		wg.Wait()
-		time.Sleep(1 * time.Minute)
+	// Send to the configured sinks
+	if err := delivery.Open(context.Background(), sinks, config, classes); err != nil {
+		fatal("Error configuring sink", "error", err)
 	}
-}
 
-func main() {
-	// Load configuration
-	serverCount, esServer, esUsername, esPassword, esIndex := loadConfiguration()
+	// Record how this run's data was produced
+	var runs *sink.RunRecorder
+	if es := delivery.Elasticsearch(); es != nil && config.RunMetadataIndex != "off" {
+		runs, err = sink.NewRunRecorder(es.Client, config.RunMetadataIndex, version, config, servers, time.Now())
+		if err == nil {
+			err = runs.Write(context.Background())
+		}
+		if err != nil {
+			slog.Error("Error writing run metadata", "index", config.RunMetadataIndex, "error", err)
+			runs = nil
+		}
+	}
 
-	// Create a new random number generator seeded with the current time
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	// Export distributed traces across the fleet alongside the metrics
+	if err := generator.ExportTraces(config); err != nil {
+		fatal("Error configuring traces", "error", err)
+	}
+
+	// Stop on SIGINT/SIGTERM or once the run's duration is up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
 
-	// Generate random servers
-	servers := generateRandomServers(serverCount, rnd)
+	go delivery.Run(ctx)
 
-	// Configure Elasticsearch client
-	cfg := elasticsearch.Config{
-		Addresses: []string{esServer},
-		Username:  esUsername,
-		Password:  esPassword,
+	// Periodically log the generator's own throughput
+	if config.SelfMetricsLogInterval > 0 {
+		go telemetry.Stats.LogSummaries(ctx, config.SelfMetricsLogInterval)
 	}
 
-	esClient, err := elasticsearch.NewClient(cfg)
-	if err != nil {
-		log.Fatalf("Error creating Elasticsearch client: %v", err)
+	// Serve health and readiness probes, self-telemetry, ground truth and
+	// the control API
+	if config.HTTPAddr != "" {
+		handler := generator.Handler(delivery.Ping, config.TruthRetention, config.ControlAPI)
+		go func() {
+			slog.Info("Serving HTTP", "addr", config.HTTPAddr)
+			if err := http.ListenAndServe(config.HTTPAddr, handler); err != nil {
+				fatal("Error serving HTTP", "error", err)
+			}
+		}()
 	}
 
-	// Create metric generator
-	generator := &MetricGenerator{
-		servers:       servers,
-		esClient:      esClient,
-		metricTracker: make(map[string]MetricData),
-		esIndex:       esIndex,
-		rnd:           rnd, // Set the local random number generator
+	// Run metric generation, flat out when benchmarking
+	var generated int
+	var bench *telemetry.Benchmark
+	if *benchmark {
+		slog.Info("Benchmarking", "duration", *duration, "max_docs", *maxDocs, "sinks", sinks)
+		bench = telemetry.StartBenchmark()
+		generated = generator.GenerateUnpaced(ctx, *maxDocs)
+	} else {
+		generated = generator.GenerateConsistentMetrics(ctx, *maxDocs)
 	}
 
-	// Run metric generation
-	// log.Printf("metric: %v\n ", servers)
-	generator.GenerateConsistentMetrics()
-}
+	// Deliver what is still batched before exiting
+	delivery.FlushAll(context.Background())
+	if err := delivery.Close(); err != nil {
+		slog.Error("Error closing sink", "error", err)
+	}
+	if bench != nil {
+		if err := bench.WriteReport(os.Stdout); err != nil {
+			slog.Error("Error writing benchmark report", "error", err)
+		}
+	}
 
-func roundFloat(val float64, precision uint) float64 {
-	ratio := math.Pow(10, float64(precision))
-	return math.Round(val*ratio) / ratio
+	failed := telemetry.Stats.Failed.Load()
+	if runs != nil {
+		status := "completed"
+		if failed > 0 {
+			status = "completed_with_failures"
+		}
+		if err := runs.Finish(context.Background(), status, generator.Now(), generated); err != nil {
+			slog.Error("Error writing run metadata", "index", config.RunMetadataIndex, "error", err)
+		}
+	}
+	slog.Info("Run finished", "generated", generated, "indexed", telemetry.Stats.Indexed.Load(), "failed", failed, "dropped", telemetry.Stats.Dropped.Load())
+	if failed > 0 {
+		fatal("Some documents could not be delivered", "failed", failed)
+	}
 }
@@ -0,0 +1,878 @@
+// Package config loads the generator's settings from the environment.
+package config
+
+import (
+	"compress/gzip"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// SinkNames lists the sinks SINK can name.
+var SinkNames = []string{
+	"elasticsearch", "graphite", "statsd", "datadog", "cloudwatch", "loki",
+	"clickhouse", "mqtt", "nats", "rabbitmq", "redis", "webhook",
+	"csv", "parquet", "s3", "gcs",
+}
+
+// Config holds every setting of a run. Load fills it from the environment;
+// programs embedding the generator can build one directly.
+type Config struct {
+	ServerCount int
+	ESServer    string
+	ESUsername  string
+	ESPassword  string
+	ESIndex     string
+	ESDocID     string
+
+	ESCloudID string
+	ESAPIKey  string
+	ESFlavor  string
+
+	ESCACert             string
+	ESClientCert         string
+	ESClientKey          string
+	ESInsecureSkipVerify bool
+	ESCertFingerprint    string
+
+	ESCompress      bool
+	ESCompressLevel int
+
+	ESBootstrapTemplate bool
+	ESRolloverInterval  time.Duration
+	ESDataStream        bool
+	ESSnapshotRepo      string
+
+	ESPipeline       string
+	ESPipelineCreate bool
+
+	ESILMPolicy         string
+	ESILMRolloverMaxAge string
+	ESILMWarmAfter      string
+	ESILMDeleteAfter    string
+
+	LocalizedMetadata bool
+
+	FieldMissingRates string
+	FieldNullRates    string
+
+	ESMaxRetries          int
+	ESRetryInitialBackoff time.Duration
+	ESRetryMaxBackoff     time.Duration
+
+	DeliveryClasses     string
+	SinkDeliveryClasses map[string]string
+
+	AgentVersionOld      string
+	AgentVersionNew      string
+	AgentRolloutStart    time.Time
+	AgentRolloutDuration time.Duration
+
+	GeoIPDB string
+
+	PublicIPs       string
+	IPv6            string
+	DatacenterCIDRs string
+
+	ServerIDTemplate string
+	HostnameTemplate string
+
+	IncidentReplayFile  string
+	IncidentReplayHosts string
+	IncidentReplayAt    string
+
+	ScenarioFile string
+
+	ThresholdPatterns string
+
+	MetricFormulas string
+
+	MetricCorrelations string
+
+	DLQFile string
+
+	HTTPAddr string
+
+	ESTransforms string
+
+	SelfMetricsLogInterval time.Duration
+
+	DocTTL time.Duration
+
+	QualityProfile string
+	WalkParams     string
+
+	LogLevel  string
+	LogFormat string
+
+	Seed int64
+
+	Workers int
+
+	DiskGrowthRates  string
+	DiskRotationTime string
+
+	TruthRetention time.Duration
+
+	RateLimit      float64
+	RateLimitBurst int
+
+	SendQueueSize     int
+	SendQueueOverflow string
+
+	CircuitBreakerFailures      int
+	CircuitBreakerProbeInterval time.Duration
+
+	SpillDir   string
+	SpillMaxMB int
+
+	EnergyMetrics bool
+
+	OSDistribution string
+	CloudProviders string
+
+	HardwareSensors bool
+
+	Chaos              bool
+	ChaosMeanInterval  time.Duration
+	ChaosIncidentKinds string
+
+	ChangeEvents       bool
+	ChangeMeanInterval time.Duration
+	ChangeIndex        string
+
+	UptimeMetrics  bool
+	RebootRate     float64
+	RebootDowntime time.Duration
+
+	EdgeValueRates string
+
+	HighCardinalityRates string
+
+	Interval        time.Duration
+	AlignTimestamps bool
+	ServerIntervals string
+
+	LateArrivals string
+	ClockSkew    string
+	DataGaps     string
+
+	FleetChurn          string
+	AutoscalingGroups   string
+	AutoscalingCooldown time.Duration
+
+	Sink string
+
+	Fleets string
+
+	GraphiteAddr       string
+	GraphiteProtocol   string
+	GraphitePrefix     string
+	GraphiteTransforms string
+
+	StatsDAddr       string
+	StatsDProtocol   string
+	StatsDPrefix     string
+	StatsDTagFormat  string
+	StatsDTags       string
+	StatsDTransforms string
+
+	DatadogAPIKey     string
+	DatadogSite       string
+	DatadogPrefix     string
+	DatadogTags       string
+	DatadogTransforms string
+
+	RunMetadataIndex string
+
+	CloudWatchRegion       string
+	CloudWatchAccessKeyID  string
+	CloudWatchSecretKey    string
+	CloudWatchSessionToken string
+	CloudWatchEndpoint     string
+	CloudWatchNamespace    string
+	CloudWatchDimensions   string
+	CloudWatchBatchSize    int
+	CloudWatchTransforms   string
+
+	ServerLogRate  float64
+	ServerLogIndex string
+
+	SecurityEventRate  float64
+	SecurityAttackRate float64
+	SecurityIndex      string
+
+	LokiURL         string
+	LokiTenant      string
+	LokiUsername    string
+	LokiPassword    string
+	LokiEnvironment string
+	LokiLabels      string
+	LokiTransforms  string
+
+	TraceRate    float64
+	OTLPEndpoint string
+	OTLPHeaders  string
+
+	Services            string
+	ServiceMetricsIndex string
+
+	Topology string
+
+	SLOTarget float64
+	SLOBurns  string
+
+	Queues     string
+	QueueIndex string
+
+	NetworkLatency      bool
+	NetworkLatencyPairs string
+	NetworkLatencyIndex string
+
+	KubernetesDeployments string
+	KubernetesCluster     string
+	KubernetesIndex       string
+
+	DockerContainers int
+	DockerNested     bool
+	DockerIndex      string
+
+	WorkloadProfiles string
+	GPUCount         int
+	GPUIndex         string
+
+	Heartbeats     bool
+	HeartbeatIndex string
+
+	ClickHouseURL        string
+	ClickHouseDatabase   string
+	ClickHouseUsername   string
+	ClickHousePassword   string
+	ClickHouseTables     string
+	ClickHouseTransforms string
+
+	MQTTBroker     string
+	MQTTTopic      string
+	MQTTQoS        int
+	MQTTRetain     bool
+	MQTTClientID   string
+	MQTTUsername   string
+	MQTTPassword   string
+	MQTTTransforms string
+
+	NATSURL        string
+	NATSSubject    string
+	NATSJetStream  bool
+	NATSUsername   string
+	NATSPassword   string
+	NATSToken      string
+	NATSTransforms string
+
+	RabbitMQURL        string
+	RabbitMQExchange   string
+	RabbitMQRoutingKey string
+	RabbitMQTransforms string
+
+	RedisURL          string
+	RedisStreamKey    string
+	RedisStreamMaxLen int
+	RedisTransforms   string
+
+	WebhookURL        string
+	WebhookFormat     string
+	WebhookBatchSize  int
+	WebhookGzip       bool
+	WebhookHeaders    string
+	WebhookUsername   string
+	WebhookPassword   string
+	WebhookToken      string
+	WebhookTransforms string
+
+	DatasetDir          string
+	DatasetGzip         bool
+	ParquetRowGroupSize int
+	CSVTransforms       string
+	ParquetTransforms   string
+
+	ObjectStoreBucket    string
+	ObjectStorePrefix    string
+	ObjectStoreFormat    string
+	ObjectStorePartition string
+	ObjectStoreEndpoint  string
+	GCSAccessToken       string
+	GCSHMACAccessKey     string
+	GCSHMACSecret        string
+	S3Transforms         string
+	GCSTransforms        string
+
+	ControlAPI bool
+}
+
+// Load reads the configuration from the environment and an optional .env
+// file, applying defaults.
+func Load() Config {
+	// Load .env file
+	err := godotenv.Load()
+	if err != nil {
+		slog.Warn("No .env file found")
+	}
+
+	// Get environment variables
+	serverCount, _ := strconv.Atoi(os.Getenv("SERVER_COUNT"))
+	if serverCount == 0 {
+		serverCount = 100
+	}
+
+	esServer := os.Getenv("ES_SERVER")
+	if esServer == "" {
+		esServer = "http://localhost:9200"
+	}
+
+	esUsername := os.Getenv("ES_USERNAME")
+	esPassword := os.Getenv("ES_PASSWORD")
+	esIndex := os.Getenv("ES_INDEX")
+	if esIndex == "" {
+		esIndex = "server-metrics"
+	}
+
+	esDocID := os.Getenv("ES_DOC_ID")
+	if esDocID == "" {
+		esDocID = "timestamp"
+	}
+
+	esInsecureSkipVerify, _ := strconv.ParseBool(os.Getenv("ES_INSECURE_SKIP_VERIFY"))
+	esCompress, err := strconv.ParseBool(os.Getenv("ES_COMPRESS"))
+	if err != nil {
+		esCompress = true
+	}
+	esCompressLevel, err := strconv.Atoi(os.Getenv("ES_COMPRESS_LEVEL"))
+	if err != nil {
+		esCompressLevel = gzip.DefaultCompression
+	}
+	esBootstrapTemplate, _ := strconv.ParseBool(os.Getenv("ES_BOOTSTRAP_TEMPLATE"))
+
+	esRolloverInterval, _ := time.ParseDuration(os.Getenv("ES_ROLLOVER_INTERVAL"))
+	esDataStream, _ := strconv.ParseBool(os.Getenv("ES_DATA_STREAM"))
+	esPipelineCreate, _ := strconv.ParseBool(os.Getenv("ES_PIPELINE_CREATE"))
+
+	esILMRolloverMaxAge := os.Getenv("ES_ILM_ROLLOVER_MAX_AGE")
+	if esILMRolloverMaxAge == "" && esDataStream {
+		esILMRolloverMaxAge = "1d"
+	}
+	esILMDeleteAfter := os.Getenv("ES_ILM_DELETE_AFTER")
+	if esILMDeleteAfter == "" {
+		esILMDeleteAfter = "7d"
+	}
+
+	localizedMetadata, _ := strconv.ParseBool(os.Getenv("LOCALIZED_METADATA"))
+
+	esMaxRetries, err := strconv.Atoi(os.Getenv("ES_MAX_RETRIES"))
+	if err != nil {
+		esMaxRetries = 3
+	}
+	esRetryInitialBackoff, _ := time.ParseDuration(os.Getenv("ES_RETRY_INITIAL_BACKOFF"))
+	if esRetryInitialBackoff == 0 {
+		esRetryInitialBackoff = 500 * time.Millisecond
+	}
+	esRetryMaxBackoff, _ := time.ParseDuration(os.Getenv("ES_RETRY_MAX_BACKOFF"))
+	if esRetryMaxBackoff == 0 {
+		esRetryMaxBackoff = 30 * time.Second
+	}
+
+	agentVersionOld := os.Getenv("AGENT_VERSION_OLD")
+	if agentVersionOld == "" {
+		agentVersionOld = "1.9.2"
+	}
+	agentVersionNew := os.Getenv("AGENT_VERSION_NEW")
+	if agentVersionNew == "" {
+		agentVersionNew = "2.0.0"
+	}
+	agentRolloutStart, err := time.Parse(time.RFC3339, os.Getenv("AGENT_ROLLOUT_START"))
+	if err != nil {
+		agentRolloutStart = time.Now().UTC()
+	}
+	agentRolloutDuration, _ := time.ParseDuration(os.Getenv("AGENT_ROLLOUT_DURATION"))
+
+	selfMetricsLogInterval, err := time.ParseDuration(os.Getenv("SELF_METRICS_LOG_INTERVAL"))
+	if err != nil {
+		selfMetricsLogInterval = time.Minute
+	}
+
+	docTTL, _ := time.ParseDuration(os.Getenv("DOC_TTL"))
+
+	interval, err := time.ParseDuration(os.Getenv("INTERVAL"))
+	if err != nil {
+		interval = time.Minute
+	}
+	alignTimestamps, _ := strconv.ParseBool(os.Getenv("ALIGN_TIMESTAMPS"))
+
+	workers, _ := strconv.Atoi(os.Getenv("WORKERS"))
+
+	runMetadataIndex := os.Getenv("RUN_METADATA_INDEX")
+	if runMetadataIndex == "" {
+		runMetadataIndex = "metricgen-runs"
+	}
+
+	cloudWatchRegion := os.Getenv("AWS_REGION")
+	if cloudWatchRegion == "" {
+		cloudWatchRegion = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	cloudWatchBatchSize, _ := strconv.Atoi(os.Getenv("CLOUDWATCH_BATCH_SIZE"))
+
+	serverLogRate, _ := strconv.ParseFloat(os.Getenv("SERVER_LOG_RATE"), 64)
+	serverLogIndex := os.Getenv("SERVER_LOG_INDEX")
+	if serverLogIndex == "" {
+		serverLogIndex = "server-logs"
+	}
+
+	securityEventRate, _ := strconv.ParseFloat(os.Getenv("SECURITY_EVENT_RATE"), 64)
+	securityAttackRate, err := strconv.ParseFloat(os.Getenv("SECURITY_ATTACK_RATE"), 64)
+	if err != nil {
+		securityAttackRate = 0.5
+	}
+	securityIndex := os.Getenv("SECURITY_INDEX")
+	if securityIndex == "" {
+		securityIndex = "security-events"
+	}
+
+	traceRate, _ := strconv.ParseFloat(os.Getenv("TRACE_RATE"), 64)
+	otlpEndpoint := os.Getenv("OTLP_ENDPOINT")
+	if otlpEndpoint == "" {
+		otlpEndpoint = "http://localhost:4318"
+	}
+
+	serviceMetricsIndex := os.Getenv("SERVICE_METRICS_INDEX")
+	if serviceMetricsIndex == "" {
+		serviceMetricsIndex = "service-metrics"
+	}
+
+	sloTarget, _ := strconv.ParseFloat(os.Getenv("SLO_TARGET"), 64)
+
+	networkLatency, _ := strconv.ParseBool(os.Getenv("NETWORK_LATENCY"))
+	networkLatencyIndex := os.Getenv("NETWORK_LATENCY_INDEX")
+	if networkLatencyIndex == "" {
+		networkLatencyIndex = "network-latency"
+	}
+
+	queueIndex := os.Getenv("QUEUE_INDEX")
+	if queueIndex == "" {
+		queueIndex = "queue-metrics"
+	}
+
+	kubernetesIndex := os.Getenv("KUBERNETES_INDEX")
+	if kubernetesIndex == "" {
+		kubernetesIndex = "kubernetes-metrics"
+	}
+
+	dockerContainers, _ := strconv.Atoi(os.Getenv("DOCKER_CONTAINERS"))
+	dockerNested, _ := strconv.ParseBool(os.Getenv("DOCKER_NESTED"))
+	dockerIndex := os.Getenv("DOCKER_INDEX")
+	if dockerIndex == "" {
+		dockerIndex = "docker-metrics"
+	}
+
+	gpuCount, err := strconv.Atoi(os.Getenv("GPU_COUNT"))
+	if err != nil {
+		gpuCount = 8
+	}
+	gpuIndex := os.Getenv("GPU_INDEX")
+	if gpuIndex == "" {
+		gpuIndex = "gpu-metrics"
+	}
+
+	heartbeats, _ := strconv.ParseBool(os.Getenv("HEARTBEATS"))
+	heartbeatIndex := os.Getenv("HEARTBEAT_INDEX")
+	if heartbeatIndex == "" {
+		heartbeatIndex = "agent-heartbeats"
+	}
+
+	mqttQoS, _ := strconv.Atoi(os.Getenv("MQTT_QOS"))
+	mqttRetain, _ := strconv.ParseBool(os.Getenv("MQTT_RETAIN"))
+
+	natsJetStream, _ := strconv.ParseBool(os.Getenv("NATS_JETSTREAM"))
+
+	redisStreamMaxLen, err := strconv.Atoi(os.Getenv("REDIS_STREAM_MAXLEN"))
+	if err != nil {
+		redisStreamMaxLen = 100000
+	}
+
+	webhookBatchSize, _ := strconv.Atoi(os.Getenv("WEBHOOK_BATCH_SIZE"))
+	webhookGzip, _ := strconv.ParseBool(os.Getenv("WEBHOOK_GZIP"))
+
+	datasetDir := os.Getenv("DATASET_DIR")
+	if datasetDir == "" {
+		datasetDir = "dataset"
+	}
+	datasetGzip, _ := strconv.ParseBool(os.Getenv("DATASET_GZIP"))
+	parquetRowGroupSize, err := strconv.Atoi(os.Getenv("PARQUET_ROW_GROUP_SIZE"))
+	if err != nil {
+		parquetRowGroupSize = 10000
+	}
+
+	controlAPI, _ := strconv.ParseBool(os.Getenv("CONTROL_API"))
+
+	// DELIVERY_CLASSES_<SINK> overrides batching for one sink of several
+	sinkDeliveryClasses := make(map[string]string)
+	for _, name := range SinkNames {
+		if spec := os.Getenv("DELIVERY_CLASSES_" + strings.ToUpper(name)); spec != "" {
+			sinkDeliveryClasses[name] = spec
+		}
+	}
+
+	energyMetrics, _ := strconv.ParseBool(os.Getenv("ENERGY_METRICS"))
+
+	hardwareSensors, _ := strconv.ParseBool(os.Getenv("HARDWARE_SENSORS"))
+
+	changeEvents, _ := strconv.ParseBool(os.Getenv("CHANGE_EVENTS"))
+	changeMeanInterval, err := time.ParseDuration(os.Getenv("CHANGE_MEAN_INTERVAL"))
+	if err != nil {
+		changeMeanInterval = time.Hour
+	}
+	changeIndex := os.Getenv("CHANGE_INDEX")
+	if changeIndex == "" {
+		changeIndex = "change-events"
+	}
+
+	chaos, _ := strconv.ParseBool(os.Getenv("CHAOS"))
+	chaosMeanInterval, err := time.ParseDuration(os.Getenv("CHAOS_MEAN_INTERVAL"))
+	if err != nil {
+		chaosMeanInterval = 30 * time.Minute
+	}
+
+	uptimeMetrics, _ := strconv.ParseBool(os.Getenv("UPTIME_METRICS"))
+	rebootRate, err := strconv.ParseFloat(os.Getenv("REBOOT_RATE"), 64)
+	if err != nil {
+		rebootRate = 0.05
+	}
+	rebootDowntime, err := time.ParseDuration(os.Getenv("REBOOT_DOWNTIME"))
+	if err != nil {
+		rebootDowntime = 2 * time.Minute
+	}
+
+	rateLimit, _ := strconv.ParseFloat(os.Getenv("RATE_LIMIT"), 64)
+	rateLimitBurst, _ := strconv.Atoi(os.Getenv("RATE_LIMIT_BURST"))
+	sendQueueSize, _ := strconv.Atoi(os.Getenv("SEND_QUEUE_SIZE"))
+	circuitBreakerFailures, err := strconv.Atoi(os.Getenv("CIRCUIT_BREAKER_FAILURES"))
+	if err != nil {
+		circuitBreakerFailures = 5
+	}
+	circuitBreakerProbeInterval, err := time.ParseDuration(os.Getenv("CIRCUIT_BREAKER_PROBE_INTERVAL"))
+	if err != nil || circuitBreakerProbeInterval <= 0 {
+		circuitBreakerProbeInterval = 30 * time.Second
+	}
+	spillMaxMB, err := strconv.Atoi(os.Getenv("SPILL_MAX_MB"))
+	if err != nil || spillMaxMB <= 0 {
+		spillMaxMB = 1024
+	}
+
+	truthRetention, err := time.ParseDuration(os.Getenv("TRUTH_RETENTION"))
+	if err != nil {
+		truthRetention = time.Hour
+	}
+
+	autoscalingCooldown, err := time.ParseDuration(os.Getenv("AUTOSCALING_COOLDOWN"))
+	if err != nil {
+		autoscalingCooldown = 5 * time.Minute
+	}
+
+	seed, err := strconv.ParseInt(os.Getenv("SEED"), 10, 64)
+	if err != nil {
+		seed = time.Now().UnixNano()
+	}
+
+	return Config{
+		ServerCount: serverCount,
+		ESServer:    esServer,
+		ESUsername:  esUsername,
+		ESPassword:  esPassword,
+		ESIndex:     esIndex,
+		ESDocID:     esDocID,
+
+		ESCloudID: os.Getenv("ES_CLOUD_ID"),
+		ESAPIKey:  os.Getenv("ES_API_KEY"),
+		ESFlavor:  os.Getenv("ES_FLAVOR"),
+
+		ESCACert:             os.Getenv("ES_CA_CERT"),
+		ESClientCert:         os.Getenv("ES_CLIENT_CERT"),
+		ESClientKey:          os.Getenv("ES_CLIENT_KEY"),
+		ESInsecureSkipVerify: esInsecureSkipVerify,
+		ESCertFingerprint:    os.Getenv("ES_CERT_FINGERPRINT"),
+
+		ESCompress:      esCompress,
+		ESCompressLevel: esCompressLevel,
+
+		ESBootstrapTemplate: esBootstrapTemplate,
+		ESRolloverInterval:  esRolloverInterval,
+		ESDataStream:        esDataStream,
+		ESSnapshotRepo:      os.Getenv("ES_SNAPSHOT_REPO"),
+
+		ESPipeline:       os.Getenv("ES_PIPELINE"),
+		ESPipelineCreate: esPipelineCreate,
+
+		ESILMPolicy:         os.Getenv("ES_ILM_POLICY"),
+		ESILMRolloverMaxAge: esILMRolloverMaxAge,
+		ESILMWarmAfter:      os.Getenv("ES_ILM_WARM_AFTER"),
+		ESILMDeleteAfter:    esILMDeleteAfter,
+
+		LocalizedMetadata: localizedMetadata,
+
+		FieldMissingRates: os.Getenv("FIELD_MISSING_RATES"),
+		FieldNullRates:    os.Getenv("FIELD_NULL_RATES"),
+
+		ESMaxRetries:          esMaxRetries,
+		ESRetryInitialBackoff: esRetryInitialBackoff,
+		ESRetryMaxBackoff:     esRetryMaxBackoff,
+
+		DeliveryClasses:     os.Getenv("DELIVERY_CLASSES"),
+		SinkDeliveryClasses: sinkDeliveryClasses,
+
+		AgentVersionOld:      agentVersionOld,
+		AgentVersionNew:      agentVersionNew,
+		AgentRolloutStart:    agentRolloutStart,
+		AgentRolloutDuration: agentRolloutDuration,
+
+		GeoIPDB: os.Getenv("GEOIP_DB"),
+
+		PublicIPs: os.Getenv("PUBLIC_IPS"),
+		IPv6:      os.Getenv("IPV6"),
+
+		DatacenterCIDRs: os.Getenv("DATACENTER_CIDRS"),
+
+		ServerIDTemplate: os.Getenv("SERVER_ID_TEMPLATE"),
+		HostnameTemplate: os.Getenv("HOSTNAME_TEMPLATE"),
+
+		IncidentReplayFile:  os.Getenv("INCIDENT_REPLAY_FILE"),
+		IncidentReplayHosts: os.Getenv("INCIDENT_REPLAY_HOSTS"),
+		IncidentReplayAt:    os.Getenv("INCIDENT_REPLAY_AT"),
+
+		ScenarioFile: os.Getenv("SCENARIO_FILE"),
+
+		ThresholdPatterns: os.Getenv("THRESHOLD_PATTERNS"),
+
+		MetricFormulas: os.Getenv("METRIC_FORMULAS"),
+
+		MetricCorrelations: os.Getenv("METRIC_CORRELATIONS"),
+
+		DLQFile: os.Getenv("DLQ_FILE"),
+
+		HTTPAddr: os.Getenv("HTTP_ADDR"),
+
+		ESTransforms: os.Getenv("SINK_TRANSFORMS_ELASTICSEARCH"),
+
+		SelfMetricsLogInterval: selfMetricsLogInterval,
+
+		DocTTL: docTTL,
+
+		QualityProfile: os.Getenv("QUALITY_PROFILE"),
+		WalkParams:     os.Getenv("WALK_PARAMS"),
+
+		LogLevel:  os.Getenv("LOG_LEVEL"),
+		LogFormat: os.Getenv("LOG_FORMAT"),
+
+		Seed: seed,
+
+		Workers: workers,
+
+		DiskGrowthRates:  os.Getenv("DISK_GROWTH_RATES"),
+		DiskRotationTime: os.Getenv("DISK_ROTATION_TIME"),
+
+		TruthRetention: truthRetention,
+
+		RateLimit:      rateLimit,
+		RateLimitBurst: rateLimitBurst,
+
+		SendQueueSize:     sendQueueSize,
+		SendQueueOverflow: os.Getenv("SEND_QUEUE_OVERFLOW"),
+
+		CircuitBreakerFailures:      circuitBreakerFailures,
+		CircuitBreakerProbeInterval: circuitBreakerProbeInterval,
+
+		SpillDir:   os.Getenv("SPILL_DIR"),
+		SpillMaxMB: spillMaxMB,
+
+		EnergyMetrics: energyMetrics,
+
+		OSDistribution: os.Getenv("OS_DISTRIBUTION"),
+		CloudProviders: os.Getenv("CLOUD_PROVIDERS"),
+
+		HardwareSensors: hardwareSensors,
+
+		Chaos:              chaos,
+		ChaosMeanInterval:  chaosMeanInterval,
+		ChaosIncidentKinds: os.Getenv("CHAOS_INCIDENTS"),
+
+		ChangeEvents:       changeEvents,
+		ChangeMeanInterval: changeMeanInterval,
+		ChangeIndex:        changeIndex,
+
+		UptimeMetrics:  uptimeMetrics,
+		RebootRate:     rebootRate,
+		RebootDowntime: rebootDowntime,
+
+		EdgeValueRates: os.Getenv("EDGE_VALUE_RATES"),
+
+		HighCardinalityRates: os.Getenv("HIGH_CARDINALITY_RATES"),
+
+		Interval:        interval,
+		AlignTimestamps: alignTimestamps,
+		ServerIntervals: os.Getenv("SERVER_INTERVALS"),
+
+		LateArrivals: os.Getenv("LATE_ARRIVALS"),
+		ClockSkew:    os.Getenv("CLOCK_SKEW"),
+		DataGaps:     os.Getenv("DATA_GAPS"),
+
+		FleetChurn:          os.Getenv("FLEET_CHURN"),
+		AutoscalingGroups:   os.Getenv("AUTOSCALING_GROUPS"),
+		AutoscalingCooldown: autoscalingCooldown,
+
+		Sink: os.Getenv("SINK"),
+
+		Fleets: os.Getenv("FLEETS"),
+
+		GraphiteAddr:       os.Getenv("GRAPHITE_ADDR"),
+		GraphiteProtocol:   os.Getenv("GRAPHITE_PROTOCOL"),
+		GraphitePrefix:     os.Getenv("GRAPHITE_PREFIX"),
+		GraphiteTransforms: os.Getenv("SINK_TRANSFORMS_GRAPHITE"),
+
+		StatsDAddr:       os.Getenv("STATSD_ADDR"),
+		StatsDProtocol:   os.Getenv("STATSD_PROTOCOL"),
+		StatsDPrefix:     os.Getenv("STATSD_PREFIX"),
+		StatsDTagFormat:  os.Getenv("STATSD_TAG_FORMAT"),
+		StatsDTags:       os.Getenv("STATSD_TAGS"),
+		StatsDTransforms: os.Getenv("SINK_TRANSFORMS_STATSD"),
+
+		DatadogAPIKey:     os.Getenv("DATADOG_API_KEY"),
+		DatadogSite:       os.Getenv("DATADOG_SITE"),
+		DatadogPrefix:     os.Getenv("DATADOG_METRIC_PREFIX"),
+		DatadogTags:       os.Getenv("DATADOG_TAGS"),
+		DatadogTransforms: os.Getenv("SINK_TRANSFORMS_DATADOG"),
+
+		RunMetadataIndex: runMetadataIndex,
+
+		CloudWatchRegion:       cloudWatchRegion,
+		CloudWatchAccessKeyID:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		CloudWatchSecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		CloudWatchSessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		CloudWatchEndpoint:     os.Getenv("CLOUDWATCH_ENDPOINT"),
+		CloudWatchNamespace:    os.Getenv("CLOUDWATCH_NAMESPACE"),
+		CloudWatchDimensions:   os.Getenv("CLOUDWATCH_DIMENSIONS"),
+		CloudWatchBatchSize:    cloudWatchBatchSize,
+		CloudWatchTransforms:   os.Getenv("SINK_TRANSFORMS_CLOUDWATCH"),
+
+		ServerLogRate:  serverLogRate,
+		ServerLogIndex: serverLogIndex,
+
+		SecurityEventRate:  securityEventRate,
+		SecurityAttackRate: securityAttackRate,
+		SecurityIndex:      securityIndex,
+
+		LokiURL:         os.Getenv("LOKI_URL"),
+		LokiTenant:      os.Getenv("LOKI_TENANT"),
+		LokiUsername:    os.Getenv("LOKI_USERNAME"),
+		LokiPassword:    os.Getenv("LOKI_PASSWORD"),
+		LokiEnvironment: os.Getenv("LOKI_ENVIRONMENT"),
+		LokiLabels:      os.Getenv("LOKI_LABELS"),
+		LokiTransforms:  os.Getenv("SINK_TRANSFORMS_LOKI"),
+
+		TraceRate:    traceRate,
+		OTLPEndpoint: otlpEndpoint,
+		OTLPHeaders:  os.Getenv("OTLP_HEADERS"),
+
+		Services:            os.Getenv("SERVICES"),
+		ServiceMetricsIndex: serviceMetricsIndex,
+
+		Topology: os.Getenv("TOPOLOGY"),
+
+		SLOTarget: sloTarget,
+		SLOBurns:  os.Getenv("SLO_BURNS"),
+
+		Queues:     os.Getenv("QUEUES"),
+		QueueIndex: queueIndex,
+
+		NetworkLatency:      networkLatency,
+		NetworkLatencyPairs: os.Getenv("NETWORK_LATENCY_PAIRS"),
+		NetworkLatencyIndex: networkLatencyIndex,
+
+		KubernetesDeployments: os.Getenv("KUBERNETES_DEPLOYMENTS"),
+		KubernetesCluster:     os.Getenv("KUBERNETES_CLUSTER"),
+		KubernetesIndex:       kubernetesIndex,
+
+		DockerContainers: dockerContainers,
+		DockerNested:     dockerNested,
+		DockerIndex:      dockerIndex,
+
+		WorkloadProfiles: os.Getenv("WORKLOAD_PROFILES"),
+		GPUCount:         gpuCount,
+		GPUIndex:         gpuIndex,
+
+		Heartbeats:     heartbeats,
+		HeartbeatIndex: heartbeatIndex,
+
+		ClickHouseURL:        os.Getenv("CLICKHOUSE_URL"),
+		ClickHouseDatabase:   os.Getenv("CLICKHOUSE_DATABASE"),
+		ClickHouseUsername:   os.Getenv("CLICKHOUSE_USERNAME"),
+		ClickHousePassword:   os.Getenv("CLICKHOUSE_PASSWORD"),
+		ClickHouseTables:     os.Getenv("CLICKHOUSE_TABLES"),
+		ClickHouseTransforms: os.Getenv("SINK_TRANSFORMS_CLICKHOUSE"),
+
+		MQTTBroker:     os.Getenv("MQTT_BROKER"),
+		MQTTTopic:      os.Getenv("MQTT_TOPIC"),
+		MQTTQoS:        mqttQoS,
+		MQTTRetain:     mqttRetain,
+		MQTTClientID:   os.Getenv("MQTT_CLIENT_ID"),
+		MQTTUsername:   os.Getenv("MQTT_USERNAME"),
+		MQTTPassword:   os.Getenv("MQTT_PASSWORD"),
+		MQTTTransforms: os.Getenv("SINK_TRANSFORMS_MQTT"),
+
+		NATSURL:        os.Getenv("NATS_URL"),
+		NATSSubject:    os.Getenv("NATS_SUBJECT"),
+		NATSJetStream:  natsJetStream,
+		NATSUsername:   os.Getenv("NATS_USERNAME"),
+		NATSPassword:   os.Getenv("NATS_PASSWORD"),
+		NATSToken:      os.Getenv("NATS_TOKEN"),
+		NATSTransforms: os.Getenv("SINK_TRANSFORMS_NATS"),
+
+		RabbitMQURL:        os.Getenv("RABBITMQ_URL"),
+		RabbitMQExchange:   os.Getenv("RABBITMQ_EXCHANGE"),
+		RabbitMQRoutingKey: os.Getenv("RABBITMQ_ROUTING_KEY"),
+		RabbitMQTransforms: os.Getenv("SINK_TRANSFORMS_RABBITMQ"),
+
+		RedisURL:          os.Getenv("REDIS_URL"),
+		RedisStreamKey:    os.Getenv("REDIS_STREAM_KEY"),
+		RedisStreamMaxLen: redisStreamMaxLen,
+		RedisTransforms:   os.Getenv("SINK_TRANSFORMS_REDIS"),
+
+		WebhookURL:        os.Getenv("WEBHOOK_URL"),
+		WebhookFormat:     os.Getenv("WEBHOOK_FORMAT"),
+		WebhookBatchSize:  webhookBatchSize,
+		WebhookGzip:       webhookGzip,
+		WebhookHeaders:    os.Getenv("WEBHOOK_HEADERS"),
+		WebhookUsername:   os.Getenv("WEBHOOK_USERNAME"),
+		WebhookPassword:   os.Getenv("WEBHOOK_PASSWORD"),
+		WebhookToken:      os.Getenv("WEBHOOK_TOKEN"),
+		WebhookTransforms: os.Getenv("SINK_TRANSFORMS_WEBHOOK"),
+
+		DatasetDir:          datasetDir,
+		DatasetGzip:         datasetGzip,
+		ParquetRowGroupSize: parquetRowGroupSize,
+		CSVTransforms:       os.Getenv("SINK_TRANSFORMS_CSV"),
+		ParquetTransforms:   os.Getenv("SINK_TRANSFORMS_PARQUET"),
+
+		ObjectStoreBucket:    os.Getenv("OBJECT_STORE_BUCKET"),
+		ObjectStorePrefix:    os.Getenv("OBJECT_STORE_PREFIX"),
+		ObjectStoreFormat:    os.Getenv("OBJECT_STORE_FORMAT"),
+		ObjectStorePartition: os.Getenv("OBJECT_STORE_PARTITION"),
+		ObjectStoreEndpoint:  os.Getenv("OBJECT_STORE_ENDPOINT"),
+		GCSAccessToken:       os.Getenv("GCS_ACCESS_TOKEN"),
+		GCSHMACAccessKey:     os.Getenv("GCS_HMAC_ACCESS_KEY"),
+		GCSHMACSecret:        os.Getenv("GCS_HMAC_SECRET"),
+		S3Transforms:         os.Getenv("SINK_TRANSFORMS_S3"),
+		GCSTransforms:        os.Getenv("SINK_TRANSFORMS_GCS"),
+
+		ControlAPI: controlAPI,
+	}
+}
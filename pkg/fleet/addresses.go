@@ -0,0 +1,199 @@
+package fleet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// countryPrefixes lists address blocks registered to large ISPs in each
+// built-in location's country, so GeoIP databases resolve public addresses
+// drawn from them to the server's country.
+var countryPrefixes = map[string]struct{ v4, v6 []string }{
+	"United States":        {[]string{"8.0.0.0/9", "24.0.0.0/12", "64.0.0.0/12"}, []string{"2600:1700::/24", "2601::/20"}},
+	"United Kingdom":       {[]string{"81.128.0.0/11", "86.128.0.0/10"}, []string{"2a00:23c0::/26"}},
+	"Germany":              {[]string{"91.0.0.0/10", "79.192.0.0/10"}, []string{"2003::/19"}},
+	"Japan":                {[]string{"126.0.0.0/8", "153.128.0.0/9"}, []string{"240b::/16"}},
+	"Switzerland":          {[]string{"85.0.0.0/13", "178.192.0.0/12"}, []string{"2a02:1200::/27"}},
+	"United Arab Emirates": {[]string{"2.48.0.0/14", "94.200.0.0/13"}, []string{"2001:8f8::/29"}},
+	"Egypt":                {[]string{"41.32.0.0/12", "156.160.0.0/11"}, []string{"2c0f:fc88::/32"}},
+}
+
+// globalUnicast is where public IPv6 addresses come from for countries
+// without prefixes of their own.
+var globalUnicast = mustParseCIDR("2000::/3")
+
+// fleetSelection parses a setting naming the fleets it applies to: "true"
+// for every fleet, or a comma-separated list of fleet names.
+func fleetSelection(name, spec string, fleets []fleetSpec) (map[string]bool, error) {
+	spec = strings.TrimSpace(spec)
+	if all, err := strconv.ParseBool(spec); spec == "" || err == nil {
+		selected := make(map[string]bool)
+		for _, f := range fleets {
+			selected[f.Name] = all
+		}
+		return selected, nil
+	}
+
+	known := make(map[string]bool)
+	for _, f := range fleets {
+		known[f.Name] = f.Name != ""
+	}
+	selected := make(map[string]bool)
+	for _, fleet := range strings.Split(spec, ",") {
+		fleet = strings.TrimSpace(fleet)
+		if !known[fleet] {
+			return nil, fmt.Errorf("%s: unknown fleet %q", name, fleet)
+		}
+		selected[fleet] = true
+	}
+	return selected, nil
+}
+
+// assignAddresses gives the servers of a fleet public IPv4 addresses from
+// their country's ranges when public is set, and IPv6 addresses when ipv6
+// is set: a unique local address inside the fleet's /48, one subnet per
+// role, and a public address as well with public. Servers that already
+// have a public IPv4 from the GeoIP database keep it. Each server draws
+// from its own source, so the addresses don't depend on the fleet's size.
+func assignAddresses(servers []ServerConfig, fleet fleetSpec, public, ipv6 bool) {
+	ulaRnd := rand.New(rand.NewSource(DeriveSeed(fleet.Seed, "ula")))
+	ula := make(net.IP, net.IPv6len)
+	ula[0] = 0xfd
+	for i := 1; i < 6; i++ {
+		ula[i] = byte(ulaRnd.Intn(256))
+	}
+
+	for i := range servers {
+		s := &servers[i]
+		rnd := rand.New(rand.NewSource(DeriveSeed(s.Seed, "addresses")))
+		prefixes := countryPrefixes[s.Location.Country]
+
+		if public && s.PublicIP == "" {
+			if len(prefixes.v4) > 0 {
+				s.PublicIP = randomIn(mustParseCIDR(prefixes.v4[rnd.Intn(len(prefixes.v4))]), rnd).String()
+			} else {
+				s.PublicIP = randomPublicIPv4(rnd).String()
+			}
+		}
+		if !ipv6 {
+			continue
+		}
+
+		subnet := &net.IPNet{IP: append(net.IP{}, ula...), Mask: net.CIDRMask(64, 128)}
+		for j, role := range roles {
+			if role == s.Role {
+				subnet.IP[7] = byte(j + 1)
+			}
+		}
+		s.IPv6Address = randomIn(subnet, rnd).String()
+		if public {
+			prefix := globalUnicast
+			if len(prefixes.v6) > 0 {
+				prefix = mustParseCIDR(prefixes.v6[rnd.Intn(len(prefixes.v6))])
+			}
+			s.PublicIPv6 = randomIn(prefix, rnd).String()
+		}
+	}
+}
+
+// randomIn returns a random host address inside n, avoiding the network
+// and broadcast addresses of IPv4 ranges.
+func randomIn(n *net.IPNet, rnd *rand.Rand) net.IP {
+	for {
+		ip := make(net.IP, len(n.IP))
+		for i := range ip {
+			ip[i] = n.IP[i]&n.Mask[i] | byte(rnd.Intn(256))&^n.Mask[i]
+		}
+		if v4 := ip.To4(); v4 == nil || (v4[3] != 0 && v4[3] != 255) {
+			return ip
+		}
+	}
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// parseDatacenterCIDRs parses DATACENTER_CIDRS, a semicolon-separated list
+// of "city=cidr[,cidr...]" entries giving each location the IPv4 blocks
+// its servers' private addresses come from, e.g.
+// "New York=10.1.0.0/16;London=10.2.0.0/16,10.3.0.0/24".
+func parseDatacenterCIDRs(spec string) (map[string][]*net.IPNet, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	blocks := make(map[string][]*net.IPNet)
+	for _, entry := range strings.Split(spec, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		city, cidrs, ok := strings.Cut(entry, "=")
+		city = strings.TrimSpace(city)
+		if !ok || city == "" {
+			return nil, fmt.Errorf("invalid datacenter CIDRs %q (want city=cidr[,cidr...])", entry)
+		}
+		if blocks[city] != nil {
+			return nil, fmt.Errorf("duplicate datacenter %q", city)
+		}
+		for _, cidr := range strings.Split(cidrs, ",") {
+			_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil || n.IP.To4() == nil {
+				return nil, fmt.Errorf("invalid IPv4 CIDR %q for datacenter %q", cidr, city)
+			}
+			if ones, _ := n.Mask.Size(); ones > 30 {
+				return nil, fmt.Errorf("CIDR %q for datacenter %q has no room for servers", cidr, city)
+			}
+			blocks[city] = append(blocks[city], n)
+		}
+	}
+	return blocks, nil
+}
+
+// allocateDatacenterAddresses hands out the addresses of each location's
+// blocks to its servers in order, skipping the network and broadcast
+// addresses of every block. Servers in locations without blocks keep
+// their random address.
+func allocateDatacenterAddresses(servers []ServerConfig, blocks map[string][]*net.IPNet) error {
+	type cursor struct {
+		block int
+		next  uint32
+	}
+	cursors := make(map[string]*cursor)
+	for i := range servers {
+		city := servers[i].Location.City
+		nets := blocks[city]
+		if nets == nil {
+			continue
+		}
+		c := cursors[city]
+		if c == nil {
+			c = &cursor{next: 1}
+			cursors[city] = c
+		}
+		for {
+			if c.block == len(nets) {
+				return fmt.Errorf("datacenter %q has run out of addresses", city)
+			}
+			ones, bits := nets[c.block].Mask.Size()
+			if c.next < 1<<(bits-ones)-1 {
+				break
+			}
+			c.block, c.next = c.block+1, 1
+		}
+		base := binary.BigEndian.Uint32(nets[c.block].IP.To4())
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, base+c.next)
+		servers[i].IPAddress = ip.String()
+		c.next++
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+// Package fleet builds the simulated servers: their roles, names, addresses
+// and locations.
+package fleet
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+)
+
+type ServerConfig struct {
+	// Index is the server's position in its fleet, from 1
+	Index     int
+	ID        string
+	Hostname  string
+	IPAddress string
+	Role      string
+	// Label is a localized display name, set only with LOCALIZED_METADATA.
+	Label    string
+	Location Location
+
+	// PublicIP is set when locations are derived from a GeoIP database or
+	// with PUBLIC_IPS, GeoTruth only with the database
+	PublicIP string
+	GeoTruth *GeoTruth
+
+	// Set only with IPV6; PublicIPv6 also needs PUBLIC_IPS
+	IPv6Address string
+	PublicIPv6  string
+
+	// Fleet is the name of the fleet the server belongs to, set only with
+	// FLEETS. Seed drives the server's random source; see serverSim.
+	Fleet string
+	Seed  int64
+}
+
+type Location struct {
+	Country   string
+	City      string
+	Latitude  float64
+	Longitude float64
+
+	// Native-script metadata, emitted only with LOCALIZED_METADATA
+	Locale       string
+	CountryLocal string
+	CityLocal    string
+}
+
+// fleetSpec is one independently seeded group of servers.
+type fleetSpec struct {
+	Name  string
+	Count int
+	Seed  int64
+}
+
+// parseFleets parses FLEETS, a comma-separated list of "name:count[:seed]"
+// entries. Fleets without a seed derive one from the run seed and their
+// name. An empty spec is a single unnamed fleet of SERVER_COUNT servers.
+func parseFleets(spec string, serverCount int, runSeed int64) ([]fleetSpec, error) {
+	if strings.TrimSpace(spec) == "" {
+		return []fleetSpec{{Count: serverCount, Seed: runSeed}}, nil
+	}
+
+	var fleets []fleetSpec
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid fleet %q (want name:count[:seed])", entry)
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("duplicate fleet %q", parts[0])
+		}
+		seen[parts[0]] = true
+
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid server count in fleet %q", entry)
+		}
+		seed := DeriveSeed(runSeed, "fleet:"+parts[0])
+		if len(parts) == 3 {
+			if seed, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid seed in fleet %q", entry)
+			}
+		}
+		fleets = append(fleets, fleetSpec{Name: parts[0], Count: count, Seed: seed})
+	}
+	return fleets, nil
+}
+
+// Build generates the configured fleets, deriving locations from the
+// GeoIP database when one is configured, adding the public and IPv6
+// addresses the fleets ask for, allocating private addresses from the
+// datacenters' blocks and naming servers from the templates. Each fleet draws from its own
+// random source, so resizing one fleet leaves the others untouched.
+func Build(config config.Config) ([]ServerConfig, error) {
+	fleets, err := parseFleets(config.Fleets, config.ServerCount, config.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := fleetSelection("PUBLIC_IPS", config.PublicIPs, fleets)
+	if err != nil {
+		return nil, err
+	}
+	ipv6, err := fleetSelection("IPV6", config.IPv6, fleets)
+	if err != nil {
+		return nil, err
+	}
+
+	datacenters, err := parseDatacenterCIDRs(config.DatacenterCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	naming, err := parseNaming(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var db *mmdbReader
+	if config.GeoIPDB != "" {
+		if db, err = openMMDB(config.GeoIPDB); err != nil {
+			return nil, fmt.Errorf("opening GeoIP database: %w", err)
+		}
+	}
+
+	var servers []ServerConfig
+	for _, fleet := range fleets {
+		rnd := rand.New(rand.NewSource(fleet.Seed))
+		members := GenerateRandomServers(fleet.Count, rnd, config.LocalizedMetadata)
+		for i := range members {
+			if fleet.Name != "" {
+				members[i].ID = fleet.Name + "-" + members[i].ID
+				members[i].Hostname = fleet.Name + "-" + members[i].Hostname
+				members[i].Fleet = fleet.Name
+			}
+			members[i].Seed = DeriveSeed(fleet.Seed, members[i].ID)
+		}
+
+		if db != nil {
+			if err := assignGeoIPLocations(members, db, rnd); err != nil {
+				return nil, fmt.Errorf("assigning GeoIP locations: %w", err)
+			}
+		}
+		assignAddresses(members, fleet, public[fleet.Name], ipv6[fleet.Name])
+
+		// Seeds stay derived from the built-in IDs, so renaming servers
+		// leaves their series unchanged
+		if naming != nil {
+			for i := range members {
+				if err := naming.apply(&members[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		servers = append(servers, members...)
+	}
+	if err := allocateDatacenterAddresses(servers, datacenters); err != nil {
+		return nil, err
+	}
+	if naming != nil {
+		if err := checkUnique(servers); err != nil {
+			return nil, err
+		}
+	}
+	return servers, nil
+}
+
+var roles = []string{"web", "db", "app", "cache", "worker"}
+
+var defaultLocations = []Location{
+	{"United States", "New York", 40.7128, -74.0060, "en-US", "United States", "New York"},
+	{"United States", "Los Angeles", 34.0522, -118.2437, "en-US", "United States", "Los Angeles"},
+	{"United Kingdom", "London", 51.5074, -0.1278, "en-GB", "United Kingdom", "London"},
+	{"Germany", "Berlin", 52.5200, 13.4050, "de-DE", "Deutschland", "Berlin"},
+	{"Japan", "Tokyo", 35.6762, 139.6503, "ja-JP", "日本", "東京"},
+}
+
+// localizedLocations are added to the pool with LOCALIZED_METADATA so that
+// umlauts, CJK and right-to-left scripts show up in the generated data.
+var localizedLocations = []Location{
+	{"Germany", "Munich", 48.1351, 11.5820, "de-DE", "Deutschland", "München"},
+	{"Germany", "Dusseldorf", 51.2277, 6.7735, "de-DE", "Deutschland", "Düsseldorf"},
+	{"Switzerland", "Zurich", 47.3769, 8.5417, "de-CH", "Schweiz", "Zürich"},
+	{"Japan", "Osaka", 34.6937, 135.5023, "ja-JP", "日本", "大阪"},
+	{"United Arab Emirates", "Dubai", 25.2048, 55.2708, "ar-AE", "الإمارات العربية المتحدة", "دبي"},
+	{"Egypt", "Cairo", 30.0444, 31.2357, "ar-EG", "مصر", "القاهرة"},
+}
+
+// roleLabels translates server roles for the localized host label, keyed
+// by the language part of the locale.
+var roleLabels = map[string]map[string]string{
+	"en": {"web": "Web server", "db": "Database", "app": "Application", "cache": "Cache", "worker": "Worker"},
+	"de": {"web": "Webserver", "db": "Datenbank", "app": "Anwendung", "cache": "Zwischenspeicher", "worker": "Hintergrundprozess"},
+	"ja": {"web": "ウェブサーバー", "db": "データベース", "app": "アプリケーション", "cache": "キャッシュ", "worker": "ワーカー"},
+	"ar": {"web": "خادم الويب", "db": "قاعدة البيانات", "app": "تطبيق", "cache": "ذاكرة التخزين المؤقت", "worker": "عامل"},
+}
+
+func GenerateRandomServers(count int, rnd *rand.Rand, localized bool) []ServerConfig {
+	locations := defaultLocations
+	if localized {
+		locations = append(append([]Location{}, defaultLocations...), localizedLocations...)
+	}
+
+	servers := make([]ServerConfig, count)
+	for i := 0; i < count; i++ {
+		loc := locations[rnd.Intn(len(locations))]
+		role := roles[rnd.Intn(len(roles))]
+
+		servers[i] = ServerConfig{
+			Index:    i + 1,
+			ID:       fmt.Sprintf("server-%03d", i+1),
+			Hostname: fmt.Sprintf("%s-host-%03d", role, i+1),
+			IPAddress: fmt.Sprintf("10.%d.%d.%d",
+				rnd.Intn(256),
+				rnd.Intn(256),
+				rnd.Intn(256)),
+			Role:     role,
+			Location: loc,
+		}
+		servers[i].Location.Latitude += rnd.Float64()*0.5 - 0.25
+		servers[i].Location.Longitude += rnd.Float64()*0.5 - 0.25
+
+		if localized {
+			lang := loc.Locale[:2]
+			servers[i].Label = fmt.Sprintf("%s %s %03d", loc.CityLocal, roleLabels[lang][role], i+1)
+		}
+	}
+
+	return servers
+}
+
+// GeoPoint serializes as an Elasticsearch geo_point object.
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// DeriveSeed mixes a run seed with a name into a new seed.
+func DeriveSeed(seed int64, name string) int64 {
+	h := fnv.New64a()
+	var b [8]byte
+	for i := range b {
+		b[i] = byte(seed >> (8 * i))
+	}
+	h.Write(b[:])
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,122 @@
+package fleet
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+)
+
+// GeoTruth is what the GeoIP database says about a server's public IP,
+// named like the output of the Elasticsearch geoip processor so enriched
+// fields can be compared against it directly.
+type GeoTruth struct {
+	CountryISOCode string   `json:"country_iso_code,omitempty"`
+	CountryName    string   `json:"country_name,omitempty"`
+	CityName       string   `json:"city_name,omitempty"`
+	Location       GeoPoint `json:"location"`
+}
+
+// reservedIPv4 lists the non-public ranges not covered by the net.IP helpers.
+var reservedIPv4 = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "192.0.2.0/24",
+		"198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24", "240.0.0.0/4",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+func isPublicIPv4(ip net.IP) bool {
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsMulticast() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range reservedIPv4 {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func randomPublicIPv4(rnd *rand.Rand) net.IP {
+	for {
+		ip := net.IPv4(byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256)), byte(rnd.Intn(256)))
+		if isPublicIPv4(ip) {
+			return ip
+		}
+	}
+}
+
+// lookupGeo extracts the City database fields the generator uses.
+func lookupGeo(db *mmdbReader, ip net.IP) (GeoTruth, bool, error) {
+	value, err := db.lookup(ip)
+	if err != nil || value == nil {
+		return GeoTruth{}, false, err
+	}
+	record, _ := value.(map[string]interface{})
+
+	var truth GeoTruth
+	country, _ := record["country"].(map[string]interface{})
+	truth.CountryISOCode, _ = country["iso_code"].(string)
+	truth.CountryName = englishName(country)
+	city, _ := record["city"].(map[string]interface{})
+	truth.CityName = englishName(city)
+
+	location, ok := record["location"].(map[string]interface{})
+	if !ok || truth.CountryName == "" {
+		return truth, false, nil
+	}
+	truth.Location.Lat, _ = location["latitude"].(float64)
+	truth.Location.Lon, _ = location["longitude"].(float64)
+	return truth, true, nil
+}
+
+func englishName(entity map[string]interface{}) string {
+	names, _ := entity["names"].(map[string]interface{})
+	name, _ := names["en"].(string)
+	return name
+}
+
+// assignGeoIPLocations gives every server a public IP that the database
+// knows about and derives its country, city and coordinates from it. The
+// localized names and label of the location it replaces would describe a
+// different place, so they are cleared.
+// Addresses resolving to a city are preferred; after a number of misses a
+// country-level match is accepted.
+func assignGeoIPLocations(servers []ServerConfig, db *mmdbReader, rnd *rand.Rand) error {
+	const maxAttempts = 1000
+	const cityAttempts = 50
+
+	for i := range servers {
+		found := false
+		for attempt := 0; attempt < maxAttempts && !found; attempt++ {
+			ip := randomPublicIPv4(rnd)
+			truth, ok, err := lookupGeo(db, ip)
+			if err != nil {
+				return fmt.Errorf("looking up %s: %w", ip, err)
+			}
+			if !ok || (truth.CityName == "" && attempt < cityAttempts) {
+				continue
+			}
+
+			servers[i].PublicIP = ip.String()
+			servers[i].GeoTruth = &truth
+			servers[i].Location.Country = truth.CountryName
+			servers[i].Location.City = truth.CityName
+			servers[i].Location.Latitude = truth.Location.Lat
+			servers[i].Location.Longitude = truth.Location.Lon
+			servers[i].Location.Locale = ""
+			servers[i].Location.CountryLocal = ""
+			servers[i].Location.CityLocal = ""
+			servers[i].Label = ""
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("no address with a location found in the GeoIP database after %d attempts", maxAttempts)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package fleet
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+)
+
+// Manifest records a fleet and the configuration that produced it, so
+// long-lived demo environments can be compared against earlier runs.
+type Manifest struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Seed        int64             `json:"seed"`
+	Config      map[string]string `json:"config"`
+	Servers     []manifestServer  `json:"servers"`
+}
+
+type manifestServer struct {
+	ID        string  `json:"id"`
+	Fleet     string  `json:"fleet,omitempty"`
+	Hostname  string  `json:"hostname"`
+	IPAddress string  `json:"ip_address"`
+	Role      string  `json:"role"`
+	Label     string  `json:"label,omitempty"`
+	Country   string  `json:"country"`
+	City      string  `json:"city"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	PublicIP  string  `json:"public_ip,omitempty"`
+	IPv6      string  `json:"ipv6_address,omitempty"`
+	PublicIP6 string  `json:"public_ipv6,omitempty"`
+}
+
+// skippedConfigFields are left out of manifests: credentials, the seed
+// (recorded separately) and values that default to the time of each run.
+var skippedConfigFields = map[string]bool{
+	"ESUsername":             true,
+	"ESPassword":             true,
+	"ESAPIKey":               true,
+	"ESCloudID":              true,
+	"DatadogAPIKey":          true,
+	"CloudWatchAccessKeyID":  true,
+	"CloudWatchSecretKey":    true,
+	"CloudWatchSessionToken": true,
+	"LokiPassword":           true,
+	"ClickHousePassword":     true,
+	"MQTTPassword":           true,
+	"NATSPassword":           true,
+	"NATSToken":              true,
+	"NATSURL":                true,
+	"RabbitMQURL":            true,
+	"RedisURL":               true,
+	"WebhookHeaders":         true,
+	"WebhookPassword":        true,
+	"WebhookToken":           true,
+	"OTLPHeaders":            true,
+	"GCSAccessToken":         true,
+	"GCSHMACAccessKey":       true,
+	"GCSHMACSecret":          true,
+	"Seed":                   true,
+	"AgentRolloutStart":      true,
+}
+
+func NewManifest(config config.Config, servers []ServerConfig) Manifest {
+	m := Manifest{
+		GeneratedAt: time.Now().UTC(),
+		Seed:        config.Seed,
+		Config:      make(map[string]string),
+	}
+
+	v := reflect.ValueOf(config)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if skippedConfigFields[name] {
+			continue
+		}
+		m.Config[name] = fmt.Sprint(v.Field(i).Interface())
+	}
+
+	for _, s := range servers {
+		m.Servers = append(m.Servers, manifestServer{
+			ID:        s.ID,
+			Fleet:     s.Fleet,
+			Hostname:  s.Hostname,
+			IPAddress: s.IPAddress,
+			Role:      s.Role,
+			Label:     s.Label,
+			Country:   s.Location.Country,
+			City:      s.Location.City,
+			Latitude:  s.Location.Latitude,
+			Longitude: s.Location.Longitude,
+			PublicIP:  s.PublicIP,
+			IPv6:      s.IPv6Address,
+			PublicIP6: s.PublicIPv6,
+		})
+	}
+	return m
+}
+
+// fields lists the server's attributes in a fixed order for diffing.
+func (s manifestServer) fields() [][2]string {
+	return [][2]string{
+		{"fleet", s.Fleet},
+		{"hostname", s.Hostname},
+		{"ip_address", s.IPAddress},
+		{"role", s.Role},
+		{"label", s.Label},
+		{"country", s.Country},
+		{"city", s.City},
+		{"latitude", strconv.FormatFloat(s.Latitude, 'f', 4, 64)},
+		{"longitude", strconv.FormatFloat(s.Longitude, 'f', 4, 64)},
+		{"public_ip", s.PublicIP},
+		{"ipv6_address", s.IPv6},
+		{"public_ipv6", s.PublicIP6},
+	}
+}
+
+func readFleetManifest(path string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// fieldChange is one attribute that differs between two manifests.
+type fieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type serverChange struct {
+	ID      string        `json:"id"`
+	Changes []fieldChange `json:"changes"`
+}
+
+// fleetDiff is the drift between two manifests.
+type fleetDiff struct {
+	Seed    *fieldChange     `json:"seed,omitempty"`
+	Config  []fieldChange    `json:"config"`
+	Added   []manifestServer `json:"added"`
+	Removed []manifestServer `json:"removed"`
+	Changed []serverChange   `json:"changed"`
+}
+
+func (d fleetDiff) empty() bool {
+	return d.Seed == nil && len(d.Config) == 0 && len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func diffFleetManifests(old, new Manifest) fleetDiff {
+	d := fleetDiff{
+		Config:  []fieldChange{},
+		Added:   []manifestServer{},
+		Removed: []manifestServer{},
+		Changed: []serverChange{},
+	}
+	if old.Seed != new.Seed {
+		d.Seed = &fieldChange{Field: "seed", Old: strconv.FormatInt(old.Seed, 10), New: strconv.FormatInt(new.Seed, 10)}
+	}
+
+	keys := make(map[string]bool)
+	for k := range old.Config {
+		keys[k] = true
+	}
+	for k := range new.Config {
+		keys[k] = true
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if old.Config[k] != new.Config[k] {
+			d.Config = append(d.Config, fieldChange{Field: k, Old: old.Config[k], New: new.Config[k]})
+		}
+	}
+
+	oldServers := make(map[string]manifestServer, len(old.Servers))
+	for _, s := range old.Servers {
+		oldServers[s.ID] = s
+	}
+	seen := make(map[string]bool, len(new.Servers))
+	for _, s := range new.Servers {
+		seen[s.ID] = true
+		prev, ok := oldServers[s.ID]
+		if !ok {
+			d.Added = append(d.Added, s)
+			continue
+		}
+		var changes []fieldChange
+		prevFields := prev.fields()
+		for i, f := range s.fields() {
+			if f[1] != prevFields[i][1] {
+				changes = append(changes, fieldChange{Field: f[0], Old: prevFields[i][1], New: f[1]})
+			}
+		}
+		if len(changes) > 0 {
+			d.Changed = append(d.Changed, serverChange{ID: s.ID, Changes: changes})
+		}
+	}
+	for _, s := range old.Servers {
+		if !seen[s.ID] {
+			d.Removed = append(d.Removed, s)
+		}
+	}
+	return d
+}
+
+func (d fleetDiff) writeText(w io.Writer) {
+	if d.empty() {
+		fmt.Fprintln(w, "No differences")
+		return
+	}
+	if d.Seed != nil {
+		fmt.Fprintf(w, "Seed: %s -> %s\n", d.Seed.Old, d.Seed.New)
+	}
+	if len(d.Config) > 0 {
+		fmt.Fprintln(w, "Config:")
+		for _, c := range d.Config {
+			fmt.Fprintf(w, "  ~ %s: %q -> %q\n", c.Field, c.Old, c.New)
+		}
+	}
+	if len(d.Added)+len(d.Removed)+len(d.Changed) > 0 {
+		fmt.Fprintf(w, "Servers: %d added, %d removed, %d changed\n", len(d.Added), len(d.Removed), len(d.Changed))
+	}
+	for _, s := range d.Added {
+		fmt.Fprintf(w, "  + %s (%s, %s, %s)\n", s.ID, s.Hostname, s.Role, s.City)
+	}
+	for _, s := range d.Removed {
+		fmt.Fprintf(w, "  - %s (%s, %s, %s)\n", s.ID, s.Hostname, s.Role, s.City)
+	}
+	for _, s := range d.Changed {
+		fmt.Fprintf(w, "  ~ %s\n", s.ID)
+		for _, c := range s.Changes {
+			fmt.Fprintf(w, "      %s: %q -> %q\n", c.Field, c.Old, c.New)
+		}
+	}
+}
+
+// Command implements "fleet snapshot [file]", which writes a manifest
+// of the fleet the current configuration produces, and
+// "fleet diff [-json] <old> <new>", which reports the drift between two
+// manifests. Use SEED for fleets that can be compared server by server.
+func Command(config config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: fleet snapshot [file] | fleet diff [-json] <old> <new>")
+	}
+
+	switch args[0] {
+	case "snapshot":
+		servers, err := Build(config)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(NewManifest(config, servers), "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		if len(args) > 1 {
+			return os.WriteFile(args[1], data, 0o644)
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	case "diff":
+		fs := flag.NewFlagSet("fleet diff", flag.ContinueOnError)
+		asJSON := fs.Bool("json", false, "print the diff as JSON")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return fmt.Errorf("usage: fleet diff [-json] <old> <new>")
+		}
+		old, err := readFleetManifest(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		new, err := readFleetManifest(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+
+		d := diffFleetManifests(old, new)
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(d)
+		}
+		d.writeText(os.Stdout)
+		return nil
+	default:
+		return fmt.Errorf("unknown fleet subcommand %q (want snapshot or diff)", args[0])
+	}
+}
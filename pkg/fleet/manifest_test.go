@@ -0,0 +1,42 @@
+package fleet
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+)
+
+// credentialName matches Config fields that look like they hold a secret.
+var credentialName = regexp.MustCompile(`Key|Token|Secret|Password`)
+
+// notCredentials match credentialName but hold no secret.
+var notCredentials = map[string]bool{
+	"ESClientKey":        true, // a path to the key file
+	"RabbitMQRoutingKey": true,
+	"RedisStreamKey":     true,
+}
+
+// TestManifestSkipsCredentials fails when a Config field that looks like a
+// credential would be written to manifests and run metadata.
+func TestManifestSkipsCredentials(t *testing.T) {
+	typ := reflect.TypeOf(config.Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := typ.Field(i).Name
+		if credentialName.MatchString(name) && !skippedConfigFields[name] && !notCredentials[name] {
+			t.Errorf("Config.%s looks like a credential but isn't in skippedConfigFields", name)
+		}
+	}
+}
+
+func TestNewManifestLeavesOutCredentials(t *testing.T) {
+	var cfg config.Config
+	cfg.GCSAccessToken, cfg.GCSHMACSecret, cfg.ESPassword = "token", "secret", "password"
+	m := NewManifest(cfg, nil)
+	for name := range skippedConfigFields {
+		if value, ok := m.Config[name]; ok {
+			t.Errorf("manifest records %s = %q", name, value)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package fleet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// mmdbReader is a minimal reader for MaxMind DB files (GeoLite2/GeoIP2
+// City and compatible databases). It loads the whole file into memory and
+// supports lookups only; see https://maxmind.github.io/MaxMind-DB/.
+type mmdbReader struct {
+	buf         []byte
+	nodeCount   uint
+	recordSize  uint
+	ipVersion   uint
+	dataStart   uint
+	ipv4Root    uint
+	ipv4Checked bool
+}
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind DB file", path)
+	}
+
+	meta := mmdbDecoder{buf: buf[i+len(mmdbMetadataMarker):]}
+	value, _, err := meta.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+
+	r := &mmdbReader{buf: buf}
+	r.nodeCount = uint(toUint64(m["node_count"]))
+	r.recordSize = uint(toUint64(m["record_size"]))
+	r.ipVersion = uint(toUint64(m["ip_version"]))
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	r.dataStart = r.nodeCount*r.recordSize/4 + 16
+	if r.dataStart > uint(len(buf)) {
+		return nil, errors.New("search tree exceeds file size")
+	}
+	return r, nil
+}
+
+// lookup returns the data record for ip, or nil if the database has none.
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	bits := ip.To16()
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		if r.ipVersion == 6 {
+			node = r.ipv4StartNode()
+		}
+	} else if r.ipVersion == 4 {
+		return nil, errors.New("IPv6 lookup in an IPv4-only database")
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		bit := (bits[i/8] >> (7 - uint(i%8))) & 1
+		node = r.readRecord(node, uint(bit))
+	}
+
+	switch {
+	case node == r.nodeCount:
+		return nil, nil
+	case node > r.nodeCount:
+		offset := node - r.nodeCount - 16
+		d := mmdbDecoder{buf: r.buf[r.dataStart:]}
+		value, _, err := d.decode(offset)
+		return value, err
+	default:
+		return nil, errors.New("invalid search tree")
+	}
+}
+
+// ipv4StartNode follows the 96 leading zero bits that IPv4 addresses
+// have in an IPv6 search tree.
+func (r *mmdbReader) ipv4StartNode() uint {
+	if r.ipv4Checked {
+		return r.ipv4Root
+	}
+	node := uint(0)
+	for i := 0; i < 96 && node < r.nodeCount; i++ {
+		node = r.readRecord(node, 0)
+	}
+	r.ipv4Root, r.ipv4Checked = node, true
+	return node
+}
+
+func (r *mmdbReader) readRecord(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		b := r.buf[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.buf[node*7 : node*7+7]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(r.buf[off : off+4]))
+	}
+}
+
+// mmdbDecoder decodes the MaxMind DB data section format.
+type mmdbDecoder struct {
+	buf []byte
+}
+
+const (
+	mmdbPointer   = 1
+	mmdbString    = 2
+	mmdbDouble    = 3
+	mmdbBytes     = 4
+	mmdbUint16    = 5
+	mmdbUint32    = 6
+	mmdbMap       = 7
+	mmdbInt32     = 8
+	mmdbUint64    = 9
+	mmdbUint128   = 10
+	mmdbArray     = 11
+	mmdbContainer = 12
+	mmdbEndMarker = 13
+	mmdbBool      = 14
+	mmdbFloat     = 15
+)
+
+var errMMDBTruncated = errors.New("truncated MaxMind DB data")
+
+// decode decodes the value at offset and returns it with the offset of
+// the next value.
+func (d *mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errMMDBTruncated
+	}
+	ctrl := d.buf[offset]
+	offset++
+
+	typ := uint(ctrl >> 5)
+	if typ == mmdbPointer {
+		pointer, next, err := d.decodePointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+	if typ == 0 {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errMMDBTruncated
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, errMMDBTruncated
+		}
+		var extra uint
+		for _, b := range d.buf[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, _ := key.(string)
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errMMDBTruncated
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case mmdbString:
+		return string(b), next, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbUint128:
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, next, nil
+	case mmdbInt32:
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), next, nil
+	case mmdbContainer, mmdbEndMarker:
+		return nil, next, nil
+	}
+	return nil, 0, fmt.Errorf("unknown MaxMind DB type %d", typ)
+}
+
+func (d *mmdbDecoder) decodePointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint((ctrl>>3)&0x3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errMMDBTruncated
+	}
+	b := d.buf[offset : offset+n]
+
+	var pointer uint
+	if n == 4 {
+		pointer = uint(binary.BigEndian.Uint32(b))
+	} else {
+		pointer = uint(ctrl & 0x7)
+		for _, c := range b {
+			pointer = pointer<<8 | uint(c)
+		}
+		switch n {
+		case 2:
+			pointer += 2048
+		case 3:
+			pointer += 526336
+		}
+	}
+	return pointer, offset + n, nil
+}
+
+func toUint64(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		return uint64(n)
+	}
+	return 0
+}
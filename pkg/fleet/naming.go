@@ -0,0 +1,130 @@
+package fleet
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+)
+
+// The templates that reproduce the built-in names.
+const (
+	defaultIDTemplate       = `{{with .Fleet}}{{.}}-{{end}}server-{{printf "%03d" .Index}}`
+	defaultHostnameTemplate = `{{with .Fleet}}{{.}}-{{end}}{{.Role}}-host-{{printf "%03d" .Index}}`
+)
+
+var namingFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	// replace takes the string last so it can end a pipeline:
+	// {{.City | replace " " "_"}}
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	// slug lowercases s and turns everything but letters and digits into
+	// single dashes, for names like "New York" in hostnames
+	"slug": func(s string) string {
+		return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}), "-")
+	},
+}
+
+// nameData is what ID and hostname templates see of a server.
+type nameData struct {
+	Index   int // position in its fleet, from 1
+	Role    string
+	City    string
+	Country string
+	Fleet   string
+}
+
+// naming renders server IDs and hostnames from SERVER_ID_TEMPLATE and
+// HOSTNAME_TEMPLATE.
+type naming struct {
+	id, hostname *template.Template
+}
+
+// parseNaming returns nil, keeping the built-in names, unless a template
+// is configured. A missing template falls back to the built-in one.
+func parseNaming(config config.Config) (*naming, error) {
+	if config.ServerIDTemplate == "" && config.HostnameTemplate == "" {
+		return nil, nil
+	}
+	n := &naming{}
+	var err error
+	if n.id, err = parseNameTemplate("SERVER_ID_TEMPLATE", config.ServerIDTemplate, defaultIDTemplate); err != nil {
+		return nil, err
+	}
+	if n.hostname, err = parseNameTemplate("HOSTNAME_TEMPLATE", config.HostnameTemplate, defaultHostnameTemplate); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func parseNameTemplate(name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	t, err := template.New(name).Funcs(namingFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// apply renames server from the templates.
+func (n *naming) apply(server *ServerConfig) error {
+	data := nameData{
+		Index:   server.Index,
+		Role:    server.Role,
+		City:    server.Location.City,
+		Country: server.Location.Country,
+		Fleet:   server.Fleet,
+	}
+	var id, hostname strings.Builder
+	if err := n.id.Execute(&id, data); err != nil {
+		return fmt.Errorf("rendering SERVER_ID_TEMPLATE: %w", err)
+	}
+	if err := n.hostname.Execute(&hostname, data); err != nil {
+		return fmt.Errorf("rendering HOSTNAME_TEMPLATE: %w", err)
+	}
+	if id.Len() == 0 || hostname.Len() == 0 {
+		return fmt.Errorf("empty server ID or hostname rendered for server %d of fleet %q", server.Index, server.Fleet)
+	}
+	server.ID, server.Hostname = id.String(), hostname.String()
+	return nil
+}
+
+// checkUnique reports the first server ID or hostname that templates gave
+// to more than one server.
+func checkUnique(servers []ServerConfig) error {
+	ids := make(map[string]bool, len(servers))
+	hostnames := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		if ids[s.ID] {
+			return fmt.Errorf("SERVER_ID_TEMPLATE gives several servers the ID %q", s.ID)
+		}
+		if hostnames[s.Hostname] {
+			return fmt.Errorf("HOSTNAME_TEMPLATE gives several servers the hostname %q", s.Hostname)
+		}
+		ids[s.ID], hostnames[s.Hostname] = true, true
+	}
+	return nil
+}
+
+// Rename renders the ID and hostname of server again after its role
+// changed, with the configured templates or the built-in names.
+func Rename(config config.Config, server *ServerConfig) error {
+	n, err := parseNaming(config)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		n = &naming{
+			id:       template.Must(parseNameTemplate("SERVER_ID_TEMPLATE", "", defaultIDTemplate)),
+			hostname: template.Must(parseNameTemplate("HOSTNAME_TEMPLATE", "", defaultHostnameTemplate)),
+		}
+	}
+	return n.apply(server)
+}
@@ -0,0 +1,210 @@
+package generate
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// Anomaly overrides metric values on a set of servers for a time window.
+type Anomaly struct {
+	Name    string
+	Kind    string
+	Targets serverSelector
+	Start   time.Time
+	End     time.Time
+	// Values returns the overridden metric values, keyed by field name,
+	// at the given time since Start.
+	Values func(elapsed time.Duration) map[string]float64
+	// Offsets shifts metric values by these amounts, keyed by field name,
+	// rather than replacing them.
+	Offsets map[string]float64
+	// Outage stops the targeted servers from reporting at all.
+	Outage bool
+	// Loss drops this share of the targeted servers' reports, as on a
+	// saturated network.
+	Loss float64
+}
+
+func (a *Anomaly) activeAt(t time.Time) bool {
+	return !t.Before(a.Start) && t.Before(a.End)
+}
+
+// anomalySet holds the scheduled and active anomalies.
+type anomalySet struct {
+	mu    sync.RWMutex
+	items []*Anomaly
+}
+
+func (s *anomalySet) Add(a *Anomaly) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = append(s.items, a)
+}
+
+// prune drops the anomalies that ended before t.
+func (s *anomalySet) prune(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.items[:0]
+	for _, a := range s.items {
+		if t.Before(a.End) {
+			kept = append(kept, a)
+		}
+	}
+	clear(s.items[len(kept):])
+	s.items = kept
+}
+
+// Active returns the anomalies affecting server at t.
+func (s *anomalySet) Active(server fleet.ServerConfig, t time.Time) []*Anomaly {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var active []*Anomaly
+	for _, a := range s.items {
+		if a.activeAt(t) && a.Targets.matches(server) {
+			active = append(active, a)
+		}
+	}
+	return active
+}
+
+// apply overrides the values of metric with those of active anomalies
+// and shifts them by their offsets. Later anomalies win when several
+// touch the same metric.
+func (s *anomalySet) apply(server fleet.ServerConfig, metric *MetricData) {
+	for _, a := range s.Active(server, metric.Timestamp) {
+		for name, offset := range a.Offsets {
+			if field := metricField(metric, name); field != nil {
+				*field = roundFloat(clampPercent(*field+offset), 2)
+			}
+		}
+		if a.Values == nil {
+			continue
+		}
+		for name, v := range a.Values(metric.Timestamp.Sub(a.Start)) {
+			if field := metricField(metric, name); field != nil {
+				*field = roundFloat(clampPercent(v), 2)
+			}
+		}
+	}
+}
+
+// down reports whether an outage keeps server from reporting at t, or
+// packet loss drops its report.
+func (s *anomalySet) down(server fleet.ServerConfig, t time.Time) bool {
+	for _, a := range s.Active(server, t) {
+		if a.Outage {
+			return true
+		}
+		if a.Loss > 0 {
+			h := fnv.New64a()
+			fmt.Fprintf(h, "%s/%s/%d", a.Name, server.ID, t.Unix())
+			if float64(h.Sum64()%1000000)/1000000 < a.Loss {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// metricField returns a pointer to the named usage metric of m, or nil.
+func metricField(m *MetricData, name string) *float64 {
+	switch name {
+	case "cpu_usage", "cpu":
+		return &m.CPUUsage
+	case "memory_usage", "memory":
+		return &m.MemoryUsage
+	case "disk_usage", "disk":
+		return &m.DiskUsage
+	}
+	return nil
+}
+
+func clampPercent(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 100 {
+		return 100
+	}
+	return v
+}
+
+// serverSelector picks servers by ID, hostname, role, fleet, location or
+// a stable share of the fleet.
+type serverSelector struct {
+	all       bool
+	names     map[string]bool
+	roles     map[string]bool
+	fleets    map[string]bool
+	countries map[string]bool
+	cities    map[string]bool
+	percent   float64
+}
+
+// parseServerSelector parses a comma-separated list of server IDs or
+// hostnames, "role:<role>", "fleet:<fleet>", "country:<country>",
+// "city:<city>", "<n>%" (a stable share of all servers) or "all".
+func parseServerSelector(spec string) (serverSelector, error) {
+	sel := serverSelector{
+		names:     map[string]bool{},
+		roles:     map[string]bool{},
+		fleets:    map[string]bool{},
+		countries: map[string]bool{},
+		cities:    map[string]bool{},
+	}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+		case item == "all" || item == "*":
+			sel.all = true
+		case strings.HasPrefix(item, "role:"):
+			sel.roles[strings.TrimPrefix(item, "role:")] = true
+		case strings.HasPrefix(item, "fleet:"):
+			sel.fleets[strings.TrimPrefix(item, "fleet:")] = true
+		case strings.HasPrefix(item, "country:"):
+			sel.countries[strings.TrimPrefix(item, "country:")] = true
+		case strings.HasPrefix(item, "city:"):
+			sel.cities[strings.TrimPrefix(item, "city:")] = true
+		case strings.HasSuffix(item, "%"):
+			p, err := strconv.ParseFloat(strings.TrimSuffix(item, "%"), 64)
+			if err != nil || p < 0 || p > 100 {
+				return sel, fmt.Errorf("invalid server percentage %q", item)
+			}
+			sel.percent = p / 100
+		default:
+			sel.names[item] = true
+		}
+	}
+	if !sel.all && len(sel.names) == 0 && len(sel.roles) == 0 && len(sel.fleets) == 0 &&
+		len(sel.countries) == 0 && len(sel.cities) == 0 && sel.percent == 0 {
+		return sel, fmt.Errorf("empty server selector %q", spec)
+	}
+	return sel, nil
+}
+
+func (s serverSelector) matches(server fleet.ServerConfig) bool {
+	if s.all || s.names[server.ID] || s.names[server.Hostname] || s.roles[server.Role] {
+		return true
+	}
+	if s.fleets[server.Fleet] || s.countries[server.Location.Country] || s.cities[server.Location.City] {
+		return true
+	}
+	if s.percent > 0 {
+		h := fnv.New64a()
+		h.Write([]byte(server.ID))
+		return float64(h.Sum64()%1000000)/1000000 < s.percent
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package generate
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// CacheMetrics are the metrics of the cache workload profile, inlined in
+// the metric document.
+type CacheMetrics struct {
+	HitRatio         float64 `json:"cache_hit_ratio"`
+	Evictions        int     `json:"cache_evictions"` // Over the interval
+	Keys             int64   `json:"cache_keys"`
+	ConnectedClients int     `json:"cache_connected_clients"`
+}
+
+// cacheState is a server's keyspace size carried between reports.
+type cacheState struct {
+	keys float64
+}
+
+// cacheShape returns the operations per second a server serves at 50% CPU
+// and the size its keyspace settles at, hashing the server ID so both are
+// stable across runs.
+func cacheShape(server fleet.ServerConfig) (ops, keys float64) {
+	h := fnv.New32a()
+	h.Write([]byte(server.ID))
+	sum := h.Sum32()
+	return 5000 + float64(sum%45000), 100000 + float64((sum>>8)%4900000)
+}
+
+// applyCache sets the cache metrics of metric for the interval since the
+// server's last report. Traffic and clients follow load, the CPU usage
+// before anomalies. Keys are evicted once memory runs short. During an
+// incident the hit ratio collapses as entries go missing and clients
+// reconnect.
+func applyCache(server fleet.ServerConfig, metric *MetricData, state *cacheState, load float64, incident bool, interval time.Duration, rnd *rand.Rand) {
+	ops, target := cacheShape(server)
+	ops *= load / 50 * (0.9 + rnd.Float64()*0.2)
+	if state.keys == 0 {
+		state.keys = target * (0.9 + rnd.Float64()*0.2)
+	}
+
+	// Every point of memory usage above 80% evicts a key on one operation
+	// in a thousand; the keyspace drifts back to its usual size
+	evictions := poisson(ops*interval.Seconds()*0.001*math.Max(metric.MemoryUsage-80, 0), rnd)
+	state.keys += (target-state.keys)*0.05 + target*rnd.NormFloat64()*0.002 - float64(evictions)
+	state.keys = math.Max(state.keys, 0)
+
+	hit := 0.95 + rnd.Float64()*0.03 - math.Min(float64(evictions)/(state.keys+1), 0.1)
+	clients := 50 + int(load*2) + rnd.Intn(10)
+	if incident {
+		hit = 0.4 + rnd.Float64()*0.3
+		clients *= 2
+	}
+
+	metric.CacheMetrics = &CacheMetrics{
+		HitRatio:         roundFloat(math.Max(hit, 0), 4),
+		Evictions:        evictions,
+		Keys:             int64(state.keys),
+		ConnectedClients: clients,
+	}
+}
@@ -0,0 +1,101 @@
+package generate
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// cardinalityRates adds high-cardinality fields to metrics, to stress
+// time series databases and Elasticsearch mappings. Each rate is the
+// share of documents that get a value never seen before:
+//
+//   - container_id and pod_name are always present and rotate to a new
+//     value at the rate, like short-lived containers and pods
+//   - request_id is a new random ID on that share of documents
+//   - label_key adds a label under a new key, growing the mapping by one
+//     field each time
+type cardinalityRates struct {
+	containerID float64
+	podName     float64
+	requestID   float64
+	labelKey    float64
+}
+
+// cardinalityState is a server's current container and pod.
+type cardinalityState struct {
+	containerID string
+	podName     string
+}
+
+// parseCardinalityRates parses HIGH_CARDINALITY_RATES, a comma-separated
+// list of "kind=rate" with kinds container_id, pod_name, request_id and
+// label_key, e.g. "container_id=0.1,request_id=1".
+func parseCardinalityRates(spec string) (*cardinalityRates, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	c := &cardinalityRates{}
+	for _, entry := range strings.Split(spec, ",") {
+		kind, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid cardinality rate %q (want kind=rate)", entry)
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid rate in cardinality rate %q", entry)
+		}
+		switch kind {
+		case "container_id":
+			c.containerID = rate
+		case "pod_name":
+			c.podName = rate
+		case "request_id":
+			c.requestID = rate
+		case "label_key":
+			c.labelKey = rate
+		default:
+			return nil, fmt.Errorf("unknown cardinality kind %q (want container_id, pod_name, request_id or label_key)", kind)
+		}
+	}
+	return c, nil
+}
+
+// apply sets the high-cardinality fields of metric, rotating the server's
+// container and pod in state as the rates say.
+func (c *cardinalityRates) apply(metric *MetricData, role string, state *cardinalityState, rnd *rand.Rand) {
+	if c == nil {
+		return
+	}
+
+	if c.containerID > 0 {
+		if state.containerID == "" || rnd.Float64() < c.containerID {
+			state.containerID = randomHex(rnd, 32)
+		}
+		metric.ContainerID = state.containerID
+	}
+	if c.podName > 0 {
+		if state.podName == "" || rnd.Float64() < c.podName {
+			state.podName = role + "-" + randomKubeSuffix(rnd, 10) + "-" + randomKubeSuffix(rnd, 5)
+		}
+		metric.PodName = state.podName
+	}
+	if c.requestID > 0 && rnd.Float64() < c.requestID {
+		var id [16]byte
+		rnd.Read(id[:])
+		metric.RequestID = formatUUID(id)
+	}
+	if c.labelKey > 0 && rnd.Float64() < c.labelKey {
+		metric.Labels = map[string]string{"l_" + randomHex(rnd, 6): randomKubeSuffix(rnd, 5)}
+	}
+}
+
+// randomHex returns n random bytes as hex.
+func randomHex(rnd *rand.Rand, n int) string {
+	b := make([]byte, n)
+	rnd.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,238 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// ChangeEvent is a deploy, configuration change or scaling event. Impact
+// is the shift the change causes in the hosts' metrics from ImpactStart
+// until ImpactEnd, the ground truth for change correlation.
+type ChangeEvent struct {
+	Timestamp       time.Time          `json:"@timestamp"`
+	EventID         string             `json:"event_id"`
+	ChangeType      string             `json:"change_type"` // deploy, rollback, config or scale
+	Service         string             `json:"service"`
+	Version         string             `json:"version,omitempty"`
+	PreviousVersion string             `json:"previous_version,omitempty"`
+	Description     string             `json:"description"`
+	Initiator       string             `json:"initiator"`
+	Hosts           []string           `json:"hosts"`
+	HostCount       int                `json:"host_count"`
+	Impact          map[string]float64 `json:"impact,omitempty"`
+	ImpactStart     *time.Time         `json:"impact_start,omitempty"`
+	ImpactEnd       *time.Time         `json:"impact_end,omitempty"`
+}
+
+// changeGenerator schedules deploys and configuration changes, arriving on
+// average every mean, and shifts the metrics of the hosts they touch a
+// little later so incidents can be correlated with changes. Scaling
+// events are recorded when autoscaling groups resize.
+type changeGenerator struct {
+	mean     time.Duration
+	index    sink.IndexNamer
+	rnd      *rand.Rand
+	next     time.Time
+	count    int
+	versions map[string]string // Running version of each role
+	releases map[string]string // Latest release of each role, rolled back or not
+	pending  []ChangeEvent     // Events due at their timestamp, such as rollbacks
+}
+
+// newChangeGenerator returns nil, disabling change events, unless
+// enabled.
+func newChangeGenerator(enabled bool, mean time.Duration, index string, seed int64) (*changeGenerator, error) {
+	if !enabled {
+		return nil, nil
+	}
+	if mean <= 0 {
+		return nil, fmt.Errorf("invalid mean time between changes %s", mean)
+	}
+	return &changeGenerator{
+		mean:     mean,
+		index:    sink.NewIndexNamer(index),
+		rnd:      rand.New(rand.NewSource(fleet.DeriveSeed(seed, "changes"))),
+		versions: make(map[string]string),
+		releases: make(map[string]string),
+	}, nil
+}
+
+// record queues an event that happened elsewhere, such as a scaling
+// event, for the next tick.
+func (c *changeGenerator) record(e ChangeEvent) {
+	if c == nil {
+		return
+	}
+	c.count++
+	e.EventID = fmt.Sprintf("chg-%06d", c.count)
+	e.HostCount = len(e.Hosts)
+	c.pending = append(c.pending, e)
+}
+
+// schedule starts the changes due by now on servers, adding the anomalies
+// that carry their impact, and returns the events due.
+func (c *changeGenerator) schedule(now time.Time, servers []fleet.ServerConfig, anomalies *anomalySet) []ChangeEvent {
+	if c.next.IsZero() {
+		c.next = now.Add(c.gap())
+	}
+	if !now.Before(c.next) && len(servers) > 0 {
+		c.next = now.Add(c.gap())
+		anomalies.prune(now)
+		c.change(now, servers, anomalies)
+	}
+
+	var due []ChangeEvent
+	kept := c.pending[:0]
+	for _, e := range c.pending {
+		if now.Before(e.Timestamp) {
+			kept = append(kept, e)
+		} else {
+			due = append(due, e)
+		}
+	}
+	c.pending = kept
+	return due
+}
+
+// gap returns the time until the next change, exponentially distributed
+// around the mean.
+func (c *changeGenerator) gap() time.Duration {
+	return time.Duration(c.rnd.ExpFloat64() * float64(c.mean))
+}
+
+// change makes a random deploy or configuration change at now. Deploys go
+// out to a whole role: most cause a short blip while instances restart,
+// the rest a regression until a rollback some time later. Configuration
+// changes touch one server or a role and now and then raise memory usage.
+func (c *changeGenerator) change(now time.Time, servers []fleet.ServerConfig, anomalies *anomalySet) {
+	role := servers[c.rnd.Intn(len(servers))].Role
+	e := ChangeEvent{Timestamp: now, Service: role}
+	targets := "role:" + role
+	var impact map[string]float64
+	var effect time.Duration
+	var regression bool
+
+	if c.rnd.Float64() < 0.7 {
+		previous := c.version(role)
+		e.ChangeType, e.Version, e.PreviousVersion = "deploy", c.bump(c.releases[role]), previous
+		e.Description = fmt.Sprintf("Deploy %s %s", role, e.Version)
+		e.Initiator = "ci-pipeline"
+		c.versions[role], c.releases[role] = e.Version, e.Version
+
+		if regression = c.rnd.Float64() < 0.35; regression {
+			impact = map[string]float64{"cpu": roundFloat(10+c.rnd.Float64()*15, 1)}
+			if c.rnd.Intn(2) == 0 {
+				impact["memory"] = roundFloat(5+c.rnd.Float64()*10, 1)
+			}
+			effect = time.Duration(20+c.rnd.Intn(100)) * time.Minute
+		} else {
+			impact = map[string]float64{"cpu": roundFloat(5+c.rnd.Float64()*10, 1)}
+			effect = time.Duration(2+c.rnd.Intn(4)) * time.Minute
+		}
+	} else {
+		e.ChangeType, e.Initiator = "config", "ops"
+		if c.rnd.Float64() < 0.6 {
+			server := servers[c.rnd.Intn(len(servers))]
+			e.Service, targets = server.Role, server.ID
+		}
+		e.Description = fmt.Sprintf("Update %s configuration", e.Service)
+		if c.rnd.Float64() < 0.4 {
+			impact = map[string]float64{"memory": roundFloat(8+c.rnd.Float64()*12, 1)}
+			effect = time.Duration(30+c.rnd.Intn(90)) * time.Minute
+		}
+	}
+
+	sel, _ := parseServerSelector(targets)
+	for _, server := range servers {
+		if sel.matches(server) {
+			e.Hosts = append(e.Hosts, server.ID)
+		}
+	}
+	sort.Strings(e.Hosts)
+
+	if impact != nil {
+		// Metrics shift a little after the change, once it has rolled out
+		start := now.Add(time.Duration(1+c.rnd.Intn(3)) * time.Minute)
+		end := start.Add(effect)
+		e.Impact, e.ImpactStart, e.ImpactEnd = impact, &start, &end
+		anomalies.Add(&Anomaly{
+			Name:    fmt.Sprintf("chg-%06d", c.count+1), // the event's ID
+			Kind:    e.ChangeType,
+			Targets: sel,
+			Start:   start,
+			End:     end,
+			Offsets: impact,
+		})
+	}
+	c.record(e)
+	slog.Info("Change", "type", e.ChangeType, "service", e.Service, "version", e.Version, "hosts", len(e.Hosts))
+
+	// Regressions are rolled back once they are noticed, which ends them
+	if regression {
+		c.record(ChangeEvent{
+			Timestamp:       *e.ImpactEnd,
+			ChangeType:      "rollback",
+			Service:         role,
+			Version:         e.PreviousVersion,
+			PreviousVersion: e.Version,
+			Description:     fmt.Sprintf("Roll back %s to %s", role, e.PreviousVersion),
+			Initiator:       "on-call",
+			Hosts:           e.Hosts,
+		})
+		c.versions[role] = e.PreviousVersion
+	}
+}
+
+// version returns the version role runs, making one up the first time.
+func (c *changeGenerator) version(role string) string {
+	if v, ok := c.versions[role]; ok {
+		return v
+	}
+	v := fmt.Sprintf("1.%d.%d", c.rnd.Intn(10), c.rnd.Intn(20))
+	c.versions[role], c.releases[role] = v, v
+	return v
+}
+
+// bump returns the next patch release of version, or now and then the
+// next minor one.
+func (c *changeGenerator) bump(version string) string {
+	var major, minor, patch int
+	fmt.Sscanf(version, "%d.%d.%d", &major, &minor, &patch)
+	if c.rnd.Float64() < 0.3 {
+		return fmt.Sprintf("%d.%d.0", major, minor+1)
+	}
+	return fmt.Sprintf("%d.%d.%d", major, minor, patch+1)
+}
+
+// changeDocuments schedules the changes due by now and encodes their
+// events.
+func (mg *MetricGenerator) changeDocuments(now time.Time) []sink.Document {
+	if mg.changes == nil {
+		return nil
+	}
+	events := mg.changes.schedule(now, mg.servers, mg.anomalies)
+	docs := make([]sink.Document, 0, len(events))
+	for _, e := range events {
+		body, err := json.Marshal(e)
+		if err != nil {
+			slog.Error("Error marshaling change event", "event_id", e.EventID, "error", err)
+			continue
+		}
+		docs = append(docs, sink.Document{
+			Type:      "event",
+			ServerID:  e.Service,
+			Role:      e.Service,
+			Timestamp: e.Timestamp,
+			Index:     mg.changes.index(e.Timestamp),
+			Body:      body,
+		})
+	}
+	return docs
+}
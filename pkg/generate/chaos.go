@@ -0,0 +1,130 @@
+package generate
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// chaosKinds lists the incidents chaos mode picks from.
+var chaosKinds = []string{"spike", "outage", "leak", "network"}
+
+// chaosMonkey schedules a stream of random incidents, arriving on average
+// every mean, for long-running alert-tuning environments.
+type chaosMonkey struct {
+	mean  time.Duration
+	kinds []string
+	rnd   *rand.Rand
+	next  time.Time
+	count int
+}
+
+// newChaosMonkey returns nil, disabling chaos mode, unless enabled. kinds
+// is a comma-separated subset of chaosKinds, all of them when empty.
+func newChaosMonkey(enabled bool, mean time.Duration, kinds string, seed int64) (*chaosMonkey, error) {
+	if !enabled {
+		return nil, nil
+	}
+	if mean <= 0 {
+		return nil, fmt.Errorf("invalid mean time between incidents %s", mean)
+	}
+	c := &chaosMonkey{mean: mean, rnd: rand.New(rand.NewSource(fleet.DeriveSeed(seed, "chaos")))}
+	if strings.TrimSpace(kinds) == "" {
+		c.kinds = chaosKinds
+		return c, nil
+	}
+	for _, kind := range strings.Split(kinds, ",") {
+		kind = strings.TrimSpace(kind)
+		known := false
+		for _, k := range chaosKinds {
+			known = known || k == kind
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown chaos incident %q (want %s)", kind, strings.Join(chaosKinds, ", "))
+		}
+		c.kinds = append(c.kinds, kind)
+	}
+	return c, nil
+}
+
+// schedule starts the incidents due by now on servers, and drops the
+// anomalies that have ended so a long run doesn't pile them up.
+func (c *chaosMonkey) schedule(now time.Time, servers []fleet.ServerConfig, anomalies *anomalySet) {
+	if c == nil || len(servers) == 0 {
+		return
+	}
+	if c.next.IsZero() {
+		c.next = now.Add(c.gap())
+	}
+	if now.Before(c.next) {
+		return
+	}
+	c.next = now.Add(c.gap())
+	anomalies.prune(now)
+
+	c.count++
+	a, targets := c.incident(now, servers)
+	anomalies.Add(a)
+	slog.Info("Chaos incident", "name", a.Name, "kind", a.Kind, "servers", targets, "start", a.Start, "end", a.End)
+}
+
+// gap returns the time until the next incident, exponentially distributed
+// around the mean so incidents arrive as a Poisson process.
+func (c *chaosMonkey) gap() time.Duration {
+	return time.Duration(c.rnd.ExpFloat64() * float64(c.mean))
+}
+
+// incident returns a random incident starting at now and the selector of
+// the servers it hits. Most hit a single server; the rest take out a
+// whole role or a share of the fleet.
+func (c *chaosMonkey) incident(now time.Time, servers []fleet.ServerConfig) (*Anomaly, string) {
+	var targets string
+	switch p := c.rnd.Float64(); {
+	case p < 0.6:
+		targets = servers[c.rnd.Intn(len(servers))].ID
+	case p < 0.85:
+		targets = "role:" + servers[c.rnd.Intn(len(servers))].Role
+	default:
+		targets = fmt.Sprintf("%d%%", 5+5*c.rnd.Intn(2))
+	}
+	sel, _ := parseServerSelector(targets)
+
+	kind := c.kinds[c.rnd.Intn(len(c.kinds))]
+	duration := time.Duration(5+c.rnd.Intn(26)) * time.Minute
+	a := &Anomaly{
+		Name:    fmt.Sprintf("chaos-%d", c.count),
+		Kind:    kind,
+		Targets: sel,
+		Start:   now,
+		End:     now.Add(duration),
+	}
+	switch kind {
+	case "spike":
+		values := map[string]float64{"cpu": 90 + c.rnd.Float64()*10}
+		if c.rnd.Intn(3) == 0 {
+			values = map[string]float64{"memory": 92 + c.rnd.Float64()*8}
+		}
+		a.Values = func(time.Duration) map[string]float64 { return values }
+	case "outage":
+		a.End = now.Add((duration / 3).Round(time.Minute))
+		a.Outage = true
+	case "leak":
+		// Memory climbs steadily until the process would be killed
+		from := 55 + c.rnd.Float64()*15
+		a.Values = func(elapsed time.Duration) map[string]float64 {
+			frac := min(float64(elapsed)/float64(duration), 1)
+			return map[string]float64{"memory": from + (99-from)*frac}
+		}
+	case "network":
+		// A saturated link drops reports and keeps the CPU busy with
+		// retransmissions
+		cpu := 70 + c.rnd.Float64()*15
+		a.Loss = 0.3 + c.rnd.Float64()*0.4
+		a.Values = func(time.Duration) map[string]float64 { return map[string]float64{"cpu": cpu} }
+	}
+	return a, targets
+}
@@ -0,0 +1,297 @@
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// fleetChurn adds and removes servers while the generator runs: servers
+// joining and being decommissioned at random, and autoscaling groups
+// following their load, so dashboards see hosts appear and disappear.
+type fleetChurn struct {
+	joinRate  float64 // Servers per hour
+	leaveRate float64
+	groups    []*autoscalingGroup
+	cooldown  time.Duration
+	rnd       *rand.Rand
+	built     int // Servers built so far; new servers are numbered on from here
+}
+
+// autoscalingGroup is the servers of one role, scaled between min and max
+// to keep their average CPU usage near target. The group shares a fixed
+// load: base servers would carry it at the model's CPU usage, and each
+// server's share shrinks as the group grows.
+type autoscalingGroup struct {
+	role     string
+	min, max int
+	target   float64
+	base     int
+	size     int
+	scaledAt time.Time
+}
+
+// parseFleetChurn parses FLEET_CHURN, a comma-separated list of
+// "join=<servers per hour>" and "leave=<servers per hour>", and
+// AUTOSCALING_GROUPS, a semicolon-separated list of
+// "<role>=<min>-<max>@<target CPU>", e.g. "web=2-20@60;worker=1-8@70".
+func parseFleetChurn(config config.Config, servers []fleet.ServerConfig) (*fleetChurn, error) {
+	if strings.TrimSpace(config.FleetChurn) == "" && strings.TrimSpace(config.AutoscalingGroups) == "" {
+		return nil, nil
+	}
+	if config.Fleets != "" {
+		return nil, errors.New("fleet churn is not supported with FLEETS")
+	}
+
+	c := &fleetChurn{
+		cooldown: config.AutoscalingCooldown,
+		rnd:      rand.New(rand.NewSource(fleet.DeriveSeed(config.Seed, "churn"))),
+		built:    len(servers),
+	}
+	if c.cooldown <= 0 {
+		c.cooldown = 5 * time.Minute
+	}
+
+	for _, entry := range strings.Split(config.FleetChurn, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kind, value, ok := strings.Cut(entry, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSuffix(value, "/h"), 64)
+		if !ok || err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid fleet churn %q (want join=<servers per hour> or leave=<servers per hour>)", entry)
+		}
+		switch kind {
+		case "join":
+			c.joinRate = rate
+		case "leave":
+			c.leaveRate = rate
+		default:
+			return nil, fmt.Errorf("unknown fleet churn kind %q (want join or leave)", kind)
+		}
+	}
+
+	roles := make(map[string]bool)
+	for _, entry := range strings.Split(config.AutoscalingGroups, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		role, spec, ok := strings.Cut(entry, "=")
+		bounds, target, ok2 := strings.Cut(spec, "@")
+		minSpec, maxSpec, ok3 := strings.Cut(bounds, "-")
+		if !ok || !ok2 || !ok3 || role == "" {
+			return nil, fmt.Errorf("invalid autoscaling group %q (want role=min-max@target)", entry)
+		}
+		if roles[role] {
+			return nil, fmt.Errorf("duplicate autoscaling group %q", role)
+		}
+		roles[role] = true
+
+		g := &autoscalingGroup{role: role}
+		var err error
+		if g.min, err = strconv.Atoi(minSpec); err != nil || g.min < 1 {
+			return nil, fmt.Errorf("invalid minimum in autoscaling group %q", entry)
+		}
+		if g.max, err = strconv.Atoi(maxSpec); err != nil || g.max < g.min {
+			return nil, fmt.Errorf("invalid maximum in autoscaling group %q", entry)
+		}
+		if g.target, err = strconv.ParseFloat(target, 64); err != nil || g.target <= 0 || g.target > 100 {
+			return nil, fmt.Errorf("invalid target CPU in autoscaling group %q", entry)
+		}
+		for _, server := range servers {
+			if server.Role == role {
+				g.size++
+			}
+		}
+		g.base = max(g.size, g.min)
+		c.groups = append(c.groups, g)
+	}
+	return c, nil
+}
+
+// group returns the autoscaling group of role, or nil.
+func (c *fleetChurn) group(role string) *autoscalingGroup {
+	for _, g := range c.groups {
+		if g.role == role {
+			return g
+		}
+	}
+	return nil
+}
+
+// shareLoad scales the CPU usage of a server in an autoscaling group to
+// its share of the group's load.
+func (c *fleetChurn) shareLoad(server fleet.ServerConfig, metric *MetricData) {
+	if c == nil {
+		return
+	}
+	if g := c.group(server.Role); g != nil && g.size > 0 {
+		metric.CPUUsage = roundFloat(clampPercent(metric.CPUUsage*float64(g.base)/float64(g.size)), 2)
+	}
+}
+
+// churnFleet lets servers join and leave over the last tick, which came
+// period ago, and scales the autoscaling groups. It leaves the fleet alone
+// while a change from the control API is pending.
+func (mg *MetricGenerator) churnFleet(period time.Duration) {
+	c := mg.churn
+	if c == nil {
+		return
+	}
+	ctl := &mg.control
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	if ctl.servers != nil {
+		return
+	}
+
+	now := mg.Now().UTC()
+	servers := append([]fleet.ServerConfig(nil), mg.servers...)
+	var removed []fleet.ServerConfig
+
+	// Servers outside the autoscaling groups come and go at random
+	for n := poisson(c.leaveRate*period.Hours(), c.rnd); n > 0 && len(servers) > 1; n-- {
+		var candidates []int
+		for i, server := range servers {
+			if c.group(server.Role) == nil {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) == 0 {
+			break
+		}
+		i := candidates[c.rnd.Intn(len(candidates))]
+		removed = append(removed, servers[i])
+		slog.Info("Server decommissioned", "server_id", servers[i].ID, "hostname", servers[i].Hostname)
+		servers = append(servers[:i], servers[i+1:]...)
+	}
+	if n := poisson(c.joinRate*period.Hours(), c.rnd); n > 0 {
+		joined, err := c.newServers(mg.fleetConfig, n, "")
+		if err != nil {
+			slog.Error("Error adding servers", "error", err)
+		}
+		for _, server := range joined {
+			// Autoscaling groups size themselves
+			if c.group(server.Role) != nil {
+				continue
+			}
+			slog.Info("Server joined", "server_id", server.ID, "hostname", server.Hostname, "role", server.Role)
+			servers = append(servers, server)
+		}
+	}
+
+	// Autoscaling groups track their target CPU usage
+	for _, g := range c.groups {
+		var members []int
+		var cpu float64
+		var reporting int
+		mg.mu.Lock()
+		for i, server := range servers {
+			if server.Role != g.role {
+				continue
+			}
+			members = append(members, i)
+			if metric, ok := mg.metricTracker[server.ID]; ok {
+				cpu += metric.CPUUsage
+				reporting++
+			}
+		}
+		mg.mu.Unlock()
+		g.size = len(members)
+
+		desired := g.size
+		if reporting > 0 {
+			// The tracked values are before the load is shared out
+			avg := cpu / float64(reporting) * float64(g.base) / float64(max(g.size, 1))
+			desired = int(math.Ceil(float64(g.size) * avg / g.target))
+			if desired < g.size {
+				// Scale in only as far as leaves headroom below the target,
+				// so the group does not flap around it
+				desired = min(int(math.Ceil(float64(g.size)*avg/(0.9*g.target))), g.size)
+			}
+		}
+		desired = min(max(desired, g.min), g.max)
+		if desired == g.size || (!g.scaledAt.IsZero() && now.Sub(g.scaledAt) < c.cooldown) {
+			continue
+		}
+
+		var hosts []string
+		if desired > g.size {
+			added, err := c.newServers(mg.fleetConfig, desired-g.size, g.role)
+			if err != nil {
+				slog.Error("Error scaling out autoscaling group", "role", g.role, "error", err)
+				continue
+			}
+			servers = append(servers, added...)
+			for _, server := range added {
+				hosts = append(hosts, server.ID)
+			}
+		} else {
+			// Scale in the newest servers first
+			drop := make(map[int]bool)
+			for _, i := range members[desired:] {
+				drop[i] = true
+				removed = append(removed, servers[i])
+				hosts = append(hosts, servers[i].ID)
+			}
+			kept := servers[:0]
+			for i, server := range servers {
+				if !drop[i] {
+					kept = append(kept, server)
+				}
+			}
+			servers = kept
+		}
+		slog.Info("Autoscaling group scaled", "role", g.role, "from", g.size, "to", desired)
+		mg.changes.record(ChangeEvent{
+			Timestamp:   now,
+			ChangeType:  "scale",
+			Service:     g.role,
+			Description: fmt.Sprintf("Scale %s from %d to %d servers", g.role, g.size, desired),
+			Initiator:   "autoscaler",
+			Hosts:       hosts,
+		})
+		g.size, g.scaledAt = desired, now
+	}
+
+	// Forget the state of servers that are gone
+	mg.mu.Lock()
+	for _, server := range removed {
+		delete(mg.metricTracker, server.ID)
+		delete(mg.sims, server.ID)
+	}
+	mg.mu.Unlock()
+	mg.servers = servers
+}
+
+// newServers builds n servers that were not in the fleet before, the ones
+// a larger SERVER_COUNT would have produced, optionally with the given
+// role.
+func (c *fleetChurn) newServers(config config.Config, n int, role string) ([]fleet.ServerConfig, error) {
+	config.ServerCount = c.built + n
+	built, err := fleet.Build(config)
+	if err != nil {
+		return nil, err
+	}
+	fresh := built[c.built:]
+	c.built += n
+	if role != "" {
+		for i := range fresh {
+			fresh[i].Role = role
+			if err := fleet.Rename(config, &fresh[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fresh, nil
+}
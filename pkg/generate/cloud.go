@@ -0,0 +1,137 @@
+package generate
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// cloudRegion is a provider region and where it is.
+type cloudRegion struct {
+	name     string
+	lat, lon float64
+}
+
+// cloudRegions lists the regions of each provider that servers are
+// placed in, the nearest one to each server's location.
+var cloudRegions = map[string][]cloudRegion{
+	"aws": {
+		{"us-east-1", 38.9, -77.4}, {"us-east-2", 40.0, -83.0}, {"us-west-1", 37.4, -121.9}, {"us-west-2", 45.8, -119.7},
+		{"ca-central-1", 45.5, -73.6}, {"sa-east-1", -23.5, -46.6},
+		{"eu-west-1", 53.3, -6.3}, {"eu-west-2", 51.5, -0.1}, {"eu-west-3", 48.9, 2.4}, {"eu-central-1", 50.1, 8.7},
+		{"eu-central-2", 47.4, 8.5}, {"eu-north-1", 59.3, 18.1}, {"eu-south-1", 45.5, 9.2},
+		{"me-central-1", 25.2, 55.3}, {"af-south-1", -33.9, 18.4},
+		{"ap-south-1", 19.1, 72.9}, {"ap-southeast-1", 1.3, 103.8}, {"ap-southeast-2", -33.9, 151.2},
+		{"ap-northeast-1", 35.7, 139.7}, {"ap-northeast-2", 37.6, 127.0}, {"ap-northeast-3", 34.7, 135.5},
+	},
+	"gcp": {
+		{"us-east4", 39.0, -77.5}, {"us-central1", 41.3, -95.9}, {"us-west1", 45.6, -121.2}, {"us-west2", 34.1, -118.2},
+		{"northamerica-northeast1", 45.5, -73.6}, {"southamerica-east1", -23.5, -46.6},
+		{"europe-west1", 50.4, 3.8}, {"europe-west2", 51.5, -0.1}, {"europe-west3", 50.1, 8.7}, {"europe-west4", 53.4, 6.8},
+		{"europe-west6", 47.4, 8.5}, {"europe-north1", 60.6, 27.0}, {"europe-west8", 45.5, 9.2},
+		{"me-central1", 25.3, 51.5}, {"africa-south1", -26.2, 28.0},
+		{"asia-south1", 19.1, 72.9}, {"asia-southeast1", 1.3, 103.8}, {"australia-southeast1", -33.9, 151.2},
+		{"asia-northeast1", 35.7, 139.7}, {"asia-northeast3", 37.6, 127.0}, {"asia-northeast2", 34.7, 135.5},
+	},
+	"azure": {
+		{"eastus", 37.4, -79.4}, {"centralus", 41.6, -93.6}, {"westus", 37.8, -122.4}, {"westus2", 47.2, -119.9},
+		{"canadacentral", 43.7, -79.4}, {"brazilsouth", -23.6, -46.6},
+		{"northeurope", 53.3, -6.3}, {"uksouth", 51.5, -0.1}, {"francecentral", 46.3, 2.4}, {"germanywestcentral", 50.1, 8.7},
+		{"switzerlandnorth", 47.5, 8.6}, {"swedencentral", 60.7, 17.1}, {"westeurope", 52.4, 4.9},
+		{"uaenorth", 25.3, 55.3}, {"southafricanorth", -25.7, 28.2},
+		{"centralindia", 18.6, 73.9}, {"southeastasia", 1.3, 103.8}, {"australiaeast", -33.9, 151.2},
+		{"japaneast", 35.7, 139.8}, {"koreacentral", 37.6, 127.0}, {"japanwest", 34.7, 135.5},
+	},
+}
+
+// cloudMachineTypes translates the instance types of the energy model into
+// each provider's machine type of the same size.
+var cloudMachineTypes = map[string]map[string]string{
+	"gcp": {
+		"m5.large": "n2-standard-2", "m5.xlarge": "n2-standard-4", "m5.2xlarge": "n2-standard-8",
+		"c5.xlarge": "c2-standard-4", "c5.2xlarge": "c2-standard-8", "c5.4xlarge": "c2-standard-16",
+		"r5.large": "n2-highmem-2", "r5.xlarge": "n2-highmem-4", "r5.2xlarge": "n2-highmem-8", "r5.4xlarge": "n2-highmem-16",
+	},
+	"azure": {
+		"m5.large": "Standard_D2s_v5", "m5.xlarge": "Standard_D4s_v5", "m5.2xlarge": "Standard_D8s_v5",
+		"c5.xlarge": "Standard_F4s_v2", "c5.2xlarge": "Standard_F8s_v2", "c5.4xlarge": "Standard_F16s_v2",
+		"r5.large": "Standard_E2s_v5", "r5.xlarge": "Standard_E4s_v5", "r5.2xlarge": "Standard_E8s_v5", "r5.4xlarge": "Standard_E16s_v5",
+	},
+}
+
+// cloudMix places servers with cloud providers in the proportions of
+// CLOUD_PROVIDERS.
+type cloudMix struct {
+	weightedMix
+	seed int64
+}
+
+// parseCloudMix parses CLOUD_PROVIDERS, e.g. "aws=60,gcp=25,azure=15".
+func parseCloudMix(spec string, seed int64) (*cloudMix, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	mix, err := parseWeightedMix(spec, "cloud provider", []string{"aws", "azure", "gcp"})
+	if err != nil {
+		return nil, err
+	}
+	return &cloudMix{mix, seed}, nil
+}
+
+// apply sets the cloud fields of metric. The provider, zone and instance
+// hash the server ID so they don't change between runs; the region is the
+// provider's nearest to the server. Servers of a fleet share an account
+// per provider.
+func (m *cloudMix) apply(server fleet.ServerConfig, metric *MetricData) {
+	if m == nil {
+		return
+	}
+	provider := m.pick(platformHash(server, "cloud"))
+	region, best := "", math.Inf(1)
+	for _, r := range cloudRegions[provider] {
+		if d := haversineKm(server.Location.Latitude, server.Location.Longitude, r.lat, r.lon); d < best {
+			region, best = r.name, d
+		}
+	}
+
+	zone := int(platformHash(server, "zone") * 3)
+	h := fnv.New64a()
+	h.Write([]byte(server.ID))
+	h.Write([]byte("instance"))
+	id := h.Sum64()
+	account := uint64(fleet.DeriveSeed(m.seed, provider+"/"+server.Fleet))
+	machine := instanceTypeFor(server).Name
+
+	metric.CloudProvider = provider
+	metric.CloudRegion = region
+	switch provider {
+	case "aws":
+		metric.CloudAccountID = fmt.Sprintf("%012d", account%1000000000000)
+		metric.CloudAvailabilityZone = region + string(rune('a'+zone))
+		metric.CloudInstanceID = fmt.Sprintf("i-0%016x", id)
+	case "gcp":
+		name := server.Fleet
+		if name == "" {
+			name = "prod"
+		}
+		metric.CloudAccountID = fmt.Sprintf("%s-%06d", name, account%1000000)
+		metric.CloudAvailabilityZone = fmt.Sprintf("%s-%c", region, 'a'+zone)
+		metric.CloudInstanceID = fmt.Sprintf("%d", id%9000000000000000000+1000000000000000000)
+		machine = cloudMachineTypes[provider][machine]
+	case "azure":
+		metric.CloudAccountID = guid(account)
+		metric.CloudAvailabilityZone = fmt.Sprintf("%s-%d", region, zone+1)
+		metric.CloudInstanceID = guid(id)
+		machine = cloudMachineTypes[provider][machine]
+	}
+	metric.CloudInstanceType = machine
+}
+
+// guid formats a hash as a version 4 GUID, stretching it to 128 bits.
+func guid(a uint64) string {
+	b := a * 0x9e3779b97f4a7c15
+	return fmt.Sprintf("%08x-%04x-4%03x-a%03x-%012x", a>>32, a>>16&0xffff, a&0xfff, b>>52&0xfff, b&0xffffffffffff)
+}
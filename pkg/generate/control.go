@@ -0,0 +1,394 @@
+package generate
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// controlState holds the changes requested through the control API. The
+// generation loop applies them between ticks, so a tick never sees the
+// fleet or the interval change under it.
+type controlState struct {
+	mu       sync.Mutex
+	paused   bool
+	interval time.Duration        // Pending interval; 0 keeps the current one
+	servers  []fleet.ServerConfig // Pending fleet; nil keeps the current one
+	added    int                  // Anomalies added so far, for naming
+	wake     chan struct{}
+}
+
+// applyControl applies the pending control changes and returns whether
+// generation is paused and the interval to tick at.
+func (mg *MetricGenerator) applyControl() (paused bool, interval time.Duration) {
+	c := &mg.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.interval > 0 {
+		mg.interval, c.interval = c.interval, 0
+	}
+	if c.servers != nil {
+		mg.servers, c.servers = c.servers, nil
+	}
+	return c.paused, mg.interval
+}
+
+// wakeLoop makes the generation loop re-read the control state instead of
+// waiting out the current interval.
+func (c *controlState) wakeLoop() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// controlView returns the pause state, interval and fleet as they will be
+// on the next tick.
+func (mg *MetricGenerator) controlView() (paused bool, interval time.Duration, servers []fleet.ServerConfig) {
+	c := &mg.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	interval, servers = mg.interval, mg.servers
+	if c.interval > 0 {
+		interval = c.interval
+	}
+	if c.servers != nil {
+		servers = c.servers
+	}
+	return c.paused, interval, servers
+}
+
+// scaleFleet grows or shrinks the fleet to count servers. The fleet is
+// rebuilt from the seed, so the servers kept are the ones already running
+// and new servers are the ones a larger SERVER_COUNT would have produced.
+func (mg *MetricGenerator) scaleFleet(count int) error {
+	if count < 1 {
+		return fmt.Errorf("server count must be at least 1, got %d", count)
+	}
+	if mg.fleetConfig.Fleets != "" {
+		return errors.New("scaling is not supported with FLEETS")
+	}
+	if mg.churn != nil {
+		return errors.New("scaling is not supported with fleet churn")
+	}
+	config := mg.fleetConfig
+	config.ServerCount = count
+	built, err := fleet.Build(config)
+	if err != nil {
+		return fmt.Errorf("building fleet: %w", err)
+	}
+
+	c := &mg.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current := c.servers
+	if current == nil {
+		current = mg.servers
+	}
+	copy(built, current)
+	c.servers = built
+	return nil
+}
+
+// controlPanel is the web UI served on / alongside the control API.
+//
+//go:embed ui/index.html
+var controlPanel []byte
+
+// registerControl adds the control API and its web UI to mux.
+func (mg *MetricGenerator) registerControl(mux *http.ServeMux) {
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(controlPanel)
+	})
+	mux.HandleFunc("GET /api/state", mg.serveState)
+	mux.HandleFunc("GET /api/servers", mg.serveServers)
+	mux.HandleFunc("POST /api/pause", mg.setPaused(true))
+	mux.HandleFunc("POST /api/resume", mg.setPaused(false))
+	mux.HandleFunc("PUT /api/interval", mg.setInterval)
+	mux.HandleFunc("PUT /api/servers", mg.setServerCount)
+	mux.HandleFunc("POST /api/anomalies", mg.addAnomaly)
+	mux.HandleFunc("DELETE /api/anomalies/{name}", mg.endAnomaly)
+}
+
+type controlStatus struct {
+	Paused    bool             `json:"paused"`
+	Interval  string           `json:"interval"`
+	Servers   int              `json:"servers"`
+	Roles     map[string]int   `json:"roles"`
+	Now       time.Time        `json:"now"`
+	LastTick  time.Time        `json:"last_tick,omitempty"`
+	Documents controlDocuments `json:"documents"`
+	Anomalies []controlAnomaly `json:"anomalies"`
+}
+
+type controlDocuments struct {
+	Generated int64 `json:"generated"`
+	Indexed   int64 `json:"indexed"`
+	Failed    int64 `json:"failed"`
+	Retries   int64 `json:"retries"`
+}
+
+type controlAnomaly struct {
+	Name   string    `json:"name"`
+	Kind   string    `json:"kind"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Active bool      `json:"active"`
+}
+
+// serveState answers GET /api/state and every successful change.
+func (mg *MetricGenerator) serveState(w http.ResponseWriter, r *http.Request) {
+	paused, interval, servers := mg.controlView()
+	now := mg.Now().UTC()
+	status := controlStatus{
+		Paused:   paused,
+		Interval: interval.String(),
+		Servers:  len(servers),
+		Roles:    make(map[string]int),
+		Now:      now,
+		LastTick: mg.lastTick(),
+		Documents: controlDocuments{
+			Generated: telemetry.Stats.Generated.Total(),
+			Indexed:   telemetry.Stats.Indexed.Load(),
+			Failed:    telemetry.Stats.Failed.Load(),
+			Retries:   telemetry.Stats.Retries.Load(),
+		},
+		Anomalies: []controlAnomaly{},
+	}
+	for _, server := range servers {
+		status.Roles[server.Role]++
+	}
+	for _, a := range mg.anomalies.pending(now) {
+		status.Anomalies = append(status.Anomalies, controlAnomaly{
+			Name: a.Name, Kind: a.Kind, Start: a.Start, End: a.End, Active: a.activeAt(now),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+type controlServer struct {
+	ID          string    `json:"id"`
+	Hostname    string    `json:"hostname"`
+	Role        string    `json:"role"`
+	City        string    `json:"city"`
+	Timestamp   time.Time `json:"@timestamp,omitempty"`
+	CPUUsage    float64   `json:"cpu_usage"`
+	MemoryUsage float64   `json:"memory_usage"`
+	DiskUsage   float64   `json:"disk_usage"`
+	Down        bool      `json:"down"`
+	Anomalies   []string  `json:"anomalies,omitempty"`
+}
+
+// serveServers answers GET /api/servers[?limit=n] with the last values
+// generated for each server of the fleet.
+func (mg *MetricGenerator) serveServers(w http.ResponseWriter, r *http.Request) {
+	_, _, servers := mg.controlView()
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		if limit > 0 && limit < len(servers) {
+			servers = servers[:limit]
+		}
+	}
+
+	now := mg.Now().UTC()
+	list := make([]controlServer, 0, len(servers))
+	for _, server := range servers {
+		mg.mu.Lock()
+		last := mg.metricTracker[server.ID]
+		mg.mu.Unlock()
+		entry := controlServer{
+			ID:          server.ID,
+			Hostname:    server.Hostname,
+			Role:        server.Role,
+			City:        server.Location.City,
+			Timestamp:   last.Timestamp,
+			CPUUsage:    last.CPUUsage,
+			MemoryUsage: last.MemoryUsage,
+			DiskUsage:   last.DiskUsage,
+		}
+		for _, a := range mg.anomalies.Active(server, now) {
+			entry.Anomalies = append(entry.Anomalies, a.Name)
+			entry.Down = entry.Down || a.Outage
+		}
+		list = append(list, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (mg *MetricGenerator) setPaused(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mg.control.mu.Lock()
+		mg.control.paused = paused
+		mg.control.mu.Unlock()
+		mg.control.wakeLoop()
+		mg.serveState(w, r)
+	}
+}
+
+func (mg *MetricGenerator) setInterval(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Interval string `json:"interval"`
+	}
+	if !decodeControl(w, r, &req) {
+		return
+	}
+	interval, err := time.ParseDuration(req.Interval)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid interval %q", req.Interval), http.StatusBadRequest)
+		return
+	}
+	if interval < minInterval {
+		http.Error(w, fmt.Sprintf("invalid INTERVAL %s (want a duration of at least %s)", interval, minInterval), http.StatusBadRequest)
+		return
+	}
+
+	mg.control.mu.Lock()
+	mg.control.interval = interval
+	mg.control.mu.Unlock()
+	mg.control.wakeLoop()
+	mg.serveState(w, r)
+}
+
+func (mg *MetricGenerator) setServerCount(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Count int `json:"count"`
+	}
+	if !decodeControl(w, r, &req) {
+		return
+	}
+	if err := mg.scaleFleet(req.Count); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mg.serveState(w, r)
+}
+
+// addAnomaly holds metrics of the selected servers at fixed values, or
+// takes the servers down, for a while starting now.
+func (mg *MetricGenerator) addAnomaly(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string             `json:"name"`
+		Servers  string             `json:"servers"`
+		Values   map[string]float64 `json:"values"`
+		Outage   bool               `json:"outage"`
+		Duration string             `json:"duration"`
+	}
+	if !decodeControl(w, r, &req) {
+		return
+	}
+	targets, err := parseServerSelector(req.Servers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Values) == 0 && !req.Outage {
+		http.Error(w, "no metric values given", http.StatusBadRequest)
+		return
+	}
+	for name := range req.Values {
+		if metricField(&MetricData{}, name) == nil {
+			http.Error(w, fmt.Sprintf("unknown metric %q", name), http.StatusBadRequest)
+			return
+		}
+	}
+	duration := 10 * time.Minute
+	if req.Duration != "" {
+		if duration, err = time.ParseDuration(req.Duration); err != nil || duration <= 0 {
+			http.Error(w, fmt.Sprintf("invalid duration %q", req.Duration), http.StatusBadRequest)
+			return
+		}
+	}
+
+	mg.control.mu.Lock()
+	mg.control.added++
+	if req.Name == "" {
+		req.Name = fmt.Sprintf("api-%d", mg.control.added)
+	}
+	mg.control.mu.Unlock()
+
+	start := mg.Now().UTC()
+	anomaly := &Anomaly{
+		Name:    req.Name,
+		Kind:    "manual",
+		Targets: targets,
+		Start:   start,
+		End:     start.Add(duration),
+		Outage:  req.Outage,
+	}
+	if req.Outage {
+		anomaly.Kind = "outage"
+	}
+	if values := req.Values; len(values) > 0 {
+		anomaly.Values = func(time.Duration) map[string]float64 { return values }
+	}
+	mg.anomalies.Add(anomaly)
+	mg.serveState(w, r)
+}
+
+// endAnomaly ends the named anomaly now, whoever scheduled it.
+func (mg *MetricGenerator) endAnomaly(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !mg.anomalies.end(name, mg.Now().UTC()) {
+		http.Error(w, fmt.Sprintf("no pending anomaly %q", name), http.StatusNotFound)
+		return
+	}
+	mg.serveState(w, r)
+}
+
+// decodeControl decodes the JSON request body into v, answering 400 and
+// returning false if it can't.
+func decodeControl(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// pending returns copies of the anomalies that have not ended by t, by
+// start time.
+func (s *anomalySet) pending(t time.Time) []Anomaly {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var pending []Anomaly
+	for _, a := range s.items {
+		if t.Before(a.End) {
+			pending = append(pending, *a)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Start.Before(pending[j].Start) })
+	return pending
+}
+
+// end ends the pending anomalies named name at t and reports whether
+// there were any.
+func (s *anomalySet) end(name string, t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ended := false
+	for _, a := range s.items {
+		if a.Name == name && t.Before(a.End) {
+			a.End = t
+			ended = true
+		}
+	}
+	return ended
+}
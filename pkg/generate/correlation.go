@@ -0,0 +1,218 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricCorrelations ties usage metrics to one another, so that a busy
+// server's memory and disk react to its CPU instead of wandering on their
+// own.
+type metricCorrelations struct {
+	rules  []correlationRule
+	maxLag time.Duration
+}
+
+// correlationRule either pulls a metric towards another, possibly lagging
+// behind it, or raises it while another crosses a threshold. Metrics are
+// indexes into formulaMetrics.
+type correlationRule struct {
+	target, source int
+
+	// Follow rules
+	strength float64 // -1 to 1; negative follows the source's inverse
+	lag      time.Duration
+
+	// Threshold rules, set when amount is non-zero
+	amount    float64
+	above     bool
+	threshold float64
+}
+
+// correlationState is the history of a server's correlated values, kept
+// as long as the longest lag.
+type correlationState struct {
+	history []correlationSample
+}
+
+type correlationSample struct {
+	at     time.Time
+	values [3]float64
+}
+
+// parseMetricCorrelations parses METRIC_CORRELATIONS, a semicolon-separated
+// list of rules over cpu, memory and disk applied in order:
+//
+//	memory~cpu:0.7@2m  memory follows cpu with strength 0.7, 2 minutes behind
+//	disk+15 if cpu>80  disk rises by 15 points while cpu is above 80
+func parseMetricCorrelations(spec string) (*metricCorrelations, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	c := &metricCorrelations{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var rule correlationRule
+		var err error
+		if effect, condition, ok := strings.Cut(entry, " if "); ok {
+			rule, err = parseThresholdRule(strings.TrimSpace(effect), strings.TrimSpace(condition))
+		} else {
+			rule, err = parseFollowRule(entry)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid correlation %q: %w", entry, err)
+		}
+		if rule.target == rule.source {
+			return nil, fmt.Errorf("invalid correlation %q: a metric cannot depend on itself", entry)
+		}
+		c.rules = append(c.rules, rule)
+		c.maxLag = max(c.maxLag, rule.lag)
+	}
+	return c, nil
+}
+
+// parseFollowRule parses "target~source[:strength][@lag]"; the strength
+// defaults to 1.
+func parseFollowRule(entry string) (correlationRule, error) {
+	target, source, ok := strings.Cut(entry, "~")
+	if !ok {
+		return correlationRule{}, fmt.Errorf("want target~source[:strength][@lag] or target+amount if source>threshold")
+	}
+	rule := correlationRule{strength: 1}
+	source, lag, hasLag := strings.Cut(source, "@")
+	if hasLag {
+		d, err := time.ParseDuration(strings.TrimSpace(lag))
+		if err != nil || d < 0 {
+			return correlationRule{}, fmt.Errorf("invalid lag %q", lag)
+		}
+		rule.lag = d
+	}
+	source, strength, hasStrength := strings.Cut(source, ":")
+	if hasStrength {
+		v, err := strconv.ParseFloat(strings.TrimSpace(strength), 64)
+		if err != nil || v < -1 || v > 1 || v == 0 {
+			return correlationRule{}, fmt.Errorf("invalid strength %q (want -1 to 1)", strength)
+		}
+		rule.strength = v
+	}
+	var err error
+	if rule.target, err = correlationMetric(target); err != nil {
+		return correlationRule{}, err
+	}
+	if rule.source, err = correlationMetric(source); err != nil {
+		return correlationRule{}, err
+	}
+	return rule, nil
+}
+
+// parseThresholdRule parses the "target+amount" effect and "source>threshold"
+// or "source<threshold" condition of a threshold rule; the amount may be
+// negative.
+func parseThresholdRule(effect, condition string) (correlationRule, error) {
+	i := strings.IndexAny(effect, "+-")
+	if i < 0 {
+		return correlationRule{}, fmt.Errorf("want target+amount if source>threshold")
+	}
+	amount, err := strconv.ParseFloat(effect[i:], 64)
+	if err != nil || amount == 0 {
+		return correlationRule{}, fmt.Errorf("invalid amount %q", effect[i:])
+	}
+	rule := correlationRule{amount: amount}
+	if rule.target, err = correlationMetric(effect[:i]); err != nil {
+		return correlationRule{}, err
+	}
+
+	j := strings.IndexAny(condition, "<>")
+	if j < 0 {
+		return correlationRule{}, fmt.Errorf("invalid condition %q (want source>threshold or source<threshold)", condition)
+	}
+	rule.above = condition[j] == '>'
+	if rule.threshold, err = strconv.ParseFloat(strings.TrimSpace(condition[j+1:]), 64); err != nil {
+		return correlationRule{}, fmt.Errorf("invalid threshold in %q", condition)
+	}
+	if rule.source, err = correlationMetric(condition[:j]); err != nil {
+		return correlationRule{}, err
+	}
+	return rule, nil
+}
+
+// correlationMetric returns the index of the named metric.
+func correlationMetric(name string) (int, error) {
+	name = strings.TrimSpace(name)
+	i, ok := formulaMetrics[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown metric %q (want cpu, memory or disk)", name)
+	}
+	return i, nil
+}
+
+// apply runs the rules over metric's usage in order, so that a rule sees
+// the changes of those before it. Like anomalies, correlations only shape
+// the emitted values and each metric's own walk carries on underneath.
+// A follow rule blends the target with the source as it was lag ago, the
+// strength being the source's share of the result.
+func (c *metricCorrelations) apply(metric *MetricData, state *correlationState) {
+	if c == nil {
+		return
+	}
+	values := [3]float64{metric.CPUUsage, metric.MemoryUsage, metric.DiskUsage}
+	for _, rule := range c.rules {
+		source := values[rule.source]
+		if rule.amount != 0 {
+			if (rule.above && source > rule.threshold) || (!rule.above && source < rule.threshold) {
+				values[rule.target] += rule.amount
+			}
+			continue
+		}
+		if rule.lag > 0 {
+			source = state.at(metric.Timestamp.Add(-rule.lag), rule.source, source)
+		}
+		if rule.strength < 0 {
+			source = 100 - source
+		}
+		w := math.Abs(rule.strength)
+		values[rule.target] = clampPercent(w*source + (1-w)*values[rule.target])
+	}
+	for i := range values {
+		values[i] = roundFloat(clampPercent(values[i]), 2)
+	}
+	metric.CPUUsage, metric.MemoryUsage, metric.DiskUsage = values[0], values[1], values[2]
+
+	if c.maxLag > 0 {
+		state.record(correlationSample{at: metric.Timestamp, values: values}, c.maxLag)
+	}
+}
+
+// at returns metric's value at t, from the latest sample no later than t.
+// Before the history reaches back to t it returns the oldest sample, and
+// without history current.
+func (s *correlationState) at(t time.Time, metric int, current float64) float64 {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if !s.history[i].at.After(t) {
+			return s.history[i].values[metric]
+		}
+	}
+	if len(s.history) > 0 {
+		return s.history[0].values[metric]
+	}
+	return current
+}
+
+// record appends sample, dropping samples no longer needed to look back
+// maxLag from it.
+func (s *correlationState) record(sample correlationSample, maxLag time.Duration) {
+	s.history = append(s.history, sample)
+	cutoff := sample.at.Add(-maxLag)
+	keep := 0
+	for keep < len(s.history)-1 && !s.history[keep+1].at.After(cutoff) {
+		keep++
+	}
+	s.history = s.history[keep:]
+}
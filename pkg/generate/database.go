@@ -0,0 +1,92 @@
+package generate
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// DatabaseMetrics are the metrics of the db workload profile, inlined in
+// the metric document.
+type DatabaseMetrics struct {
+	Role                  string  `json:"db_role"` // primary or replica
+	Connections           int     `json:"db_connections"`
+	QueriesPerSecond      float64 `json:"db_queries_per_second"`
+	ReplicationLagSeconds float64 `json:"db_replication_lag_seconds"`
+	SlowQueries           int     `json:"db_slow_queries"` // Over the interval
+	BufferCacheHitRatio   float64 `json:"db_buffer_cache_hit_ratio"`
+}
+
+// databaseState is a replica's replication lag carried between reports.
+type databaseState struct {
+	lag float64 // Seconds
+}
+
+// databaseMaxConnections is the connection limit of every database.
+const databaseMaxConnections = 500
+
+// databaseShape returns whether a server is a primary and the queries per
+// second it serves at 50% CPU, hashing the server ID so both are stable
+// across runs. One database in three is a primary.
+func databaseShape(server fleet.ServerConfig) (primary bool, qps float64) {
+	h := fnv.New32a()
+	h.Write([]byte(server.ID))
+	sum := h.Sum32()
+	return sum%3 == 0, 500 + float64((sum>>8)%2500)
+}
+
+// applyDatabase sets the database metrics of metric for the interval
+// since the server's last report. Queries follow load, the CPU usage
+// before anomalies, which carries the daily load curve. During an
+// incident throughput drops while clients pile up, and a replica falls
+// further behind its primary until the incident ends.
+func applyDatabase(server fleet.ServerConfig, metric *MetricData, state *databaseState, load float64, incident bool, interval time.Duration, rnd *rand.Rand) {
+	primary, base := databaseShape(server)
+	qps := base * load / 50 * (0.9 + rnd.Float64()*0.2)
+
+	// Each connection runs about 20 queries a second; the pool keeps a
+	// few idle ones open
+	waiting := qps / 20
+	if incident {
+		qps *= 0.6
+		waiting *= 3
+	}
+	connections := min(int(waiting)+10+rnd.Intn(10), databaseMaxConnections)
+
+	// One query in 100000 is slow, and far more once the CPU saturates
+	slowRate := 1e-5
+	if metric.CPUUsage > 80 {
+		slowRate *= 1 + metric.CPUUsage - 80
+	}
+	slow := poisson(qps*interval.Seconds()*slowRate, rnd)
+
+	// Memory pressure evicts pages from the buffer cache
+	hit := 0.995 - rnd.Float64()*0.01
+	if metric.MemoryUsage > 85 {
+		hit -= (metric.MemoryUsage - 85) / 100
+	}
+
+	db := &DatabaseMetrics{
+		Role:                "replica",
+		Connections:         connections,
+		QueriesPerSecond:    roundFloat(qps, 1),
+		SlowQueries:         slow,
+		BufferCacheHitRatio: roundFloat(math.Max(hit, 0), 4),
+	}
+	if primary {
+		db.Role = "primary"
+	} else {
+		// Replicas fall behind by half of the incident's duration and
+		// replay twice as fast as real time once it is over
+		if incident {
+			state.lag += interval.Seconds() / 2
+		} else {
+			state.lag = math.Max(state.lag-interval.Seconds()*2, 0)
+		}
+		db.ReplicationLagSeconds = roundFloat(state.lag+0.05+rnd.ExpFloat64()*0.2, 3)
+	}
+	metric.DatabaseMetrics = db
+}
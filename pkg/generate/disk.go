@@ -0,0 +1,79 @@
+package generate
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDiskGrowthRates are the disk growth rates, in percent per hour,
+// of the log-heavy roles.
+var defaultDiskGrowthRates = map[string]float64{
+	"web":    1.5,
+	"app":    1.0,
+	"worker": 0.8,
+}
+
+// diskSawtooth models disks that fill steadily with logs and drop back to
+// their baseline when logs are rotated once a day. Roles without a growth
+// rate keep the quality profile's random walk.
+type diskSawtooth struct {
+	rates    map[string]float64
+	rotateAt time.Duration // offset from midnight UTC
+}
+
+// parseDiskSawtooth parses DISK_GROWTH_RATES, a comma-separated list of
+// "role=percent per hour" entries (or "off"), and DISK_ROTATION_TIME, the
+// daily rotation time as "HH:MM" in UTC.
+func parseDiskSawtooth(ratesSpec, rotation string) (*diskSawtooth, error) {
+	d := &diskSawtooth{rates: make(map[string]float64)}
+
+	switch strings.TrimSpace(ratesSpec) {
+	case "":
+		for role, rate := range defaultDiskGrowthRates {
+			d.rates[role] = rate
+		}
+	case "off":
+	default:
+		for _, entry := range strings.Split(ratesSpec, ",") {
+			role, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid disk growth rate %q (want role=percent per hour)", entry)
+			}
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 {
+				return nil, fmt.Errorf("invalid disk growth rate %q", entry)
+			}
+			d.rates[role] = rate
+		}
+	}
+
+	if rotation != "" {
+		t, err := time.Parse("15:04", rotation)
+		if err != nil {
+			return nil, fmt.Errorf("invalid disk rotation time %q (want HH:MM)", rotation)
+		}
+		d.rotateAt = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return d, nil
+}
+
+// usage returns the disk usage of a server with the given role and
+// baseline at now, and whether the role follows a sawtooth at all.
+func (d *diskSawtooth) usage(role string, base float64, now time.Time, rnd *rand.Rand) (float64, bool) {
+	if d == nil {
+		return 0, false
+	}
+	rate, ok := d.rates[role]
+	if !ok {
+		return 0, false
+	}
+
+	sinceRotation := now.Sub(now.Truncate(24 * time.Hour).Add(d.rotateAt))
+	if sinceRotation < 0 {
+		sinceRotation += 24 * time.Hour
+	}
+	return clampPercent(base + rate*sinceRotation.Hours() + rnd.Float64()*0.4 - 0.2), true
+}
@@ -0,0 +1,146 @@
+package generate
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DocumentIDGenerator produces the Elasticsearch document ID for a metric.
+type DocumentIDGenerator interface {
+	NewID(metric MetricData) string
+}
+
+func newDocumentIDGenerator(strategy string) (DocumentIDGenerator, error) {
+	switch strategy {
+	case "timestamp":
+		return timestampIDGenerator{}, nil
+	case "hash":
+		return hashIDGenerator{}, nil
+	case "auto":
+		return autoIDGenerator{}, nil
+	case "ulid":
+		return &ulidGenerator{}, nil
+	case "uuid":
+		return uuidGenerator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown document ID strategy %q (want timestamp, hash, ulid, uuid or auto)", strategy)
+	}
+}
+
+// timestampIDGenerator keeps the original "<server>-<unix seconds>" format.
+type timestampIDGenerator struct{}
+
+func (timestampIDGenerator) NewID(metric MetricData) string {
+	return fmt.Sprintf("%s-%d", metric.ServerID, metric.Timestamp.Unix())
+}
+
+// hashIDGenerator derives the ID from the server and the full-precision
+// timestamp, so regenerating or retrying a metric always yields the same
+// ID while metrics less than a second apart don't collide.
+type hashIDGenerator struct{}
+
+func (hashIDGenerator) NewID(metric MetricData) string {
+	sum := sha256.Sum256([]byte(metric.ServerID + "|" + metric.Timestamp.UTC().Format(time.RFC3339Nano)))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// autoIDGenerator leaves the ID empty for Elasticsearch to assign.
+type autoIDGenerator struct{}
+
+func (autoIDGenerator) NewID(MetricData) string {
+	return ""
+}
+
+// uuidGenerator produces random version 4 UUIDs.
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewID(MetricData) string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return formatUUID(b)
+}
+
+// formatUUID marks b as a random version 4 UUID and formats it.
+func formatUUID(b [16]byte) string {
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], b[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], b[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], b[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], b[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], b[10:])
+	return string(buf[:])
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidGenerator produces monotonic ULIDs: a 48-bit millisecond timestamp
+// followed by 80 random bits. IDs created within the same millisecond
+// increment the random part so they stay strictly ordered.
+type ulidGenerator struct {
+	mu      sync.Mutex
+	lastMS  uint64
+	lastRnd [10]byte
+}
+
+func (g *ulidGenerator) NewID(metric MetricData) string {
+	ms := uint64(metric.Timestamp.UnixMilli())
+
+	g.mu.Lock()
+	if ms <= g.lastMS {
+		// Same (or earlier) millisecond: keep the previous timestamp and
+		// bump the entropy so the ID sorts after the last one.
+		ms = g.lastMS
+		for i := len(g.lastRnd) - 1; i >= 0; i-- {
+			g.lastRnd[i]++
+			if g.lastRnd[i] != 0 {
+				break
+			}
+		}
+	} else {
+		if _, err := rand.Read(g.lastRnd[:]); err != nil {
+			g.mu.Unlock()
+			panic(err)
+		}
+		g.lastMS = ms
+	}
+	entropy := g.lastRnd
+	g.mu.Unlock()
+
+	var id [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(id[0:6], ts[2:])
+	copy(id[6:], entropy[:])
+
+	return encodeULID(id)
+}
+
+// encodeULID renders 128 bits as 26 Crockford base32 characters.
+func encodeULID(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[0:8])
+	lo := binary.BigEndian.Uint64(id[8:16])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
@@ -0,0 +1,240 @@
+package generate
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// DockerContainer holds one container's stats in the shape of Metricbeat's
+// docker module: ECS container metadata and docker.* cgroup stats.
+type DockerContainer struct {
+	Container ContainerMeta `json:"container"`
+	Docker    DockerStats   `json:"docker"`
+}
+
+type ContainerMeta struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image struct {
+		Name string `json:"name"`
+	} `json:"image"`
+	Runtime string `json:"runtime"`
+}
+
+type DockerStats struct {
+	CPU struct {
+		Total struct {
+			Pct  float64 `json:"pct"` // share of one core; up to the host's cores
+			Norm struct {
+				Pct float64 `json:"pct"` // share of the whole host
+			} `json:"norm"`
+		} `json:"total"`
+	} `json:"cpu"`
+	Memory struct {
+		Usage struct {
+			Total int64   `json:"total"`
+			Pct   float64 `json:"pct"`
+		} `json:"usage"`
+		RSS struct {
+			Total int64 `json:"total"`
+		} `json:"rss"`
+		Limit int64 `json:"limit"`
+	} `json:"memory"`
+}
+
+// DockerDocument is a container's stats as a document of its own.
+type DockerDocument struct {
+	Timestamp time.Time `json:"@timestamp"`
+	ServerID  string    `json:"server_id"`
+	Hostname  string    `json:"hostname"`
+	Role      string    `json:"role"`
+	DockerContainer
+}
+
+// roleImages lists the images a role's hosts run, busiest first. Every
+// host also runs the sidecars once its role's images are used up.
+var roleImages = map[string][]string{
+	"web":    {"nginx:1.25", "storefront:3.8.1"},
+	"app":    {"orders-api:2.14.0", "inventory-api:1.9.3"},
+	"db":     {"postgres:16.2", "pgbouncer:1.22"},
+	"cache":  {"redis:7.2", "memcached:1.6"},
+	"worker": {"celery-worker:5.3", "celery-beat:5.3"},
+}
+
+var sidecarImages = []string{"fluent-bit:3.0", "node-exporter:1.7.0", "otel-collector:0.98.0"}
+
+// dockerGenerator simulates containers on every server. Containers split
+// the host's CPU and memory usage between them by weight, so their stats
+// add up to a little less than the host's.
+type dockerGenerator struct {
+	perHost int
+	nest    bool
+	index   sink.IndexNamer
+}
+
+// simContainer is the state a container carries between ticks.
+type simContainer struct {
+	meta    ContainerMeta
+	sidecar bool
+	weight  float64
+	limit   int64   // memory limit in bytes
+	rss     float64 // share of the usage that is RSS rather than cache
+	level   float64 // sidecar memory usage as a share of its limit
+}
+
+// newDockerGenerator returns nil, disabling containers, when perHost is 0.
+func newDockerGenerator(perHost int, nested bool, index string) (*dockerGenerator, error) {
+	if perHost == 0 {
+		return nil, nil
+	}
+	if perHost < 0 || perHost > 100 {
+		return nil, fmt.Errorf("DOCKER_CONTAINERS must be between 0 and 100, got %d", perHost)
+	}
+	return &dockerGenerator{perHost: perHost, nest: nested, index: sink.NewIndexNamer(index)}, nil
+}
+
+// nested reports whether containers are nested in their host's metric
+// document rather than sent as documents of their own.
+func (g *dockerGenerator) nested() bool {
+	return g != nil && g.nest
+}
+
+// dockerHostCores returns a server's number of cores, its memory being
+// 4 GiB per core.
+func dockerHostCores(server fleet.ServerConfig) int {
+	h := fnv.New32a()
+	h.Write([]byte(server.ID))
+	return []int{2, 4, 8, 16}[h.Sum32()%4]
+}
+
+// containers creates a server's containers.
+func (g *dockerGenerator) containers(server fleet.ServerConfig, rnd *rand.Rand) []*simContainer {
+	images := append(append([]string(nil), roleImages[server.Role]...), sidecarImages...)
+	hostMemory := int64(dockerHostCores(server)) << 32
+	out := make([]*simContainer, g.perHost)
+	for i := range out {
+		image := images[i%len(images)]
+		id := make([]byte, 32)
+		rnd.Read(id)
+
+		c := &simContainer{
+			sidecar: i%len(images) >= len(images)-len(sidecarImages),
+			weight:  1 / float64(i+1),
+			rss:     0.3 + rnd.Float64()*0.3,
+			level:   0.3 + rnd.Float64()*0.3,
+		}
+		c.meta.ID = hex.EncodeToString(id)
+		c.meta.Name = fmt.Sprintf("%s-%s-%d", server.Role, imageName(image), i/len(images)+1)
+		c.meta.Image.Name = image
+		c.meta.Runtime = "docker"
+		// Sidecars are small and capped tight; workloads run without a
+		// limit, which Docker reports as the host's memory
+		c.limit = hostMemory
+		if c.sidecar {
+			c.weight, c.limit = 0.02, 256<<20
+		}
+		out[i] = c
+	}
+	return out
+}
+
+// imageName strips the tag from an image reference.
+func imageName(image string) string {
+	if i := strings.LastIndex(image, ":"); i >= 0 {
+		return image[:i]
+	}
+	return image
+}
+
+// generate returns the stats of a server's containers given the host
+// metric just generated.
+func (g *dockerGenerator) generate(server fleet.ServerConfig, metric MetricData, sim *serverSim) []DockerContainer {
+	if sim.containers == nil {
+		sim.containers = g.containers(server, sim.dockerRnd)
+	}
+	rnd := sim.dockerRnd
+	cores := float64(dockerHostCores(server))
+	hostMemory := float64(int64(dockerHostCores(server)) << 32)
+
+	var total float64
+	shares := make([]float64, len(sim.containers))
+	for i, c := range sim.containers {
+		shares[i] = c.weight * (0.8 + 0.4*rnd.Float64())
+		total += shares[i]
+	}
+
+	out := make([]DockerContainer, len(sim.containers))
+	for i, c := range sim.containers {
+		// Containers account for most of the host's usage; the rest is
+		// the OS and the Docker daemon
+		share := 0.85 * shares[i] / total
+		norm := metric.CPUUsage / 100 * share
+		memory := math.Min(metric.MemoryUsage/100*hostMemory*share, float64(c.limit))
+		if c.sidecar {
+			c.level = math.Min(0.9, math.Max(0.2, c.level+rnd.NormFloat64()*0.01))
+			memory = c.level * float64(c.limit)
+		}
+		c.rss = math.Min(0.95, math.Max(0.2, c.rss+rnd.NormFloat64()*0.01))
+
+		var s DockerStats
+		s.CPU.Total.Norm.Pct = roundFloat(norm, 4)
+		s.CPU.Total.Pct = roundFloat(norm*cores, 4)
+		s.Memory.Usage.Total = int64(memory)
+		s.Memory.Usage.Pct = roundFloat(memory/float64(c.limit), 4)
+		s.Memory.RSS.Total = int64(memory * c.rss)
+		s.Memory.Limit = c.limit
+		out[i] = DockerContainer{Container: c.meta, Docker: s}
+	}
+	return out
+}
+
+// dockerContainers generates the containers of one server for the current
+// tick. The caller either nests them in the host's metric or encodes them
+// with dockerDocuments.
+func (mg *MetricGenerator) dockerContainers(server fleet.ServerConfig, metric MetricData) []DockerContainer {
+	if mg.docker == nil {
+		return nil
+	}
+	mg.mu.Lock()
+	sim := mg.serverSim(server)
+	mg.mu.Unlock()
+	return mg.docker.generate(server, metric, sim)
+}
+
+// dockerDocuments encodes containers as documents of their own.
+func (mg *MetricGenerator) dockerDocuments(server fleet.ServerConfig, metric MetricData, containers []DockerContainer) []sink.Document {
+	docs := make([]sink.Document, 0, len(containers))
+	for _, c := range containers {
+		body, err := json.Marshal(DockerDocument{
+			Timestamp:       metric.Timestamp,
+			ServerID:        server.ID,
+			Hostname:        server.Hostname,
+			Role:            server.Role,
+			DockerContainer: c,
+		})
+		if err != nil {
+			slog.Error("Error marshaling container stats", "container", c.Container.Name, "error", err)
+			continue
+		}
+		docs = append(docs, sink.Document{
+			Type:      "docker",
+			ServerID:  server.ID,
+			Hostname:  server.Hostname,
+			Role:      server.Role,
+			Timestamp: metric.Timestamp,
+			Index:     mg.docker.index(metric.Timestamp),
+			Body:      body,
+		})
+	}
+	return docs
+}
@@ -0,0 +1,84 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// denormalValues are subnormal doubles, the values most likely to be
+// rounded away or rejected by a serializer.
+var denormalValues = []float64{
+	math.SmallestNonzeroFloat64,
+	1e-310,
+	2.225073858507201e-308, // largest subnormal
+}
+
+// edgeValues replaces usage metrics with edge float values, exactly 0,
+// exactly 100 or a subnormal, so sinks and dashboards get exercised on
+// the values that break serializers and percentage formatting.
+type edgeValues struct {
+	zero     float64
+	full     float64
+	denormal float64
+}
+
+// parseEdgeValueRates parses EDGE_VALUE_RATES, a comma-separated list of
+// "kind=rate" with kinds zero, full and denormal, e.g.
+// "zero=0.01,full=0.005,denormal=0.001". Rates are per document.
+func parseEdgeValueRates(spec string) (*edgeValues, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	e := &edgeValues{}
+	for _, entry := range strings.Split(spec, ",") {
+		kind, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid edge value rate %q (want kind=rate)", entry)
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid rate in edge value rate %q", entry)
+		}
+		switch kind {
+		case "zero":
+			e.zero = rate
+		case "full":
+			e.full = rate
+		case "denormal":
+			e.denormal = rate
+		default:
+			return nil, fmt.Errorf("unknown edge value kind %q (want zero, full or denormal)", kind)
+		}
+	}
+	if total := e.zero + e.full + e.denormal; total > 1 {
+		return nil, fmt.Errorf("invalid edge value rates %q (rates add up to %g, want at most 1)", spec, total)
+	}
+	return e, nil
+}
+
+// apply replaces one randomly chosen usage metric of metric with an edge
+// value at the configured rates.
+func (e *edgeValues) apply(metric *MetricData, rnd *rand.Rand) {
+	if e == nil {
+		return
+	}
+
+	var value float64
+	switch p := rnd.Float64(); {
+	case p < e.zero:
+		value = 0
+	case p < e.zero+e.full:
+		value = 100
+	case p < e.zero+e.full+e.denormal:
+		value = denormalValues[rnd.Intn(len(denormalValues))]
+	default:
+		return
+	}
+
+	fields := []*float64{&metric.CPUUsage, &metric.MemoryUsage, &metric.DiskUsage}
+	*fields[rnd.Intn(len(fields))] = value
+}
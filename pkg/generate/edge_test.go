@@ -0,0 +1,143 @@
+package generate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// edgeTestValues are the values EDGE_VALUE_RATES emits.
+var edgeTestValues = []float64{0, 100, 5e-324, 1e-310, 2.225073858507201e-308}
+
+// edgeMetric returns a metric with every usage field set to v.
+func edgeMetric(v float64) MetricData {
+	return MetricData{
+		Timestamp:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		ServerID:    "server-001",
+		Hostname:    "web-01",
+		CPUUsage:    v,
+		MemoryUsage: v,
+		DiskUsage:   v,
+	}
+}
+
+func TestEdgeValuesRoundTripEncoders(t *testing.T) {
+	decoders := map[string]func(t *testing.T, out []byte) [3]float64{
+		"json": func(t *testing.T, out []byte) [3]float64 {
+			var m MetricData
+			if err := json.Unmarshal(out, &m); err != nil {
+				t.Fatal(err)
+			}
+			return [3]float64{m.CPUUsage, m.MemoryUsage, m.DiskUsage}
+		},
+		// The usage doubles are the last three fields of the datum
+		"avro": func(t *testing.T, out []byte) [3]float64 {
+			var got [3]float64
+			tail := out[len(out)-24:]
+			for i := range got {
+				got[i] = math.Float64frombits(binary.LittleEndian.Uint64(tail[i*8:]))
+			}
+			return got
+		},
+		// ... and of the message, each as a one-byte tag and a fixed64
+		"protobuf": func(t *testing.T, out []byte) [3]float64 {
+			var got [3]float64
+			tail := out[len(out)-27:]
+			for i := range got {
+				if tag := tail[i*9]; tag != byte((i+9)<<3|1) {
+					t.Fatalf("field %d has tag %#x", i+9, tag)
+				}
+				got[i] = math.Float64frombits(binary.LittleEndian.Uint64(tail[i*9+1:]))
+			}
+			return got
+		},
+		"line-protocol": func(t *testing.T, out []byte) [3]float64 {
+			fields := strings.Fields(string(out))
+			values := map[string]float64{}
+			for _, field := range strings.Split(fields[1], ",") {
+				k, v, _ := strings.Cut(field, "=")
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				values[k] = f
+			}
+			return [3]float64{values["cpu_usage"], values["memory_usage"], values["disk_usage"]}
+		},
+	}
+
+	for _, format := range metricFormats {
+		decode, ok := decoders[format.name]
+		if !ok {
+			t.Errorf("no decoder for format %s", format.name)
+			continue
+		}
+		for _, v := range edgeTestValues {
+			out, err := format.encode(nil, edgeMetric(v))
+			if err != nil {
+				t.Fatalf("%s: encoding %g: %v", format.name, v, err)
+			}
+			for i, got := range decode(t, out) {
+				if math.Float64bits(got) != math.Float64bits(v) {
+					t.Errorf("%s: usage field %d of %g came back as %g", format.name, i, v, got)
+				}
+			}
+		}
+	}
+}
+
+func TestEdgeValuesRoundTripDocumentBody(t *testing.T) {
+	mg := &MetricGenerator{}
+	for _, v := range edgeTestValues {
+		metric := edgeMetric(v)
+		metric.SchemaVersion = currentSchemaVersion
+		body, err := mg.encode(metric)
+		if err != nil {
+			t.Fatalf("encoding %g: %v", v, err)
+		}
+		var got MetricData
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.CPUUsage != v || got.MemoryUsage != v || got.DiskUsage != v {
+			t.Errorf("%g came back as %g, %g, %g", v, got.CPUUsage, got.MemoryUsage, got.DiskUsage)
+		}
+	}
+}
+
+func TestParseEdgeValueRates(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    edgeValues
+		wantErr bool
+	}{
+		{spec: "zero=0.01,full=0.005,denormal=0.001", want: edgeValues{zero: 0.01, full: 0.005, denormal: 0.001}},
+		{spec: "zero=0.5,full=0.5", want: edgeValues{zero: 0.5, full: 0.5}},
+		{spec: "denormal=1", want: edgeValues{denormal: 1}},
+		{spec: "zero=0.5,full=0.4,denormal=0.2", wantErr: true},
+		{spec: "zero=1.5", wantErr: true},
+		{spec: "zero=-0.1", wantErr: true},
+		{spec: "nan=0.1", wantErr: true},
+		{spec: "zero", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseEdgeValueRates(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseEdgeValueRates(%q) = %+v, want an error", tt.spec, *got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseEdgeValueRates(%q): %v", tt.spec, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("parseEdgeValueRates(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package generate
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// instanceType is a machine profile with its power draw at idle and at
+// full CPU load, in watts.
+type instanceType struct {
+	Name      string
+	IdleWatts float64
+	MaxWatts  float64
+}
+
+// roleInstanceTypes lists the instance types each role runs on.
+var roleInstanceTypes = map[string][]instanceType{
+	"web":    {{"m5.large", 22, 76}, {"c5.xlarge", 30, 112}},
+	"db":     {{"r5.2xlarge", 58, 215}, {"r5.4xlarge", 110, 410}},
+	"app":    {{"m5.xlarge", 35, 130}, {"m5.2xlarge", 60, 230}},
+	"cache":  {{"r5.large", 20, 70}, {"r5.xlarge", 34, 122}},
+	"worker": {{"c5.2xlarge", 52, 205}, {"c5.4xlarge", 98, 395}},
+}
+
+var defaultInstanceType = instanceType{"m5.large", 22, 76}
+
+// carbonIntensity is the grid carbon intensity by country in grams of
+// CO2e per kWh, rounded from recent annual averages.
+var carbonIntensity = map[string]float64{
+	"United States":        370,
+	"United Kingdom":       230,
+	"Germany":              380,
+	"Japan":                460,
+	"Singapore":            410,
+	"Australia":            550,
+	"Brazil":               100,
+	"India":                710,
+	"Canada":               130,
+	"France":               60,
+	"Switzerland":          40,
+	"United Arab Emirates": 420,
+	"Egypt":                450,
+}
+
+// worldCarbonIntensity is used for countries without their own figure.
+const worldCarbonIntensity = 475
+
+// instanceTypeFor picks a server's instance type from its role, hashing
+// the server ID so the choice is stable across runs.
+func instanceTypeFor(server fleet.ServerConfig) instanceType {
+	types := roleInstanceTypes[server.Role]
+	if len(types) == 0 {
+		return defaultInstanceType
+	}
+	h := fnv.New32a()
+	h.Write([]byte(server.ID))
+	return types[h.Sum32()%uint32(len(types))]
+}
+
+// watts is the instance's power draw at cpu percent CPU usage.
+func (it instanceType) watts(cpu float64) float64 {
+	return it.IdleWatts + (it.MaxWatts-it.IdleWatts)*cpu/100
+}
+
+// applyEnergy derives power draw from CPU usage with a linear model between
+// the instance's idle and maximum draw, and the CO2e emitted over one
+// interval at the region's carbon intensity.
+func applyEnergy(server fleet.ServerConfig, metric *MetricData, interval time.Duration) {
+	it := instanceTypeFor(server)
+	intensity, ok := carbonIntensity[server.Location.Country]
+	if !ok {
+		intensity = worldCarbonIntensity
+	}
+
+	watts := it.watts(metric.CPUUsage)
+	metric.InstanceType = it.Name
+	metric.PowerWatts = roundFloat(watts, 2)
+	metric.CarbonIntensity = intensity
+	metric.CO2eGrams = roundFloat(watts/1000*interval.Hours()*intensity, 4)
+}
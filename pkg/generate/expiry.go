@@ -0,0 +1,43 @@
+package generate
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// expiringDeliverer stamps every document with an expires_at time, DOC_TTL
+// after its timestamp, on its way to next. Stamping documents as they are
+// delivered covers every document type, not only metrics.
+type expiringDeliverer struct {
+	next Deliverer
+	ttl  time.Duration
+}
+
+func (d expiringDeliverer) Submit(ctx context.Context, docs ...sink.Document) {
+	stamped := make([]sink.Document, len(docs))
+	for i, doc := range docs {
+		doc.Body = stampExpiry(doc.Body, doc.Timestamp.Add(d.ttl))
+		stamped[i] = doc
+	}
+	d.next.Submit(ctx, stamped...)
+}
+
+// stampExpiry adds an expires_at field to the JSON object body. Bodies
+// that aren't JSON objects are returned unchanged.
+func stampExpiry(body []byte, expiresAt time.Time) []byte {
+	body = bytes.TrimSpace(body)
+	if len(body) < 2 || body[0] != '{' || body[len(body)-1] != '}' {
+		return body
+	}
+	stamped := make([]byte, 0, len(body)+48)
+	stamped = append(stamped, `{"expires_at":"`...)
+	stamped = expiresAt.UTC().AppendFormat(stamped, time.RFC3339Nano)
+	stamped = append(stamped, '"')
+	if rest := bytes.TrimSpace(body[1:]); len(rest) > 0 && rest[0] != '}' {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, body[1:]...)
+}
@@ -0,0 +1,452 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The expression language behind METRIC_FORMULAS. Expressions are type
+// checked and compiled to closures once, when they are parsed, so
+// evaluating one per server and tick costs little and can't fail.
+//
+//	literals     12.5  "web"  true  false  pi
+//	operators    + - * / %  == != < <= > >=  && || !  cond ? a : b
+//	functions    sin cos tan abs floor ceil round sqrt exp log pow
+//	             min max clamp(x, lo, hi) rand() normal(mean, stddev)
+
+type exprType int
+
+const (
+	exprNumber exprType = iota
+	exprString
+	exprBool
+)
+
+func (t exprType) String() string {
+	return [...]string{"number", "string", "bool"}[t]
+}
+
+// exprVars declares the variables an expression may use and assigns each
+// a slot in exprEnv, numbering each type from zero in declaration order.
+type exprVars struct {
+	slots  map[string]int
+	types  map[string]exprType
+	counts [2]int
+}
+
+func (v *exprVars) declare(name string, typ exprType) {
+	if v.slots == nil {
+		v.slots, v.types = make(map[string]int), make(map[string]exprType)
+	}
+	v.slots[name], v.types[name] = v.counts[typ], typ
+	v.counts[typ]++
+}
+
+// exprEnv holds the variable values for one evaluation, indexed by slot.
+type exprEnv struct {
+	numbers []float64
+	strings []string
+	rnd     *rand.Rand
+}
+
+// compiledExpr is an expression of one type; only the matching function
+// is set.
+type compiledExpr struct {
+	typ  exprType
+	num  func(*exprEnv) float64
+	str  func(*exprEnv) string
+	bool func(*exprEnv) bool
+}
+
+// compileExpr parses src, which must evaluate to a number.
+func compileExpr(src string, vars *exprVars) (func(*exprEnv) float64, error) {
+	p := &exprParser{src: src, vars: vars}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	e, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected %q", p.tok)
+	}
+	if e.typ != exprNumber {
+		return nil, fmt.Errorf("expression is a %s, want a number", e.typ)
+	}
+	return e.num, nil
+}
+
+type exprParser struct {
+	src  string
+	pos  int
+	tok  string // Current token; "" at the end
+	at   int    // Position of the current token
+	vars *exprVars
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return p.errorAt(p.at, format, args...)
+}
+
+func (p *exprParser) errorAt(at int, format string, args ...any) error {
+	return fmt.Errorf("at offset %d: %s", at, fmt.Sprintf(format, args...))
+}
+
+// next advances to the next token.
+func (p *exprParser) next() error {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	p.at = p.pos
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return nil
+	}
+
+	start, c := p.pos, p.src[p.pos]
+	switch {
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && (isExprDigit(p.src[p.pos]) ||
+			(p.src[p.pos] == '+' || p.src[p.pos] == '-') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+			p.pos++
+		}
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || p.src[p.pos] == '.' ||
+			unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(p.src[p.pos+1:], c)
+		if end < 0 {
+			return p.errorf("unterminated string")
+		}
+		p.pos += end + 2
+	default:
+		p.pos++
+		if p.pos < len(p.src) {
+			switch two := p.src[start : p.pos+1]; two {
+			case "==", "!=", "<=", ">=", "&&", "||":
+				p.pos++
+			}
+		}
+	}
+	p.tok = p.src[start:p.pos]
+	return nil
+}
+
+func isExprDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c == '.' || c == 'e' || c == 'E'
+}
+
+func (p *exprParser) expect(tok string) error {
+	if p.tok != tok {
+		if p.tok == "" {
+			return p.errorf("expected %q at the end", tok)
+		}
+		return p.errorf("expected %q, got %q", tok, p.tok)
+	}
+	return p.next()
+}
+
+func (p *exprParser) ternary() (compiledExpr, error) {
+	cond, err := p.binary(0)
+	if err != nil || p.tok != "?" {
+		return cond, err
+	}
+	if cond.typ != exprBool {
+		return cond, p.errorf("condition is a %s, want a bool", cond.typ)
+	}
+	if err := p.next(); err != nil {
+		return cond, err
+	}
+	yes, err := p.ternary()
+	if err != nil {
+		return cond, err
+	}
+	if err := p.expect(":"); err != nil {
+		return cond, err
+	}
+	no, err := p.ternary()
+	if err != nil {
+		return cond, err
+	}
+	if yes.typ != no.typ {
+		return cond, p.errorf("branches are a %s and a %s", yes.typ, no.typ)
+	}
+
+	c := cond.bool
+	switch yes.typ {
+	case exprNumber:
+		return compiledExpr{typ: exprNumber, num: func(e *exprEnv) float64 {
+			if c(e) {
+				return yes.num(e)
+			}
+			return no.num(e)
+		}}, nil
+	case exprString:
+		return compiledExpr{typ: exprString, str: func(e *exprEnv) string {
+			if c(e) {
+				return yes.str(e)
+			}
+			return no.str(e)
+		}}, nil
+	}
+	return compiledExpr{typ: exprBool, bool: func(e *exprEnv) bool {
+		if c(e) {
+			return yes.bool(e)
+		}
+		return no.bool(e)
+	}}, nil
+}
+
+// exprPrecedence lists the binary operators from loosest to tightest.
+var exprPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) binary(level int) (compiledExpr, error) {
+	if level == len(exprPrecedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return left, err
+	}
+	for isExprOperator(p.tok, exprPrecedence[level]) {
+		op, at := p.tok, p.at
+		if err := p.next(); err != nil {
+			return left, err
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return left, err
+		}
+		if left, err = p.combine(op, at, left, right); err != nil {
+			return left, err
+		}
+	}
+	return left, nil
+}
+
+func isExprOperator(tok string, ops []string) bool {
+	for _, op := range ops {
+		if tok == op {
+			return true
+		}
+	}
+	return false
+}
+
+// combine compiles a binary operation at offset at, checking the operand
+// types.
+func (p *exprParser) combine(op string, at int, l, r compiledExpr) (compiledExpr, error) {
+	if l.typ != r.typ {
+		return l, p.errorAt(at, "%q between a %s and a %s", op, l.typ, r.typ)
+	}
+	switch {
+	case l.typ == exprBool && (op == "&&" || op == "||"):
+		a, b := l.bool, r.bool
+		if op == "&&" {
+			return compiledExpr{typ: exprBool, bool: func(e *exprEnv) bool { return a(e) && b(e) }}, nil
+		}
+		return compiledExpr{typ: exprBool, bool: func(e *exprEnv) bool { return a(e) || b(e) }}, nil
+	case l.typ == exprString && (op == "==" || op == "!="):
+		a, b, want := l.str, r.str, op == "=="
+		return compiledExpr{typ: exprBool, bool: func(e *exprEnv) bool { return (a(e) == b(e)) == want }}, nil
+	case l.typ == exprBool && (op == "==" || op == "!="):
+		a, b, want := l.bool, r.bool, op == "=="
+		return compiledExpr{typ: exprBool, bool: func(e *exprEnv) bool { return (a(e) == b(e)) == want }}, nil
+	case l.typ != exprNumber:
+		return l, p.errorAt(at, "%q on a %s", op, l.typ)
+	}
+
+	a, b := l.num, r.num
+	var cmp func(x, y float64) bool
+	switch op {
+	case "+":
+		return compiledExpr{typ: exprNumber, num: func(e *exprEnv) float64 { return a(e) + b(e) }}, nil
+	case "-":
+		return compiledExpr{typ: exprNumber, num: func(e *exprEnv) float64 { return a(e) - b(e) }}, nil
+	case "*":
+		return compiledExpr{typ: exprNumber, num: func(e *exprEnv) float64 { return a(e) * b(e) }}, nil
+	case "/":
+		return compiledExpr{typ: exprNumber, num: func(e *exprEnv) float64 { return a(e) / b(e) }}, nil
+	case "%":
+		return compiledExpr{typ: exprNumber, num: func(e *exprEnv) float64 { return math.Mod(a(e), b(e)) }}, nil
+	case "==":
+		cmp = func(x, y float64) bool { return x == y }
+	case "!=":
+		cmp = func(x, y float64) bool { return x != y }
+	case "<":
+		cmp = func(x, y float64) bool { return x < y }
+	case "<=":
+		cmp = func(x, y float64) bool { return x <= y }
+	case ">":
+		cmp = func(x, y float64) bool { return x > y }
+	case ">=":
+		cmp = func(x, y float64) bool { return x >= y }
+	default:
+		return l, p.errorAt(at, "%q on numbers", op)
+	}
+	return compiledExpr{typ: exprBool, bool: func(e *exprEnv) bool { return cmp(a(e), b(e)) }}, nil
+}
+
+func (p *exprParser) unary() (compiledExpr, error) {
+	switch p.tok {
+	case "-", "!":
+		op := p.tok
+		if err := p.next(); err != nil {
+			return compiledExpr{}, err
+		}
+		x, err := p.unary()
+		if err != nil {
+			return x, err
+		}
+		if op == "-" {
+			if x.typ != exprNumber {
+				return x, p.errorf("\"-\" on a %s", x.typ)
+			}
+			f := x.num
+			return compiledExpr{typ: exprNumber, num: func(e *exprEnv) float64 { return -f(e) }}, nil
+		}
+		if x.typ != exprBool {
+			return x, p.errorf("\"!\" on a %s", x.typ)
+		}
+		f := x.bool
+		return compiledExpr{typ: exprBool, bool: func(e *exprEnv) bool { return !f(e) }}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (compiledExpr, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return compiledExpr{}, p.errorf("unexpected end of expression")
+	case tok == "(":
+		if err := p.next(); err != nil {
+			return compiledExpr{}, err
+		}
+		x, err := p.ternary()
+		if err != nil {
+			return x, err
+		}
+		return x, p.expect(")")
+	case tok[0] == '"' || tok[0] == '\'':
+		s := tok[1 : len(tok)-1]
+		return compiledExpr{typ: exprString, str: func(*exprEnv) string { return s }}, p.next()
+	case tok[0] >= '0' && tok[0] <= '9' || tok[0] == '.':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return compiledExpr{}, p.errorf("invalid number %q", tok)
+		}
+		return compiledExpr{typ: exprNumber, num: func(*exprEnv) float64 { return v }}, p.next()
+	case tok == "true" || tok == "false":
+		v := tok == "true"
+		return compiledExpr{typ: exprBool, bool: func(*exprEnv) bool { return v }}, p.next()
+	case tok == "pi":
+		return compiledExpr{typ: exprNumber, num: func(*exprEnv) float64 { return math.Pi }}, p.next()
+	case tok[0] == '_' || unicode.IsLetter(rune(tok[0])):
+		if err := p.next(); err != nil {
+			return compiledExpr{}, err
+		}
+		if p.tok == "(" {
+			return p.call(tok)
+		}
+		slot, ok := p.vars.slots[tok]
+		if !ok {
+			return compiledExpr{}, p.errorf("unknown variable %q", tok)
+		}
+		if p.vars.types[tok] == exprString {
+			return compiledExpr{typ: exprString, str: func(e *exprEnv) string { return e.strings[slot] }}, nil
+		}
+		return compiledExpr{typ: exprNumber, num: func(e *exprEnv) float64 { return e.numbers[slot] }}, nil
+	}
+	return compiledExpr{}, p.errorf("unexpected %q", tok)
+}
+
+// exprFunctions are the numeric functions, keyed by name, with their
+// number of arguments; -1 accepts one or more.
+var exprFunctions = map[string]struct {
+	args int
+	fn   func(e *exprEnv, x []float64) float64
+}{
+	"sin":   {1, func(_ *exprEnv, x []float64) float64 { return math.Sin(x[0]) }},
+	"cos":   {1, func(_ *exprEnv, x []float64) float64 { return math.Cos(x[0]) }},
+	"tan":   {1, func(_ *exprEnv, x []float64) float64 { return math.Tan(x[0]) }},
+	"abs":   {1, func(_ *exprEnv, x []float64) float64 { return math.Abs(x[0]) }},
+	"floor": {1, func(_ *exprEnv, x []float64) float64 { return math.Floor(x[0]) }},
+	"ceil":  {1, func(_ *exprEnv, x []float64) float64 { return math.Ceil(x[0]) }},
+	"round": {1, func(_ *exprEnv, x []float64) float64 { return math.Round(x[0]) }},
+	"sqrt":  {1, func(_ *exprEnv, x []float64) float64 { return math.Sqrt(x[0]) }},
+	"exp":   {1, func(_ *exprEnv, x []float64) float64 { return math.Exp(x[0]) }},
+	"log":   {1, func(_ *exprEnv, x []float64) float64 { return math.Log(x[0]) }},
+	"pow":   {2, func(_ *exprEnv, x []float64) float64 { return math.Pow(x[0], x[1]) }},
+	"min": {-1, func(_ *exprEnv, x []float64) float64 {
+		m := x[0]
+		for _, v := range x[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	}},
+	"max": {-1, func(_ *exprEnv, x []float64) float64 {
+		m := x[0]
+		for _, v := range x[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	}},
+	"clamp":  {3, func(_ *exprEnv, x []float64) float64 { return math.Max(x[1], math.Min(x[2], x[0])) }},
+	"rand":   {0, func(e *exprEnv, _ []float64) float64 { return e.rnd.Float64() }},
+	"normal": {2, func(e *exprEnv, x []float64) float64 { return x[0] + e.rnd.NormFloat64()*x[1] }},
+}
+
+func (p *exprParser) call(name string) (compiledExpr, error) {
+	fn, ok := exprFunctions[name]
+	if !ok {
+		return compiledExpr{}, p.errorf("unknown function %q", name)
+	}
+	if err := p.next(); err != nil {
+		return compiledExpr{}, err
+	}
+
+	var args []func(*exprEnv) float64
+	for p.tok != ")" {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return compiledExpr{}, err
+			}
+		}
+		arg, err := p.ternary()
+		if err != nil {
+			return arg, err
+		}
+		if arg.typ != exprNumber {
+			return arg, p.errorf("argument %d of %s is a %s, want a number", len(args)+1, name, arg.typ)
+		}
+		args = append(args, arg.num)
+	}
+	if err := p.next(); err != nil {
+		return compiledExpr{}, err
+	}
+	if fn.args >= 0 && len(args) != fn.args || fn.args < 0 && len(args) == 0 {
+		return compiledExpr{}, p.errorf("wrong number of arguments to %s", name)
+	}
+
+	return compiledExpr{typ: exprNumber, num: func(e *exprEnv) float64 {
+		x := make([]float64, len(args))
+		for i, arg := range args {
+			x[i] = arg(e)
+		}
+		return fn.fn(e, x)
+	}}, nil
+}
@@ -0,0 +1,111 @@
+package generate
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fieldGroups lets a fault name several related fields at once.
+var fieldGroups = map[string][]string{
+	"geo": {"latitude", "longitude", "location"},
+}
+
+// fieldFault makes a field missing (or null) at the given rate. Per-host
+// faults always affect the same hosts, modelling agents that never report
+// a field; per-document faults hit random documents.
+type fieldFault struct {
+	fields  []string
+	name    string
+	rate    float64
+	perHost bool
+	null    bool
+}
+
+// fieldInjector removes or nulls fields in documents so that
+// dashboards and queries get exercised against incomplete data.
+type fieldInjector struct {
+	faults []fieldFault
+	seed   int64
+}
+
+// newFieldInjector parses FIELD_MISSING_RATES and FIELD_NULL_RATES, both
+// comma-separated lists of "field=rate" where the rate may carry a "/host"
+// suffix, e.g. "geo=0.02/host,disk_usage=0.005".
+func newFieldInjector(missingSpec, nullSpec string, seed int64) (*fieldInjector, error) {
+	fi := &fieldInjector{seed: seed}
+	for _, spec := range []struct {
+		value string
+		null  bool
+	}{{missingSpec, false}, {nullSpec, true}} {
+		if strings.TrimSpace(spec.value) == "" {
+			continue
+		}
+		for _, entry := range strings.Split(spec.value, ",") {
+			name, rateSpec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid field fault %q (want field=rate)", entry)
+			}
+
+			fault := fieldFault{name: name, null: spec.null, fields: []string{name}}
+			if group, ok := fieldGroups[name]; ok {
+				fault.fields = group
+			}
+			if r, ok := strings.CutSuffix(rateSpec, "/host"); ok {
+				rateSpec = r
+				fault.perHost = true
+			}
+
+			rate, err := strconv.ParseFloat(rateSpec, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid rate in field fault %q", entry)
+			}
+			fault.rate = rate
+			fi.faults = append(fi.faults, fault)
+		}
+	}
+	return fi, nil
+}
+
+// active reports whether any fault is configured.
+func (fi *fieldInjector) active() bool {
+	return fi != nil && len(fi.faults) > 0
+}
+
+// apply removes or nulls the fields of doc hit by a fault.
+func (fi *fieldInjector) apply(serverID string, ts time.Time, doc map[string]interface{}) {
+	if !fi.active() {
+		return
+	}
+	for _, fault := range fi.faults {
+		if !fault.hits(fi.seed, serverID, ts) {
+			continue
+		}
+		for _, field := range fault.fields {
+			if fault.null {
+				doc[field] = nil
+			} else {
+				delete(doc, field)
+			}
+		}
+	}
+}
+
+func (f fieldFault) hits(seed int64, serverID string, ts time.Time) bool {
+	if f.rate == 0 {
+		return false
+	}
+
+	// Hash the host and fault so the same hosts are affected on every tick;
+	// per-document faults also hash the seed and timestamp
+	h := fnv.New64a()
+	h.Write([]byte(serverID))
+	h.Write([]byte{0})
+	h.Write([]byte(f.name))
+	if !f.perHost {
+		fmt.Fprintf(h, "\x00%d\x00%d", seed, ts.UnixNano())
+	}
+	return float64(h.Sum64()%1000000)/1000000 < f.rate
+}
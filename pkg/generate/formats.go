@@ -0,0 +1,282 @@
+package generate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// metricEncoder appends the serialized form of a metric to buf.
+type metricEncoder func(buf []byte, metric MetricData) ([]byte, error)
+
+var metricFormats = []struct {
+	name   string
+	encode metricEncoder
+}{
+	{"json", encodeJSON},
+	{"avro", encodeAvro},
+	{"protobuf", encodeProtobuf},
+	{"line-protocol", encodeLineProtocol},
+}
+
+func encodeJSON(buf []byte, metric MetricData) ([]byte, error) {
+	b, err := json.Marshal(metric)
+	if err != nil {
+		return buf, err
+	}
+	return append(append(buf, b...), '\n'), nil
+}
+
+// avroSchema is the record schema encodeAvro writes, in field order.
+const avroSchema = `{
+  "type": "record", "name": "MetricData",
+  "fields": [
+    {"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+    {"name": "server_id", "type": "string"},
+    {"name": "hostname", "type": "string"},
+    {"name": "ip_address", "type": "string"},
+    {"name": "country", "type": "string"},
+    {"name": "city", "type": "string"},
+    {"name": "latitude", "type": "double"},
+    {"name": "longitude", "type": "double"},
+    {"name": "cpu_usage", "type": "double"},
+    {"name": "memory_usage", "type": "double"},
+    {"name": "disk_usage", "type": "double"}
+  ]
+}`
+
+// encodeAvro writes a single Avro binary datum (no container framing).
+func encodeAvro(buf []byte, metric MetricData) ([]byte, error) {
+	buf = binary.AppendVarint(buf, metric.Timestamp.UnixMicro())
+	for _, s := range []string{metric.ServerID, metric.Hostname, metric.IPAddress, metric.Country, metric.City} {
+		buf = binary.AppendVarint(buf, int64(len(s)))
+		buf = append(buf, s...)
+	}
+	for _, f := range []float64{metric.Latitude, metric.Longitude, metric.CPUUsage, metric.MemoryUsage, metric.DiskUsage} {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+	}
+	return buf, nil
+}
+
+// protobufSchema documents the message encodeProtobuf writes.
+const protobufSchema = `message MetricData {
+  int64  timestamp_micros = 1;
+  string server_id        = 2;
+  string hostname         = 3;
+  string ip_address       = 4;
+  string country          = 5;
+  string city             = 6;
+  double latitude         = 7;
+  double longitude        = 8;
+  double cpu_usage        = 9;
+  double memory_usage     = 10;
+  double disk_usage       = 11;
+}`
+
+// encodeProtobuf writes a length-delimited protobuf message.
+func encodeProtobuf(buf []byte, metric MetricData) ([]byte, error) {
+	var msg []byte
+	msg = binary.AppendUvarint(msg, 1<<3|0)
+	msg = binary.AppendUvarint(msg, uint64(metric.Timestamp.UnixMicro()))
+	for i, s := range []string{metric.ServerID, metric.Hostname, metric.IPAddress, metric.Country, metric.City} {
+		msg = binary.AppendUvarint(msg, uint64(i+2)<<3|2)
+		msg = binary.AppendUvarint(msg, uint64(len(s)))
+		msg = append(msg, s...)
+	}
+	for i, f := range []float64{metric.Latitude, metric.Longitude, metric.CPUUsage, metric.MemoryUsage, metric.DiskUsage} {
+		msg = binary.AppendUvarint(msg, uint64(i+7)<<3|1)
+		msg = binary.LittleEndian.AppendUint64(msg, math.Float64bits(f))
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(msg)))
+	return append(buf, msg...), nil
+}
+
+var lineProtocolTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// encodeLineProtocol writes an InfluxDB line protocol point.
+func encodeLineProtocol(buf []byte, metric MetricData) ([]byte, error) {
+	buf = append(buf, "server_metrics"...)
+	for _, tag := range []struct{ key, value string }{
+		{"server_id", metric.ServerID},
+		{"hostname", metric.Hostname},
+		{"ip_address", metric.IPAddress},
+		{"country", metric.Country},
+		{"city", metric.City},
+	} {
+		buf = append(buf, ',')
+		buf = append(buf, tag.key...)
+		buf = append(buf, '=')
+		buf = append(buf, lineProtocolTagEscaper.Replace(tag.value)...)
+	}
+	for i, field := range []struct {
+		key   string
+		value float64
+	}{
+		{"latitude", metric.Latitude},
+		{"longitude", metric.Longitude},
+		{"cpu_usage", metric.CPUUsage},
+		{"memory_usage", metric.MemoryUsage},
+		{"disk_usage", metric.DiskUsage},
+	} {
+		if i == 0 {
+			buf = append(buf, ' ')
+		} else {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, field.key...)
+		buf = append(buf, '=')
+		// 'g' keeps subnormals short instead of spelling out 300 zeros
+		buf = strconv.AppendFloat(buf, field.value, 'g', -1, 64)
+	}
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, metric.Timestamp.UnixNano(), 10)
+	return append(buf, '\n'), nil
+}
+
+// discardDeliverer drops every document; bench-formats only needs the
+// generator's metrics.
+type discardDeliverer struct{}
+
+func (discardDeliverer) Submit(context.Context, ...sink.Document) {}
+
+// BenchFormatsCommand generates documents for the configured fleet without
+// contacting Elasticsearch and reports how fast each format encodes them
+// and how large the output is, raw and gzipped.
+func BenchFormatsCommand(config config.Config, args []string) error {
+	fs := flag.NewFlagSet("bench-formats", flag.ContinueOnError)
+	docs := fs.Int("docs", 100000, "number of documents to encode per format")
+	showSchemas := fs.Bool("schemas", false, "print the Avro and protobuf schemas used")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *showSchemas {
+		fmt.Printf("Avro schema:\n%s\n\nProtobuf schema:\n%s\n\n", avroSchema, protobufSchema)
+	}
+
+	rnd := rand.New(rand.NewSource(config.Seed))
+	servers := fleet.GenerateRandomServers(config.ServerCount, rnd, config.LocalizedMetadata)
+	generator, err := NewMetricGenerator(config, servers, discardDeliverer{})
+	if err != nil {
+		return fmt.Errorf("configuring generator: %w", err)
+	}
+
+	metrics := make([]MetricData, *docs)
+	for i := range metrics {
+		metrics[i] = generator.generateConsistentServerMetric(generator.servers[i%len(generator.servers)])
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "format\tdocs/sec\tMB/sec\tbytes/doc\tgzip bytes/doc\tgzip ratio\t")
+
+	for _, format := range metricFormats {
+		buf := make([]byte, 0, 256*len(metrics))
+
+		start := time.Now()
+		for _, metric := range metrics {
+			var err error
+			if buf, err = format.encode(buf, metric); err != nil {
+				return fmt.Errorf("%s: %w", format.name, err)
+			}
+		}
+		elapsed := time.Since(start).Seconds()
+
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(buf)
+		gz.Close()
+
+		n := float64(len(metrics))
+		fmt.Fprintf(w, "%s\t%.0f\t%.1f\t%.1f\t%.1f\t%.2f\t\n",
+			format.name,
+			n/elapsed,
+			float64(len(buf))/elapsed/1e6,
+			float64(len(buf))/n,
+			float64(compressed.Len())/n,
+			float64(len(buf))/float64(compressed.Len()))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	return benchDocumentIDs(metrics, len(servers), config.Interval)
+}
+
+// benchDocumentIDs reports how fast each ES_DOC_ID strategy creates IDs
+// for metrics and how ordered the IDs are. Lucene appends terms that sort
+// after the last one cheaply, and IDs sharing a long prefix with their
+// neighbours land in the same blocks of its terms dictionary. metrics are
+// spread over ticks interval apart, perServer metrics per tick, as a run
+// would create them.
+func benchDocumentIDs(metrics []MetricData, perServer int, interval time.Duration) error {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	timed := make([]MetricData, len(metrics))
+	start := time.Now().Truncate(interval)
+	for i, metric := range metrics {
+		metric.Timestamp = start.Add(time.Duration(i/perServer) * interval)
+		timed[i] = metric
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "ES_DOC_ID\tIDs/sec\tbytes/ID\tshared prefix\tin order\t")
+
+	for _, strategy := range []string{"timestamp", "hash", "ulid", "uuid"} {
+		ids, err := newDocumentIDGenerator(strategy)
+		if err != nil {
+			return err
+		}
+		created := make([]string, len(timed))
+
+		begin := time.Now()
+		for i, metric := range timed {
+			created[i] = ids.NewID(metric)
+		}
+		elapsed := time.Since(begin).Seconds()
+
+		var size, shared, ordered int
+		for i, id := range created {
+			size += len(id)
+			if i == 0 {
+				continue
+			}
+			prev := created[i-1]
+			n := 0
+			for n < len(id) && n < len(prev) && id[n] == prev[n] {
+				n++
+			}
+			shared += n
+			if id > prev {
+				ordered++
+			}
+		}
+
+		n := float64(len(created))
+		pairs := math.Max(n-1, 1)
+		fmt.Fprintf(w, "%s\t%.0f\t%.1f\t%.1f bytes\t%.1f%%\t\n",
+			strategy,
+			n/elapsed,
+			float64(size)/n,
+			float64(shared)/pairs,
+			float64(ordered)/pairs*100)
+	}
+
+	return w.Flush()
+}
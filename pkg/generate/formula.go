@@ -0,0 +1,99 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// metricFormulas computes usage metrics from user-supplied expressions
+// instead of the built-in model, which they can still build on.
+type metricFormulas struct {
+	vars     exprVars
+	formulas [3]func(*exprEnv) float64 // cpu, memory and disk; nil keeps the model's value
+}
+
+// formulaMetrics maps metric names to their index in formulas.
+var formulaMetrics = map[string]int{"cpu": 0, "memory": 1, "disk": 2}
+
+// formulaNumbers and formulaStrings are the variables formulas can use.
+// cpu, memory and disk are this tick's values from the built-in model;
+// prev_* are last tick's emitted values.
+var (
+	formulaNumbers = []string{
+		"cpu", "memory", "disk", "prev_cpu", "prev_memory", "prev_disk",
+		"t", "hour", "minute", "weekday", "latitude", "longitude",
+	}
+	formulaStrings = []string{"server_id", "hostname", "role", "city", "country", "fleet"}
+)
+
+// parseMetricFormulas parses METRIC_FORMULAS, a semicolon-separated list
+// of "metric=expression" with metrics cpu, memory and disk, e.g.
+// "cpu=role == 'db' ? 70 + 10*sin(2*pi*hour/24) : cpu".
+func parseMetricFormulas(spec string) (*metricFormulas, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	f := &metricFormulas{}
+	for _, name := range formulaNumbers {
+		f.vars.declare(name, exprNumber)
+	}
+	for _, name := range formulaStrings {
+		f.vars.declare(name, exprString)
+	}
+
+	for _, entry := range strings.Split(spec, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		metric, src, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid metric formula %q (want metric=expression)", entry)
+		}
+		metric = strings.TrimSpace(metric)
+		i, ok := formulaMetrics[metric]
+		if !ok {
+			return nil, fmt.Errorf("unknown metric %q in formula (want cpu, memory or disk)", metric)
+		}
+		formula, err := compileExpr(src, &f.vars)
+		if err != nil {
+			return nil, fmt.Errorf("formula for %s: %w", metric, err)
+		}
+		f.formulas[i] = formula
+	}
+	return f, nil
+}
+
+// apply replaces cpu, mem and disk with the values of their formulas. A
+// formula that yields NaN or an infinity keeps the model's value.
+func (f *metricFormulas) apply(server fleet.ServerConfig, prev MetricData, exists bool, now time.Time, rnd *rand.Rand, cpu, mem, disk *float64) {
+	if f == nil {
+		return
+	}
+	if !exists {
+		prev.CPUUsage, prev.MemoryUsage, prev.DiskUsage = *cpu, *mem, *disk
+	}
+	env := &exprEnv{
+		numbers: []float64{
+			*cpu, *mem, *disk, prev.CPUUsage, prev.MemoryUsage, prev.DiskUsage,
+			float64(now.Unix()), float64(now.Hour()) + float64(now.Minute())/60, float64(now.Minute()),
+			float64(now.Weekday()), server.Location.Latitude, server.Location.Longitude,
+		},
+		strings: []string{server.ID, server.Hostname, server.Role, server.Location.City, server.Location.Country, server.Fleet},
+		rnd:     rnd,
+	}
+
+	for i, usage := range []*float64{cpu, mem, disk} {
+		if f.formulas[i] == nil {
+			continue
+		}
+		if v := f.formulas[i](env); !math.IsNaN(v) && !math.IsInf(v, 0) {
+			*usage = clampPercent(v)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package generate
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// dataGaps leaves holes in servers' series, at random or on a schedule,
+// to exercise gap filling and no-data alerts. The servers keep running
+// through a gap; only their reports go missing.
+type dataGaps struct {
+	seed  int64
+	rules []gapRule
+}
+
+// gapRule drops a share of the reports of the servers targets selects,
+// or every report during the first length of each period.
+type gapRule struct {
+	targets serverSelector
+	drop    float64
+	length  time.Duration
+	period  time.Duration
+}
+
+// parseDataGaps parses DATA_GAPS, a semicolon-separated list of
+// "selector=gap" where gap is a share of reports to drop, like "1%", or
+// a gap length every period, like "5m/1h", e.g. "all=0.5%;2%=5m/1h".
+func parseDataGaps(spec string, seed int64) (*dataGaps, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	g := &dataGaps{seed: seed}
+	for _, entry := range strings.Split(spec, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid data gap %q (want selector=gap)", entry)
+		}
+		targets, err := parseServerSelector(entry[:i])
+		if err != nil {
+			return nil, err
+		}
+		rule := gapRule{targets: targets}
+		gap := strings.TrimSpace(entry[i+1:])
+		if share, ok := strings.CutSuffix(gap, "%"); ok {
+			p, err := strconv.ParseFloat(share, 64)
+			if err != nil || p <= 0 || p > 100 {
+				return nil, fmt.Errorf("invalid share in data gap %q (want a percentage above 0)", entry)
+			}
+			rule.drop = p / 100
+		} else {
+			length, period, ok := strings.Cut(gap, "/")
+			rule.length, err = time.ParseDuration(length)
+			if err == nil && ok {
+				rule.period, err = time.ParseDuration(period)
+			}
+			if !ok || err != nil || rule.length <= 0 || rule.period <= rule.length {
+				return nil, fmt.Errorf("invalid schedule in data gap %q (want a share like 1%% or a gap shorter than its period, like 5m/1h)", entry)
+			}
+		}
+		g.rules = append(g.rules, rule)
+	}
+	return g, nil
+}
+
+// missing reports whether server's report at t falls in a gap of any rule
+// that matches it. Each server's scheduled gaps start at a point of the
+// period of their own, so the fleet doesn't go quiet all at once.
+func (g *dataGaps) missing(server fleet.ServerConfig, t time.Time) bool {
+	if g == nil {
+		return false
+	}
+	for i, rule := range g.rules {
+		if !rule.targets.matches(server) {
+			continue
+		}
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d/%d/%s", g.seed, i, server.ID)
+		if rule.drop > 0 {
+			fmt.Fprintf(h, "/%d", t.UnixNano())
+			if float64(h.Sum64()%1000000)/1000000 < rule.drop {
+				return true
+			}
+			continue
+		}
+		period := int64(rule.period)
+		phase := int64(h.Sum64() % uint64(period))
+		if into := ((t.UnixNano()-phase)%period + period) % period; time.Duration(into) < rule.length {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,860 @@
+// Package generate simulates a fleet's metrics and the logs, traces and
+// other documents that go with them, one tick at a time.
+package generate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+type MetricData struct {
+	Timestamp   time.Time      `json:"@timestamp"`
+	ServerID    string         `json:"server_id"`
+	Hostname    string         `json:"hostname"`
+	IPAddress   string         `json:"ip_address"`
+	Country     string         `json:"country"`
+	City        string         `json:"city"`
+	Latitude    float64        `json:"latitude"`
+	Longitude   float64        `json:"longitude"`
+	Location    fleet.GeoPoint `json:"location"`
+	CPUUsage    float64        `json:"cpu_usage"`
+	MemoryUsage float64        `json:"memory_usage"`
+	DiskUsage   float64        `json:"disk_usage"`
+
+	AgentVersion  string `json:"agent_version"`
+	SchemaVersion int    `json:"schema_version"`
+
+	// Set only with FLEETS
+	Fleet string `json:"fleet,omitempty"`
+
+	// Set only with ENERGY_METRICS; power_watts also with HARDWARE_SENSORS
+	InstanceType    string  `json:"instance_type,omitempty"`
+	PowerWatts      float64 `json:"power_watts,omitempty"`
+	CarbonIntensity float64 `json:"carbon_intensity,omitempty"` // gCO2e per kWh
+	CO2eGrams       float64 `json:"co2e_grams,omitempty"`       // emitted over one interval
+
+	// Set only with OS_DISTRIBUTION
+	OSFamily      string `json:"os_family,omitempty"`
+	OSName        string `json:"os_name,omitempty"`
+	OSVersion     string `json:"os_version,omitempty"`
+	KernelVersion string `json:"kernel_version,omitempty"`
+	Architecture  string `json:"architecture,omitempty"`
+
+	// Set only with CLOUD_PROVIDERS
+	CloudProvider         string `json:"cloud_provider,omitempty"`
+	CloudAccountID        string `json:"cloud_account_id,omitempty"`
+	CloudRegion           string `json:"cloud_region,omitempty"`
+	CloudAvailabilityZone string `json:"cloud_availability_zone,omitempty"`
+	CloudInstanceType     string `json:"cloud_instance_type,omitempty"`
+	CloudInstanceID       string `json:"cloud_instance_id,omitempty"`
+
+	// Set only with HARDWARE_SENSORS
+	InletTemperature float64 `json:"inlet_temperature_celsius,omitempty"`
+	CPUTemperature   float64 `json:"cpu_temperature_celsius,omitempty"`
+	FanSpeedRPM      float64 `json:"fan_speed_rpm,omitempty"`
+
+	// Set only with DOC_TTL, as documents are delivered
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// Set only with GEOIP_DB or PUBLIC_IPS; geoip_truth only with GEOIP_DB
+	PublicIP string          `json:"public_ip,omitempty"`
+	GeoTruth *fleet.GeoTruth `json:"geoip_truth,omitempty"`
+
+	// Set only with IPV6; public_ipv6 also needs PUBLIC_IPS
+	IPv6Address string `json:"ipv6_address,omitempty"`
+	PublicIPv6  string `json:"public_ipv6,omitempty"`
+
+	// Localized metadata, set only with LOCALIZED_METADATA
+	Locale       string `json:"locale,omitempty"`
+	CountryLocal string `json:"country_local,omitempty"`
+	CityLocal    string `json:"city_local,omitempty"`
+	HostLabel    string `json:"host_label,omitempty"`
+
+	// Set only with DOCKER_CONTAINERS and DOCKER_NESTED
+	Containers []DockerContainer `json:"containers,omitempty"`
+
+	// Set only with HIGH_CARDINALITY_RATES
+	ContainerID string            `json:"container_id,omitempty"`
+	PodName     string            `json:"pod_name,omitempty"`
+	RequestID   string            `json:"request_id,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
+	// Set only with the jvm workload profile
+	*JVMMetrics
+
+	// Set only with the db workload profile
+	*DatabaseMetrics
+
+	// Set only with the web workload profile
+	*WebMetrics
+
+	// Set only with the cache workload profile
+	*CacheMetrics
+
+	// Set only with UPTIME_METRICS
+	UptimeSeconds int64 `json:"uptime_seconds,omitempty"`
+
+	// Set only with CLOCK_SKEW: how far the server's clock, and so the
+	// timestamp, is off
+	ClockOffsetMs int64 `json:"clock_offset_ms,omitempty"`
+}
+
+// Deliverer accepts generated documents for delivery.
+type Deliverer interface {
+	Submit(ctx context.Context, docs ...sink.Document)
+}
+
+type MetricGenerator struct {
+	servers       []fleet.ServerConfig
+	delivery      Deliverer
+	metricTracker map[string]MetricData
+	esIndex       sink.IndexNamer
+	docIDs        DocumentIDGenerator
+	fields        *fieldInjector
+	rollout       agentRollout
+	anomalies     *anomalySet
+	chaos         *chaosMonkey
+	changes       *changeGenerator
+	patterns      *thresholdPatterns
+	interval      time.Duration
+	align         bool         // ALIGN_TIMESTAMPS: tick on wall-clock interval boundaries
+	tickAt        atomic.Int64 // Aligned time of the tick in progress, in Unix nanoseconds
+	intervals     *serverIntervals
+	timing        *timingFaults
+	gaps          *dataGaps
+	churn         *fleetChurn
+	fleetSchedule emissionSchedule // Fleet-wide documents, with SERVER_INTERVALS
+	quality       qualityProfile
+	walk          *walkConfig
+	clock         func() time.Time // Simulated time; nil uses the wall clock
+	ticks         tickClock
+	seed          int64
+	sims          map[string]*serverSim
+	workers       int
+	disk          *diskSawtooth
+	energy        bool
+	platforms     *osMix
+	clouds        *cloudMix
+	sensors       bool
+	edges         *edgeValues
+	formulas      *metricFormulas
+	correlations  *metricCorrelations
+	cardinality   *cardinalityRates
+	reboots       *rebootModel
+	truthWindow   time.Duration // How long values are kept for /truth; 0 keeps none
+	logs          *logGenerator
+	traces        *traceGenerator
+	services      *serviceGenerator
+	queues        *queueGenerator
+	latency       *latencyMatrix
+	kube          *kubeCluster
+	docker        *dockerGenerator
+	profiles      workloadProfiles
+	gpus          *gpuGenerator
+	heartbeats    *heartbeats
+	security      *securityGenerator
+	fleetConfig   config.Config // Rebuilds the fleet when the control API scales it
+	control       controlState
+	mu            sync.Mutex
+}
+
+// NewMetricGenerator sets up the simulations config describes over
+// servers. Documents are handed to delivery as they are generated.
+func NewMetricGenerator(config config.Config, servers []fleet.ServerConfig, delivery Deliverer) (*MetricGenerator, error) {
+	// Select the document ID strategy
+	docIDs, err := newDocumentIDGenerator(config.ESDocID)
+	if err != nil {
+		return nil, fmt.Errorf("configuring document IDs: %w", err)
+	}
+
+	// Parse the missing/null field injection rates
+	fields, err := newFieldInjector(config.FieldMissingRates, config.FieldNullRates, config.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("configuring field injection: %w", err)
+	}
+
+	// Pick how much effort goes into each metric
+	quality, err := parseQualityProfile(config.QualityProfile)
+	if err != nil {
+		return nil, fmt.Errorf("configuring quality profile: %w", err)
+	}
+	walk, err := parseWalkParams(config.WalkParams)
+	if err != nil {
+		return nil, fmt.Errorf("configuring walk parameters: %w", err)
+	}
+
+	// Parse the edge float value rates
+	edges, err := parseEdgeValueRates(config.EdgeValueRates)
+	if err != nil {
+		return nil, fmt.Errorf("configuring edge values: %w", err)
+	}
+
+	// Compile the user-supplied metric formulas
+	formulas, err := parseMetricFormulas(config.MetricFormulas)
+	if err != nil {
+		return nil, fmt.Errorf("configuring metric formulas: %w", err)
+	}
+
+	// Parse the correlations between metrics
+	correlations, err := parseMetricCorrelations(config.MetricCorrelations)
+	if err != nil {
+		return nil, fmt.Errorf("configuring metric correlations: %w", err)
+	}
+
+	// Parse the high-cardinality field rates
+	cardinality, err := parseCardinalityRates(config.HighCardinalityRates)
+	if err != nil {
+		return nil, fmt.Errorf("configuring high cardinality: %w", err)
+	}
+
+	// Track uptime and reboot servers now and then
+	reboots, err := newRebootModel(config.UptimeMetrics, config.RebootRate, config.RebootDowntime)
+	if err != nil {
+		return nil, fmt.Errorf("configuring reboots: %w", err)
+	}
+
+	// Let servers report at intervals of their own
+	if config.Interval < minInterval {
+		return nil, fmt.Errorf("invalid INTERVAL %s (want a duration of at least %s)", config.Interval, minInterval)
+	}
+	intervals, err := parseServerIntervals(config.ServerIntervals)
+	if err != nil {
+		return nil, fmt.Errorf("configuring server intervals: %w", err)
+	}
+	if intervals.shortest(config.Interval) < time.Second && config.ESDocID == "timestamp" {
+		slog.Warn("Intervals under a second give servers several documents per second, which ES_DOC_ID=timestamp overwrites; consider ES_DOC_ID=hash")
+	}
+
+	// Let documents arrive late and server clocks run off
+	timing, err := parseTimingFaults(config.LateArrivals, config.ClockSkew)
+	if err != nil {
+		return nil, fmt.Errorf("configuring timing faults: %w", err)
+	}
+
+	// Let servers' series have holes
+	gaps, err := parseDataGaps(config.DataGaps, config.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("configuring data gaps: %w", err)
+	}
+
+	// Let servers join, leave and autoscale while running
+	churn, err := parseFleetChurn(config, servers)
+	if err != nil {
+		return nil, fmt.Errorf("configuring fleet churn: %w", err)
+	}
+
+	// Write log lines alongside the metrics
+	logs, err := newLogGenerator(config.ServerLogRate, config.ServerLogIndex)
+	if err != nil {
+		return nil, fmt.Errorf("configuring server logs: %w", err)
+	}
+	security, err := newSecurityGenerator(config.SecurityEventRate, config.SecurityAttackRate, config.SecurityIndex)
+	if err != nil {
+		return nil, fmt.Errorf("configuring security events: %w", err)
+	}
+
+	// Model log-heavy disks as a daily sawtooth
+	disk, err := parseDiskSawtooth(config.DiskGrowthRates, config.DiskRotationTime)
+	if err != nil {
+		return nil, fmt.Errorf("configuring disk growth: %w", err)
+	}
+
+	// Summarize the simulated services running on the fleet, burning
+	// their error budgets as scripted from startup
+	slo, err := parseSLOs(config.SLOTarget, config.SLOBurns, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("configuring SLOs: %w", err)
+	}
+	services, err := newServiceGenerator(config.Services, config.Topology, config.ServiceMetricsIndex, slo, servers, config.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("configuring services: %w", err)
+	}
+
+	// Follow the message queues between the fleet's roles
+	queues, err := newQueueGenerator(config.Queues, config.QueueIndex, servers, config.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("configuring queues: %w", err)
+	}
+
+	// Probe the network between the fleet's locations
+	latency, err := newLatencyMatrix(config.NetworkLatency, config.NetworkLatencyPairs, config.NetworkLatencyIndex, servers, config.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("configuring network latency: %w", err)
+	}
+
+	// Schedule the simulated Kubernetes pods onto the fleet's servers
+	kube, err := newKubeCluster(config.KubernetesDeployments, config.KubernetesCluster, config.KubernetesIndex, servers, config.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("configuring Kubernetes: %w", err)
+	}
+
+	docker, err := newDockerGenerator(config.DockerContainers, config.DockerNested, config.DockerIndex)
+	if err != nil {
+		return nil, fmt.Errorf("configuring Docker containers: %w", err)
+	}
+
+	platforms, err := parseOSMix(config.OSDistribution)
+	if err != nil {
+		return nil, fmt.Errorf("configuring operating systems: %w", err)
+	}
+	clouds, err := parseCloudMix(config.CloudProviders, config.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("configuring cloud metadata: %w", err)
+	}
+
+	// Pick the servers running each workload profile
+	profiles, err := parseWorkloadProfiles(config.WorkloadProfiles)
+	if err != nil {
+		return nil, fmt.Errorf("configuring workload profiles: %w", err)
+	}
+
+	gpus, err := newGPUGenerator(profiles, config.GPUCount, config.GPUIndex)
+	if err != nil {
+		return nil, fmt.Errorf("configuring GPUs: %w", err)
+	}
+
+	// Schedule the recorded incident, if any
+	anomalies := &anomalySet{}
+	if config.IncidentReplayFile != "" {
+		replay, err := loadIncidentReplay(config)
+		if err != nil {
+			return nil, fmt.Errorf("loading incident replay: %w", err)
+		}
+		anomalies.Add(replay)
+		slog.Info("Replaying incident", "file", config.IncidentReplayFile,
+			"hosts", config.IncidentReplayHosts, "start", replay.Start, "end", replay.End)
+	}
+
+	// Schedule the scripted scenario, if any, from startup
+	if config.ScenarioFile != "" {
+		sc, err := loadScenario(config.ScenarioFile, time.Now().UTC())
+		if err != nil {
+			return nil, fmt.Errorf("loading scenario %s: %w", config.ScenarioFile, err)
+		}
+		slog.Info("Running scenario", "name", sc.Name, "events", len(sc.Events))
+		for _, event := range sc.Events {
+			anomalies.Add(event)
+			slog.Info("Scheduled scenario event", "name", event.Name, "kind", event.Kind, "start", event.Start, "end", event.End)
+		}
+	}
+
+	// Parse the threshold-crossing test patterns
+	patterns, err := parseThresholdPatterns(config.ThresholdPatterns)
+	if err != nil {
+		return nil, fmt.Errorf("configuring threshold patterns: %w", err)
+	}
+
+	changes, err := newChangeGenerator(config.ChangeEvents, config.ChangeMeanInterval, config.ChangeIndex, config.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("configuring change events: %w", err)
+	}
+
+	// Schedule random incidents in chaos mode
+	chaos, err := newChaosMonkey(config.Chaos, config.ChaosMeanInterval, config.ChaosIncidentKinds, config.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("configuring chaos mode: %w", err)
+	}
+
+	// Stamp every document with its expiry
+	if config.DocTTL > 0 {
+		delivery = expiringDeliverer{next: delivery, ttl: config.DocTTL}
+	}
+
+	return &MetricGenerator{
+		servers:       servers,
+		delivery:      delivery,
+		metricTracker: make(map[string]MetricData),
+		esIndex:       sink.NewIndexNamer(config.ESIndex),
+		docIDs:        docIDs,
+		fields:        fields,
+		rollout: agentRollout{
+			OldVersion: config.AgentVersionOld,
+			NewVersion: config.AgentVersionNew,
+			Start:      config.AgentRolloutStart,
+			Duration:   config.AgentRolloutDuration,
+		},
+		anomalies:    anomalies,
+		chaos:        chaos,
+		changes:      changes,
+		patterns:     patterns,
+		interval:     config.Interval,
+		align:        config.AlignTimestamps,
+		intervals:    intervals,
+		timing:       timing,
+		gaps:         gaps,
+		churn:        churn,
+		quality:      quality,
+		walk:         walk,
+		workers:      config.Workers,
+		disk:         disk,
+		energy:       config.EnergyMetrics,
+		platforms:    platforms,
+		clouds:       clouds,
+		sensors:      config.HardwareSensors,
+		edges:        edges,
+		formulas:     formulas,
+		correlations: correlations,
+		cardinality:  cardinality,
+		reboots:      reboots,
+		seed:         config.Seed,
+		logs:         logs,
+		services:     services,
+		queues:       queues,
+		latency:      latency,
+		kube:         kube,
+		docker:       docker,
+		profiles:     profiles,
+		gpus:         gpus,
+		security:     security,
+		heartbeats:   newHeartbeats(config.Heartbeats, config.HeartbeatIndex),
+
+		fleetConfig: config,
+		control:     controlState{wake: make(chan struct{}, 1)},
+	}, nil
+}
+
+// ExportTraces traces requests across the fleet as config describes,
+// exporting them over OTLP. Dry runs leave traces out to stay offline.
+func (mg *MetricGenerator) ExportTraces(config config.Config) error {
+	exporter, err := newOTLPExporter(config.OTLPEndpoint, config.OTLPHeaders)
+	if err != nil {
+		return fmt.Errorf("configuring OTLP export: %w", err)
+	}
+	mg.traces, err = newTraceGenerator(config.TraceRate, mg.servers, config.Seed, exporter)
+	if err != nil {
+		return fmt.Errorf("configuring traces: %w", err)
+	}
+	return nil
+}
+
+func (mg *MetricGenerator) generateConsistentServerMetric(server fleet.ServerConfig) MetricData {
+	// Only the shared maps are locked; a server is generated by one worker
+	// at a time, so its own state is safe to use unlocked.
+	mg.mu.Lock()
+	prevMetric, exists := mg.metricTracker[server.ID]
+	sim := mg.serverSim(server)
+	mg.mu.Unlock()
+
+	now := mg.Now().UTC()
+	cpuUsage, memoryUsage, diskUsage := mg.nextUsage(server, prevMetric, exists, now, sim)
+	if !exists {
+		sim.diskBase = diskUsage
+	}
+	if disk, ok := mg.disk.usage(server.Role, sim.diskBase, now, sim.rnd); ok {
+		diskUsage = disk
+	}
+	mg.formulas.apply(server, prevMetric, exists, now, sim.formulaRnd, &cpuUsage, &memoryUsage, &diskUsage)
+
+	metric := MetricData{
+		Timestamp:   now,
+		ServerID:    server.ID,
+		Hostname:    server.Hostname,
+		IPAddress:   server.IPAddress,
+		Country:     server.Location.Country,
+		City:        server.Location.City,
+		Latitude:    server.Location.Latitude,
+		Longitude:   server.Location.Longitude,
+		Location:    fleet.GeoPoint{Lat: server.Location.Latitude, Lon: server.Location.Longitude},
+		HostLabel:   server.Label,
+		Fleet:       server.Fleet,
+		PublicIP:    server.PublicIP,
+		GeoTruth:    server.GeoTruth,
+		IPv6Address: server.IPv6Address,
+		PublicIPv6:  server.PublicIPv6,
+		CPUUsage:    roundFloat(cpuUsage, 2),
+		MemoryUsage: roundFloat(memoryUsage, 2),
+		DiskUsage:   roundFloat(diskUsage, 2),
+	}
+
+	if server.Label != "" {
+		metric.Locale = server.Location.Locale
+		metric.CountryLocal = server.Location.CountryLocal
+		metric.CityLocal = server.Location.CityLocal
+	}
+
+	metric.AgentVersion, metric.SchemaVersion = mg.rollout.versionAt(server.ID, metric.Timestamp)
+
+	// Anomalies only shape the emitted values; the random walk continues
+	// from the baseline so metrics recover once an anomaly ends.
+	mg.mu.Lock()
+	mg.metricTracker[server.ID] = metric
+	mg.mu.Unlock()
+	mg.correlations.apply(&metric, &sim.correlation)
+	mg.churn.shareLoad(server, &metric)
+	mg.reboots.apply(&sim.uptime, &metric)
+	mg.platforms.apply(server, &metric)
+	mg.clouds.apply(server, &metric)
+	mg.patterns.apply(server, &metric)
+	load := metric.CPUUsage
+	mg.anomalies.apply(server, &metric)
+	mg.applyProfiles(server, &metric, load, sim)
+	// Edge values bypass rounding, which would flush subnormals to zero
+	mg.edges.apply(&metric, sim.rnd)
+	mg.cardinality.apply(&metric, server.Role, &sim.cardinality, sim.cardinalityRnd)
+
+	if mg.sensors {
+		applySensors(server, &metric, &sim.sensors, mg.serverInterval(server), sim.sensorRnd)
+	}
+	if mg.energy {
+		applyEnergy(server, &metric, mg.serverInterval(server))
+	}
+
+	if mg.truthWindow > 0 {
+		sim.truth.record(truthPoint{
+			Timestamp:      metric.Timestamp,
+			CPUUsage:       metric.CPUUsage,
+			MemoryUsage:    metric.MemoryUsage,
+			DiskUsage:      metric.DiskUsage,
+			BaselineCPU:    roundFloat(cpuUsage, 2),
+			BaselineMemory: roundFloat(memoryUsage, 2),
+			BaselineDisk:   roundFloat(diskUsage, 2),
+		}, mg.truthWindow)
+	}
+
+	// The server stamps its report with its own clock
+	timestamp, offset := mg.timing.skew(&sim.skew, sim.timingRnd, metric.Timestamp)
+	metric.Timestamp, metric.ClockOffsetMs = timestamp, offset.Milliseconds()
+	return metric
+}
+
+// metricDocument encodes metric into a document ready for delivery.
+func (mg *MetricGenerator) metricDocument(server fleet.ServerConfig, metric MetricData) (sink.Document, error) {
+	jsonMetric, err := mg.encode(metric)
+	if err != nil {
+		return sink.Document{}, err
+	}
+
+	return sink.Document{
+		Type:      "metric",
+		ServerID:  metric.ServerID,
+		Hostname:  server.Hostname,
+		Role:      server.Role,
+		Timestamp: metric.Timestamp,
+		Index:     mg.esIndex(metric.Timestamp),
+		ID:        mg.docIDs.NewID(metric),
+		Body:      jsonMetric,
+	}, nil
+}
+
+// GenerateConsistentMetrics ticks until ctx is done or maxDocs documents
+// have been generated (0 means no limit) and returns how many were.
+func (mg *MetricGenerator) GenerateConsistentMetrics(ctx context.Context, maxDocs int) int {
+	defer mg.timing.release(mg.delivery, time.Time{})
+	generated := 0
+	var tickedAt time.Time
+	for {
+		paused, interval := mg.applyControl()
+		interval = mg.intervals.shortest(interval)
+		wait := interval - time.Since(tickedAt)
+		if mg.align && !tickedAt.IsZero() {
+			// Tick on the wall-clock boundaries of the interval
+			wait = time.Until(tickedAt.Truncate(interval).Add(interval))
+		}
+		if !paused && wait <= 0 {
+			limit := 0
+			if maxDocs > 0 {
+				limit = maxDocs - generated
+			}
+			generated += mg.tick(limit)
+			if maxDocs > 0 && generated >= maxDocs {
+				return generated
+			}
+			tickedAt, wait = time.Now(), interval
+			if mg.align {
+				wait = time.Until(tickedAt.Truncate(interval).Add(interval))
+			}
+		}
+
+		// The control API wakes the loop so pausing, resuming and interval
+		// changes take effect without waiting out the current interval.
+		var next <-chan time.Time
+		if !paused {
+			next = time.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return generated
+		case <-next:
+		case <-mg.control.wake:
+		}
+	}
+}
+
+// GenerateUnpaced ticks back to back, as fast as the sinks accept the
+// documents, until ctx is done or maxDocs documents have been generated
+// (0 means no limit), and returns how many were.
+func (mg *MetricGenerator) GenerateUnpaced(ctx context.Context, maxDocs int) int {
+	defer mg.timing.release(mg.delivery, time.Time{})
+	generated := 0
+	for ctx.Err() == nil && (maxDocs == 0 || generated < maxDocs) {
+		limit := 0
+		if maxDocs > 0 {
+			limit = maxDocs - generated
+		}
+		generated += mg.tick(limit)
+	}
+	return generated
+}
+
+// GenerateTicks generates up to ticks ticks back to back, stopping early
+// once maxDocs documents have been generated (0 means no limit), and
+// returns how many were. A non-zero start replaces the wall clock with
+// simulated time advancing one interval per tick.
+func (mg *MetricGenerator) GenerateTicks(ticks, maxDocs int, start time.Time) int {
+	defer mg.timing.release(mg.delivery, time.Time{})
+	var clock *simulatedClock
+	if !start.IsZero() {
+		clock = newSimulatedClock(start)
+		mg.clock = clock.Now
+	}
+	generated := 0
+	for i := 0; i < ticks && (maxDocs == 0 || generated < maxDocs); i++ {
+		generated += mg.tick(maxDocs - generated)
+		if clock != nil {
+			clock.Advance(mg.TickInterval())
+		}
+	}
+	return generated
+}
+
+// Tick generates one tick at the generator's current time and returns
+// the number of metrics generated.
+func (mg *MetricGenerator) Tick() int {
+	return mg.tick(0)
+}
+
+// TickInterval returns how often the generator ticks: its interval, or
+// the shortest of SERVER_INTERVALS if that is shorter.
+func (mg *MetricGenerator) TickInterval() time.Duration {
+	return mg.intervals.shortest(mg.interval)
+}
+
+// workerChunkSize is how many servers a worker generates before handing
+// their documents to the dispatcher in one go.
+const workerChunkSize = 256
+
+// tickCounts counts the documents of each type a worker generated.
+type tickCounts struct {
+	metrics, heartbeats, logs, security, containers, gpus int64
+}
+
+// tick generates and submits one metric per server using a fixed pool of
+// workers, each taking chunks of servers. With SERVER_INTERVALS only the
+// servers due to report do, and with fleet churn the fleet changes first.
+// A positive limit considers only the first limit servers. It returns the
+// number of metrics generated.
+func (mg *MetricGenerator) tick(limit int) int {
+	start := time.Now()
+	period := mg.intervals.shortest(mg.interval)
+	if mg.align {
+		// Every document of the tick carries the boundary it belongs to
+		mg.tickAt.Store(mg.Now().Truncate(period).UnixNano())
+		defer mg.tickAt.Store(0)
+	}
+	mg.timing.release(mg.delivery, mg.Now().UTC())
+	mg.churnFleet(period)
+	mg.chaos.schedule(mg.Now().UTC(), mg.servers, mg.anomalies)
+	if docs := mg.changeDocuments(mg.Now().UTC()); len(docs) > 0 {
+		telemetry.Stats.Generated.Add("event", int64(len(docs)))
+		mg.delivery.Submit(context.Background(), docs...)
+	}
+	servers := mg.servers
+	if limit > 0 && limit < len(servers) {
+		servers = servers[:limit]
+	}
+	var generated atomic.Int64
+
+	chunks := make(chan []fleet.ServerConfig)
+	var wg sync.WaitGroup
+	for i := 0; i < mg.workerCount(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			docs := make([]sink.Document, 0, workerChunkSize)
+			for chunk := range chunks {
+				docs = docs[:0]
+				var n tickCounts
+				for _, srv := range chunk {
+					first := len(docs)
+					if !mg.reportsNow(srv, period) || mg.anomalies.down(srv, mg.Now().UTC()) || mg.rebooting(srv) {
+						continue
+					}
+					before := n
+					metric := mg.generateConsistentServerMetric(srv)
+					running := mg.dockerContainers(srv, metric)
+					if mg.docker.nested() {
+						metric.Containers, running = running, nil
+					}
+					doc, err := mg.metricDocument(srv, metric)
+					if err != nil {
+						slog.Error("Error marshaling metric", "server_id", metric.ServerID, "error", err)
+						continue
+					}
+					docs = append(docs, doc)
+					n.metrics++
+					if hb, ok := mg.heartbeatDocument(srv, metric); ok {
+						docs = append(docs, hb)
+						n.heartbeats++
+					}
+					mg.traces.observe(srv, metric)
+					mg.services.observe(srv, metric)
+					mg.queues.observe(srv, metric)
+					mg.kube.observe(srv, metric)
+
+					lines := mg.logDocuments(srv, metric)
+					docs = append(docs, lines...)
+					n.logs += int64(len(lines))
+
+					events := mg.securityDocuments(srv, metric)
+					docs = append(docs, events...)
+					n.security += int64(len(events))
+
+					if len(running) > 0 {
+						docs = append(docs, mg.dockerDocuments(srv, metric, running)...)
+						n.containers += int64(len(running))
+					}
+
+					readings := mg.gpuDocuments(srv, metric)
+					docs = append(docs, readings...)
+					n.gpus += int64(len(readings))
+
+					if mg.gaps.missing(srv, mg.Now().UTC()) {
+						// The server carried on and its state moved with
+						// it; only its report is lost
+						docs, n = docs[:first], before
+					} else if mg.holdLate(srv, docs[first:]) {
+						docs = docs[:first]
+					}
+				}
+				telemetry.Stats.Generated.Add("metric", n.metrics)
+				generated.Add(n.metrics)
+				if n.heartbeats > 0 {
+					telemetry.Stats.Generated.Add("heartbeat", n.heartbeats)
+				}
+				if n.logs > 0 {
+					telemetry.Stats.Generated.Add("log", n.logs)
+				}
+				if n.security > 0 {
+					telemetry.Stats.Generated.Add("security", n.security)
+				}
+				if n.containers > 0 {
+					telemetry.Stats.Generated.Add("docker", n.containers)
+				}
+				if n.gpus > 0 {
+					telemetry.Stats.Generated.Add("gpu", n.gpus)
+				}
+				mg.delivery.Submit(context.Background(), docs...)
+			}
+		}()
+	}
+
+	for i := 0; i < len(servers); i += workerChunkSize {
+		chunks <- servers[i:min(i+workerChunkSize, len(servers))]
+	}
+	close(chunks)
+	wg.Wait()
+
+	// Fleet-wide documents keep to the generator's interval
+	if mg.fleetDue(period) {
+		if docs := mg.serviceDocuments(mg.Now().UTC()); len(docs) > 0 {
+			telemetry.Stats.Generated.Add("service", int64(len(docs)))
+			mg.delivery.Submit(context.Background(), docs...)
+		}
+		if docs := mg.queueDocuments(mg.Now().UTC()); len(docs) > 0 {
+			telemetry.Stats.Generated.Add("queue", int64(len(docs)))
+			mg.delivery.Submit(context.Background(), docs...)
+		}
+		if docs := mg.latencyDocuments(mg.Now().UTC()); len(docs) > 0 {
+			telemetry.Stats.Generated.Add("latency", int64(len(docs)))
+			mg.delivery.Submit(context.Background(), docs...)
+		}
+		if docs := mg.kubeDocuments(mg.Now().UTC()); len(docs) > 0 {
+			telemetry.Stats.Generated.Add("pod", int64(len(docs)/2))
+			telemetry.Stats.Generated.Add("container", int64(len(docs)/2))
+			mg.delivery.Submit(context.Background(), docs...)
+		}
+		mg.traces.tick(context.Background(), mg.Now().UTC(), mg.interval)
+	}
+	now := time.Now()
+	mg.ticks.mark(now)
+	telemetry.Stats.TickDuration.Observe(now.Sub(start))
+	telemetry.Stats.LastTick.Store(now.Unix())
+	return int(generated.Load())
+}
+
+// serverInterval returns how often server reports.
+func (mg *MetricGenerator) serverInterval(server fleet.ServerConfig) time.Duration {
+	return mg.intervals.of(server, mg.interval)
+}
+
+// reportsNow reports whether server is due to report on a tick, which
+// comes every period. Without SERVER_INTERVALS every server reports on
+// every tick.
+func (mg *MetricGenerator) reportsNow(server fleet.ServerConfig, period time.Duration) bool {
+	if mg.intervals == nil {
+		return true
+	}
+	mg.mu.Lock()
+	sim := mg.serverSim(server)
+	mg.mu.Unlock()
+
+	now, interval := mg.Now().UTC(), mg.serverInterval(server)
+	if sim.schedule.next.IsZero() && mg.align {
+		// Aligned servers report on the boundaries of their own interval
+		sim.schedule.next = now.Truncate(interval)
+		if sim.schedule.next.Before(now) {
+			sim.schedule.next = sim.schedule.next.Add(interval)
+		}
+	} else if sim.schedule.next.IsZero() {
+		// The first report comes at a random point of the first interval,
+		// spreading the fleet's timestamps
+		rnd := rand.New(rand.NewSource(fleet.DeriveSeed(mg.seed, server.ID+"/interval")))
+		sim.schedule.next = now.Add(time.Duration(rnd.Int63n(int64(interval))))
+	}
+	return sim.schedule.due(now, interval, period)
+}
+
+// fleetDue reports whether the fleet-wide documents are due on a tick,
+// which with SERVER_INTERVALS may come more often than the generator's
+// interval.
+func (mg *MetricGenerator) fleetDue(period time.Duration) bool {
+	if mg.intervals == nil {
+		return true
+	}
+	now := mg.Now().UTC()
+	if mg.fleetSchedule.next.IsZero() {
+		mg.fleetSchedule.next = now
+	}
+	return mg.fleetSchedule.due(now, mg.interval, period)
+}
+
+// workerCount returns the configured number of workers, defaulting to one
+// per CPU.
+func (mg *MetricGenerator) workerCount() int {
+	if mg.workers > 0 {
+		return mg.workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// lastTick returns when the generation loop last completed a tick.
+func (mg *MetricGenerator) lastTick() time.Time {
+	return mg.ticks.last()
+}
+
+func roundFloat(val float64, precision uint) float64 {
+	ratio := math.Pow(10, float64(precision))
+	return math.Round(val*ratio) / ratio
+}
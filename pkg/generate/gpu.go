@@ -0,0 +1,210 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// GPUDocument is one GPU's readings in the shape of NVIDIA's DCGM
+// exporter: the exporter's labels and its DCGM_FI_DEV_* fields.
+type GPUDocument struct {
+	Timestamp time.Time `json:"@timestamp"`
+	ServerID  string    `json:"server_id"`
+	Hostname  string    `json:"hostname"`
+	Role      string    `json:"role"`
+	GPU       string    `json:"gpu"`
+	UUID      string    `json:"UUID"`
+	Device    string    `json:"device"`
+	ModelName string    `json:"modelName"`
+
+	GPUUtil     float64 `json:"DCGM_FI_DEV_GPU_UTIL"`      // %
+	MemCopyUtil float64 `json:"DCGM_FI_DEV_MEM_COPY_UTIL"` // %
+	FBUsed      float64 `json:"DCGM_FI_DEV_FB_USED"`       // MiB
+	FBFree      float64 `json:"DCGM_FI_DEV_FB_FREE"`       // MiB
+	GPUTemp     float64 `json:"DCGM_FI_DEV_GPU_TEMP"`      // °C
+	MemoryTemp  float64 `json:"DCGM_FI_DEV_MEMORY_TEMP"`   // °C
+	PowerUsage  float64 `json:"DCGM_FI_DEV_POWER_USAGE"`   // W
+	SMClock     float64 `json:"DCGM_FI_DEV_SM_CLOCK"`      // MHz
+}
+
+// gpuModel is a GPU with its framebuffer size, power draw at idle and at
+// full load, and boost clock.
+type gpuModel struct {
+	Name      string
+	MemoryMiB float64
+	IdleWatts float64
+	MaxWatts  float64
+	MaxClock  float64
+}
+
+var gpuModels = []gpuModel{
+	{"NVIDIA A100-SXM4-80GB", 81920, 60, 400, 1410},
+	{"NVIDIA H100 80GB HBM3", 81559, 70, 700, 1980},
+	{"NVIDIA L4", 23034, 16, 72, 2040},
+	{"Tesla T4", 15360, 10, 70, 1590},
+}
+
+// gpuIdleClock is the SM clock of a GPU with nothing to do.
+const gpuIdleClock = 210
+
+// gpuGenerator simulates the GPUs of the servers running the gpu
+// workload profile. A server's GPUs run training jobs together: busy and
+// holding most of their memory for hours, then idle until the next job.
+type gpuGenerator struct {
+	perHost int
+	index   sink.IndexNamer
+}
+
+// simGPU is the state a GPU carries between ticks.
+type simGPU struct {
+	uuid string
+	temp float64
+}
+
+// gpuJob is the job running on a server's GPUs, if any, and when it
+// changes.
+type gpuJob struct {
+	running bool
+	until   time.Time
+	memory  float64 // Share of the framebuffer the job holds
+}
+
+// newGPUGenerator returns nil, disabling GPUs, unless profiles has the
+// gpu profile.
+func newGPUGenerator(profiles workloadProfiles, perHost int, index string) (*gpuGenerator, error) {
+	if _, ok := profiles["gpu"]; !ok {
+		return nil, nil
+	}
+	if perHost < 1 || perHost > 16 {
+		return nil, fmt.Errorf("GPU_COUNT must be between 1 and 16, got %d", perHost)
+	}
+	return &gpuGenerator{perHost: perHost, index: sink.NewIndexNamer(index)}, nil
+}
+
+// gpuModelFor picks a server's GPU model, hashing the server ID so the
+// choice is stable across runs.
+func gpuModelFor(server fleet.ServerConfig) gpuModel {
+	h := fnv.New32a()
+	h.Write([]byte(server.ID))
+	return gpuModels[h.Sum32()%uint32(len(gpuModels))]
+}
+
+// generate returns the readings of a server's GPUs at now, reported every
+// interval.
+func (g *gpuGenerator) generate(server fleet.ServerConfig, now time.Time, interval time.Duration, sim *serverSim) []GPUDocument {
+	rnd := sim.gpuRnd
+	model := gpuModelFor(server)
+	if sim.gpus == nil {
+		sim.gpus = make([]*simGPU, g.perHost)
+		for i := range sim.gpus {
+			var id [16]byte
+			rnd.Read(id[:])
+			sim.gpus[i] = &simGPU{uuid: "GPU-" + formatUUID(id)}
+		}
+		// Start somewhere in a job or between two
+		sim.gpuJob.running = rnd.Float64() < 0.8
+		sim.gpuJob.memory = 0.55 + rnd.Float64()*0.4
+		sim.gpuJob.until = now.Add(time.Duration(rnd.ExpFloat64() * float64(time.Hour)))
+	}
+
+	// Jobs run for three hours on average with a quarter of an hour
+	// between them
+	job := &sim.gpuJob
+	for !now.Before(job.until) {
+		job.running = !job.running
+		mean := 15 * time.Minute
+		if job.running {
+			mean = 3 * time.Hour
+			job.memory = 0.55 + rnd.Float64()*0.4
+		}
+		job.until = job.until.Add(time.Duration(rnd.ExpFloat64() * float64(mean)))
+	}
+
+	// Evaluation and checkpointing stall all of a server's GPUs at once
+	stalled := job.running && rnd.Float64() < 0.05
+
+	out := make([]GPUDocument, len(sim.gpus))
+	for i, gpu := range sim.gpus {
+		util, memory := rnd.Float64()*2, 0.005+rnd.Float64()*0.002
+		if job.running {
+			util = math.Min(100, 92+rnd.NormFloat64()*4)
+			if stalled {
+				util *= 0.2 + rnd.Float64()*0.2
+			}
+			memory = job.memory + rnd.NormFloat64()*0.005
+		}
+		util = math.Max(util, 0)
+		used := math.Round(math.Min(memory, 1) * model.MemoryMiB)
+
+		target := 32 + 0.5*util
+		if gpu.temp == 0 {
+			gpu.temp = target
+		} else {
+			gpu.temp += (target - gpu.temp) * (1 - math.Exp(-interval.Seconds()/thermalTimeConstant.Seconds()))
+		}
+		temp := gpu.temp + rnd.NormFloat64()*0.5
+
+		clock := float64(gpuIdleClock)
+		if util > 5 {
+			clock = model.MaxClock - math.Round(rnd.Float64()*60)
+		}
+
+		out[i] = GPUDocument{
+			Timestamp:   now,
+			ServerID:    server.ID,
+			Hostname:    server.Hostname,
+			Role:        server.Role,
+			GPU:         fmt.Sprint(i),
+			UUID:        gpu.uuid,
+			Device:      fmt.Sprintf("nvidia%d", i),
+			ModelName:   model.Name,
+			GPUUtil:     math.Round(util),
+			MemCopyUtil: math.Round(util * (0.4 + rnd.Float64()*0.2)),
+			FBUsed:      used,
+			FBFree:      model.MemoryMiB - used,
+			GPUTemp:     math.Round(temp),
+			MemoryTemp:  math.Round(temp + 6 + rnd.Float64()*4),
+			PowerUsage:  roundFloat(model.IdleWatts+(model.MaxWatts-model.IdleWatts)*util/100*(0.85+rnd.Float64()*0.15), 3),
+			SMClock:     clock,
+		}
+	}
+	return out
+}
+
+// gpuDocuments generates and encodes the GPUs of one server for the
+// current tick, if it runs the gpu profile.
+func (mg *MetricGenerator) gpuDocuments(server fleet.ServerConfig, metric MetricData) []sink.Document {
+	if mg.gpus == nil || !mg.profiles.runs(server, "gpu") {
+		return nil
+	}
+	mg.mu.Lock()
+	sim := mg.serverSim(server)
+	mg.mu.Unlock()
+
+	gpus := mg.gpus.generate(server, metric.Timestamp, mg.serverInterval(server), sim)
+	docs := make([]sink.Document, 0, len(gpus))
+	for _, gpu := range gpus {
+		body, err := json.Marshal(gpu)
+		if err != nil {
+			slog.Error("Error marshaling GPU readings", "server_id", server.ID, "gpu", gpu.GPU, "error", err)
+			continue
+		}
+		docs = append(docs, sink.Document{
+			Type:      "gpu",
+			ServerID:  server.ID,
+			Hostname:  server.Hostname,
+			Role:      server.Role,
+			Timestamp: metric.Timestamp,
+			Index:     mg.gpus.index(metric.Timestamp),
+			Body:      body,
+		})
+	}
+	return docs
+}
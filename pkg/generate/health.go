@@ -0,0 +1,111 @@
+package generate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// healthChecker backs the /healthz and /readyz endpoints.
+type healthChecker struct {
+	generator *MetricGenerator
+	ping      func(ctx context.Context) error
+}
+
+type healthStatus struct {
+	Status   string    `json:"status"`
+	LastTick time.Time `json:"last_tick,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// loopAlive reports an error unless the generation loop has completed a
+// tick recently or was paused through the control API. A loop stuck for
+// three intervals is considered dead.
+func (h *healthChecker) loopAlive() (time.Time, error) {
+	last := h.generator.lastTick()
+	paused, interval, _ := h.generator.controlView()
+	if paused {
+		return last, nil
+	}
+	if last.IsZero() {
+		return last, fmt.Errorf("generation loop has not completed a tick yet")
+	}
+	if stale := 3 * interval; time.Since(last) > stale {
+		return last, fmt.Errorf("no tick completed in the last %s", stale)
+	}
+	return last, nil
+}
+
+// Handler serves the health and readiness probes, with ping checking the
+// sinks, self-telemetry and ground truth for the last truthRetention, plus
+// the control API if control is set.
+func (mg *MetricGenerator) Handler(ping func(ctx context.Context) error, truthRetention time.Duration, control bool) http.Handler {
+	mg.truthWindow = truthRetention
+	health := &healthChecker{generator: mg, ping: ping}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", health.healthz)
+	mux.HandleFunc("/readyz", health.readyz)
+	mux.HandleFunc("/metrics", telemetry.Stats.ServeHTTP)
+	mux.HandleFunc("/truth", mg.serveTruth)
+	if control {
+		mg.registerControl(mux)
+	}
+	return mux
+}
+
+// healthz is the liveness probe: the generation loop is running.
+func (h *healthChecker) healthz(w http.ResponseWriter, r *http.Request) {
+	last, err := h.loopAlive()
+	if err != nil && !last.IsZero() {
+		writeHealth(w, http.StatusServiceUnavailable, healthStatus{Status: "unhealthy", LastTick: last, Error: err.Error()})
+		return
+	}
+	// Not having ticked yet is fine for liveness; the first tick may
+	// still be running on a large fleet.
+	writeHealth(w, http.StatusOK, healthStatus{Status: "ok", LastTick: last})
+}
+
+// readyz is the readiness probe: the loop has ticked and the sink answers.
+func (h *healthChecker) readyz(w http.ResponseWriter, r *http.Request) {
+	last, err := h.loopAlive()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if perr := h.ping(ctx); perr != nil {
+			err = fmt.Errorf("sink unreachable: %w", perr)
+		}
+	}
+	if err != nil {
+		writeHealth(w, http.StatusServiceUnavailable, healthStatus{Status: "not ready", LastTick: last, Error: err.Error()})
+		return
+	}
+	writeHealth(w, http.StatusOK, healthStatus{Status: "ready", LastTick: last})
+}
+
+func writeHealth(w http.ResponseWriter, code int, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// tickClock records when the generation loop last completed a tick.
+type tickClock struct {
+	nanos atomic.Int64
+}
+
+func (c *tickClock) mark(t time.Time) {
+	c.nanos.Store(t.UnixNano())
+}
+
+func (c *tickClock) last() time.Time {
+	n := c.nanos.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
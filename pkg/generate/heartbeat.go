@@ -0,0 +1,96 @@
+package generate
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// Heartbeat is an agent's check-in, sent alongside every report. A server
+// that is down or rebooting stops checking in, so the gap between
+// last_seen and now is what "host not reporting" rules look at.
+type Heartbeat struct {
+	Timestamp    time.Time  `json:"@timestamp"`
+	ServerID     string     `json:"server_id"`
+	Hostname     string     `json:"hostname"`
+	Role         string     `json:"role"`
+	Fleet        string     `json:"fleet,omitempty"`
+	AgentVersion string     `json:"agent_version"`
+	Status       string     `json:"status"`
+	LastSeen     time.Time  `json:"last_seen"`
+	PreviousSeen *time.Time `json:"previous_seen,omitempty"`
+	// Interval is how often the agent is expected to check in
+	Interval float64 `json:"check_in_interval_seconds"`
+	Sequence int64   `json:"sequence"` // check-ins since the agent last started
+}
+
+// heartbeatState is a server's agent between check-ins.
+type heartbeatState struct {
+	lastSeen time.Time
+	sequence int64
+}
+
+// heartbeats emits agent check-ins, set only with HEARTBEATS.
+type heartbeats struct {
+	index sink.IndexNamer
+}
+
+// newHeartbeats returns nil, disabling heartbeats, unless enabled.
+func newHeartbeats(enabled bool, index string) *heartbeats {
+	if !enabled {
+		return nil
+	}
+	return &heartbeats{index: sink.NewIndexNamer(index)}
+}
+
+// heartbeatDocument encodes server's check-in for the report just
+// generated. It returns false without heartbeats.
+func (mg *MetricGenerator) heartbeatDocument(server fleet.ServerConfig, metric MetricData) (sink.Document, bool) {
+	if mg.heartbeats == nil {
+		return sink.Document{}, false
+	}
+	mg.mu.Lock()
+	sim := mg.serverSim(server)
+	mg.mu.Unlock()
+
+	// A reboot restarts the agent's count
+	state := &sim.heartbeat
+	if sim.uptime.bootedAt.After(state.lastSeen) {
+		state.sequence = 0
+	}
+	hb := Heartbeat{
+		Timestamp:    metric.Timestamp,
+		ServerID:     server.ID,
+		Hostname:     server.Hostname,
+		Role:         server.Role,
+		Fleet:        server.Fleet,
+		AgentVersion: metric.AgentVersion,
+		Status:       "online",
+		LastSeen:     metric.Timestamp,
+		Interval:     mg.serverInterval(server).Seconds(),
+		Sequence:     state.sequence + 1,
+	}
+	if !state.lastSeen.IsZero() {
+		previous := state.lastSeen
+		hb.PreviousSeen = &previous
+	}
+	state.lastSeen, state.sequence = hb.LastSeen, hb.Sequence
+
+	body, err := json.Marshal(hb)
+	if err != nil {
+		slog.Error("Error marshaling heartbeat", "server_id", server.ID, "error", err)
+		return sink.Document{}, false
+	}
+	return sink.Document{
+		Type:      "heartbeat",
+		ServerID:  server.ID,
+		Hostname:  server.Hostname,
+		Role:      server.Role,
+		Timestamp: hb.Timestamp,
+		Index:     mg.heartbeats.index(hb.Timestamp),
+		Body:      body,
+	}, true
+}
@@ -0,0 +1,164 @@
+package generate
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+)
+
+// incidentShape is a recorded metric curve: values per metric at offsets
+// from the first sample.
+type incidentShape struct {
+	offsets []time.Duration
+	values  map[string][]float64
+}
+
+// loadIncidentCSV reads a CSV with a header row of "timestamp" followed by
+// metric names (cpu_usage, memory_usage, disk_usage). Timestamps may be
+// RFC 3339 or Unix seconds; only their differences matter.
+func loadIncidentCSV(path string) (*incidentShape, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if len(header) < 2 || strings.ToLower(header[0]) != "timestamp" {
+		return nil, fmt.Errorf("first column must be timestamp")
+	}
+	for _, name := range header[1:] {
+		if metricField(&MetricData{}, name) == nil {
+			return nil, fmt.Errorf("unknown metric column %q", name)
+		}
+	}
+
+	type sample struct {
+		at     time.Time
+		values []float64
+	}
+	var samples []sample
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		at, err := parseIncidentTime(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		s := sample{at: at, values: make([]float64, len(header)-1)}
+		for i, field := range record[1:] {
+			if s.values[i], err = strconv.ParseFloat(field, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid value %q", line, field)
+			}
+		}
+		samples = append(samples, s)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no samples in %s", path)
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].at.Before(samples[j].at) })
+
+	shape := &incidentShape{values: make(map[string][]float64)}
+	for _, s := range samples {
+		shape.offsets = append(shape.offsets, s.at.Sub(samples[0].at))
+		for i, name := range header[1:] {
+			shape.values[name] = append(shape.values[name], s.values[i])
+		}
+	}
+	return shape, nil
+}
+
+func parseIncidentTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))), nil
+}
+
+func (s *incidentShape) duration() time.Duration {
+	return s.offsets[len(s.offsets)-1]
+}
+
+// at linearly interpolates every metric at elapsed.
+func (s *incidentShape) at(elapsed time.Duration) map[string]float64 {
+	i := sort.Search(len(s.offsets), func(i int) bool { return s.offsets[i] >= elapsed })
+
+	values := make(map[string]float64, len(s.values))
+	for name, series := range s.values {
+		switch {
+		case i == 0:
+			values[name] = series[0]
+		case i >= len(series):
+			values[name] = series[len(series)-1]
+		default:
+			span := s.offsets[i] - s.offsets[i-1]
+			frac := float64(elapsed-s.offsets[i-1]) / float64(span)
+			values[name] = series[i-1] + (series[i]-series[i-1])*frac
+		}
+	}
+	return values
+}
+
+// newIncidentReplay schedules shape to play on the selected servers from start.
+func newIncidentReplay(name string, shape *incidentShape, targets serverSelector, start time.Time) *Anomaly {
+	return &Anomaly{
+		Name:    name,
+		Kind:    "incident-replay",
+		Targets: targets,
+		Start:   start,
+		// Hold the last sample for a moment so single-sample shapes still apply
+		End:    start.Add(shape.duration() + time.Second),
+		Values: shape.at,
+	}
+}
+
+// loadIncidentReplay builds the incident replay described by
+// INCIDENT_REPLAY_FILE, INCIDENT_REPLAY_HOSTS and INCIDENT_REPLAY_AT. The
+// start time is RFC 3339 or a delay after startup such as "15m".
+func loadIncidentReplay(config config.Config) (*Anomaly, error) {
+	shape, err := loadIncidentCSV(config.IncidentReplayFile)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := parseServerSelector(config.IncidentReplayHosts)
+	if err != nil {
+		return nil, fmt.Errorf("INCIDENT_REPLAY_HOSTS: %w", err)
+	}
+
+	start := time.Now().UTC()
+	if config.IncidentReplayAt != "" {
+		if t, err := time.Parse(time.RFC3339, config.IncidentReplayAt); err == nil {
+			start = t
+		} else if d, err := time.ParseDuration(config.IncidentReplayAt); err == nil {
+			start = start.Add(d)
+		} else {
+			return nil, fmt.Errorf("INCIDENT_REPLAY_AT: invalid time %q", config.IncidentReplayAt)
+		}
+	}
+
+	return newIncidentReplay(config.IncidentReplayFile, shape, targets, start), nil
+}
@@ -0,0 +1,98 @@
+package generate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// minInterval is the shortest interval servers can report at.
+const minInterval = 100 * time.Millisecond
+
+// serverIntervals gives servers emission intervals of their own, like
+// agents configured differently across a heterogeneous fleet. Servers
+// no rule matches report at the generator's interval.
+type serverIntervals struct {
+	rules []intervalRule
+}
+
+type intervalRule struct {
+	targets  serverSelector
+	interval time.Duration
+}
+
+// parseServerIntervals parses SERVER_INTERVALS, a semicolon-separated
+// list of "selector=interval" where the first matching rule wins, e.g.
+// "role:db=10s;fleet:edge=5m".
+func parseServerIntervals(spec string) (*serverIntervals, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	si := &serverIntervals{}
+	for _, entry := range strings.Split(spec, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid server interval %q (want selector=interval)", entry)
+		}
+		targets, err := parseServerSelector(entry[:i])
+		if err != nil {
+			return nil, err
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(entry[i+1:]))
+		if err != nil || interval < minInterval {
+			return nil, fmt.Errorf("invalid interval in server interval %q (want a duration of at least %s)", entry, minInterval)
+		}
+		si.rules = append(si.rules, intervalRule{targets: targets, interval: interval})
+	}
+	return si, nil
+}
+
+// of returns server's interval, or fallback if no rule matches.
+func (si *serverIntervals) of(server fleet.ServerConfig, fallback time.Duration) time.Duration {
+	if si != nil {
+		for _, rule := range si.rules {
+			if rule.targets.matches(server) {
+				return rule.interval
+			}
+		}
+	}
+	return fallback
+}
+
+// shortest returns the shortest interval of any rule or fallback, which
+// the generator ticks at so every server can report on time.
+func (si *serverIntervals) shortest(fallback time.Duration) time.Duration {
+	shortest := fallback
+	if si != nil {
+		for _, rule := range si.rules {
+			shortest = min(shortest, rule.interval)
+		}
+	}
+	return shortest
+}
+
+// emissionSchedule tracks when a server reports next.
+type emissionSchedule struct {
+	next time.Time
+}
+
+// due reports whether a server reporting every interval is due at now,
+// scheduling its next report if so. The generator ticks every tick, so
+// anything due within half a tick is due now.
+func (s *emissionSchedule) due(now time.Time, interval, tick time.Duration) bool {
+	if now.Before(s.next.Add(-tick / 2)) {
+		return false
+	}
+	s.next = s.next.Add(interval)
+	if !s.next.After(now) {
+		// Fell behind, e.g. after a pause: report at the interval from now
+		s.next = now.Add(interval)
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package generate
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// JVMMetrics are the metrics of the jvm workload profile, inlined in the
+// metric document.
+type JVMMetrics struct {
+	HeapUsedBytes      int64   `json:"jvm_heap_used_bytes"`
+	HeapCommittedBytes int64   `json:"jvm_heap_committed_bytes"`
+	HeapMaxBytes       int64   `json:"jvm_heap_max_bytes"`
+	GCCount            int     `json:"jvm_gc_count"`        // Collections over the interval
+	GCPauseMs          float64 `json:"jvm_gc_pause_ms"`     // Total pause over the interval
+	GCPauseMaxMs       float64 `json:"jvm_gc_pause_max_ms"` // Longest pause over the interval
+	Threads            int     `json:"jvm_threads"`
+}
+
+// jvmState is a server's JVM heap carried between reports. Allocations
+// fill the heap until a young collection drops it back to the live set,
+// drawing the familiar sawtooth. The live set creeps up as objects are
+// promoted until a full collection clears the old generation.
+type jvmState struct {
+	used      float64 // Bytes
+	committed float64
+	live      float64
+}
+
+// jvmMaxHeap returns a server's maximum heap (-Xmx), hashing the server
+// ID so it is stable across runs.
+func jvmMaxHeap(server fleet.ServerConfig) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(server.ID))
+	return []float64{2 << 30, 4 << 30, 8 << 30}[h.Sum32()%3]
+}
+
+// applyJVM sets the JVM metrics of metric for the interval since the
+// server's last report. The allocation rate follows the CPU usage.
+func applyJVM(server fleet.ServerConfig, metric *MetricData, state *jvmState, interval time.Duration, rnd *rand.Rand) {
+	heapMax := jvmMaxHeap(server)
+	if state.committed == 0 {
+		state.committed = heapMax / 2
+		state.live = heapMax * (0.15 + rnd.Float64()*0.1)
+		state.used = state.live + rnd.Float64()*(0.7*state.committed-state.live)
+	}
+
+	// At 50% CPU the application allocates a fifth of its maximum heap
+	// a minute, so the heap is collected every few minutes
+	alloc := heapMax * 0.4 * metric.CPUUsage / 100 * interval.Minutes() * (0.8 + rnd.Float64()*0.4)
+	var collections int
+	var pauses, longest float64
+	for {
+		threshold := 0.75 * state.committed
+		if state.used+alloc < threshold {
+			state.used += alloc
+			break
+		}
+		alloc -= threshold - state.used
+
+		// Some survivors are promoted; once the old generation nears
+		// the heap's limit a full collection clears it
+		pause := 5 + rnd.ExpFloat64()*15
+		state.live += heapMax * 0.01 * rnd.Float64()
+		if state.live > 0.6*heapMax {
+			pause = 200 + rnd.ExpFloat64()*400
+			state.live = heapMax * (0.15 + rnd.Float64()*0.1)
+		}
+		state.used = state.live
+		collections++
+		pauses += pause
+		longest = math.Max(longest, pause)
+
+		// The heap grows while the live set takes up much of it
+		if state.live > 0.5*state.committed {
+			state.committed = math.Min(heapMax, state.committed*1.25)
+		}
+	}
+
+	metric.JVMMetrics = &JVMMetrics{
+		HeapUsedBytes:      int64(state.used),
+		HeapCommittedBytes: int64(state.committed),
+		HeapMaxBytes:       int64(heapMax),
+		GCCount:            collections,
+		GCPauseMs:          roundFloat(pauses, 1),
+		GCPauseMaxMs:       roundFloat(longest, 1),
+		// Request thread pools grow with load
+		Threads: 60 + int(metric.CPUUsage*1.5) + rnd.Intn(10),
+	}
+}
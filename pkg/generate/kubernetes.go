@@ -0,0 +1,372 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// PodStatus is a kube-state-metrics-like view of one pod.
+type PodStatus struct {
+	Timestamp  time.Time `json:"@timestamp"`
+	Cluster    string    `json:"cluster"`
+	Namespace  string    `json:"namespace"`
+	Deployment string    `json:"deployment"`
+	Pod        string    `json:"pod"`
+	Node       string    `json:"node"`
+	Phase      string    `json:"phase"`
+
+	Ready                int     `json:"kube_pod_status_ready"`
+	Restarts             int     `json:"kube_pod_container_status_restarts_total"`
+	LastTerminatedReason string  `json:"kube_pod_container_status_last_terminated_reason,omitempty"`
+	CPURequestCores      float64 `json:"kube_pod_container_resource_requests_cpu_cores"`
+	CPULimitCores        float64 `json:"kube_pod_container_resource_limits_cpu_cores"`
+	MemoryRequestBytes   int64   `json:"kube_pod_container_resource_requests_memory_bytes"`
+	MemoryLimitBytes     int64   `json:"kube_pod_container_resource_limits_memory_bytes"`
+}
+
+// ContainerStats is a cAdvisor-like view of one container.
+type ContainerStats struct {
+	Timestamp time.Time `json:"@timestamp"`
+	Cluster   string    `json:"cluster"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	Image     string    `json:"image"`
+	Node      string    `json:"node"`
+
+	CPUUsageSeconds     float64 `json:"container_cpu_usage_seconds_total"`
+	CPUThrottledSeconds float64 `json:"container_cpu_cfs_throttled_seconds_total"`
+	CPUQuota            int64   `json:"container_spec_cpu_quota"` // microseconds per 100ms period
+	MemoryWorkingSet    int64   `json:"container_memory_working_set_bytes"`
+	MemoryLimit         int64   `json:"container_spec_memory_limit_bytes"`
+}
+
+// deploymentSpec is one simulated Kubernetes deployment with a single
+// container per pod.
+type deploymentSpec struct {
+	Namespace   string
+	Name        string
+	Replicas    int
+	CPULimit    float64 // cores
+	MemoryLimit int64   // bytes
+}
+
+// defaultDeployments are simulated with KUBERNETES_DEPLOYMENTS=default.
+const defaultDeployments = "shop/frontend:6:500m:512Mi,shop/orders:4:1:1Gi,shop/payments:2:500m:768Mi," +
+	"shop/postgres:1:2:4Gi,monitoring/prometheus:1:2:4Gi,kube-system/coredns:2:100m:170Mi"
+
+// parseDeployments parses KUBERNETES_DEPLOYMENTS, a comma-separated list
+// of "namespace/name:replicas[:cpu limit[:memory limit]]" entries with
+// Kubernetes quantities, e.g. "shop/frontend:6:500m:512Mi".
+func parseDeployments(spec string) ([]deploymentSpec, error) {
+	var deployments []deploymentSpec
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		namespace, name, ok := strings.Cut(parts[0], "/")
+		if !ok || namespace == "" || name == "" || len(parts) < 2 || len(parts) > 4 {
+			return nil, fmt.Errorf("invalid deployment %q (want namespace/name:replicas[:cpu[:memory]])", entry)
+		}
+		d := deploymentSpec{Namespace: namespace, Name: name, CPULimit: 1, MemoryLimit: 1 << 30}
+		replicas, err := strconv.Atoi(parts[1])
+		if err != nil || replicas < 1 {
+			return nil, fmt.Errorf("invalid replicas in deployment %q", entry)
+		}
+		d.Replicas = replicas
+		if len(parts) > 2 {
+			if d.CPULimit, err = parseCPUQuantity(parts[2]); err != nil {
+				return nil, fmt.Errorf("invalid CPU limit in deployment %q: %w", entry, err)
+			}
+		}
+		if len(parts) > 3 {
+			if d.MemoryLimit, err = parseMemoryQuantity(parts[3]); err != nil {
+				return nil, fmt.Errorf("invalid memory limit in deployment %q: %w", entry, err)
+			}
+		}
+		deployments = append(deployments, d)
+	}
+	return deployments, nil
+}
+
+// parseCPUQuantity parses a CPU quantity in cores or millicores ("500m").
+func parseCPUQuantity(s string) (float64, error) {
+	scale := 1.0
+	if v, ok := strings.CutSuffix(s, "m"); ok {
+		s, scale = v, 0.001
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid CPU quantity %q", s)
+	}
+	return f * scale, nil
+}
+
+// memorySuffixes are the binary and decimal suffixes of memory quantities.
+var memorySuffixes = []struct {
+	suffix string
+	scale  int64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
+
+// parseMemoryQuantity parses a memory quantity such as "512Mi" or "1G".
+func parseMemoryQuantity(s string) (int64, error) {
+	scale := int64(1)
+	for _, m := range memorySuffixes {
+		if v, ok := strings.CutSuffix(s, m.suffix); ok {
+			s, scale = v, m.scale
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid memory quantity %q", s)
+	}
+	return int64(f * float64(scale)), nil
+}
+
+// simPod is the state a pod carries between ticks.
+type simPod struct {
+	Deployment *deploymentSpec
+	Name       string
+	Image      string
+	Node       fleet.ServerConfig
+
+	cpuLoad       float64 // share of the CPU limit in use
+	memory        float64 // working set as a share of the memory limit
+	leak          float64 // memory growth per tick, for leaky deployments
+	cpuSeconds    float64
+	throttled     float64
+	restarts      int
+	lastReason    string
+	restartedTick bool
+}
+
+// kubeCluster simulates pods of the configured deployments scheduled on
+// the fleet's servers, which act as nodes. Pods get busier with their
+// node, leaky containers grow until they are OOM-killed and restarted,
+// and a few crash at random.
+type kubeCluster struct {
+	name  string
+	pods  []*simPod
+	index sink.IndexNamer
+
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	nodeCPU map[string]float64 // CPU usage last emitted per server
+}
+
+// kubeCrashRate is the chance per tick that a container crashes on its own.
+const kubeCrashRate = 0.0005
+
+// newKubeCluster returns nil, disabling Kubernetes mode, when spec is empty.
+func newKubeCluster(spec, name, index string, servers []fleet.ServerConfig, seed int64) (*kubeCluster, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	if spec == "default" {
+		spec = defaultDeployments
+	}
+	deployments, err := parseDeployments(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("kubernetes mode needs at least one server to act as a node")
+	}
+	if name == "" {
+		name = "demo"
+	}
+
+	c := &kubeCluster{
+		name:    name,
+		index:   sink.NewIndexNamer(index),
+		rnd:     rand.New(rand.NewSource(fleet.DeriveSeed(seed, "kubernetes"))),
+		nodeCPU: make(map[string]float64),
+	}
+	for i := range deployments {
+		d := &deployments[i]
+		// Pods of a deployment share its current ReplicaSet's hash
+		replicaSet := randomKubeSuffix(c.rnd, 10)
+		image := fmt.Sprintf("registry.example.com/%s/%s:1.%d.%d", d.Namespace, d.Name, c.rnd.Intn(20), c.rnd.Intn(10))
+		leaky := c.rnd.Float64() < 0.3
+		for r := 0; r < d.Replicas; r++ {
+			pod := &simPod{
+				Deployment: d,
+				Name:       d.Name + "-" + replicaSet + "-" + randomKubeSuffix(c.rnd, 5),
+				Image:      image,
+				cpuLoad:    0.1 + c.rnd.Float64()*0.3,
+				memory:     0.3 + c.rnd.Float64()*0.3,
+			}
+			pod.Node = servers[kubeNodeIndex(pod.Name, len(servers))]
+			if leaky {
+				pod.leak = 0.002 + c.rnd.Float64()*0.006
+			}
+			c.pods = append(c.pods, pod)
+		}
+	}
+	return c, nil
+}
+
+// kubeNodeIndex spreads pods over nodes by hashing their names.
+func kubeNodeIndex(pod string, nodes int) int {
+	h := fnv.New32a()
+	h.Write([]byte(pod))
+	return int(h.Sum32() % uint32(nodes))
+}
+
+// randomKubeSuffix returns n characters from the alphabet Kubernetes uses
+// for generated names.
+func randomKubeSuffix(rnd *rand.Rand, n int) string {
+	const alphabet = "bcdfghjklmnpqrstvwxz2456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphabet[rnd.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+// observe records the CPU usage emitted for a node.
+func (c *kubeCluster) observe(server fleet.ServerConfig, metric MetricData) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.nodeCPU[server.ID] = metric.CPUUsage
+	c.mu.Unlock()
+}
+
+// advance moves every pod forward by one tick.
+func (c *kubeCluster) advance(interval time.Duration) {
+	for _, pod := range c.pods {
+		pod.restartedTick = false
+
+		// Pods on a busy node get busier themselves
+		nodeLoad := c.nodeCPU[pod.Node.ID] / 100
+		pod.cpuLoad += 0.2*(0.15+0.8*nodeLoad-pod.cpuLoad) + c.rnd.NormFloat64()*0.03
+		pod.cpuLoad = math.Max(0.01, pod.cpuLoad)
+		pod.memory += pod.leak + c.rnd.NormFloat64()*0.005
+		pod.memory = math.Max(0.05, pod.memory)
+
+		reason := ""
+		switch {
+		case pod.memory >= 1:
+			reason = "OOMKilled"
+		case c.rnd.Float64() < kubeCrashRate:
+			reason = "Error"
+		}
+		if reason != "" {
+			// The restarted container starts from scratch, counters included
+			pod.restarts++
+			pod.lastReason = reason
+			pod.restartedTick = true
+			pod.memory = 0.3 + c.rnd.Float64()*0.2
+			pod.cpuSeconds, pod.throttled = 0, 0
+			continue
+		}
+
+		limit := pod.Deployment.CPULimit
+		used := math.Min(pod.cpuLoad, 1) * limit
+		pod.cpuSeconds += used * interval.Seconds()
+		if pod.cpuLoad > 1 {
+			pod.throttled += (pod.cpuLoad - 1) * limit * interval.Seconds()
+		}
+	}
+}
+
+// generate advances the cluster and returns the pod and container views
+// of every pod at now.
+func (c *kubeCluster) generate(now time.Time, interval time.Duration) ([]PodStatus, []ContainerStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance(interval)
+
+	pods := make([]PodStatus, 0, len(c.pods))
+	containers := make([]ContainerStats, 0, len(c.pods))
+	for _, pod := range c.pods {
+		d := pod.Deployment
+		status := PodStatus{
+			Timestamp:            now,
+			Cluster:              c.name,
+			Namespace:            d.Namespace,
+			Deployment:           d.Name,
+			Pod:                  pod.Name,
+			Node:                 pod.Node.Hostname,
+			Phase:                "Running",
+			Ready:                1,
+			Restarts:             pod.restarts,
+			LastTerminatedReason: pod.lastReason,
+			CPURequestCores:      d.CPULimit / 2,
+			CPULimitCores:        d.CPULimit,
+			MemoryRequestBytes:   d.MemoryLimit / 2,
+			MemoryLimitBytes:     d.MemoryLimit,
+		}
+		if pod.restartedTick {
+			status.Ready = 0
+		}
+		pods = append(pods, status)
+
+		containers = append(containers, ContainerStats{
+			Timestamp:           now,
+			Cluster:             c.name,
+			Namespace:           d.Namespace,
+			Pod:                 pod.Name,
+			Container:           d.Name,
+			Image:               pod.Image,
+			Node:                pod.Node.Hostname,
+			CPUUsageSeconds:     roundFloat(pod.cpuSeconds, 3),
+			CPUThrottledSeconds: roundFloat(pod.throttled, 3),
+			CPUQuota:            int64(d.CPULimit * 100000),
+			MemoryWorkingSet:    int64(math.Min(pod.memory, 1) * float64(d.MemoryLimit)),
+			MemoryLimit:         d.MemoryLimit,
+		})
+	}
+	return pods, containers
+}
+
+// kubeDocuments generates and encodes the pod and container documents of
+// the tick that just finished.
+func (mg *MetricGenerator) kubeDocuments(now time.Time) []sink.Document {
+	if mg.kube == nil {
+		return nil
+	}
+	pods, containers := mg.kube.generate(now, mg.interval)
+	docs := make([]sink.Document, 0, len(pods)+len(containers))
+	add := func(docType string, node fleet.ServerConfig, v interface{}) {
+		body, err := json.Marshal(v)
+		if err != nil {
+			slog.Error("Error marshaling Kubernetes document", "type", docType, "error", err)
+			return
+		}
+		docs = append(docs, sink.Document{
+			Type:      docType,
+			ServerID:  node.ID,
+			Hostname:  node.Hostname,
+			Role:      node.Role,
+			Timestamp: now,
+			Index:     mg.kube.index(now),
+			Body:      body,
+		})
+	}
+	for i := range pods {
+		node := mg.kube.pods[i].Node
+		add("pod", node, pods[i])
+		add("container", node, containers[i])
+	}
+	return docs
+}
@@ -0,0 +1,346 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// LogEntry is one synthetic log line from a simulated server.
+type LogEntry struct {
+	Timestamp time.Time `json:"@timestamp"`
+	ServerID  string    `json:"server_id"`
+	Hostname  string    `json:"hostname"`
+	Role      string    `json:"role"`
+	Fleet     string    `json:"fleet,omitempty"`
+	// Source is "syslog" for system daemons and "application" for the
+	// server's main process.
+	Source     string `json:"source"`
+	Process    string `json:"process"`
+	PID        int    `json:"pid"`
+	Level      string `json:"level"`
+	Message    string `json:"message"`
+	StackTrace string `json:"stack_trace,omitempty"`
+
+	// Anomaly names the anomaly active on the server when the line was
+	// written, as ground truth for correlating logs with metrics.
+	Anomaly string `json:"anomaly,omitempty"`
+}
+
+// logTemplate produces the message of one kind of log line.
+type logTemplate struct {
+	Process string // empty means the role's application process
+	Level   string
+	Message func(rnd *rand.Rand) string
+	// Stack asks for a stack trace in the application's language
+	Stack bool
+}
+
+// roleProcesses is the main application process of each role.
+var roleProcesses = map[string]string{
+	"web":    "nginx",
+	"db":     "postgres",
+	"app":    "java",
+	"cache":  "redis-server",
+	"worker": "celery",
+}
+
+// routineLogs are the lines a healthy server writes, per role.
+var routineLogs = map[string][]logTemplate{
+	"web": {
+		{Level: "INFO", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf(`%s - - "GET /api/v1/orders/%d HTTP/1.1" 200 %d %.3f`, randomClientIP(rnd), 1000+rnd.Intn(90000), 200+rnd.Intn(4000), 0.005+rnd.Float64()*0.2)
+		}},
+		{Level: "INFO", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf(`%s - - "POST /api/v1/cart HTTP/1.1" 201 %d %.3f`, randomClientIP(rnd), 80+rnd.Intn(400), 0.01+rnd.Float64()*0.3)
+		}},
+		{Level: "WARN", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf(`%s - - "GET /wp-login.php HTTP/1.1" 404 %d 0.001`, randomClientIP(rnd), 150+rnd.Intn(50))
+		}},
+	},
+	"db": {
+		{Level: "INFO", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("checkpoint complete: wrote %d buffers (%.1f%%); 0 WAL file(s) added, 0 removed, %d recycled", 100+rnd.Intn(5000), rnd.Float64()*5, rnd.Intn(4))
+		}},
+		{Level: "INFO", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("automatic vacuum of table \"shop.public.orders\": index scans: 1, pages: 0 removed, %d remain", 1000+rnd.Intn(100000))
+		}},
+		{Level: "WARN", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("duration: %d.%03d ms  statement: SELECT * FROM orders WHERE customer_id = %d", 1000+rnd.Intn(3000), rnd.Intn(1000), rnd.Intn(100000))
+		}},
+	},
+	"app": {
+		{Level: "INFO", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Processed order %d in %dms", 100000+rnd.Intn(900000), 5+rnd.Intn(200))
+		}},
+		{Level: "DEBUG", Message: func(rnd *rand.Rand) string {
+			total := 10 + rnd.Intn(10)
+			active := rnd.Intn(total)
+			return fmt.Sprintf("HikariPool-1 - Pool stats (total=%d, active=%d, idle=%d, waiting=0)", total, active, total-active)
+		}},
+		{Level: "INFO", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Cache refresh of product catalog completed, %d entries", 5000+rnd.Intn(20000))
+		}},
+	},
+	"cache": {
+		{Level: "INFO", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("%d changes in 60 seconds. Saving...", 1000+rnd.Intn(50000))
+		}},
+		{Level: "INFO", Message: func(*rand.Rand) string {
+			return "Background saving terminated with success"
+		}},
+		{Level: "INFO", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Accepted %s:%d", randomClientIP(rnd), 30000+rnd.Intn(30000))
+		}},
+	},
+	"worker": {
+		{Level: "INFO", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Task jobs.send_email[%08x] succeeded in %.3fs: None", rnd.Uint32(), 0.05+rnd.Float64()*2)
+		}},
+		{Level: "INFO", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Task jobs.resize_image[%08x] received", rnd.Uint32())
+		}},
+		{Level: "WARN", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Task jobs.sync_inventory[%08x] retry: Retry in %ds", rnd.Uint32(), 10+rnd.Intn(50))
+		}},
+	},
+}
+
+// syslogLines are routine lines from system daemons, shared by all roles.
+var syslogLines = []logTemplate{
+	{Process: "systemd", Level: "INFO", Message: func(rnd *rand.Rand) string {
+		return fmt.Sprintf("Started Session %d of user deploy.", 1000+rnd.Intn(9000))
+	}},
+	{Process: "CRON", Level: "INFO", Message: func(*rand.Rand) string {
+		return "(root) CMD (command -v debian-sa1 > /dev/null && debian-sa1 1 1)"
+	}},
+	{Process: "sshd", Level: "INFO", Message: func(rnd *rand.Rand) string {
+		return fmt.Sprintf("Accepted publickey for deploy from 10.%d.%d.%d port %d ssh2", rnd.Intn(256), rnd.Intn(256), 1+rnd.Intn(254), 40000+rnd.Intn(20000))
+	}},
+	{Process: "systemd-timesyncd", Level: "INFO", Message: func(*rand.Rand) string {
+		return "Synchronized to time server 169.254.169.123:123 (169.254.169.123)."
+	}},
+}
+
+// pressureLogs are the lines written while a resource is exhausted, keyed
+// by the usage metric.
+var pressureLogs = map[string][]logTemplate{
+	"cpu_usage": {
+		{Level: "WARN", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Request processing took %dms, exceeding the 1000ms threshold", 1000+rnd.Intn(9000))
+		}},
+		{Level: "ERROR", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Timed out after %dms waiting for a worker thread", 5000+rnd.Intn(25000))
+		}, Stack: true},
+		{Process: "kernel", Level: "WARN", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("sched: RT throttling activated, %d tasks runnable", 50+rnd.Intn(200))
+		}},
+	},
+	"memory_usage": {
+		{Level: "ERROR", Message: func(*rand.Rand) string {
+			return "Out of memory while allocating a buffer"
+		}, Stack: true},
+		{Level: "WARN", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("GC pause of %dms, heap %d%% full after collection", 500+rnd.Intn(4500), 90+rnd.Intn(10))
+		}},
+		{Process: "kernel", Level: "ERROR", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Out of memory: Killed process %d (python3) total-vm:%dkB, anon-rss:%dkB", 2000+rnd.Intn(30000), 1000000+rnd.Intn(8000000), 500000+rnd.Intn(4000000))
+		}},
+	},
+	"disk_usage": {
+		{Level: "ERROR", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("could not write to file \"/var/lib/data/tmp.%d\": No space left on device", rnd.Intn(100000))
+		}, Stack: true},
+		{Level: "WARN", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Free disk space below threshold: %dMB remaining", 10+rnd.Intn(500))
+		}},
+		{Process: "kernel", Level: "ERROR", Message: func(*rand.Rand) string {
+			return "EXT4-fs warning (device nvme0n1p1): ext4_dx_add_entry: Directory index full!"
+		}},
+	},
+	// Written during anomalies that don't exhaust a resource, e.g. a dip
+	"": {
+		{Level: "ERROR", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Health check failed: connection refused (attempt %d)", 1+rnd.Intn(5))
+		}},
+		{Level: "ERROR", Message: func(rnd *rand.Rand) string {
+			return fmt.Sprintf("Upstream 10.%d.%d.%d:8080 marked as unavailable", rnd.Intn(256), rnd.Intn(256), 1+rnd.Intn(254))
+		}, Stack: true},
+	},
+}
+
+// stackTraces is a template stack trace per application process; %s is
+// replaced with the error message.
+var stackTraces = map[string]string{
+	"java": "java.lang.RuntimeException: %s\n" +
+		"\tat com.example.shop.orders.OrderService.process(OrderService.java:142)\n" +
+		"\tat com.example.shop.orders.OrderController.create(OrderController.java:57)\n" +
+		"\tat org.springframework.web.servlet.FrameworkServlet.service(FrameworkServlet.java:883)\n" +
+		"\tat org.apache.catalina.core.ApplicationFilterChain.doFilter(ApplicationFilterChain.java:166)",
+	"celery": "Traceback (most recent call last):\n" +
+		"  File \"/app/jobs/tasks.py\", line 88, in sync_inventory\n" +
+		"    client.push(batch)\n" +
+		"  File \"/app/jobs/client.py\", line 41, in push\n" +
+		"    raise WorkerError(msg)\n" +
+		"jobs.client.WorkerError: %s",
+}
+
+// pressureThreshold is the usage, in percent, above which a resource
+// counts as exhausted.
+const pressureThreshold = 90
+
+// logGenerator writes log lines for the simulated servers, correlated with
+// their metrics: servers under pressure or affected by an anomaly log more
+// and switch to warnings and errors about the exhausted resource.
+type logGenerator struct {
+	rate  float64 // Average lines per server per tick when healthy
+	index sink.IndexNamer
+}
+
+// newLogGenerator returns nil, disabling logs, when rate is zero.
+func newLogGenerator(rate float64, index string) (*logGenerator, error) {
+	if rate < 0 || math.IsNaN(rate) {
+		return nil, fmt.Errorf("invalid log rate %v", rate)
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+	return &logGenerator{rate: rate, index: sink.NewIndexNamer(index)}, nil
+}
+
+// generate returns the lines server wrote over the interval ending at the
+// metric's timestamp, oldest first.
+func (g *logGenerator) generate(server fleet.ServerConfig, metric MetricData, active []*Anomaly, interval time.Duration, rnd *rand.Rand) []LogEntry {
+	var pressure []string
+	for _, name := range []string{"cpu_usage", "memory_usage", "disk_usage"} {
+		if *metricField(&metric, name) >= pressureThreshold {
+			pressure = append(pressure, name)
+		}
+	}
+	var anomaly string
+	if len(active) > 0 {
+		anomaly = active[len(active)-1].Name
+		if len(pressure) == 0 {
+			pressure = []string{""}
+		}
+	}
+
+	mean := g.rate
+	if len(pressure) > 0 {
+		mean *= 4
+	}
+	n := poisson(mean, rnd)
+	if n == 0 {
+		return nil
+	}
+
+	process := roleProcesses[server.Role]
+	entries := make([]LogEntry, 0, n)
+	for i := 0; i < n; i++ {
+		var tmpl logTemplate
+		switch p := rnd.Float64(); {
+		case len(pressure) > 0 && p < 0.5:
+			options := pressureLogs[pressure[rnd.Intn(len(pressure))]]
+			tmpl = options[rnd.Intn(len(options))]
+		case p < 0.8 && len(routineLogs[server.Role]) > 0:
+			options := routineLogs[server.Role]
+			tmpl = options[rnd.Intn(len(options))]
+		default:
+			tmpl = syslogLines[rnd.Intn(len(syslogLines))]
+		}
+
+		entry := LogEntry{
+			Timestamp: metric.Timestamp.Add(-time.Duration(rnd.Int63n(int64(interval)))).Truncate(time.Millisecond),
+			ServerID:  server.ID,
+			Hostname:  server.Hostname,
+			Role:      server.Role,
+			Fleet:     server.Fleet,
+			Source:    "application",
+			Process:   process,
+			Level:     tmpl.Level,
+			Message:   tmpl.Message(rnd),
+			Anomaly:   anomaly,
+		}
+		if tmpl.Process != "" {
+			entry.Source, entry.Process = "syslog", tmpl.Process
+		}
+		entry.PID = processID(server.ID, entry.Process)
+		if trace, ok := stackTraces[entry.Process]; ok && tmpl.Stack {
+			entry.StackTrace = fmt.Sprintf(trace, entry.Message)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Timestamp.Before(entries[j].Timestamp) })
+	return entries
+}
+
+// logDocuments generates the log lines of one server since its last
+// report and encodes them for delivery. Log documents get IDs from the sink.
+func (mg *MetricGenerator) logDocuments(server fleet.ServerConfig, metric MetricData) []sink.Document {
+	if mg.logs == nil {
+		return nil
+	}
+	mg.mu.Lock()
+	sim := mg.serverSim(server)
+	mg.mu.Unlock()
+
+	active := mg.anomalies.Active(server, metric.Timestamp)
+	entries := mg.logs.generate(server, metric, active, mg.serverInterval(server), sim.logRnd)
+	docs := make([]sink.Document, 0, len(entries))
+	for _, entry := range entries {
+		body, err := json.Marshal(entry)
+		if err != nil {
+			slog.Error("Error marshaling log entry", "server_id", server.ID, "error", err)
+			continue
+		}
+		docs = append(docs, sink.Document{
+			Type:      "log",
+			ServerID:  server.ID,
+			Hostname:  server.Hostname,
+			Role:      server.Role,
+			Timestamp: entry.Timestamp,
+			Index:     mg.logs.index(entry.Timestamp),
+			Body:      body,
+		})
+	}
+	return docs
+}
+
+// processID returns a stable PID for a process on a server. The kernel
+// logs as PID 0.
+func processID(serverID, process string) int {
+	if process == "kernel" {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(serverID))
+	h.Write([]byte(strings.ToLower(process)))
+	return 300 + int(h.Sum32()%32000)
+}
+
+// poisson draws from a Poisson distribution with the given mean, using a
+// normal approximation for large means.
+func poisson(mean float64, rnd *rand.Rand) int {
+	if mean > 30 {
+		return max(0, int(math.Round(mean+rnd.NormFloat64()*math.Sqrt(mean))))
+	}
+	limit, p, n := math.Exp(-mean), rnd.Float64(), 0
+	for p > limit {
+		p *= rnd.Float64()
+		n++
+	}
+	return n
+}
+
+func randomClientIP(rnd *rand.Rand) string {
+	return fmt.Sprintf("%d.%d.%d.%d", 1+rnd.Intn(222), rnd.Intn(256), rnd.Intn(256), 1+rnd.Intn(254))
+}
@@ -0,0 +1,199 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// NetworkLatency is one tick's probe from one location to another.
+type NetworkLatency struct {
+	Timestamp           time.Time      `json:"@timestamp"`
+	SourceCity          string         `json:"source_city"`
+	SourceCountry       string         `json:"source_country"`
+	SourceLocation      fleet.GeoPoint `json:"source_location"`
+	DestinationCity     string         `json:"destination_city"`
+	DestinationCountry  string         `json:"destination_country"`
+	DestinationLocation fleet.GeoPoint `json:"destination_location"`
+	DistanceKm          float64        `json:"distance_km"`
+
+	LatencyMs  float64 `json:"latency_ms"` // round trip
+	JitterMs   float64 `json:"jitter_ms"`
+	PacketLoss float64 `json:"packet_loss"` // share of probes lost
+	Congested  bool    `json:"congested"`
+}
+
+// latencySite is a location the fleet runs in, placed at the mean
+// position of its servers.
+type latencySite struct {
+	city, country string
+	lat, lon      float64
+}
+
+// latencyPath is a probed path between two sites. The route is longer
+// than the great circle, and now and then it congests.
+type latencyPath struct {
+	from, to  *latencySite
+	distance  float64 // km
+	baseRTT   float64 // ms
+	congested bool
+}
+
+// latencyMatrix measures the network between the fleet's locations.
+type latencyMatrix struct {
+	paths []*latencyPath
+	index sink.IndexNamer
+	rnd   *rand.Rand
+}
+
+// newLatencyMatrix returns nil, disabling latency metrics, unless enabled
+// or pairs is set. pairs is a comma-separated list of "source:destination"
+// cities to probe; without it every location probes every other.
+func newLatencyMatrix(enabled bool, pairs, index string, servers []fleet.ServerConfig, seed int64) (*latencyMatrix, error) {
+	if !enabled && strings.TrimSpace(pairs) == "" {
+		return nil, nil
+	}
+
+	sites := make(map[string]*latencySite)
+	counts := make(map[string]float64)
+	for _, server := range servers {
+		loc := server.Location
+		s := sites[loc.City]
+		if s == nil {
+			s = &latencySite{city: loc.City, country: loc.Country}
+			sites[loc.City] = s
+		}
+		counts[loc.City]++
+		n := counts[loc.City]
+		s.lat += (loc.Latitude - s.lat) / n
+		s.lon += (loc.Longitude - s.lon) / n
+	}
+	cities := make([]string, 0, len(sites))
+	for city := range sites {
+		cities = append(cities, city)
+	}
+	sort.Strings(cities)
+
+	m := &latencyMatrix{
+		index: sink.NewIndexNamer(index),
+		rnd:   rand.New(rand.NewSource(fleet.DeriveSeed(seed, "latency"))),
+	}
+	if strings.TrimSpace(pairs) == "" {
+		for _, from := range cities {
+			for _, to := range cities {
+				if from != to {
+					m.paths = append(m.paths, newLatencyPath(sites[from], sites[to]))
+				}
+			}
+		}
+		return m, nil
+	}
+	for _, pair := range strings.Split(pairs, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, ":")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == to {
+			return nil, fmt.Errorf("invalid latency pair %q (want source:destination)", pair)
+		}
+		for _, city := range []string{from, to} {
+			if sites[city] == nil {
+				return nil, fmt.Errorf("latency pair %q: no servers in %q (have %s)", pair, city, strings.Join(cities, ", "))
+			}
+		}
+		m.paths = append(m.paths, newLatencyPath(sites[from], sites[to]))
+	}
+	return m, nil
+}
+
+// newLatencyPath returns the path between two sites. Light travels about
+// 200 km per millisecond in fiber, along routes half as long again as the
+// great circle, and every round trip spends a couple of milliseconds in
+// routers and switches.
+func newLatencyPath(from, to *latencySite) *latencyPath {
+	distance := haversineKm(from.lat, from.lon, to.lat, to.lon)
+	return &latencyPath{from: from, to: to, distance: distance, baseRTT: 2*distance*1.5/200 + 2}
+}
+
+// haversineKm returns the great-circle distance between two points.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371.0
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// generate returns the probes of every path for the tick ending at now.
+// A path congests about once a day and recovers within the hour, queueing
+// delay raising its latency and jitter while it drops packets.
+func (m *latencyMatrix) generate(now time.Time, interval time.Duration) []NetworkLatency {
+	out := make([]NetworkLatency, 0, len(m.paths))
+	for _, p := range m.paths {
+		if p.congested {
+			p.congested = m.rnd.Float64() >= 1-math.Exp(-interval.Minutes()/20)
+		} else {
+			p.congested = m.rnd.Float64() < 1-math.Exp(-interval.Hours()/24)
+		}
+
+		rtt := p.baseRTT * (1 + 0.05*m.rnd.ExpFloat64())
+		jitter := 0.02 * p.baseRTT * (0.5 + m.rnd.Float64())
+		loss := 0.0001 * m.rnd.ExpFloat64()
+		if p.congested {
+			rtt += 20 + 0.5*p.baseRTT*m.rnd.Float64()
+			jitter *= 5
+			loss = 0.01 + 0.04*m.rnd.Float64()
+		}
+
+		out = append(out, NetworkLatency{
+			Timestamp:           now,
+			SourceCity:          p.from.city,
+			SourceCountry:       p.from.country,
+			SourceLocation:      fleet.GeoPoint{Lat: roundFloat(p.from.lat, 4), Lon: roundFloat(p.from.lon, 4)},
+			DestinationCity:     p.to.city,
+			DestinationCountry:  p.to.country,
+			DestinationLocation: fleet.GeoPoint{Lat: roundFloat(p.to.lat, 4), Lon: roundFloat(p.to.lon, 4)},
+			DistanceKm:          roundFloat(p.distance, 1),
+			LatencyMs:           roundFloat(rtt, 2),
+			JitterMs:            roundFloat(jitter, 2),
+			PacketLoss:          roundFloat(loss, 5),
+			Congested:           p.congested,
+		})
+	}
+	return out
+}
+
+// latencyDocuments generates and encodes the latency probes of the tick
+// that just finished.
+func (mg *MetricGenerator) latencyDocuments(now time.Time) []sink.Document {
+	if mg.latency == nil {
+		return nil
+	}
+	probes := mg.latency.generate(now, mg.interval)
+	docs := make([]sink.Document, 0, len(probes))
+	for _, p := range probes {
+		body, err := json.Marshal(p)
+		if err != nil {
+			slog.Error("Error marshaling latency probe", "source", p.SourceCity, "destination", p.DestinationCity, "error", err)
+			continue
+		}
+		// Probes measure a pair of locations rather than one server; the
+		// pair is in the body
+		docs = append(docs, sink.Document{
+			Type:      "latency",
+			Timestamp: p.Timestamp,
+			Index:     mg.latency.index(p.Timestamp),
+			Body:      body,
+		})
+	}
+	return docs
+}
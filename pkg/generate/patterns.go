@@ -0,0 +1,132 @@
+package generate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// thresholdPatterns drive metrics of selected servers across alert
+// thresholds and back on a fixed schedule, so that the debounce and
+// for-duration settings of alert rules can be checked against a known
+// answer.
+type thresholdPatterns struct {
+	rules []thresholdPattern
+}
+
+// thresholdPattern holds metric margin points above threshold for each of
+// the above durations in turn, dropping margin points below it for below
+// in between. The schedule repeats every cycle, counted from the Unix
+// epoch, so every run and every server crosses at the same instants.
+type thresholdPattern struct {
+	targets   serverSelector
+	metric    string
+	threshold float64
+	margin    float64
+	above     []time.Duration
+	below     time.Duration
+	cycle     time.Duration
+}
+
+// parseThresholdPatterns parses THRESHOLD_PATTERNS, a semicolon-separated
+// list of "selector=metric@threshold:above,.../below[:margin]" entries,
+// e.g. "role:db=cpu@85:1m,3m,5m/2m" holds the db servers' CPU above 85%
+// for 1, 3 and 5 minutes in turn with 2 minutes below in between.
+func parseThresholdPatterns(spec string) (*thresholdPatterns, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	tp := &thresholdPatterns{}
+	for _, entry := range strings.Split(spec, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		rule, err := parseThresholdPattern(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold pattern %q: %w", entry, err)
+		}
+		tp.rules = append(tp.rules, rule)
+	}
+	return tp, nil
+}
+
+func parseThresholdPattern(entry string) (thresholdPattern, error) {
+	i := strings.LastIndex(entry, "=")
+	if i < 0 {
+		return thresholdPattern{}, fmt.Errorf("want selector=metric@threshold:above/below[:margin]")
+	}
+	targets, err := parseServerSelector(entry[:i])
+	if err != nil {
+		return thresholdPattern{}, err
+	}
+	p := thresholdPattern{targets: targets, margin: 5}
+
+	parts := strings.Split(strings.TrimSpace(entry[i+1:]), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return thresholdPattern{}, fmt.Errorf("want selector=metric@threshold:above/below[:margin]")
+	}
+	metric, threshold, ok := strings.Cut(parts[0], "@")
+	if !ok || metricField(&MetricData{}, metric) == nil {
+		return thresholdPattern{}, fmt.Errorf("unknown metric %q", metric)
+	}
+	p.metric = metric
+	if p.threshold, err = strconv.ParseFloat(threshold, 64); err != nil || p.threshold < 0 || p.threshold > 100 {
+		return thresholdPattern{}, fmt.Errorf("invalid threshold %q", threshold)
+	}
+
+	above, below, ok := strings.Cut(parts[1], "/")
+	if !ok {
+		return thresholdPattern{}, fmt.Errorf("want above/below durations, e.g. 3m/2m")
+	}
+	for _, s := range strings.Split(above, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil || d <= 0 {
+			return thresholdPattern{}, fmt.Errorf("invalid duration %q", s)
+		}
+		p.above = append(p.above, d)
+		p.cycle += d
+	}
+	if p.below, err = time.ParseDuration(strings.TrimSpace(below)); err != nil || p.below <= 0 {
+		return thresholdPattern{}, fmt.Errorf("invalid duration %q", below)
+	}
+	p.cycle += p.below * time.Duration(len(p.above))
+
+	if len(parts) == 3 {
+		if p.margin, err = strconv.ParseFloat(parts[2], 64); err != nil || p.margin <= 0 {
+			return thresholdPattern{}, fmt.Errorf("invalid margin %q", parts[2])
+		}
+	}
+	return p, nil
+}
+
+// value returns the pattern's value at t.
+func (p thresholdPattern) value(t time.Time) float64 {
+	pos := time.Duration(t.UnixNano() % int64(p.cycle))
+	for _, above := range p.above {
+		if pos < above {
+			return p.threshold + p.margin
+		}
+		pos -= above + p.below
+		if pos < 0 {
+			break
+		}
+	}
+	return p.threshold - p.margin
+}
+
+// apply sets the metrics of server that patterns drive. Anomalies still
+// apply on top.
+func (tp *thresholdPatterns) apply(server fleet.ServerConfig, metric *MetricData) {
+	if tp == nil {
+		return
+	}
+	for _, rule := range tp.rules {
+		if rule.targets.matches(server) {
+			*metricField(metric, rule.metric) = roundFloat(clampPercent(rule.value(metric.Timestamp)), 2)
+		}
+	}
+}
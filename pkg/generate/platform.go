@@ -0,0 +1,167 @@
+package generate
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// osRelease is an operating system release with the kernel it ships.
+// Family follows the ECS os.family values.
+type osRelease struct {
+	Family  string
+	Name    string
+	Version string
+	Kernel  string
+}
+
+// osReleases lists the releases of each distribution OS_DISTRIBUTION can
+// name. Kernels are the x86_64 builds; arm64 servers get the arm64 ones.
+var osReleases = map[string][]osRelease{
+	"ubuntu": {
+		{"debian", "Ubuntu", "20.04", "5.4.0-182-generic"},
+		{"debian", "Ubuntu", "22.04", "5.15.0-112-generic"},
+		{"debian", "Ubuntu", "24.04", "6.8.0-36-generic"},
+	},
+	"debian": {
+		{"debian", "Debian GNU/Linux", "11", "5.10.0-30-amd64"},
+		{"debian", "Debian GNU/Linux", "12", "6.1.0-21-amd64"},
+	},
+	"rhel": {
+		{"redhat", "Red Hat Enterprise Linux", "8.10", "4.18.0-553.el8_10.x86_64"},
+		{"redhat", "Red Hat Enterprise Linux", "9.4", "5.14.0-427.13.1.el9_4.x86_64"},
+	},
+	"amazon": {
+		{"redhat", "Amazon Linux", "2", "5.10.217-205.860.amzn2.x86_64"},
+		{"redhat", "Amazon Linux", "2023", "6.1.92-99.174.amzn2023.x86_64"},
+	},
+	"windows": {
+		{"windows", "Windows Server 2019 Datacenter", "10.0.17763", "10.0.17763.5820"},
+		{"windows", "Windows Server 2022 Datacenter", "10.0.20348", "10.0.20348.2527"},
+	},
+}
+
+// armShare is the share of Linux servers running on arm64.
+const armShare = 0.15
+
+// weightedMix picks among names in proportion to their weights.
+type weightedMix struct {
+	names   []string
+	weights []float64 // cumulative, ending at 1
+}
+
+// parseWeightedMix parses a comma-separated list of "name=weight" entries
+// naming what, e.g. "ubuntu=70,rhel=20,windows=10". Weights need not add
+// up to 100.
+func parseWeightedMix(spec, what string, known []string) (weightedMix, error) {
+	var m weightedMix
+	var total float64
+	for _, entry := range strings.Split(spec, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok {
+			return m, fmt.Errorf("invalid %s entry %q (want name=weight)", what, entry)
+		}
+		if !slices.Contains(known, name) {
+			return m, fmt.Errorf("unknown %s %q (want %s)", what, name, strings.Join(known, ", "))
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(weight), 64)
+		if err != nil || w <= 0 {
+			return m, fmt.Errorf("invalid weight in %s entry %q", what, entry)
+		}
+		total += w
+		m.names = append(m.names, name)
+		m.weights = append(m.weights, total)
+	}
+	for i := range m.weights {
+		m.weights[i] /= total
+	}
+	return m, nil
+}
+
+// pick returns the name p, in [0, 1), falls on.
+func (m weightedMix) pick(p float64) string {
+	i := 0
+	for i < len(m.weights)-1 && p >= m.weights[i] {
+		i++
+	}
+	return m.names[i]
+}
+
+// osMix assigns operating systems to servers in the proportions of
+// OS_DISTRIBUTION.
+type osMix struct {
+	weightedMix
+}
+
+// parseOSMix parses OS_DISTRIBUTION, e.g. "ubuntu=70,rhel=20,windows=10".
+func parseOSMix(spec string) (*osMix, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	known := make([]string, 0, len(osReleases))
+	for name := range osReleases {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	mix, err := parseWeightedMix(spec, "OS distribution", known)
+	if err != nil {
+		return nil, err
+	}
+	return &osMix{mix}, nil
+}
+
+// platformHash returns a stable value in [0, 1) for server and purpose.
+func platformHash(server fleet.ServerConfig, purpose string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(server.ID))
+	h.Write([]byte(purpose))
+	return float64(h.Sum64()%1000000) / 1000000
+}
+
+// platform returns server's operating system release and architecture,
+// hashing the server ID so the choice is stable across runs.
+func (m *osMix) platform(server fleet.ServerConfig) (osRelease, string) {
+	releases := osReleases[m.pick(platformHash(server, "os"))]
+	release := releases[int(platformHash(server, "release")*float64(len(releases)))]
+
+	if release.Family == "windows" || platformHash(server, "arch") >= armShare {
+		return release, "x86_64"
+	}
+	release.Kernel = strings.NewReplacer("x86_64", "aarch64", "-amd64", "-arm64").Replace(release.Kernel)
+	return release, "aarch64"
+}
+
+// apply sets the platform fields of metric. Windows servers hold more
+// memory, for the file cache and services Linux doesn't run, and burn CPU
+// for a quarter of an hour a day while Defender scans the disk.
+func (m *osMix) apply(server fleet.ServerConfig, metric *MetricData) {
+	if m == nil {
+		return
+	}
+	release, arch := m.platform(server)
+	metric.OSFamily = release.Family
+	metric.OSName = release.Name
+	metric.OSVersion = release.Version
+	metric.KernelVersion = release.Kernel
+	metric.Architecture = arch
+
+	if release.Family != "windows" {
+		return
+	}
+	metric.MemoryUsage = roundFloat(clampPercent(metric.MemoryUsage+8), 2)
+	scan := time.Duration(platformHash(server, "scan") * float64(24*time.Hour))
+	since := metric.Timestamp.Sub(metric.Timestamp.Truncate(24*time.Hour)) - scan
+	if since < 0 {
+		since += 24 * time.Hour
+	}
+	if since < 15*time.Minute {
+		metric.CPUUsage = roundFloat(clampPercent(metric.CPUUsage+20), 2)
+	}
+}
@@ -0,0 +1,92 @@
+package generate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// workloadProfiles maps the name of each enabled workload profile to the
+// servers running it. A profile adds the metrics of what a server runs,
+// on top of its host metrics.
+type workloadProfiles map[string]serverSelector
+
+// profileTargets lists the known profiles and the servers each runs on
+// unless WORKLOAD_PROFILES says otherwise.
+var profileTargets = map[string]string{
+	"gpu":   "role:worker",
+	"jvm":   "role:app",
+	"db":    "role:db",
+	"web":   "role:web",
+	"cache": "role:cache",
+}
+
+// parseWorkloadProfiles parses WORKLOAD_PROFILES, a semicolon-separated
+// list of profile names, each optionally followed by "=selector" to pick
+// other servers than its default, e.g. "gpu=role:worker,role:app".
+func parseWorkloadProfiles(spec string) (workloadProfiles, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	profiles := make(workloadProfiles)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, targets, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if _, known := profileTargets[name]; !known {
+			names := make([]string, 0, len(profileTargets))
+			for name := range profileTargets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown workload profile %q (want one of %s)", name, strings.Join(names, ", "))
+		}
+		if _, dup := profiles[name]; dup {
+			return nil, fmt.Errorf("duplicate workload profile %q", name)
+		}
+		if !ok {
+			targets = profileTargets[name]
+		}
+		sel, err := parseServerSelector(targets)
+		if err != nil {
+			return nil, fmt.Errorf("workload profile %q: %w", name, err)
+		}
+		profiles[name] = sel
+	}
+	return profiles, nil
+}
+
+// runs reports whether server runs the named profile.
+func (p workloadProfiles) runs(server fleet.ServerConfig, name string) bool {
+	sel, ok := p[name]
+	return ok && sel.matches(server)
+}
+
+// applyProfiles adds the metrics of the profiles server runs to metric,
+// after anomalies so that incidents show in them. load is the server's
+// CPU usage before anomalies, standing for the demand on it.
+func (mg *MetricGenerator) applyProfiles(server fleet.ServerConfig, metric *MetricData, load float64, sim *serverSim) {
+	if mg.profiles == nil {
+		return
+	}
+	interval := mg.serverInterval(server)
+	if mg.profiles.runs(server, "jvm") {
+		applyJVM(server, metric, &sim.jvm, interval, sim.jvmRnd)
+	}
+	incident := len(mg.anomalies.Active(server, metric.Timestamp)) > 0
+	if mg.profiles.runs(server, "db") {
+		applyDatabase(server, metric, &sim.database, load, incident, interval, sim.databaseRnd)
+	}
+	if mg.profiles.runs(server, "web") {
+		applyWeb(server, metric, load, incident, interval, sim.webRnd)
+	}
+	if mg.profiles.runs(server, "cache") {
+		applyCache(server, metric, &sim.cache, load, incident, interval, sim.cacheRnd)
+	}
+}
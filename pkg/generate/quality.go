@@ -0,0 +1,123 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// qualityProfile trades generation cost against realism. Fast keeps
+// massive load tests cheap, realistic gives demos believable daily
+// patterns, and standard is the original random walk.
+type qualityProfile int
+
+const (
+	qualityStandard qualityProfile = iota
+	qualityFast
+	qualityRealistic
+)
+
+func parseQualityProfile(name string) (qualityProfile, error) {
+	switch name {
+	case "", "standard":
+		return qualityStandard, nil
+	case "fast":
+		return qualityFast, nil
+	case "realistic":
+		return qualityRealistic, nil
+	default:
+		return 0, fmt.Errorf("unknown quality profile %q (want fast, standard or realistic)", name)
+	}
+}
+
+// loadState is a server's coarse activity level in the realistic profile.
+type loadState int
+
+const (
+	loadNormal loadState = iota
+	loadIdle
+	loadBusy
+)
+
+// loadStateCPU is the CPU usage each state pulls towards at peak hours.
+var loadStateCPU = [...]float64{loadIdle: 8, loadNormal: 35, loadBusy: 80}
+
+// loadStateTransitions holds per-tick transition probabilities; each row
+// lists the chance of moving to idle, normal and busy.
+var loadStateTransitions = [...][3]float64{
+	loadIdle:   {0.90, 0.10, 0.00},
+	loadNormal: {0.03, 0.93, 0.04},
+	loadBusy:   {0.00, 0.15, 0.85},
+}
+
+// simState is the per-server state the realistic profile carries between
+// ticks; a zero value starts in the normal state.
+type simState struct {
+	load    loadState
+	memBase float64
+}
+
+// nextUsage advances a server's CPU, memory and disk usage by one tick
+// according to the generator's quality profile and, for the random walks,
+// the role's WALK_PARAMS.
+func (mg *MetricGenerator) nextUsage(server fleet.ServerConfig, prev MetricData, exists bool, now time.Time, sim *serverSim) (cpu, mem, disk float64) {
+	rnd := sim.rnd
+	if !exists {
+		return 10 + rnd.Float64()*40, 20 + rnd.Float64()*50, 5 + rnd.Float64()*30
+	}
+
+	if mg.quality == qualityRealistic {
+		return realisticUsage(server, prev, now, rnd, &sim.state)
+	}
+
+	p := mg.walk.of(server.Role)
+	step := func(i int) float64 { return rnd.Float64()*2*p.step[i] - p.step[i] }
+	if mg.quality == qualityFast {
+		return clampPercent(prev.CPUUsage + step(0)),
+			clampPercent(prev.MemoryUsage + step(1)),
+			clampPercent(prev.DiskUsage + step(2))
+	}
+
+	cpu = math.Max(0, math.Min(100, prev.CPUUsage+step(0)+p.wave(0, now)))
+	mem = math.Max(0, math.Min(100, prev.MemoryUsage+step(1)+p.wave(1, now)))
+	disk = math.Max(0, math.Min(100, prev.DiskUsage+step(2)+p.wave(2, now)))
+	return cpu, mem, disk
+}
+
+// realisticUsage models daily and weekly seasonality in the server's local
+// time, idle/normal/busy states, memory that follows CPU and disk that
+// fills up slowly.
+func realisticUsage(server fleet.ServerConfig, prev MetricData, now time.Time, rnd *rand.Rand, state *simState) (cpu, mem, disk float64) {
+	if state.memBase == 0 {
+		state.memBase = prev.MemoryUsage * 0.6
+	}
+
+	p := rnd.Float64()
+	for next, chance := range loadStateTransitions[state.load] {
+		if p < chance {
+			state.load = loadState(next)
+			break
+		}
+		p -= chance
+	}
+
+	// Approximate local solar time from the longitude
+	local := now.Add(time.Duration(server.Location.Longitude / 15 * float64(time.Hour)))
+	hour := float64(local.Hour()) + float64(local.Minute())/60
+	season := 0.6 + 0.4*math.Cos(2*math.Pi*(hour-14)/24)
+	if wd := local.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		season *= 0.7
+	}
+
+	target := loadStateCPU[state.load] * season
+	cpu = clampPercent(prev.CPUUsage + 0.3*(target-prev.CPUUsage) + rnd.NormFloat64()*3)
+
+	memTarget := state.memBase + 0.4*cpu
+	mem = clampPercent(prev.MemoryUsage + 0.1*(memTarget-prev.MemoryUsage) + rnd.NormFloat64())
+
+	disk = clampPercent(prev.DiskUsage + 0.02 + rnd.Float64()*0.03)
+	return cpu, mem, disk
+}
@@ -0,0 +1,255 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// QueueMetrics is the state of one simulated message queue and its
+// consumer group at the end of a tick.
+type QueueMetrics struct {
+	Timestamp     time.Time `json:"@timestamp"`
+	Queue         string    `json:"queue"`
+	ConsumerGroup string    `json:"consumer_group"`
+	ProducerRole  string    `json:"producer_role"`
+	ConsumerRole  string    `json:"consumer_role"`
+	Producers     int       `json:"producers"`
+	Consumers     int       `json:"consumers"`
+
+	Published   int64   `json:"published"` // messages over the tick
+	Consumed    int64   `json:"consumed"`
+	PublishRate float64 `json:"publish_rate"` // messages per second
+	ConsumeRate float64 `json:"consume_rate"`
+
+	// Depth counts the messages waiting for a consumer, and ConsumerLag
+	// those the group has not acknowledged yet, delivered ones included
+	Depth              int64   `json:"depth"`
+	ConsumerLag        int64   `json:"consumer_lag"`
+	ConsumerLagSeconds float64 `json:"consumer_lag_seconds"`
+}
+
+// queueSpec is one simulated queue, fed by every server of one role and
+// drained by a consumer group running on every server of another.
+type queueSpec struct {
+	Name     string
+	Producer string
+	Consumer string
+	Rate     float64 // messages per second at 50% producer CPU
+}
+
+// queueState is a queue's backlog carried between ticks.
+type queueState struct {
+	depth      float64
+	lagSeconds float64
+}
+
+// queuePrefetch is how many messages each consumer holds unacknowledged
+// at most.
+const queuePrefetch = 50
+
+// parseQueues parses QUEUES, a comma-separated list of
+// "name:producer role:consumer role[:messages per second]" entries, e.g.
+// "orders:web:worker:500,emails:app:worker".
+func parseQueues(spec string) ([]queueSpec, error) {
+	var queues []queueSpec
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 3 || len(parts) > 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid queue %q (want name:producer:consumer[:rate])", entry)
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("duplicate queue %q", parts[0])
+		}
+		seen[parts[0]] = true
+
+		queue := queueSpec{Name: parts[0], Producer: parts[1], Consumer: parts[2], Rate: 100}
+		if len(parts) > 3 {
+			rate, err := strconv.ParseFloat(parts[3], 64)
+			if err != nil || rate <= 0 {
+				return nil, fmt.Errorf("invalid message rate in queue %q", entry)
+			}
+			queue.Rate = rate
+		}
+		queues = append(queues, queue)
+	}
+	return queues, nil
+}
+
+// queueGenerator produces depth and consumer lag metrics for the
+// configured queues. Producers publish with the CPU usage of their role,
+// and consumers drain the queue as fast as the servers of theirs that
+// reported this tick allow, so a backlog builds whenever consumers are
+// down or saturated and drains once they recover.
+type queueGenerator struct {
+	queues    []queueSpec
+	instances map[string]int // servers per role
+	index     sink.IndexNamer
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+	// cpu sums the CPU usage emitted this tick per role, and reporting
+	// holds the servers that reported
+	cpu       map[string]float64
+	samples   map[string]int
+	reporting map[string]map[string]bool
+	state     map[string]*queueState
+}
+
+// newQueueGenerator returns nil, disabling queue metrics, when spec lists
+// no queues.
+func newQueueGenerator(spec, index string, servers []fleet.ServerConfig, seed int64) (*queueGenerator, error) {
+	queues, err := parseQueues(spec)
+	if err != nil || len(queues) == 0 {
+		return nil, err
+	}
+
+	g := &queueGenerator{
+		queues:    queues,
+		instances: make(map[string]int),
+		index:     sink.NewIndexNamer(index),
+		rnd:       rand.New(rand.NewSource(fleet.DeriveSeed(seed, "queues"))),
+		cpu:       make(map[string]float64),
+		samples:   make(map[string]int),
+		reporting: make(map[string]map[string]bool),
+		state:     make(map[string]*queueState, len(queues)),
+	}
+	for _, server := range servers {
+		g.instances[server.Role]++
+	}
+	for _, queue := range queues {
+		for _, role := range []string{queue.Producer, queue.Consumer} {
+			if g.instances[role] == 0 {
+				return nil, fmt.Errorf("queue %q uses role %q, which no server has", queue.Name, role)
+			}
+		}
+		g.state[queue.Name] = &queueState{}
+	}
+	return g, nil
+}
+
+// observe records that server reported this tick and adds the CPU usage
+// emitted for it to its role's mean.
+func (g *queueGenerator) observe(server fleet.ServerConfig, metric MetricData) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.cpu[server.Role] += metric.CPUUsage
+	g.samples[server.Role]++
+	if g.reporting[server.Role] == nil {
+		g.reporting[server.Role] = make(map[string]bool)
+	}
+	g.reporting[server.Role][server.ID] = true
+	g.mu.Unlock()
+}
+
+// generate returns the metrics of every queue for the tick ending at now
+// and starts the next tick's observations.
+func (g *queueGenerator) generate(now time.Time, interval time.Duration) []QueueMetrics {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	seconds := interval.Seconds()
+	out := make([]QueueMetrics, 0, len(g.queues))
+	for _, queue := range g.queues {
+		state := g.state[queue.Name]
+		producers, consumers := len(g.reporting[queue.Producer]), len(g.reporting[queue.Consumer])
+
+		// Servers that did not report publish nothing
+		publishRate := queue.Rate * g.meanCPU(queue.Producer) / 50 * float64(producers) / float64(g.instances[queue.Producer])
+		published := poisson(publishRate*seconds*(0.9+0.2*g.rnd.Float64()), g.rnd)
+
+		// At full strength the group keeps up with 1.5 times the usual
+		// rate, and slows down as its servers saturate above 90% CPU
+		capacity := queue.Rate * 1.5 * float64(consumers) / float64(g.instances[queue.Consumer])
+		if cpu := g.meanCPU(queue.Consumer); cpu > 90 {
+			capacity *= math.Max(1-(cpu-90)/20, 0)
+		}
+		available := state.depth + float64(published)
+		consumed := math.Floor(math.Min(available, capacity*seconds*(0.9+0.2*g.rnd.Float64())))
+		state.depth = available - consumed
+		inflight := math.Min(consumed, float64(consumers*queuePrefetch)*(0.5+0.5*g.rnd.Float64()))
+
+		// Lag in time is how long the backlog takes to drain at the
+		// current pace, or how long the group has been stalled
+		switch {
+		case state.depth == 0:
+			state.lagSeconds = 0
+		case consumed > 0:
+			state.lagSeconds = state.depth / (consumed / seconds)
+		default:
+			state.lagSeconds += seconds
+		}
+
+		out = append(out, QueueMetrics{
+			Timestamp:          now,
+			Queue:              queue.Name,
+			ConsumerGroup:      queue.Name + "-" + queue.Consumer,
+			ProducerRole:       queue.Producer,
+			ConsumerRole:       queue.Consumer,
+			Producers:          producers,
+			Consumers:          consumers,
+			Published:          int64(published),
+			Consumed:           int64(consumed),
+			PublishRate:        roundFloat(float64(published)/seconds, 2),
+			ConsumeRate:        roundFloat(consumed/seconds, 2),
+			Depth:              int64(state.depth),
+			ConsumerLag:        int64(state.depth + inflight),
+			ConsumerLagSeconds: roundFloat(state.lagSeconds, 1),
+		})
+	}
+
+	clear(g.cpu)
+	clear(g.samples)
+	clear(g.reporting)
+	return out
+}
+
+// meanCPU returns the mean CPU usage emitted this tick by role's servers.
+func (g *queueGenerator) meanCPU(role string) float64 {
+	if n := g.samples[role]; n > 0 {
+		return g.cpu[role] / float64(n)
+	}
+	return 0
+}
+
+// queueDocuments generates and encodes the queue metrics of the tick that
+// just finished.
+func (mg *MetricGenerator) queueDocuments(now time.Time) []sink.Document {
+	if mg.queues == nil {
+		return nil
+	}
+	metrics := mg.queues.generate(now, mg.interval)
+	docs := make([]sink.Document, 0, len(metrics))
+	for _, m := range metrics {
+		body, err := json.Marshal(m)
+		if err != nil {
+			slog.Error("Error marshaling queue metrics", "queue", m.Queue, "error", err)
+			continue
+		}
+		docs = append(docs, sink.Document{
+			Type:      "queue",
+			ServerID:  m.Queue,
+			Role:      m.ConsumerRole,
+			Timestamp: m.Timestamp,
+			Index:     mg.queues.index(m.Timestamp),
+			Body:      body,
+		})
+	}
+	return docs
+}
@@ -0,0 +1,313 @@
+package generate
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// ReplayCommand sends recorded metrics from an NDJSON or CSV file to the
+// configured sinks, paced by their timestamps:
+//
+//	replay [-shift] [-loop] [-speed 1] [-format ndjson|csv] <file>
+func ReplayCommand(config config.Config, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	shift := fs.Bool("shift", false, "shift timestamps so the recording starts now")
+	loop := fs.Bool("loop", false, "start over at the end of the recording, continuing its timeline")
+	speed := fs.Float64("speed", 1, "playback speed relative to the recording; 0 sends as fast as the sinks accept")
+	format := fs.String("format", "", "ndjson or csv (default from the file extension)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: replay [-shift] [-loop] [-speed 1] [-format ndjson|csv] <file>")
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = "ndjson"
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			*format = "csv"
+		}
+	}
+	if *format != "ndjson" && *format != "csv" {
+		return fmt.Errorf("unknown format %q (want ndjson or csv)", *format)
+	}
+	if *speed < 0 {
+		return fmt.Errorf("speed must not be negative")
+	}
+
+	classes, err := sink.ParseDeliveryClasses(config.DeliveryClasses)
+	if err != nil {
+		return fmt.Errorf("configuring delivery classes: %w", err)
+	}
+	sinks, err := sink.ParseSinkList(config.Sink)
+	if err != nil {
+		return err
+	}
+	delivery := &sink.FanOut{}
+	if err := delivery.Open(context.Background(), sinks, config, classes); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go delivery.Run(ctx)
+
+	p := &recordingPlayer{
+		path:     path,
+		format:   *format,
+		speed:    *speed,
+		index:    sink.NewIndexNamer(config.ESIndex),
+		delivery: delivery,
+	}
+	replayed, err := p.play(ctx, *shift, *loop)
+
+	delivery.FlushAll(context.Background())
+	if cerr := delivery.Close(); cerr != nil {
+		slog.Error("Error closing sink", "error", cerr)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := telemetry.Stats.Failed.Load()
+	slog.Info("Replay finished", "replayed", replayed, "indexed", telemetry.Stats.Indexed.Load(), "failed", failed)
+	if failed > 0 {
+		return fmt.Errorf("%d documents could not be delivered", failed)
+	}
+	return nil
+}
+
+// recordingPlayer plays a recording, one pass at a time.
+type recordingPlayer struct {
+	path     string
+	format   string
+	speed    float64
+	index    sink.IndexNamer
+	delivery Deliverer
+
+	// Learned on the first pass: the recording's first and last
+	// timestamps and the smallest gap between two of them
+	first, last time.Time
+	step        time.Duration
+
+	// offset is added to recorded timestamps; playback is paced from
+	// started, when the record stamped origin was sent
+	offset  time.Duration
+	started time.Time
+	origin  time.Time
+}
+
+// play replays the recording until it ends or, with loop, until ctx is
+// done, and returns how many records were sent.
+func (p *recordingPlayer) play(ctx context.Context, shift, loop bool) (int, error) {
+	p.started = time.Now()
+	var sent int
+	for pass := 0; ; pass++ {
+		n, err := p.pass(ctx, pass == 0, shift)
+		sent += n
+		if errors.Is(err, context.Canceled) {
+			return sent, nil
+		}
+		if err != nil || !loop || ctx.Err() != nil {
+			return sent, err
+		}
+		if sent == 0 {
+			return 0, fmt.Errorf("%s has no records", p.path)
+		}
+		// Continue the timeline one step after the recording ended
+		if p.step == 0 {
+			p.step = time.Minute
+		}
+		p.offset += p.last.Sub(p.first) + p.step
+		slog.Info("Replaying recording again", "file", p.path, "pass", pass+2)
+	}
+}
+
+// pass sends every record of the recording once. The first pass also
+// learns the recording's time range.
+func (p *recordingPlayer) pass(ctx context.Context, first, shift bool) (int, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	next := newNDJSONRecords(f)
+	if p.format == "csv" {
+		if next, err = newCSVRecords(f); err != nil {
+			return 0, fmt.Errorf("%s: %w", p.path, err)
+		}
+	}
+
+	var sent int
+	for {
+		record, err := next()
+		if err == io.EOF {
+			return sent, nil
+		}
+		if err == nil {
+			err = p.send(ctx, record, first, shift)
+		}
+		if errors.Is(err, context.Canceled) {
+			return sent, err
+		}
+		if err != nil {
+			return sent, fmt.Errorf("%s: record %d: %w", p.path, sent+1, err)
+		}
+		sent++
+	}
+}
+
+// send waits until record is due and hands it to the sinks.
+func (p *recordingPlayer) send(ctx context.Context, record map[string]any, first, shift bool) error {
+	ts, err := recordTimestamp(record)
+	if err != nil {
+		return err
+	}
+	if first {
+		if p.first.IsZero() {
+			p.first = ts
+			if shift {
+				p.offset = p.started.Sub(ts)
+			}
+			p.origin = ts.Add(p.offset)
+		}
+		if gap := ts.Sub(p.last); !p.last.IsZero() && gap > 0 && (p.step == 0 || gap < p.step) {
+			p.step = gap
+		}
+		if ts.After(p.last) {
+			p.last = ts
+		}
+	}
+	ts = ts.Add(p.offset)
+
+	if p.speed > 0 {
+		due := p.started.Add(time.Duration(float64(ts.Sub(p.origin)) / p.speed))
+		if err := sleepUntil(ctx, due); err != nil {
+			return err
+		}
+	}
+
+	record["@timestamp"] = ts.Format(time.RFC3339Nano)
+	delete(record, "timestamp")
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	serverID, _ := record["server_id"].(string)
+	hostname, _ := record["hostname"].(string)
+	role, _ := record["role"].(string)
+
+	telemetry.Stats.Generated.Add("metric", 1)
+	p.delivery.Submit(ctx, sink.Document{
+		Type:      "metric",
+		ServerID:  serverID,
+		Hostname:  hostname,
+		Role:      role,
+		Timestamp: ts,
+		Index:     p.index(ts),
+		Body:      body,
+	})
+	return nil
+}
+
+// sleepUntil waits until t or until ctx is done.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// recordTimestamp returns the time of a recorded metric, from its
+// "@timestamp" or "timestamp" field in RFC 3339 or Unix seconds.
+func recordTimestamp(record map[string]any) (time.Time, error) {
+	v, ok := record["@timestamp"]
+	if !ok {
+		v, ok = record["timestamp"]
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("no @timestamp or timestamp field")
+	}
+	return parseIncidentTime(fmt.Sprint(v))
+}
+
+// newNDJSONRecords reads one JSON object per line.
+func newNDJSONRecords(r io.Reader) func() (map[string]any, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	return func() (map[string]any, error) {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			dec := json.NewDecoder(strings.NewReader(line))
+			dec.UseNumber()
+			var record map[string]any
+			if err := dec.Decode(&record); err != nil {
+				return nil, err
+			}
+			return record, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
+
+// newCSVRecords reads rows of a CSV file with a header, turning numeric
+// cells into numbers and leaving out empty ones.
+func newCSVRecords(r io.Reader) (func() (map[string]any, error), error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	return func() (map[string]any, error) {
+		row, err := reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		record := make(map[string]any, len(row))
+		for i, cell := range row {
+			if cell == "" || i >= len(header) {
+				continue
+			}
+			if f, err := strconv.ParseFloat(cell, 64); err == nil && header[i] != "server_id" && header[i] != "hostname" {
+				record[header[i]] = f
+			} else {
+				record[header[i]] = cell
+			}
+		}
+		return record, nil
+	}, nil
+}
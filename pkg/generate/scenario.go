@@ -0,0 +1,225 @@
+package generate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scenario is a scripted timeline of anomalies, described in YAML:
+//
+//	name: checkout walkthrough
+//	events:
+//	  - at: T+10m
+//	    kind: spike
+//	    servers: role:web
+//	    metric: cpu
+//	    value: 95
+//	    duration: 15m
+//	  - at: T+30m
+//	    kind: outage
+//	    servers: fleet:eu-west
+//	    duration: 10m
+type scenario struct {
+	Name   string
+	Events []*Anomaly
+}
+
+// scenarioEventKeys are the keys an event may have.
+var scenarioEventKeys = map[string]bool{
+	"at": true, "name": true, "kind": true, "servers": true, "metric": true,
+	"value": true, "from": true, "values": true, "duration": true, "file": true,
+}
+
+// loadScenario reads the scenario at path, with event times relative to
+// start. Replay files are relative to the scenario's directory.
+func loadScenario(path string, start time.Time) (*scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseYAML(string(data))
+	if err != nil {
+		return nil, err
+	}
+	root, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a mapping with \"name\" and \"events\"")
+	}
+
+	sc := &scenario{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	for key, v := range root {
+		switch key {
+		case "name":
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("name: expected a string")
+			}
+			sc.Name = name
+		case "events":
+		default:
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+	}
+
+	events, ok := root["events"].([]any)
+	if !ok || len(events) == 0 {
+		return nil, fmt.Errorf("events: expected a list of events")
+	}
+	for i, v := range events {
+		fields, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("event %d: expected a mapping", i+1)
+		}
+		event, err := parseScenarioEvent(fields, start, filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", i+1, err)
+		}
+		if event.Name == "" {
+			event.Name = fmt.Sprintf("%s: %s at T+%s", sc.Name, event.Kind, event.Start.Sub(start))
+		}
+		sc.Events = append(sc.Events, event)
+	}
+	sort.SliceStable(sc.Events, func(i, j int) bool { return sc.Events[i].Start.Before(sc.Events[j].Start) })
+	return sc, nil
+}
+
+// parseScenarioEvent turns one event into an anomaly. Kinds are "spike"
+// (hold metrics at fixed values), "ramp" (move a metric linearly from one
+// value to another), "outage" (stop reporting) and "replay" (play a
+// recorded incident CSV).
+func parseScenarioEvent(fields map[string]any, start time.Time, dir string) (*Anomaly, error) {
+	str := make(map[string]string)
+	for key, v := range fields {
+		if !scenarioEventKeys[key] {
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+		if s, ok := v.(string); ok {
+			str[key] = s
+		} else if key != "values" {
+			return nil, fmt.Errorf("%s: expected a single value", key)
+		}
+	}
+
+	at, err := parseScenarioOffset(str["at"])
+	if err != nil {
+		return nil, err
+	}
+	targets, err := parseServerSelector(str["servers"])
+	if err != nil {
+		return nil, fmt.Errorf("servers: %w", err)
+	}
+	a := &Anomaly{Name: str["name"], Kind: str["kind"], Targets: targets, Start: start.Add(at)}
+
+	var duration time.Duration
+	if s := str["duration"]; s != "" {
+		if duration, err = time.ParseDuration(s); err != nil || duration <= 0 {
+			return nil, fmt.Errorf("duration: invalid duration %q", s)
+		}
+	} else if a.Kind != "replay" {
+		return nil, fmt.Errorf("duration is required")
+	}
+
+	switch a.Kind {
+	case "spike":
+		values, err := scenarioValues(fields, str)
+		if err != nil {
+			return nil, err
+		}
+		a.Values = func(time.Duration) map[string]float64 { return values }
+	case "ramp":
+		metric := str["metric"]
+		if metricField(&MetricData{}, metric) == nil {
+			return nil, fmt.Errorf("metric: unknown metric %q", metric)
+		}
+		from, err := strconv.ParseFloat(str["from"], 64)
+		if err != nil {
+			return nil, fmt.Errorf("from: invalid value %q", str["from"])
+		}
+		to, err := strconv.ParseFloat(str["value"], 64)
+		if err != nil {
+			return nil, fmt.Errorf("value: invalid value %q", str["value"])
+		}
+		a.Values = func(elapsed time.Duration) map[string]float64 {
+			frac := min(float64(elapsed)/float64(duration), 1)
+			return map[string]float64{metric: from + (to-from)*frac}
+		}
+	case "outage":
+		a.Outage = true
+	case "replay":
+		file := str["file"]
+		if file == "" {
+			return nil, fmt.Errorf("file is required")
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		shape, err := loadIncidentCSV(file)
+		if err != nil {
+			return nil, fmt.Errorf("file: %w", err)
+		}
+		if duration == 0 {
+			duration = shape.duration()
+		}
+		a.Values = shape.at
+	case "":
+		return nil, fmt.Errorf("kind is required")
+	default:
+		return nil, fmt.Errorf("unknown kind %q", a.Kind)
+	}
+	a.End = a.Start.Add(duration)
+	return a, nil
+}
+
+// scenarioValues returns the values of a spike, given either as "metric"
+// and "value" or as a "values" mapping of metric names to values.
+func scenarioValues(fields map[string]any, str map[string]string) (map[string]float64, error) {
+	values := make(map[string]float64)
+	if raw, ok := fields["values"]; ok {
+		m, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("values: expected a mapping of metric names to values")
+		}
+		for name, v := range m {
+			s, _ := v.(string)
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("values: invalid value for %s", name)
+			}
+			values[name] = f
+		}
+	}
+	if metric := str["metric"]; metric != "" {
+		f, err := strconv.ParseFloat(str["value"], 64)
+		if err != nil {
+			return nil, fmt.Errorf("value: invalid value %q", str["value"])
+		}
+		values[metric] = f
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("metric and value, or values, are required")
+	}
+	for name := range values {
+		if metricField(&MetricData{}, name) == nil {
+			return nil, fmt.Errorf("unknown metric %q", name)
+		}
+	}
+	return values, nil
+}
+
+// parseScenarioOffset parses an event time such as "T+10m", "+10m" or
+// "10m". An empty time is the start of the scenario.
+func parseScenarioOffset(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimPrefix(strings.TrimPrefix(s, "T"), "+"))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("at: invalid time %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,82 @@
+package generate
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"time"
+)
+
+// currentSchemaVersion is the document shape described by MetricData.
+const currentSchemaVersion = 2
+
+// legacyFieldNames maps current field names to the names schema version 1
+// agents used. Version 1 agents also predate the geo_point location field.
+var legacyFieldNames = map[string]string{
+	"hostname":     "host",
+	"cpu_usage":    "cpu_pct",
+	"memory_usage": "mem_pct",
+	"disk_usage":   "disk_pct",
+}
+
+// agentRollout upgrades the simulated agents from OldVersion to NewVersion
+// over Duration, starting at Start. Each server upgrades at a stable,
+// hash-derived point in the window, so the share of documents in the new
+// schema grows steadily over the course of the rollout.
+type agentRollout struct {
+	OldVersion string
+	NewVersion string
+	Start      time.Time
+	Duration   time.Duration
+}
+
+// versionAt returns the agent version and schema version a server reports at t.
+func (r agentRollout) versionAt(serverID string, t time.Time) (string, int) {
+	if r.Duration <= 0 {
+		return r.NewVersion, currentSchemaVersion
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(serverID))
+	position := float64(h.Sum64()%1000000) / 1000000
+
+	upgradeAt := r.Start.Add(time.Duration(position * float64(r.Duration)))
+	if t.Before(upgradeAt) {
+		return r.OldVersion, 1
+	}
+	return r.NewVersion, currentSchemaVersion
+}
+
+// downgradeSchema rewrites a current document into the given older schema.
+func downgradeSchema(doc map[string]interface{}, version int) {
+	if version >= currentSchemaVersion {
+		return
+	}
+	for current, legacy := range legacyFieldNames {
+		if v, ok := doc[current]; ok {
+			doc[legacy] = v
+			delete(doc, current)
+		}
+	}
+	delete(doc, "location")
+}
+
+// encode marshals a metric in the schema of the agent that produced it
+// and applies the configured missing/null field faults.
+func (mg *MetricGenerator) encode(metric MetricData) ([]byte, error) {
+	raw, err := json.Marshal(metric)
+	if err != nil {
+		return nil, err
+	}
+	if metric.SchemaVersion >= currentSchemaVersion && !mg.fields.active() {
+		return raw, nil
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	downgradeSchema(doc, metric.SchemaVersion)
+	mg.fields.apply(metric.ServerID, metric.Timestamp, doc)
+
+	return json.Marshal(doc)
+}
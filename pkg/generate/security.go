@@ -0,0 +1,246 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// SecurityEvent is an authentication attempt, a sudo command or a port
+// scan seen on a simulated server, shaped for SIEM dashboards.
+type SecurityEvent struct {
+	Timestamp time.Time `json:"@timestamp"`
+	ServerID  string    `json:"server_id"`
+	Hostname  string    `json:"hostname"`
+	Role      string    `json:"role"`
+	Fleet     string    `json:"fleet,omitempty"`
+	IPAddress string    `json:"ip_address"`
+
+	Category string `json:"event_category"` // authentication, process or network
+	Action   string `json:"event_action"`   // ssh_login, sudo or port_scan
+	Outcome  string `json:"event_outcome"`  // success or failure
+	Severity string `json:"severity"`       // low, medium or high
+	Message  string `json:"message"`
+
+	User         string `json:"user,omitempty"`
+	SourceIP     string `json:"source_ip,omitempty"`
+	SourcePort   int    `json:"source_port,omitempty"`
+	Command      string `json:"command,omitempty"`
+	Ports        []int  `json:"destination_ports,omitempty"`
+	PortsScanned int    `json:"ports_scanned,omitempty"`
+
+	// Attack names the attack the event belongs to, as ground truth for
+	// detection rules.
+	Attack string `json:"attack,omitempty"`
+}
+
+// securityAttack is an attack in progress on one server: a brute-force
+// run against SSH or a port scan, from one source.
+type securityAttack struct {
+	name     string
+	kind     string // brute_force or port_scan
+	sourceIP string
+	rate     float64 // attempts per minute
+	end      time.Time
+	breached bool // the brute force ends with a successful login
+}
+
+// securityState is a server's attack in progress, if any.
+type securityState struct {
+	attack *securityAttack
+	count  int
+}
+
+// securityGenerator writes the security events of the simulated servers:
+// routine logins and sudo use by the operations team, a trickle of failed
+// logins from the internet on public-facing servers, and now and then a
+// brute-force attack or port scan.
+type securityGenerator struct {
+	rate       float64 // Average routine events per server per tick
+	attackRate float64 // Attacks per server per day
+	index      sink.IndexNamer
+}
+
+// newSecurityGenerator returns nil, disabling security events, when rate
+// is zero.
+func newSecurityGenerator(rate, attackRate float64, index string) (*securityGenerator, error) {
+	if rate < 0 || math.IsNaN(rate) {
+		return nil, fmt.Errorf("invalid security event rate %v", rate)
+	}
+	if attackRate < 0 || math.IsNaN(attackRate) {
+		return nil, fmt.Errorf("invalid security attack rate %v (want attacks per server per day)", attackRate)
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+	return &securityGenerator{rate: rate, attackRate: attackRate, index: sink.NewIndexNamer(index)}, nil
+}
+
+var (
+	operators    = []string{"deploy", "alice", "bob", "ansible"}
+	guessedUsers = []string{"root", "admin", "test", "ubuntu", "oracle", "postgres", "git", "user"}
+	// sudoCommands are run by operators; {process} is the role's
+	// application process
+	sudoCommands = []string{
+		"/usr/bin/systemctl restart {process}",
+		"/usr/bin/journalctl -u {process} --since today",
+		"/usr/bin/apt-get upgrade -y",
+		"/usr/bin/tail -n 200 /var/log/syslog",
+		"/usr/sbin/ss -tlnp",
+	}
+	// scannedPorts are the ports scanners try first
+	scannedPorts = []int{21, 22, 23, 25, 53, 80, 110, 135, 139, 143, 443, 445, 1433, 3306, 3389, 5432, 5900, 6379, 8080, 8443, 9200, 27017}
+)
+
+// generate returns the events on server over the interval ending at now,
+// oldest first.
+func (g *securityGenerator) generate(server fleet.ServerConfig, now time.Time, interval time.Duration, state *securityState, rnd *rand.Rand) []SecurityEvent {
+	base := SecurityEvent{
+		ServerID:  server.ID,
+		Hostname:  server.Hostname,
+		Role:      server.Role,
+		Fleet:     server.Fleet,
+		IPAddress: server.IPAddress,
+	}
+	at := func() time.Time {
+		return now.Add(-time.Duration(rnd.Int63n(int64(interval)))).Truncate(time.Millisecond)
+	}
+
+	var events []SecurityEvent
+	for n := poisson(g.rate, rnd); n > 0; n-- {
+		e := base
+		e.Timestamp = at()
+		switch p := rnd.Float64(); {
+		case p < 0.45:
+			e.Category, e.Action, e.Outcome, e.Severity = "authentication", "ssh_login", "success", "low"
+			e.User, e.SourceIP, e.SourcePort = operators[rnd.Intn(len(operators))], fmt.Sprintf("10.%d.%d.%d", rnd.Intn(256), rnd.Intn(256), 1+rnd.Intn(254)), 40000+rnd.Intn(20000)
+			e.Message = fmt.Sprintf("Accepted publickey for %s from %s port %d ssh2", e.User, e.SourceIP, e.SourcePort)
+		case p < 0.75:
+			e.Category, e.Action, e.Outcome, e.Severity = "process", "sudo", "success", "low"
+			e.User = operators[rnd.Intn(len(operators))]
+			e.Command = strings.ReplaceAll(sudoCommands[rnd.Intn(len(sudoCommands))], "{process}", roleProcesses[server.Role])
+			if rnd.Intn(20) == 0 {
+				e.Outcome, e.Severity = "failure", "medium"
+				e.Message = fmt.Sprintf("%s : 3 incorrect password attempts ; TTY=pts/0 ; PWD=/home/%s ; USER=root ; COMMAND=%s", e.User, e.User, e.Command)
+			} else {
+				e.Message = fmt.Sprintf("%s : TTY=pts/0 ; PWD=/home/%s ; USER=root ; COMMAND=%s", e.User, e.User, e.Command)
+			}
+		default:
+			// Public-facing servers are probed by the internet; the rest
+			// only see operators mistyping
+			e.Category, e.Action, e.Outcome, e.Severity = "authentication", "ssh_login", "failure", "low"
+			e.SourcePort = 30000 + rnd.Intn(35000)
+			if server.Role == "web" || server.PublicIP != "" {
+				e.User, e.SourceIP = guessedUsers[rnd.Intn(len(guessedUsers))], randomClientIP(rnd)
+				e.Message = fmt.Sprintf("Invalid user %s from %s port %d", e.User, e.SourceIP, e.SourcePort)
+			} else {
+				e.User, e.SourceIP = operators[rnd.Intn(len(operators))], fmt.Sprintf("10.%d.%d.%d", rnd.Intn(256), rnd.Intn(256), 1+rnd.Intn(254))
+				e.Message = fmt.Sprintf("Failed password for %s from %s port %d ssh2", e.User, e.SourceIP, e.SourcePort)
+			}
+		}
+		events = append(events, e)
+	}
+
+	if state.attack == nil && rnd.Float64() < 1-math.Exp(-g.attackRate*interval.Hours()/24) {
+		state.count++
+		a := &securityAttack{sourceIP: randomClientIP(rnd), end: now.Add(time.Duration(5+rnd.Intn(16)) * time.Minute)}
+		if rnd.Intn(2) == 0 {
+			a.kind, a.rate, a.breached = "brute_force", 10+rnd.Float64()*50, rnd.Intn(20) == 0
+		} else {
+			a.kind, a.rate = "port_scan", 200+rnd.Float64()*2000
+		}
+		a.name = fmt.Sprintf("%s-%s-%d", a.kind, server.ID, state.count)
+		state.attack = a
+		slog.Info("Security attack", "name", a.name, "server_id", server.ID, "source_ip", a.sourceIP)
+	}
+	if a := state.attack; a != nil {
+		events = append(events, a.events(base, now, interval, rnd)...)
+		if !now.Before(a.end) {
+			state.attack = nil
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	return events
+}
+
+// events returns the attack's events over the interval ending at now. A
+// brute force is one failed login per attempt and, if it succeeds, a
+// final login as root. A port scan is summarized in one event per
+// interval with the ports it tried.
+func (a *securityAttack) events(base SecurityEvent, now time.Time, interval time.Duration, rnd *rand.Rand) []SecurityEvent {
+	base.SourceIP, base.Attack = a.sourceIP, a.name
+	attempts := poisson(a.rate*interval.Minutes(), rnd)
+	last := !now.Before(a.end)
+
+	if a.kind == "port_scan" {
+		if attempts == 0 {
+			return nil
+		}
+		e := base
+		e.Timestamp = now.Truncate(time.Millisecond)
+		e.Category, e.Action, e.Outcome, e.Severity = "network", "port_scan", "failure", "medium"
+		e.PortsScanned = attempts
+		e.Ports = scannedPorts[:min(attempts, len(scannedPorts))]
+		e.Message = fmt.Sprintf("Blocked %d connection attempts from %s", attempts, a.sourceIP)
+		return []SecurityEvent{e}
+	}
+
+	events := make([]SecurityEvent, 0, attempts+1)
+	for i := 0; i < attempts; i++ {
+		e := base
+		e.Timestamp = now.Add(-time.Duration(rnd.Int63n(int64(interval)))).Truncate(time.Millisecond)
+		e.Category, e.Action, e.Outcome, e.Severity = "authentication", "ssh_login", "failure", "medium"
+		e.User, e.SourcePort = guessedUsers[rnd.Intn(len(guessedUsers))], 30000+rnd.Intn(35000)
+		e.Message = fmt.Sprintf("Failed password for %s from %s port %d ssh2", e.User, a.sourceIP, e.SourcePort)
+		events = append(events, e)
+	}
+	if last && a.breached {
+		e := base
+		e.Timestamp = now.Truncate(time.Millisecond)
+		e.Category, e.Action, e.Outcome, e.Severity = "authentication", "ssh_login", "success", "high"
+		e.User, e.SourcePort = "root", 30000+rnd.Intn(35000)
+		e.Message = fmt.Sprintf("Accepted password for root from %s port %d ssh2", a.sourceIP, e.SourcePort)
+		events = append(events, e)
+	}
+	return events
+}
+
+// securityDocuments generates the security events of one server since its
+// last report and encodes them for delivery.
+func (mg *MetricGenerator) securityDocuments(server fleet.ServerConfig, metric MetricData) []sink.Document {
+	if mg.security == nil {
+		return nil
+	}
+	mg.mu.Lock()
+	sim := mg.serverSim(server)
+	mg.mu.Unlock()
+
+	events := mg.security.generate(server, metric.Timestamp, mg.serverInterval(server), &sim.security, sim.securityRnd)
+	docs := make([]sink.Document, 0, len(events))
+	for _, e := range events {
+		body, err := json.Marshal(e)
+		if err != nil {
+			slog.Error("Error marshaling security event", "server_id", server.ID, "error", err)
+			continue
+		}
+		docs = append(docs, sink.Document{
+			Type:      "security",
+			ServerID:  server.ID,
+			Hostname:  server.Hostname,
+			Role:      server.Role,
+			Timestamp: e.Timestamp,
+			Index:     mg.security.index(e.Timestamp),
+			Body:      body,
+		})
+	}
+	return docs
+}
@@ -0,0 +1,143 @@
+package generate
+
+import (
+	"math/rand"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// serverSim is the per-server simulation state carried between ticks. A
+// server is only ever generated by one worker at a time, so its state
+// needs no locking of its own.
+type serverSim struct {
+	// rnd is derived from the fleet's seed and the server ID, so a server's
+	// series is the same on every run with the same seed no matter how the
+	// workers are scheduled.
+	rnd   *rand.Rand
+	state simState
+
+	// schedule is when the server reports next, with SERVER_INTERVALS
+	schedule emissionSchedule
+
+	// diskBase is the disk usage right after log rotation
+	diskBase float64
+
+	// truth is read by the /truth endpoint while the server is generated
+	truth truthHistory
+
+	// logRnd drives log generation, set only with SERVER_LOG_RATE. It is
+	// separate from rnd so enabling logs leaves the metrics unchanged.
+	logRnd *rand.Rand
+
+	// securityRnd and security drive SECURITY_EVENT_RATE
+	securityRnd *rand.Rand
+	security    securityState
+
+	// dockerRnd and containers drive the server's containers, set only
+	// with DOCKER_CONTAINERS
+	dockerRnd  *rand.Rand
+	containers []*simContainer
+
+	// formulaRnd backs rand() and normal() in METRIC_FORMULAS
+	formulaRnd *rand.Rand
+
+	// correlation holds the history METRIC_CORRELATIONS lags look back on
+	correlation correlationState
+
+	// cardinalityRnd and cardinality drive HIGH_CARDINALITY_RATES
+	cardinalityRnd *rand.Rand
+	cardinality    cardinalityState
+
+	// uptimeRnd and uptime drive UPTIME_METRICS
+	uptimeRnd *rand.Rand
+	uptime    uptimeState
+
+	// heartbeat is the agent's last check-in, with HEARTBEATS
+	heartbeat heartbeatState
+
+	// sensorRnd and sensors drive HARDWARE_SENSORS
+	sensorRnd *rand.Rand
+	sensors   sensorState
+
+	// gpuRnd, gpus and gpuJob drive the gpu workload profile
+	gpuRnd *rand.Rand
+	gpus   []*simGPU
+	gpuJob gpuJob
+
+	// jvmRnd and jvm drive the jvm workload profile
+	jvmRnd *rand.Rand
+	jvm    jvmState
+
+	// databaseRnd and database drive the db workload profile
+	databaseRnd *rand.Rand
+	database    databaseState
+
+	// webRnd drives the web workload profile
+	webRnd *rand.Rand
+
+	// cacheRnd and cache drive the cache workload profile
+	cacheRnd *rand.Rand
+	cache    cacheState
+
+	// timingRnd and skew drive LATE_ARRIVALS and CLOCK_SKEW
+	timingRnd *rand.Rand
+	skew      clockSkew
+}
+
+// serverSim returns the simulation state for one server, creating it on
+// first use. Servers built without a seed of their own derive one from
+// the generator's. The caller must hold mg.mu.
+func (mg *MetricGenerator) serverSim(server fleet.ServerConfig) *serverSim {
+	if sim, ok := mg.sims[server.ID]; ok {
+		return sim
+	}
+	if mg.sims == nil {
+		mg.sims = make(map[string]*serverSim)
+	}
+	seed := server.Seed
+	if seed == 0 {
+		seed = fleet.DeriveSeed(mg.seed, server.ID)
+	}
+	sim := &serverSim{rnd: rand.New(rand.NewSource(seed))}
+	if mg.logs != nil {
+		sim.logRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "logs")))
+	}
+	if mg.security != nil {
+		sim.securityRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "security")))
+	}
+	if mg.docker != nil {
+		sim.dockerRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "docker")))
+	}
+	if mg.formulas != nil {
+		sim.formulaRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "formulas")))
+	}
+	if mg.cardinality != nil {
+		sim.cardinalityRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "cardinality")))
+	}
+	if mg.reboots != nil {
+		sim.uptimeRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "uptime")))
+	}
+	if mg.sensors {
+		sim.sensorRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "sensors")))
+	}
+	if mg.gpus != nil {
+		sim.gpuRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "gpu")))
+	}
+	if _, ok := mg.profiles["jvm"]; ok {
+		sim.jvmRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "jvm")))
+	}
+	if _, ok := mg.profiles["db"]; ok {
+		sim.databaseRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "db")))
+	}
+	if _, ok := mg.profiles["web"]; ok {
+		sim.webRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "web")))
+	}
+	if _, ok := mg.profiles["cache"]; ok {
+		sim.cacheRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "cache")))
+	}
+	if mg.timing != nil {
+		sim.timingRnd = rand.New(rand.NewSource(fleet.DeriveSeed(seed, "timing")))
+	}
+	mg.sims[server.ID] = sim
+	return sim
+}
@@ -0,0 +1,50 @@
+package generate
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// sensorState is a server's hardware sensor readings carried between
+// reports. The CPU heats up and cools down gradually, so the temperature
+// trails the CPU usage instead of jumping with it.
+type sensorState struct {
+	inlet float64 // Air temperature at the server's intake
+	cpu   float64 // CPU package temperature
+}
+
+// thermalTimeConstant is how long the CPU temperature takes to cover
+// about two thirds of the way to the temperature of the current load.
+const thermalTimeConstant = 2 * time.Minute
+
+// applySensors sets the hardware sensor readings of metric from its CPU
+// usage: the inlet and CPU temperatures, the fan speed that follows the
+// CPU temperature, and the power draw of the server's instance type.
+func applySensors(server fleet.ServerConfig, metric *MetricData, state *sensorState, interval time.Duration, rnd *rand.Rand) {
+	if state.inlet == 0 {
+		// Cold aisles run between 18 and 27°C
+		state.inlet = 18 + rnd.Float64()*9
+	}
+	// Under full load the CPU runs about 55°C above its idle temperature
+	target := state.inlet + 15 + 55*metric.CPUUsage/100
+	if state.cpu == 0 {
+		state.cpu = target
+	} else {
+		state.cpu += (target - state.cpu) * (1 - math.Exp(-interval.Seconds()/thermalTimeConstant.Seconds()))
+	}
+	inlet := state.inlet + rnd.NormFloat64()*0.2
+	cpu := state.cpu + rnd.NormFloat64()*0.5
+
+	// Fans idle at 2000 rpm and ramp up linearly from 45°C to full speed
+	// at 90°C
+	rpm := 2000 + (12000-2000)*math.Min(math.Max((cpu-45)/45, 0), 1)
+	rpm += rnd.NormFloat64() * 40
+
+	metric.InletTemperature = roundFloat(inlet, 1)
+	metric.CPUTemperature = roundFloat(cpu, 1)
+	metric.FanSpeedRPM = math.Round(rpm)
+	metric.PowerWatts = roundFloat(instanceTypeFor(server).watts(metric.CPUUsage), 2)
+}
@@ -0,0 +1,308 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// ServiceMetrics is the RED summary (rate, errors, duration) of one
+// simulated service over one tick.
+type ServiceMetrics struct {
+	Timestamp time.Time `json:"@timestamp"`
+	Service   string    `json:"service"`
+	Role      string    `json:"role"`
+	Instances int       `json:"instances"`
+
+	Requests    int64   `json:"requests"`
+	Errors      int64   `json:"errors"`
+	RequestRate float64 `json:"request_rate"` // requests per second
+	ErrorRate   float64 `json:"error_rate"`   // share of failed requests
+
+	LatencyP50 float64 `json:"latency_p50"` // milliseconds
+	LatencyP90 float64 `json:"latency_p90"`
+	LatencyP95 float64 `json:"latency_p95"`
+	LatencyP99 float64 `json:"latency_p99"`
+
+	// HostCPUUsage is the mean CPU usage of the servers running the service
+	HostCPUUsage float64 `json:"host_cpu_usage"`
+
+	// DependsOn lists the services and roles the service calls, set only
+	// with TOPOLOGY
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// ServiceSLO is set only with SLO_TARGET or SLO_BURNS
+	*ServiceSLO
+}
+
+// serviceSpec is one simulated service, running on every server of a role.
+type serviceSpec struct {
+	Name    string
+	Role    string
+	RPS     float64
+	Latency time.Duration // median at idle
+	// Deps are the services, and "role:<role>" server groups, it calls
+	Deps []string
+}
+
+// parseServices parses SERVICES, a comma-separated list of
+// "name:role[:requests per second[:median latency]]" entries, e.g.
+// "checkout:web:200:80ms,orders:app".
+func parseServices(spec string) ([]serviceSpec, error) {
+	var services []serviceSpec
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 4 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid service %q (want name:role[:rps[:latency]])", entry)
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("duplicate service %q", parts[0])
+		}
+		seen[parts[0]] = true
+
+		svc := serviceSpec{Name: parts[0], Role: parts[1], RPS: 100, Latency: 50 * time.Millisecond}
+		if len(parts) > 2 {
+			rps, err := strconv.ParseFloat(parts[2], 64)
+			if err != nil || rps <= 0 {
+				return nil, fmt.Errorf("invalid request rate in service %q", entry)
+			}
+			svc.RPS = rps
+		}
+		if len(parts) > 3 {
+			latency, err := time.ParseDuration(parts[3])
+			if err != nil || latency <= 0 {
+				return nil, fmt.Errorf("invalid latency in service %q", entry)
+			}
+			svc.Latency = latency
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// latencySpread is the log-normal sigma of request latencies, and
+// latencyQuantiles the standard normal quantiles of the reported
+// percentiles.
+const latencySpread = 0.5
+
+var latencyQuantiles = [4]float64{0, 1.2816, 1.6449, 2.3263}
+
+// serviceGenerator produces RED metrics for the configured services.
+// Services slow down and fail more as the CPU usage of their hosts climbs,
+// so service dashboards react to the same incidents as host dashboards.
+type serviceGenerator struct {
+	services  []serviceSpec
+	instances map[string]int // servers per role
+	index     sink.IndexNamer
+	slo       *sloModel
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+	// cpu sums the CPU usage emitted this tick per role
+	cpu     map[string]float64
+	samples map[string]int
+}
+
+// newServiceGenerator returns nil, disabling service metrics, when spec
+// lists no services.
+func newServiceGenerator(spec, topology, index string, slo *sloModel, servers []fleet.ServerConfig, seed int64) (*serviceGenerator, error) {
+	services, err := parseServices(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		if strings.TrimSpace(topology) != "" {
+			return nil, fmt.Errorf("TOPOLOGY needs SERVICES")
+		}
+		if slo != nil {
+			return nil, fmt.Errorf("SLO_TARGET and SLO_BURNS need SERVICES")
+		}
+		return nil, nil
+	}
+
+	g := &serviceGenerator{
+		services:  services,
+		instances: make(map[string]int),
+		index:     sink.NewIndexNamer(index),
+		slo:       slo,
+		rnd:       rand.New(rand.NewSource(fleet.DeriveSeed(seed, "services"))),
+		cpu:       make(map[string]float64),
+		samples:   make(map[string]int),
+	}
+	for _, server := range servers {
+		g.instances[server.Role]++
+	}
+	for _, svc := range services {
+		if g.instances[svc.Role] == 0 {
+			return nil, fmt.Errorf("service %q runs on role %q, which no server has", svc.Name, svc.Role)
+		}
+	}
+	if slo != nil {
+		for _, e := range slo.episodes {
+			if !slices.ContainsFunc(services, func(svc serviceSpec) bool { return svc.Name == e.service }) {
+				return nil, fmt.Errorf("SLO burn for unknown service %q", e.service)
+			}
+		}
+	}
+	if g.services, err = parseTopology(topology, services, g.instances); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// observe adds the CPU usage emitted for server to its role's mean.
+func (g *serviceGenerator) observe(server fleet.ServerConfig, metric MetricData) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.cpu[server.Role] += metric.CPUUsage
+	g.samples[server.Role]++
+	g.mu.Unlock()
+}
+
+// generate returns the RED metrics of every service for the tick ending
+// at now and starts the next tick's CPU means. Services are generated
+// after their dependencies, whose latency adds to theirs and whose errors
+// fail their requests too. A scripted SLO burn sets the error rate of its
+// service alone, leaving the services calling it to their usual errors.
+func (g *serviceGenerator) generate(now time.Time, interval time.Duration) []ServiceMetrics {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	type outcome struct {
+		latency   [4]float64
+		errorRate float64
+	}
+	outcomes := make(map[string]outcome, len(g.services))
+
+	out := make([]ServiceMetrics, 0, len(g.services))
+	for _, svc := range g.services {
+		cpu := g.meanCPU(svc.Role)
+		latency, errorRate := g.callProfile(svc.Latency, cpu)
+		ok := 1 - errorRate
+		for _, dep := range svc.Deps {
+			var depLatency [4]float64
+			var depErrors float64
+			if role, isRole := strings.CutPrefix(dep, "role:"); isRole {
+				depLatency, depErrors = g.callProfile(roleCallLatency(role), g.meanCPU(role))
+			} else {
+				depLatency, depErrors = outcomes[dep].latency, outcomes[dep].errorRate
+			}
+			for i := range latency {
+				latency[i] += depLatency[i]
+			}
+			ok *= 1 - depErrors
+		}
+		errorRate = 1 - ok
+		outcomes[svc.Name] = outcome{latency: latency, errorRate: errorRate}
+
+		var slo *ServiceSLO
+		if g.slo != nil {
+			slo = &ServiceSLO{Target: g.slo.target}
+			if e, burning := g.slo.episode(svc.Name, now); burning {
+				errorRate = e.rate * (1 - g.slo.target)
+				slo.ScriptedBurnRate, slo.Episode = e.rate, e.name
+			}
+		}
+
+		requests := poisson(svc.RPS*interval.Seconds(), g.rnd)
+		errors := int64(math.Round(float64(requests) * errorRate))
+		m := ServiceMetrics{
+			Timestamp:    now,
+			Service:      svc.Name,
+			Role:         svc.Role,
+			Instances:    g.instances[svc.Role],
+			Requests:     int64(requests),
+			Errors:       errors,
+			RequestRate:  roundFloat(float64(requests)/interval.Seconds(), 2),
+			LatencyP50:   roundFloat(latency[0], 2),
+			LatencyP90:   roundFloat(latency[1], 2),
+			LatencyP95:   roundFloat(latency[2], 2),
+			LatencyP99:   roundFloat(latency[3], 2),
+			HostCPUUsage: roundFloat(cpu, 2),
+			DependsOn:    svc.Deps,
+		}
+		if requests > 0 {
+			m.ErrorRate = roundFloat(float64(errors)/float64(requests), 4)
+		}
+		if slo != nil {
+			success := 1.0
+			if requests > 0 {
+				success = 1 - float64(errors)/float64(requests)
+			}
+			slo.SuccessRatio = roundFloat(success, 6)
+			slo.BurnRate = roundFloat((1-success)/(1-g.slo.target), 2)
+			m.ServiceSLO = slo
+		}
+		out = append(out, m)
+	}
+
+	clear(g.cpu)
+	clear(g.samples)
+	return out
+}
+
+// meanCPU returns the mean CPU usage emitted this tick by role's servers.
+func (g *serviceGenerator) meanCPU(role string) float64 {
+	if n := g.samples[role]; n > 0 {
+		return g.cpu[role] / float64(n)
+	}
+	return 0
+}
+
+// callProfile returns the latency percentiles, in milliseconds, and error
+// rate of calls served by hosts at the given CPU usage. Busy hosts shift
+// the whole distribution, stretch its tail and fail above 85% CPU.
+func (g *serviceGenerator) callProfile(median time.Duration, cpu float64) ([4]float64, float64) {
+	load := cpu / 100
+	ms := float64(median) / float64(time.Millisecond) * (1 + 3*load*load*load) * (0.9 + 0.2*g.rnd.Float64())
+	spread := latencySpread * (1 + load*load)
+	var p [4]float64
+	for i, z := range latencyQuantiles {
+		p[i] = ms * math.Exp(spread*z)
+	}
+	errorRate := math.Min(1, (0.001+0.5*math.Max(0, (cpu-85)/15))*(0.5+g.rnd.Float64()))
+	return p, errorRate
+}
+
+// serviceDocuments generates and encodes the service metrics of the tick
+// that just finished.
+func (mg *MetricGenerator) serviceDocuments(now time.Time) []sink.Document {
+	if mg.services == nil {
+		return nil
+	}
+	metrics := mg.services.generate(now, mg.interval)
+	docs := make([]sink.Document, 0, len(metrics))
+	for _, m := range metrics {
+		body, err := json.Marshal(m)
+		if err != nil {
+			slog.Error("Error marshaling service metrics", "service", m.Service, "error", err)
+			continue
+		}
+		docs = append(docs, sink.Document{
+			Type:      "service",
+			ServerID:  m.Service,
+			Role:      m.Role,
+			Timestamp: m.Timestamp,
+			Index:     mg.services.index(m.Timestamp),
+			Body:      body,
+		})
+	}
+	return docs
+}
@@ -0,0 +1,99 @@
+package generate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServiceSLO is a service's SLI for one tick measured against its SLO,
+// inlined in the service document. ScriptedBurnRate is the ground truth
+// alert rules are checked against.
+type ServiceSLO struct {
+	Target           float64 `json:"slo_target"`        // e.g. 0.999
+	SuccessRatio     float64 `json:"sli_success_ratio"` // share of requests that succeeded
+	BurnRate         float64 `json:"burn_rate"`         // error rate over the error budget
+	ScriptedBurnRate float64 `json:"scripted_burn_rate"`
+	Episode          string  `json:"burn_episode,omitempty"`
+}
+
+// sloModel holds the SLO of every service and the scripted episodes that
+// burn through their error budgets.
+type sloModel struct {
+	target   float64
+	episodes []burnEpisode
+}
+
+// burnEpisode makes a service fail at rate times its error budget from
+// start until end.
+type burnEpisode struct {
+	name    string
+	service string
+	rate    float64
+	start   time.Time
+	end     time.Time
+}
+
+// parseSLOs parses SLO_TARGET, the availability target of every service
+// in percent, and SLO_BURNS, a semicolon-separated list of
+// "service=burn rate@start/duration" episodes with start times relative
+// to start as in scenarios, e.g. "checkout=14.4@T+10m/1h;orders=6@T+2h/6h".
+// It returns nil, leaving SLO fields out, when neither is set.
+func parseSLOs(target float64, burns string, start time.Time) (*sloModel, error) {
+	if target == 0 && strings.TrimSpace(burns) == "" {
+		return nil, nil
+	}
+	if target == 0 {
+		target = 99.9
+	}
+	if target <= 0 || target >= 100 {
+		return nil, fmt.Errorf("invalid SLO target %g%% (want between 0 and 100)", target)
+	}
+
+	m := &sloModel{target: roundFloat(target/100, 6)}
+	for _, entry := range strings.Split(burns, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		service, rest, ok1 := strings.Cut(entry, "=")
+		rate, window, ok2 := strings.Cut(rest, "@")
+		at, duration, ok3 := strings.Cut(window, "/")
+		if !ok1 || !ok2 || !ok3 || strings.TrimSpace(service) == "" {
+			return nil, fmt.Errorf("invalid SLO burn %q (want service=burn rate@start/duration)", entry)
+		}
+		e := burnEpisode{name: fmt.Sprintf("burn-%d", len(m.episodes)+1), service: strings.TrimSpace(service)}
+		var err error
+		if e.rate, err = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(rate), "x"), 64); err != nil || e.rate < 0 {
+			return nil, fmt.Errorf("invalid burn rate in SLO burn %q", entry)
+		}
+		if e.rate*(1-m.target) > 1 {
+			return nil, fmt.Errorf("burn rate in SLO burn %q fails more than every request", entry)
+		}
+		offset, err := parseScenarioOffset(strings.TrimSpace(at))
+		if err != nil {
+			return nil, fmt.Errorf("invalid start in SLO burn %q", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration in SLO burn %q", entry)
+		}
+		e.start = start.Add(offset)
+		e.end = e.start.Add(d)
+		m.episodes = append(m.episodes, e)
+	}
+	return m, nil
+}
+
+// episode returns the episode burning service's budget at t, if any.
+// Later episodes win when several overlap.
+func (m *sloModel) episode(service string, t time.Time) (burnEpisode, bool) {
+	for i := len(m.episodes) - 1; i >= 0; i-- {
+		e := m.episodes[i]
+		if e.service == service && !t.Before(e.start) && t.Before(e.end) {
+			return e, true
+		}
+	}
+	return burnEpisode{}, false
+}
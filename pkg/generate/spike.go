@@ -0,0 +1,83 @@
+package generate
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+)
+
+// SpikeCommand implements "spike -servers <selector> -cpu 95 [-duration
+// 10m]", which injects an anomaly into a running generator through its
+// control API, starting now, so presenters can trigger an alert live.
+func SpikeCommand(config config.Config, args []string) error {
+	fs := flag.NewFlagSet("spike", flag.ContinueOnError)
+	servers := fs.String("servers", "", "servers to spike, with the selectors of INCIDENT_REPLAY_HOSTS, e.g. server-017 or role:db")
+	values := make(map[string]float64)
+	for _, metric := range []string{"cpu", "memory", "disk"} {
+		fs.Func(metric, "hold "+metric+" usage at this value", func(s string) error {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || v < 0 || v > 100 {
+				return fmt.Errorf("want a percentage")
+			}
+			values[metric] = v
+			return nil
+		})
+	}
+	outage := fs.Bool("outage", false, "stop the servers reporting instead")
+	duration := fs.Duration("duration", 10*time.Minute, "how long the anomaly lasts")
+	name := fs.String("name", "", "anomaly name, to end it early with DELETE /api/anomalies/{name}")
+	addr := fs.String("addr", config.HTTPAddr, "HTTP address of the running generator")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *servers == "" || (len(values) == 0 && !*outage) {
+		return fmt.Errorf("usage: spike -servers <selector> [-cpu 95] [-memory 90] [-disk 98] [-outage] [-duration 10m] [-name n] [-addr host:port]")
+	}
+	if *duration <= 0 {
+		return fmt.Errorf("invalid duration %s", *duration)
+	}
+	base, err := generatorURL(*addr)
+	if err != nil {
+		return err
+	}
+
+	req := map[string]any{"servers": *servers, "duration": duration.String(), "name": *name}
+	if *outage {
+		req["outage"] = true
+	} else {
+		req["values"] = values
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	res, err := http.Post(base+"/api/anomalies", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body))
+	}
+
+	var status controlStatus
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return err
+	}
+	for _, a := range status.Anomalies {
+		state := "pending"
+		if a.Active {
+			state = "active"
+		}
+		fmt.Printf("%s (%s, %s) from %s until %s\n", a.Name, a.Kind, state, a.Start.Format(time.RFC3339), a.End.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// timingFaults makes servers' documents arrive late and their clocks run
+// off, so pipelines' handling of out-of-order data can be tested.
+type timingFaults struct {
+	// LATE_ARRIVALS: the share of reports held back, and for how long
+	lateRate           float64
+	minDelay, maxDelay time.Duration
+
+	// CLOCK_SKEW: the share of servers whose clock is off, by up to
+	// maxOffset and drifting up to maxDrift further every hour
+	skewShare float64
+	maxOffset time.Duration
+	maxDrift  time.Duration
+
+	mu   sync.Mutex
+	held []lateBatch
+}
+
+// lateBatch is one server's report, held back until release.
+type lateBatch struct {
+	release time.Time
+	docs    []sink.Document
+}
+
+// clockSkew is how far one server's clock is off: offset from its first
+// report on, plus drift seconds gained every second since.
+type clockSkew struct {
+	drawn  bool
+	skewed bool
+	offset time.Duration
+	drift  float64
+	since  time.Time
+}
+
+// parseTimingFaults parses LATE_ARRIVALS, a comma-separated list of
+// "rate=<share of reports>" and "delay=<duration>[-<duration>]", and
+// CLOCK_SKEW, a comma-separated list of "servers=<share of servers>",
+// "offset=<duration>" and "drift=<duration>/h", e.g.
+// "rate=0.05,delay=30s-10m" and "servers=0.2,offset=90s,drift=2s/h".
+func parseTimingFaults(late, skew string) (*timingFaults, error) {
+	if strings.TrimSpace(late) == "" && strings.TrimSpace(skew) == "" {
+		return nil, nil
+	}
+
+	t := &timingFaults{minDelay: time.Minute, maxDelay: time.Minute, skewShare: 1}
+	err := parseTimingSpec(late, "LATE_ARRIVALS", func(key, value string) error {
+		switch key {
+		case "rate":
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return fmt.Errorf("invalid late arrival rate %q (want a share between 0 and 1)", value)
+			}
+			t.lateRate = rate
+		case "delay":
+			from, to, ranged := strings.Cut(value, "-")
+			minDelay, err := time.ParseDuration(from)
+			maxDelay := minDelay
+			if err == nil && ranged {
+				maxDelay, err = time.ParseDuration(to)
+			}
+			if err != nil || minDelay <= 0 || maxDelay < minDelay {
+				return fmt.Errorf("invalid late arrival delay %q (want a duration or a range like 30s-10m)", value)
+			}
+			t.minDelay, t.maxDelay = minDelay, maxDelay
+		default:
+			return fmt.Errorf("unknown late arrival setting %q (want rate or delay)", key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(late) != "" && t.lateRate == 0 {
+		return nil, fmt.Errorf("invalid LATE_ARRIVALS %q (rate is required)", late)
+	}
+
+	err = parseTimingSpec(skew, "CLOCK_SKEW", func(key, value string) error {
+		switch key {
+		case "servers":
+			share, err := strconv.ParseFloat(value, 64)
+			if err != nil || share <= 0 || share > 1 {
+				return fmt.Errorf("invalid clock skew servers %q (want a share between 0 and 1)", value)
+			}
+			t.skewShare = share
+		case "offset":
+			offset, err := time.ParseDuration(value)
+			if err != nil || offset < 0 {
+				return fmt.Errorf("invalid clock skew offset %q (want a duration)", value)
+			}
+			t.maxOffset = offset
+		case "drift":
+			drift, err := time.ParseDuration(strings.TrimSuffix(value, "/h"))
+			if err != nil || drift < 0 {
+				return fmt.Errorf("invalid clock skew drift %q (want a duration per hour, e.g. 2s/h)", value)
+			}
+			t.maxDrift = drift
+		default:
+			return fmt.Errorf("unknown clock skew setting %q (want servers, offset or drift)", key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(skew) != "" && t.maxOffset == 0 && t.maxDrift == 0 {
+		return nil, fmt.Errorf("invalid CLOCK_SKEW %q (want an offset, a drift or both)", skew)
+	}
+	return t, nil
+}
+
+// parseTimingSpec calls set with each key and value of a comma-separated
+// list of key=value settings.
+func parseTimingSpec(spec, name string, set func(key, value string) error) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid %s setting %q (want key=value)", name, entry)
+		}
+		if err := set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skew returns the time server's clock shows at now and how far off it
+// is. The skew is drawn on the server's first report, from rnd.
+func (t *timingFaults) skew(s *clockSkew, rnd *rand.Rand, now time.Time) (time.Time, time.Duration) {
+	if t == nil || t.maxOffset == 0 && t.maxDrift == 0 {
+		return now, 0
+	}
+	if !s.drawn {
+		s.drawn, s.since = true, now
+		s.skewed = rnd.Float64() < t.skewShare
+		s.offset = time.Duration((rnd.Float64()*2 - 1) * float64(t.maxOffset))
+		s.drift = (rnd.Float64()*2 - 1) * float64(t.maxDrift) / float64(time.Hour)
+	}
+	if !s.skewed {
+		return now, 0
+	}
+	off := s.offset + time.Duration(s.drift*float64(now.Sub(s.since)))
+	return now.Add(off), off
+}
+
+// holdLate decides from rnd whether a server's report of docs arrives
+// late, holding a copy of them back until a random delay after now if
+// so.
+func (t *timingFaults) holdLate(docs []sink.Document, rnd *rand.Rand, now time.Time) bool {
+	if t == nil || t.lateRate == 0 || len(docs) == 0 || rnd.Float64() >= t.lateRate {
+		return false
+	}
+	delay := t.minDelay
+	if t.maxDelay > t.minDelay {
+		delay += time.Duration(rnd.Int63n(int64(t.maxDelay - t.minDelay)))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.held = append(t.held, lateBatch{release: now.Add(delay), docs: append([]sink.Document(nil), docs...)})
+	return true
+}
+
+// release submits the held reports due by now, or all of them if now is
+// zero, in the order they fall due.
+func (t *timingFaults) release(delivery Deliverer, now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	var due []lateBatch
+	kept := t.held[:0]
+	for _, b := range t.held {
+		if now.IsZero() || !b.release.After(now) {
+			due = append(due, b)
+		} else {
+			kept = append(kept, b)
+		}
+	}
+	t.held = kept
+	t.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].release.Before(due[j].release) })
+	for _, b := range due {
+		delivery.Submit(context.Background(), b.docs...)
+	}
+}
+
+// holdLate holds server's report of docs back with LATE_ARRIVALS,
+// reporting whether it did.
+func (mg *MetricGenerator) holdLate(server fleet.ServerConfig, docs []sink.Document) bool {
+	if mg.timing == nil || mg.timing.lateRate == 0 {
+		return false
+	}
+	mg.mu.Lock()
+	sim := mg.serverSim(server)
+	mg.mu.Unlock()
+	return mg.timing.holdLate(docs, sim.timingRnd, mg.Now().UTC())
+}
@@ -0,0 +1,98 @@
+package generate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// roleCallLatency is the median latency of a call made directly to the
+// servers of a role, such as a database query, at idle.
+func roleCallLatency(role string) time.Duration {
+	switch role {
+	case "db":
+		return 5 * time.Millisecond
+	case "cache":
+		return 500 * time.Microsecond
+	}
+	return 2 * time.Millisecond
+}
+
+// parseTopology parses TOPOLOGY, a semicolon-separated list of
+// "service->dependency,..." entries, where a dependency is another service
+// or "role:<role>" for calls straight to a group of servers, e.g.
+// "checkout->orders,search;orders->role:db;search->role:cache". It returns
+// services with their dependencies, ordered so every service comes after
+// the services it calls.
+func parseTopology(spec string, services []serviceSpec, instances map[string]int) ([]serviceSpec, error) {
+	byName := make(map[string]int, len(services))
+	for i, svc := range services {
+		byName[svc.Name] = i
+	}
+
+	deps := make([][]string, len(services))
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(entry, "->")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid topology entry %q (want service->dependency,...)", entry)
+		}
+		i, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("topology entry %q names unknown service %q", entry, name)
+		}
+		for _, dep := range strings.Split(list, ",") {
+			dep = strings.TrimSpace(dep)
+			if role, isRole := strings.CutPrefix(dep, "role:"); isRole {
+				if instances[role] == 0 {
+					return nil, fmt.Errorf("topology entry %q depends on role %q, which no server has", entry, role)
+				}
+			} else if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("topology entry %q depends on unknown service %q", entry, dep)
+			}
+			deps[i] = append(deps[i], dep)
+		}
+	}
+
+	// Depth-first topological sort, keeping the SERVICES order otherwise
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(services))
+	ordered := make([]serviceSpec, 0, len(services))
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		path = append(path, services[i].Name)
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("topology has a cycle: %s", strings.Join(path, " -> "))
+		case done:
+			return nil
+		}
+		state[i] = visiting
+		for _, dep := range deps[i] {
+			if j, ok := byName[dep]; ok {
+				if err := visit(j, path); err != nil {
+					return err
+				}
+			}
+		}
+		state[i] = done
+		svc := services[i]
+		svc.Deps = deps[i]
+		ordered = append(ordered, svc)
+		return nil
+	}
+	for i := range services {
+		if err := visit(i, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
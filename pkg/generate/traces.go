@@ -0,0 +1,434 @@
+package generate
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// traceServices names the simulated service each role runs. Requests
+// enter through the web tier, call the app tier and end in the database,
+// with the app tier consulting the cache first when the fleet has one.
+var traceServices = map[string]string{
+	"web":   "storefront",
+	"app":   "orders-api",
+	"db":    "postgres",
+	"cache": "redis",
+}
+
+// Span kinds as numbered by OTLP.
+const (
+	spanKindServer = 2
+	spanKindClient = 3
+)
+
+// span is one finished operation of a synthetic trace.
+type span struct {
+	TraceID  [16]byte
+	ID       [8]byte
+	ParentID [8]byte
+	Service  string
+	Server   fleet.ServerConfig
+	Name     string
+	Kind     int
+	Start    time.Time
+	End      time.Time
+	Attrs    [][2]string
+	Error    bool
+}
+
+// traceGenerator produces distributed traces across the fleet's web, app,
+// db and cache servers. Every hop is slower on a busier host, so trace
+// latency follows the CPU metrics generated for the same tick.
+type traceGenerator struct {
+	rate     float64 // Average traces per tick
+	exporter *otlpExporter
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+	// byRole holds the servers of each traced role
+	byRole map[string][]fleet.ServerConfig
+	// cpu is the CPU usage last emitted for each server
+	cpu map[string]float64
+}
+
+// newTraceGenerator returns nil, disabling traces, when rate is zero.
+func newTraceGenerator(rate float64, servers []fleet.ServerConfig, seed int64, exporter *otlpExporter) (*traceGenerator, error) {
+	if rate < 0 || math.IsNaN(rate) {
+		return nil, fmt.Errorf("invalid trace rate %v", rate)
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+
+	g := &traceGenerator{
+		rate:     rate,
+		exporter: exporter,
+		rnd:      rand.New(rand.NewSource(fleet.DeriveSeed(seed, "traces"))),
+		byRole:   make(map[string][]fleet.ServerConfig),
+		cpu:      make(map[string]float64),
+	}
+	for _, server := range servers {
+		if _, ok := traceServices[server.Role]; ok {
+			g.byRole[server.Role] = append(g.byRole[server.Role], server)
+		}
+	}
+	if len(g.byRole["web"]) == 0 && len(g.byRole["app"]) == 0 {
+		return nil, fmt.Errorf("traces need at least one web or app server")
+	}
+	return g, nil
+}
+
+// observe records the CPU usage emitted for server.
+func (g *traceGenerator) observe(server fleet.ServerConfig, metric MetricData) {
+	if g == nil {
+		return
+	}
+	if _, ok := traceServices[server.Role]; !ok {
+		return
+	}
+	g.mu.Lock()
+	g.cpu[server.ID] = metric.CPUUsage
+	g.mu.Unlock()
+}
+
+// tick generates the traces of the interval ending at now and exports them.
+func (g *traceGenerator) tick(ctx context.Context, now time.Time, interval time.Duration) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	var spans []span
+	for i, n := 0, poisson(g.rate, g.rnd); i < n; i++ {
+		start := now.Add(-time.Duration(g.rnd.Int63n(int64(interval))))
+		spans = append(spans, g.trace(start)...)
+	}
+	g.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+	telemetry.Stats.Generated.Add("span", int64(len(spans)))
+	g.exporter.Export(ctx, spans)
+}
+
+// pick returns a random server of role, or false if the fleet has none.
+func (g *traceGenerator) pick(role string) (fleet.ServerConfig, bool) {
+	servers := g.byRole[role]
+	if len(servers) == 0 {
+		return fleet.ServerConfig{}, false
+	}
+	return servers[g.rnd.Intn(len(servers))], true
+}
+
+// latency draws a log-normally distributed duration around median,
+// stretched by the host's CPU usage: up to four times slower at 100%.
+func (g *traceGenerator) latency(median time.Duration, server fleet.ServerConfig) time.Duration {
+	load := g.cpu[server.ID] / 100
+	factor := (1 + 3*load*load*load) * math.Exp(0.5*g.rnd.NormFloat64())
+	return time.Duration(float64(median) * factor)
+}
+
+// failed reports whether a call served by server fails. Errors are rare on
+// healthy hosts and climb steeply above 90% CPU.
+func (g *traceGenerator) failed(server fleet.ServerConfig) bool {
+	p := 0.002 + 0.3*math.Max(0, (g.cpu[server.ID]-90)/10)
+	return g.rnd.Float64() < p
+}
+
+// networkHop is the median one-way latency between two servers.
+const networkHop = 500 * time.Microsecond
+
+// trace builds one request starting at start. The caller must hold g.mu.
+func (g *traceGenerator) trace(start time.Time) []span {
+	var traceID [16]byte
+	g.rnd.Read(traceID[:])
+	newSpan := func(parent *span, service string, server fleet.ServerConfig, name string, kind int) span {
+		s := span{TraceID: traceID, Service: service, Server: server, Name: name, Kind: kind}
+		g.rnd.Read(s.ID[:])
+		if parent != nil {
+			s.ParentID = parent.ID
+		}
+		return s
+	}
+	orderID := strconv.Itoa(100000 + g.rnd.Intn(900000))
+
+	// Build the app tier first; its duration and outcome shape the caller
+	var spans []span
+	app, hasApp := g.pick("app")
+	var appSpan span
+	if hasApp {
+		appSpan = newSpan(nil, traceServices["app"], app, "GET /orders/{id}", spanKindServer)
+		appSpan.Attrs = [][2]string{{"http.request.method", "GET"}, {"url.path", "/orders/" + orderID}}
+		cursor := start.Add(g.latency(networkHop, app))
+		appSpan.Start = cursor
+		cursor = cursor.Add(g.latency(2*time.Millisecond, app))
+
+		if cache, ok := g.pick("cache"); ok && g.rnd.Float64() < 0.5 {
+			call := newSpan(&appSpan, traceServices["app"], app, "GET", spanKindClient)
+			call.Attrs = [][2]string{{"db.system", "redis"}, {"server.address", cache.Hostname}}
+			call.Start = cursor
+			cursor = cursor.Add(g.latency(networkHop, cache) + g.latency(300*time.Microsecond, cache))
+			call.End = cursor
+			spans = append(spans, call)
+		}
+		if db, ok := g.pick("db"); ok {
+			call := newSpan(&appSpan, traceServices["app"], app, "SELECT shop.orders", spanKindClient)
+			call.Attrs = [][2]string{{"db.system", "postgresql"}, {"server.address", db.Hostname}, {"db.statement", "SELECT * FROM orders WHERE id = $1"}}
+			call.Start = cursor
+			cursor = cursor.Add(g.latency(networkHop, db) + g.latency(4*time.Millisecond, db))
+			call.End = cursor
+			call.Error = g.failed(db)
+			appSpan.Error = call.Error
+			spans = append(spans, call)
+		}
+		cursor = cursor.Add(g.latency(10*time.Millisecond, app))
+		appSpan.End = cursor
+		appSpan.Error = appSpan.Error || g.failed(app)
+		appSpan.Attrs = append(appSpan.Attrs, [2]string{"http.response.status_code", statusCode(appSpan.Error, 500)})
+	}
+
+	web, hasWeb := g.pick("web")
+	if !hasWeb {
+		// The app tier is the entry point
+		return shiftSpans(append(spans, appSpan), start.Sub(appSpan.Start))
+	}
+
+	root := newSpan(nil, traceServices["web"], web, "GET /api/v1/orders/{id}", spanKindServer)
+	root.Start = start
+	root.Attrs = [][2]string{{"http.request.method", "GET"}, {"url.path", "/api/v1/orders/" + orderID}}
+	cursor := start.Add(g.latency(time.Millisecond, web))
+	if hasApp {
+		call := newSpan(&root, traceServices["web"], web, "GET", spanKindClient)
+		call.Attrs = [][2]string{{"http.request.method", "GET"}, {"server.address", app.Hostname}}
+		call.Start = cursor
+		appSpan.ParentID = call.ID
+		// Move the app tier so it starts one hop after the client call
+		spans = shiftSpans(append(spans, appSpan), cursor.Sub(start))
+		cursor = spans[len(spans)-1].End.Add(g.latency(networkHop, web))
+		call.End = cursor
+		call.Error = appSpan.Error
+		root.Error = call.Error
+		spans = append(spans, call)
+	}
+	root.End = cursor.Add(g.latency(time.Millisecond, web))
+	root.Error = root.Error || g.failed(web)
+	root.Attrs = append(root.Attrs, [2]string{"http.response.status_code", statusCode(root.Error, 502)})
+	return append(spans, root)
+}
+
+// shiftSpans moves spans by d.
+func shiftSpans(spans []span, d time.Duration) []span {
+	for i := range spans {
+		spans[i].Start = spans[i].Start.Add(d)
+		spans[i].End = spans[i].End.Add(d)
+	}
+	return spans
+}
+
+func statusCode(failed bool, code int) string {
+	if failed {
+		return strconv.Itoa(code)
+	}
+	return "200"
+}
+
+// otlpExporter sends spans to an OpenTelemetry collector, or anything else
+// speaking OTLP over HTTP with JSON encoding such as Elastic APM Server.
+type otlpExporter struct {
+	client   *http.Client
+	endpoint string
+	headers  map[string]string
+	retry    sink.RetryPolicy
+}
+
+// newOTLPExporter parses OTLP_HEADERS, a comma-separated list of
+// "name=value" pairs sent with every request.
+func newOTLPExporter(endpoint, headerSpec string) (*otlpExporter, error) {
+	e := &otlpExporter{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		headers:  make(map[string]string),
+		retry:    sink.RetryPolicy{MaxRetries: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second},
+	}
+	for _, entry := range strings.Split(headerSpec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid OTLP header %q (want name=value)", entry)
+		}
+		e.headers[name] = value
+	}
+	return e, nil
+}
+
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// OTLP status codes.
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+// request groups spans by the service and host that produced them.
+func (e *otlpExporter) request(spans []span) otlpTraceRequest {
+	var req otlpTraceRequest
+	index := make(map[[2]string]int)
+	for _, s := range spans {
+		key := [2]string{s.Service, s.Server.ID}
+		i, ok := index[key]
+		if !ok {
+			i = len(req.ResourceSpans)
+			index[key] = i
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{Attributes: []otlpAttribute{
+					{"service.name", otlpAnyValue{s.Service}},
+					{"host.name", otlpAnyValue{s.Server.Hostname}},
+					{"host.id", otlpAnyValue{s.Server.ID}},
+				}},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "sample-metric-generator"}}},
+			})
+		}
+
+		out := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.ID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusUnset},
+		}
+		if s.ParentID != ([8]byte{}) {
+			out.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		for _, attr := range s.Attrs {
+			out.Attributes = append(out.Attributes, otlpAttribute{attr[0], otlpAnyValue{attr[1]}})
+		}
+		if s.Error {
+			out.Status.Code = otlpStatusError
+		}
+		scope := &req.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, out)
+	}
+	return req
+}
+
+// Export sends spans in one request, retrying transient failures.
+func (e *otlpExporter) Export(ctx context.Context, spans []span) {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		slog.Error("Error encoding spans", "spans", len(spans), "error", err)
+		telemetry.Stats.Failed.Add(int64(len(spans)))
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := e.post(ctx, body)
+		telemetry.Stats.BatchDuration.Observe(time.Since(start))
+		if err == nil {
+			telemetry.Stats.Indexed.Add(int64(len(spans)))
+			return
+		}
+		if !sink.IsRetryable(err) || attempt >= e.retry.MaxRetries {
+			slog.Error("Error exporting spans", "spans", len(spans), "attempt", attempt+1, "error", err)
+			telemetry.Stats.Failed.Add(int64(len(spans)))
+			return
+		}
+
+		telemetry.Stats.Retries.Add(int64(len(spans)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.retry.Backoff(attempt)):
+		}
+	}
+}
+
+func (e *otlpExporter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return &sink.SendError{Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		reason, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return &sink.SendError{Status: res.StatusCode, Reason: string(bytes.TrimSpace(reason))}
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
@@ -0,0 +1,206 @@
+package generate
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// truthPoint is what the generator emitted for a server at one tick,
+// alongside the baseline the random walk produced before anomalies.
+type truthPoint struct {
+	Timestamp      time.Time `json:"@timestamp"`
+	CPUUsage       float64   `json:"cpu_usage"`
+	MemoryUsage    float64   `json:"memory_usage"`
+	DiskUsage      float64   `json:"disk_usage"`
+	BaselineCPU    float64   `json:"baseline_cpu_usage"`
+	BaselineMemory float64   `json:"baseline_memory_usage"`
+	BaselineDisk   float64   `json:"baseline_disk_usage"`
+}
+
+// truthHistory keeps a server's recent truth points for TRUTH_RETENTION.
+type truthHistory struct {
+	mu     sync.Mutex
+	points []truthPoint
+}
+
+func (h *truthHistory) record(p truthPoint, retention time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff := p.Timestamp.Add(-retention)
+	i := 0
+	for i < len(h.points) && !h.points[i].Timestamp.After(cutoff) {
+		i++
+	}
+	h.points = append(h.points[i:], p)
+}
+
+// since returns the points newer than t.
+func (h *truthHistory) since(t time.Time) []truthPoint {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	points := []truthPoint{}
+	for _, p := range h.points {
+		if p.Timestamp.After(t) {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+type truthAnomaly struct {
+	Name  string    `json:"name"`
+	Kind  string    `json:"kind"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type truthReport struct {
+	ServerID  string         `json:"server_id"`
+	Hostname  string         `json:"hostname"`
+	Role      string         `json:"role"`
+	City      string         `json:"city"`
+	Now       time.Time      `json:"now"`
+	Anomalies []truthAnomaly `json:"active_anomalies"`
+	Points    []truthPoint   `json:"points"`
+}
+
+// serveTruth answers /truth?server=<id or hostname>&window=<duration> with
+// the values generated for a server and the anomalies active on it, so
+// presenters can check what dashboards should be showing.
+func (mg *MetricGenerator) serveTruth(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("server")
+	window := 15 * time.Minute
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, "invalid window: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	var server *fleet.ServerConfig
+	_, _, servers := mg.controlView()
+	for i := range servers {
+		if servers[i].ID == name || servers[i].Hostname == name {
+			server = &servers[i]
+			break
+		}
+	}
+	if server == nil {
+		http.Error(w, fmt.Sprintf("unknown server %q", name), http.StatusNotFound)
+		return
+	}
+
+	now := mg.Now().UTC()
+	report := truthReport{
+		ServerID:  server.ID,
+		Hostname:  server.Hostname,
+		Role:      server.Role,
+		City:      server.Location.City,
+		Now:       now,
+		Anomalies: []truthAnomaly{},
+		Points:    []truthPoint{},
+	}
+	for _, a := range mg.anomalies.Active(*server, now) {
+		report.Anomalies = append(report.Anomalies, truthAnomaly{Name: a.Name, Kind: a.Kind, Start: a.Start, End: a.End})
+	}
+
+	mg.mu.Lock()
+	sim := mg.sims[server.ID]
+	mg.mu.Unlock()
+	if sim != nil {
+		report.Points = sim.truth.since(now.Add(-window))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// TruthCommand implements "truth -server <id> [-window 15m]", which asks a
+// running generator for the ground truth of one server.
+func TruthCommand(config config.Config, args []string) error {
+	fs := flag.NewFlagSet("truth", flag.ContinueOnError)
+	server := fs.String("server", "", "server ID or hostname")
+	window := fs.Duration("window", 15*time.Minute, "how far back to show values")
+	addr := fs.String("addr", config.HTTPAddr, "HTTP address of the running generator")
+	asJSON := fs.Bool("json", false, "print the raw JSON report")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *server == "" {
+		return fmt.Errorf("usage: truth -server <id or hostname> [-window 15m] [-addr host:port] [-json]")
+	}
+	base, err := generatorURL(*addr)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"server": {*server}, "window": {window.String()}}
+	res, err := http.Get(base + "/truth?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body))
+	}
+
+	var report truthReport
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	fmt.Printf("%s (%s, %s, %s) at %s\n", report.ServerID, report.Hostname, report.Role, report.City, report.Now.Format(time.RFC3339))
+	if len(report.Anomalies) == 0 {
+		fmt.Println("No active anomalies")
+	}
+	for _, a := range report.Anomalies {
+		fmt.Printf("Active anomaly: %s (%s) from %s until %s\n", a.Name, a.Kind, a.Start.Format(time.RFC3339), a.End.Format(time.RFC3339))
+	}
+	fmt.Println()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "time\tcpu\tmemory\tdisk\tbaseline cpu\tbaseline memory\tbaseline disk")
+	for _, p := range report.Points {
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\n",
+			p.Timestamp.Format(time.RFC3339), p.CPUUsage, p.MemoryUsage, p.DiskUsage,
+			p.BaselineCPU, p.BaselineMemory, p.BaselineDisk)
+	}
+	return tw.Flush()
+}
+
+// generatorURL returns the base URL of the generator running at addr, as
+// given to -addr or HTTP_ADDR.
+func generatorURL(addr string) (string, error) {
+	if addr == "" {
+		return "", fmt.Errorf("no generator address; set HTTP_ADDR or pass -addr")
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/"), nil
+}
@@ -0,0 +1,96 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// rebootModel tracks how long servers have been up and reboots them now
+// and then. A rebooting server stops reporting for a while, comes back
+// with its uptime reset, and burns CPU for a few minutes as services
+// start and caches warm up.
+type rebootModel struct {
+	rate     float64 // Reboots per server per day
+	downtime time.Duration
+}
+
+// uptimeState is a server's boot time and, while it reboots, when it is
+// back.
+type uptimeState struct {
+	bootedAt  time.Time
+	downUntil time.Time
+}
+
+// bootSpikeDecay is how quickly the CPU spike after a boot fades; it is
+// down to a third after this long.
+const bootSpikeDecay = 3 * time.Minute
+
+func newRebootModel(enabled bool, rate float64, downtime time.Duration) (*rebootModel, error) {
+	if !enabled {
+		return nil, nil
+	}
+	if rate < 0 {
+		return nil, fmt.Errorf("invalid reboot rate %v (want reboots per server per day, at least 0)", rate)
+	}
+	if downtime <= 0 {
+		downtime = 2 * time.Minute
+	}
+	return &rebootModel{rate: rate, downtime: downtime}, nil
+}
+
+// down reports whether the server is rebooting at now, deciding on a new
+// reboot over the interval since its last report. The first call places
+// the last boot up to 30 days back.
+func (r *rebootModel) down(state *uptimeState, now time.Time, interval time.Duration, rnd *rand.Rand) bool {
+	if r == nil {
+		return false
+	}
+	if state.bootedAt.IsZero() {
+		state.bootedAt = now.Add(-time.Duration(rnd.Int63n(int64(30 * 24 * time.Hour))))
+	}
+	if !state.downUntil.IsZero() {
+		if now.Before(state.downUntil) {
+			return true
+		}
+		state.downUntil = time.Time{}
+		return false
+	}
+
+	if rnd.Float64() < 1-math.Exp(-r.rate*interval.Hours()/24) {
+		// Downtime varies by half either way; the agent starts up to a
+		// minute after the kernel boots
+		downtime := time.Duration(float64(r.downtime) * (0.5 + rnd.Float64()))
+		state.downUntil = now.Add(downtime)
+		state.bootedAt = state.downUntil.Add(-time.Duration(20+rnd.Intn(40)) * time.Second)
+		return true
+	}
+	return false
+}
+
+// apply sets the uptime of metric and adds the CPU spike of a recent
+// boot.
+func (r *rebootModel) apply(state *uptimeState, metric *MetricData) {
+	if r == nil {
+		return
+	}
+	uptime := metric.Timestamp.Sub(state.bootedAt)
+	metric.UptimeSeconds = int64(uptime.Seconds())
+	if spike := math.Exp(-uptime.Seconds() / bootSpikeDecay.Seconds()); spike > 0.01 {
+		metric.CPUUsage = roundFloat(metric.CPUUsage+max(95-metric.CPUUsage, 0)*spike, 2)
+	}
+}
+
+// rebooting reports whether server is down for a reboot.
+func (mg *MetricGenerator) rebooting(server fleet.ServerConfig) bool {
+	if mg.reboots == nil {
+		return false
+	}
+	mg.mu.Lock()
+	sim := mg.serverSim(server)
+	mg.mu.Unlock()
+	return mg.reboots.down(&sim.uptime, mg.Now().UTC(), mg.serverInterval(server), sim.uptimeRnd)
+}
@@ -0,0 +1,137 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// walkParams shape the random walk of the fast and standard quality
+// profiles, per metric in formulaMetrics order. Each tick a metric moves
+// by up to step in either direction, plus a wave of the given amplitude
+// whose argument advances by one every period: a sine for CPU, a cosine
+// for memory and a tangent for disk.
+type walkParams struct {
+	step      [3]float64
+	amplitude [3]float64
+	period    [3]time.Duration
+}
+
+// defaultWalkParams are the walk's original settings.
+var defaultWalkParams = walkParams{
+	step:      [3]float64{5, 4, 3},
+	amplitude: [3]float64{5, 3, 2},
+	period:    [3]time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute},
+}
+
+// walkConfig holds the walk parameters of the fleet and of the roles that
+// override them.
+type walkConfig struct {
+	base  walkParams
+	roles map[string]walkParams
+}
+
+// parseWalkParams parses WALK_PARAMS, a semicolon-separated list of
+// "[role.]metric.param=value" entries with params step, amplitude and
+// period, e.g. "disk.amplitude=0;db.cpu.step=2". Role entries apply on
+// top of the fleet-wide ones whatever their order.
+func parseWalkParams(spec string) (*walkConfig, error) {
+	w := &walkConfig{base: defaultWalkParams, roles: make(map[string]walkParams)}
+	if strings.TrimSpace(spec) == "" {
+		return w, nil
+	}
+
+	type setting struct {
+		metric, param, value string
+	}
+	var roleOrder []string
+	roleSettings := make(map[string][]setting)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		parts := strings.Split(strings.TrimSpace(key), ".")
+		if !ok || len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid walk parameter %q (want [role.]metric.param=value)", entry)
+		}
+		if len(parts) == 2 {
+			if err := w.base.set(parts[0], parts[1], strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid walk parameter %q: %w", entry, err)
+			}
+			continue
+		}
+		role := parts[0]
+		if _, seen := roleSettings[role]; !seen {
+			roleOrder = append(roleOrder, role)
+		}
+		roleSettings[role] = append(roleSettings[role], setting{parts[1], parts[2], strings.TrimSpace(value)})
+	}
+
+	for _, role := range roleOrder {
+		params := w.base
+		for _, s := range roleSettings[role] {
+			if err := params.set(s.metric, s.param, s.value); err != nil {
+				return nil, fmt.Errorf("invalid walk parameter for role %q: %w", role, err)
+			}
+		}
+		w.roles[role] = params
+	}
+	return w, nil
+}
+
+// set sets one parameter of metric from its textual value.
+func (p *walkParams) set(metric, param, value string) error {
+	i, ok := formulaMetrics[metric]
+	if !ok {
+		return fmt.Errorf("unknown metric %q (want cpu, memory or disk)", metric)
+	}
+	switch param {
+	case "step", "amplitude":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid %s %q", param, value)
+		}
+		if param == "step" {
+			p.step[i] = v
+		} else {
+			p.amplitude[i] = v
+		}
+	case "period":
+		d, err := time.ParseDuration(value)
+		if err != nil || d < time.Second || d%time.Second != 0 {
+			return fmt.Errorf("invalid period %q (want whole seconds)", value)
+		}
+		p.period[i] = d
+	default:
+		return fmt.Errorf("unknown parameter %q (want step, amplitude or period)", param)
+	}
+	return nil
+}
+
+// of returns the walk parameters of role.
+func (w *walkConfig) of(role string) walkParams {
+	if params, ok := w.roles[role]; ok {
+		return params
+	}
+	return w.base
+}
+
+// wave returns the wave term of metric at now.
+func (p walkParams) wave(metric int, now time.Time) float64 {
+	if p.amplitude[metric] == 0 {
+		return 0
+	}
+	x := float64(now.Unix() / int64(p.period[metric]/time.Second))
+	switch metric {
+	case 0:
+		return math.Sin(x) * p.amplitude[metric]
+	case 1:
+		return math.Cos(x) * p.amplitude[metric]
+	default:
+		return math.Tan(x) * p.amplitude[metric]
+	}
+}
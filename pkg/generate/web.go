@@ -0,0 +1,62 @@
+package generate
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+)
+
+// WebMetrics are the metrics of the web workload profile, inlined in the
+// metric document.
+type WebMetrics struct {
+	RequestsPerSecond float64 `json:"http_requests_per_second"`
+	Responses4xx      int64   `json:"http_responses_4xx"` // Over the interval
+	Responses5xx      int64   `json:"http_responses_5xx"` // Over the interval
+	ResponseTimeP50   float64 `json:"http_response_time_p50_ms"`
+	ResponseTimeP95   float64 `json:"http_response_time_p95_ms"`
+	ResponseTimeP99   float64 `json:"http_response_time_p99_ms"`
+}
+
+// webShape returns the requests per second a server serves at 50% CPU and
+// its median response time at idle, hashing the server ID so both are
+// stable across runs.
+func webShape(server fleet.ServerConfig) (rps, median float64) {
+	h := fnv.New32a()
+	h.Write([]byte(server.ID))
+	sum := h.Sum32()
+	return 200 + float64(sum%800), 20 + float64((sum>>10)%40)
+}
+
+// applyWeb sets the HTTP metrics of metric for the interval since the
+// server's last report. Traffic follows load, the CPU usage before
+// anomalies, which carries the daily load curve. Responses slow down and
+// fail more as the CPU usage climbs, as in SERVICES, and a fifth fail
+// during an incident.
+func applyWeb(server fleet.ServerConfig, metric *MetricData, load float64, incident bool, interval time.Duration, rnd *rand.Rand) {
+	base, median := webShape(server)
+	rps := base * load / 50 * (0.9 + rnd.Float64()*0.2)
+	requests := rps * interval.Seconds()
+
+	cpu := metric.CPUUsage / 100
+	ms := median * (1 + 3*cpu*cpu*cpu) * (0.9 + 0.2*rnd.Float64())
+	spread := latencySpread * (1 + cpu*cpu)
+
+	// Clients get about 3% of their requests wrong whatever the load
+	clientErrors := 0.03 * (0.7 + 0.6*rnd.Float64())
+	serverErrors := math.Min(1, (0.001+0.5*math.Max(0, (metric.CPUUsage-85)/15))*(0.5+rnd.Float64()))
+	if incident {
+		serverErrors = math.Max(serverErrors, 0.2*(0.8+0.4*rnd.Float64()))
+	}
+
+	metric.WebMetrics = &WebMetrics{
+		RequestsPerSecond: roundFloat(rps, 1),
+		Responses4xx:      int64(math.Round(requests * clientErrors)),
+		Responses5xx:      int64(math.Round(requests * serverErrors)),
+		ResponseTimeP50:   roundFloat(ms*math.Exp(spread*latencyQuantiles[0]), 2),
+		ResponseTimeP95:   roundFloat(ms*math.Exp(spread*latencyQuantiles[2]), 2),
+		ResponseTimeP99:   roundFloat(ms*math.Exp(spread*latencyQuantiles[3]), 2),
+	}
+}
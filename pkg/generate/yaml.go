@@ -0,0 +1,272 @@
+package generate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML parses the subset of YAML scenario files use: block mappings
+// and sequences, flow mappings and sequences of scalars, plain and quoted
+// scalars, and comments. Mappings decode to map[string]any, sequences to
+// []any and scalars to string.
+func parseYAML(data string) (any, error) {
+	p := &yamlParser{}
+	for n, raw := range strings.Split(data, "\n") {
+		text := strings.TrimRight(stripYAMLComment(raw), " \t\r")
+		if strings.TrimSpace(text) == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimLeft(text, " "), "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", n+1)
+		}
+		trimmed := strings.TrimLeft(text, " ")
+		p.lines = append(p.lines, yamlLine{num: n + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) block(indent int) (any, error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	var items []any
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || !isYAMLItem(line.text) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if rest == "" {
+			p.pos++
+			item, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		// Treat what follows the dash as a line of its own, so a mapping
+		// can start on the same line as its item.
+		p.lines[p.pos] = yamlLine{num: line.num, indent: indent + len(line.text) - len(rest), text: rest}
+		item, err := p.block(p.lines[p.pos].indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := make(map[string]any)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || isYAMLItem(line.text) && line.indent == indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+		key, value, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line.num)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.num, key)
+		}
+		p.pos++
+		if value == "" {
+			v, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			continue
+		}
+		v, err := parseYAMLValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.num, err)
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// nested parses the block under a key or dash at indent, if any. A
+// sequence may sit at the same indentation as its key.
+func (p *yamlParser) nested(indent int) (any, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > indent || next.indent == indent && isYAMLItem(next.text) {
+		return p.block(next.indent)
+	}
+	return nil, nil
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" outside quotes.
+func splitYAMLKey(text string) (key, value string, ok bool) {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case opensYAMLQuote(text, i):
+			quote = c
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			key, err := parseYAMLScalar(strings.TrimSpace(text[:i]))
+			if err != nil || key == "" {
+				return "", "", false
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// parseYAMLValue parses an inline value: a scalar or a flow collection.
+func parseYAMLValue(s string) (any, error) {
+	switch {
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("unterminated flow mapping %q", s)
+		}
+		m := make(map[string]any)
+		for _, entry := range splitYAMLFlow(s[1 : len(s)-1]) {
+			key, value, ok := splitYAMLKey(entry)
+			if !ok {
+				return nil, fmt.Errorf("expected \"key: value\" in %q", s)
+			}
+			v, err := parseYAMLScalar(value)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+		return m, nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated flow sequence %q", s)
+		}
+		var items []any
+		for _, entry := range splitYAMLFlow(s[1 : len(s)-1]) {
+			v, err := parseYAMLScalar(entry)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	}
+	return parseYAMLScalar(s)
+}
+
+// splitYAMLFlow splits the inside of a flow collection at commas outside
+// quotes, dropping empty entries.
+func splitYAMLFlow(s string) []string {
+	var entries []string
+	quote, start := byte(0), 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			c := s[i]
+			if quote != 0 {
+				if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if opensYAMLQuote(s, i) {
+				quote = c
+				continue
+			}
+			if c != ',' {
+				continue
+			}
+		}
+		if entry := strings.TrimSpace(s[start:i]); entry != "" {
+			entries = append(entries, entry)
+		}
+		start = i + 1
+	}
+	return entries
+}
+
+func parseYAMLScalar(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", s)
+		}
+		return v, nil
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'"):
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	return s, nil
+}
+
+// stripYAMLComment removes a "#" comment that starts the line or follows
+// whitespace, outside quotes.
+func stripYAMLComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case opensYAMLQuote(line, i):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// opensYAMLQuote reports whether s[i] starts a quoted scalar rather than
+// being an apostrophe inside a plain one.
+func opensYAMLQuote(s string, i int) bool {
+	if s[i] != '"' && s[i] != '\'' {
+		return false
+	}
+	return i == 0 || strings.IndexByte(" \t:[{,-", s[i-1]) >= 0
+}
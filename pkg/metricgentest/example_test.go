@@ -0,0 +1,25 @@
+package metricgentest_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/metricgentest"
+)
+
+func Example() {
+	cfg := config.Load()
+	cfg.ServerCount, cfg.Seed = 3, 42
+
+	g, err := metricgentest.New(cfg, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		panic(err)
+	}
+	docs, err := g.Advance(time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(len(docs.Metrics), docs.Metrics[0].Timestamp, g.Now())
+	// Output: 180 2026-01-01 00:00:00 +0000 UTC 2026-01-01 01:00:00 +0000 UTC
+}
@@ -0,0 +1,181 @@
+// Package metricgentest runs the metric generator in memory on simulated
+// time, so other projects can write table-driven tests against realistic
+// metric streams.
+//
+//	cfg := config.Load()
+//	cfg.ServerCount, cfg.Seed = 10, 42
+//	g, err := metricgentest.New(cfg, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+//	...
+//	docs, err := g.Advance(time.Hour)
+//	for _, m := range docs.Metrics {
+//		...
+//	}
+package metricgentest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/generate"
+	"github.com/nandasatria/sample-metric-generator/pkg/sink"
+)
+
+// Generator is a generator whose clock only moves when told to and whose
+// documents are captured instead of sent anywhere.
+type Generator struct {
+	mg      *generate.MetricGenerator
+	servers []fleet.ServerConfig
+
+	mu       sync.Mutex
+	now      time.Time
+	captured []sink.Document
+}
+
+// Documents is what the generator produced, decoded by document type.
+// Raw holds every document as it would have been delivered, in order.
+type Documents struct {
+	Metrics    []generate.MetricData
+	Heartbeats []generate.Heartbeat
+	Logs       []generate.LogEntry
+	Security   []generate.SecurityEvent
+	Docker     []generate.DockerDocument
+	GPUs       []generate.GPUDocument
+	Services   []generate.ServiceMetrics
+	Queues     []generate.QueueMetrics
+	Latencies  []generate.NetworkLatency
+	Pods       []generate.PodStatus
+	Containers []generate.ContainerStats
+	Events     []generate.ChangeEvent
+
+	Raw []sink.Document
+}
+
+// New builds the fleet and generator cfg describes, with the simulated
+// clock at start. Start from config.Load and set Seed for repeatable
+// documents.
+func New(cfg config.Config, start time.Time) (*Generator, error) {
+	servers, err := fleet.Build(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("building fleet: %w", err)
+	}
+	g := &Generator{servers: servers, now: start}
+	if g.mg, err = generate.NewMetricGenerator(cfg, servers, g); err != nil {
+		return nil, err
+	}
+	g.mg.UseClock(g.Now)
+	return g, nil
+}
+
+// Submit captures docs; it makes Generator the generator's Deliverer.
+func (g *Generator) Submit(_ context.Context, docs ...sink.Document) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.captured = append(g.captured, docs...)
+}
+
+// Now returns the simulated time.
+func (g *Generator) Now() time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.now
+}
+
+// Interval returns how far each tick moves the clock.
+func (g *Generator) Interval() time.Duration {
+	return g.mg.TickInterval()
+}
+
+// Servers returns the simulated fleet.
+func (g *Generator) Servers() []fleet.ServerConfig {
+	return g.servers
+}
+
+// Generator returns the generator itself, for the rare test that needs
+// more than documents.
+func (g *Generator) Generator() *generate.MetricGenerator {
+	return g.mg
+}
+
+// Tick generates one tick at the current simulated time, moves the clock
+// on by one interval and returns the documents of the tick.
+func (g *Generator) Tick() (Documents, error) {
+	g.step()
+	return g.take()
+}
+
+// Advance ticks until d has passed on the simulated clock and returns the
+// documents of all those ticks. A d shorter than the interval still ticks
+// once.
+func (g *Generator) Advance(d time.Duration) (Documents, error) {
+	until := g.Now().Add(d)
+	for g.step().Before(until) {
+	}
+	return g.take()
+}
+
+// step generates one tick and moves the clock on by one interval,
+// returning the new time.
+func (g *Generator) step() time.Time {
+	g.mg.Tick()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.now = g.now.Add(g.mg.TickInterval())
+	return g.now
+}
+
+// take decodes and forgets the documents captured so far.
+func (g *Generator) take() (Documents, error) {
+	g.mu.Lock()
+	captured := g.captured
+	g.captured = nil
+	g.mu.Unlock()
+
+	docs := Documents{Raw: captured}
+	for _, doc := range captured {
+		var err error
+		switch doc.Type {
+		case "metric":
+			docs.Metrics, err = appendDecoded(docs.Metrics, doc)
+		case "heartbeat":
+			docs.Heartbeats, err = appendDecoded(docs.Heartbeats, doc)
+		case "log":
+			docs.Logs, err = appendDecoded(docs.Logs, doc)
+		case "security":
+			docs.Security, err = appendDecoded(docs.Security, doc)
+		case "docker":
+			docs.Docker, err = appendDecoded(docs.Docker, doc)
+		case "gpu":
+			docs.GPUs, err = appendDecoded(docs.GPUs, doc)
+		case "service":
+			docs.Services, err = appendDecoded(docs.Services, doc)
+		case "queue":
+			docs.Queues, err = appendDecoded(docs.Queues, doc)
+		case "latency":
+			docs.Latencies, err = appendDecoded(docs.Latencies, doc)
+		case "pod":
+			docs.Pods, err = appendDecoded(docs.Pods, doc)
+		case "container":
+			docs.Containers, err = appendDecoded(docs.Containers, doc)
+		case "event":
+			docs.Events, err = appendDecoded(docs.Events, doc)
+		}
+		if err != nil {
+			return docs, fmt.Errorf("decoding %s document %q: %w", doc.Type, doc.ID, err)
+		}
+	}
+	return docs, nil
+}
+
+// appendDecoded decodes doc's body into a T and appends it to list.
+func appendDecoded[T any](list []T, doc sink.Document) ([]T, error) {
+	var v T
+	if err := json.Unmarshal(doc.Body, &v); err != nil {
+		return list, err
+	}
+	return append(list, v), nil
+}
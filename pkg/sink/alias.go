@@ -0,0 +1,113 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+)
+
+// AliasCommand implements "alias create" and "alias rollover". ES_INDEX is
+// used as the write alias name; backing indices are named <alias>-000001,
+// <alias>-000002 and so on.
+func AliasCommand(config config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: alias create|rollover")
+	}
+
+	client, err := newElasticsearchClient(config)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "create":
+		index, err := createWriteAlias(ctx, client, config.ESIndex)
+		if err != nil {
+			return err
+		}
+		slog.Info("Created index with write alias", "index", index, "alias", config.ESIndex)
+	case "rollover":
+		oldIndex, newIndex, err := rolloverAlias(ctx, client, config.ESIndex)
+		if err != nil {
+			return err
+		}
+		slog.Info("Rolled over alias", "alias", config.ESIndex, "old_index", oldIndex, "new_index", newIndex)
+	default:
+		return fmt.Errorf("unknown alias subcommand %q (want create or rollover)", args[0])
+	}
+	return nil
+}
+
+// createWriteAlias creates the first backing index with alias as its write alias.
+func createWriteAlias(ctx context.Context, client *elasticsearch.Client, alias string) (string, error) {
+	index := alias + "-000001"
+	body, err := json.Marshal(map[string]interface{}{
+		"aliases": map[string]interface{}{
+			alias: map[string]bool{"is_write_index": true},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req := esapi.IndicesCreateRequest{
+		Index: index,
+		Body:  bytes.NewReader(body),
+	}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return "", err
+	}
+	return index, checkResponse(res)
+}
+
+// rolloverAlias points the write alias at a new backing index unconditionally.
+func rolloverAlias(ctx context.Context, client *elasticsearch.Client, alias string) (string, string, error) {
+	req := esapi.IndicesRolloverRequest{Alias: alias}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", "", checkResponse(res)
+	}
+
+	var result struct {
+		OldIndex string `json:"old_index"`
+		NewIndex string `json:"new_index"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", "", err
+	}
+	return result.OldIndex, result.NewIndex, nil
+}
+
+// rolloverPeriodically rolls the alias every interval while the generator
+// keeps writing through it, demonstrating a zero-downtime index switch.
+func rolloverPeriodically(ctx context.Context, client *elasticsearch.Client, alias string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			oldIndex, newIndex, err := rolloverAlias(ctx, client, alias)
+			if err != nil {
+				slog.Error("Error rolling over alias", "alias", alias, "error", err)
+				continue
+			}
+			slog.Info("Rolled over alias", "alias", alias, "old_index", oldIndex, "new_index", newIndex)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package sink
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// deliveryOutcome tallies what became of the documents of one Send, for
+// wrappers that need to know whether it succeeded.
+type deliveryOutcome struct {
+	indexed atomic.Int64
+	failed  atomic.Int64
+}
+
+type outcomeKey struct{}
+
+// withOutcome returns a context that tallies the documents sinks record as
+// indexed or failed while using it.
+func withOutcome(ctx context.Context) (context.Context, *deliveryOutcome) {
+	o := &deliveryOutcome{}
+	return context.WithValue(ctx, outcomeKey{}, o), o
+}
+
+// recordIndexed counts n documents accepted by a sink.
+func recordIndexed(ctx context.Context, n int64) {
+	telemetry.Stats.Indexed.Add(n)
+	if o, ok := ctx.Value(outcomeKey{}).(*deliveryOutcome); ok {
+		o.indexed.Add(n)
+	}
+}
+
+// recordFailed counts n documents that failed permanently, ran out of
+// retries or were rejected by an open circuit breaker.
+func recordFailed(ctx context.Context, n int64) {
+	telemetry.Stats.Failed.Add(n)
+	if o, ok := ctx.Value(outcomeKey{}).(*deliveryOutcome); ok {
+		o.failed.Add(n)
+	}
+}
+
+// Circuit breaker states.
+const (
+	breakerClosed   = "closed"    // sending normally
+	breakerOpen     = "open"      // rejecting documents until the next probe
+	breakerHalfOpen = "half-open" // one batch is probing the sink
+)
+
+// breakerSink stops sending to a sink after threshold consecutive sends
+// failed outright, so a dead backend doesn't flood the log with errors.
+// While open it fails documents without trying, or spills them to disk,
+// and every probe interval lets one batch through: if that one gets in,
+// sending resumes and the spilled documents are replayed.
+type breakerSink struct {
+	Sink
+	name      string
+	threshold int
+	probe     time.Duration
+	spill     *spillQueue
+
+	mu       sync.Mutex
+	state    string
+	failures int // consecutive failed sends
+	next     time.Time
+	rejected int64 // documents failed unsent since the breaker opened
+}
+
+// withCircuitBreaker wraps sink in a circuit breaker tripping after
+// threshold consecutive failed sends, spilling documents to spill, if not
+// nil, while open. A threshold of zero or less returns sink unchanged.
+func withCircuitBreaker(sink Sink, name string, threshold int, probe time.Duration, spill *spillQueue) Sink {
+	if threshold <= 0 {
+		return sink
+	}
+	b := &breakerSink{Sink: sink, name: name, threshold: threshold, probe: probe, spill: spill, state: breakerClosed}
+	// Deliver what earlier runs spilled
+	if spill.pending() {
+		go b.replay()
+	}
+	return b
+}
+
+func (b *breakerSink) Send(ctx context.Context, docs []Document) {
+	if !b.allow(len(docs)) {
+		if b.spill != nil {
+			b.spill.write(ctx, docs)
+		} else {
+			recordFailed(ctx, int64(len(docs)))
+		}
+		return
+	}
+	b.done(!b.send(ctx, docs))
+}
+
+// send hands docs to the sink, reporting whether any got in or none
+// failed.
+func (b *breakerSink) send(ctx context.Context, docs []Document) bool {
+	sendCtx, outcome := withOutcome(ctx)
+	b.Sink.Send(sendCtx, docs)
+	return outcome.indexed.Load() > 0 || outcome.failed.Load() == 0
+}
+
+// replay sends the spilled documents while the sink takes them.
+func (b *breakerSink) replay() {
+	b.spill.replay(func(docs []Document) bool {
+		ok := b.send(context.Background(), docs)
+		b.done(!ok)
+		return ok
+	})
+}
+
+// allow reports whether a send of n documents may go ahead, turning the
+// open breaker half-open when a probe is due.
+func (b *breakerSink) allow(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if time.Now().Before(b.next) {
+			b.rejected += int64(n)
+			return false
+		}
+		b.state = breakerHalfOpen
+		slog.Info("Circuit breaker half-open, probing sink", "sink", b.name, "rejected", b.rejected)
+		return true
+	}
+	// A probe is already in flight
+	b.rejected += int64(n)
+	return false
+}
+
+// done records the outcome of a send that went ahead.
+func (b *breakerSink) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if b.state != breakerClosed {
+			slog.Info("Circuit breaker closed, sink recovered", "sink", b.name, "rejected", b.rejected)
+			if b.spill != nil {
+				go b.replay()
+			}
+		}
+		b.state, b.failures, b.rejected = breakerClosed, 0, 0
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.state == breakerHalfOpen:
+		b.state, b.next = breakerOpen, time.Now().Add(b.probe)
+		slog.Warn("Circuit breaker reopened, probe failed", "sink", b.name, "next_probe", b.probe)
+	case b.state == breakerClosed && b.failures >= b.threshold:
+		b.state, b.next = breakerOpen, time.Now().Add(b.probe)
+		slog.Warn("Circuit breaker open, pausing sends", "sink", b.name, "consecutive_failures", b.failures, "next_probe", b.probe)
+	}
+}
@@ -0,0 +1,214 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// clickHouseMetricTable is the schema of the table metric documents go to
+// by default. JSON fields without a column, such as location, are skipped.
+const clickHouseMetricTable = `CREATE TABLE IF NOT EXISTS %s (
+	` + "`@timestamp`" + ` DateTime64(3, 'UTC'),
+	server_id LowCardinality(String),
+	hostname LowCardinality(String),
+	ip_address String,
+	country LowCardinality(String),
+	city LowCardinality(String),
+	latitude Float64,
+	longitude Float64,
+	cpu_usage Float64,
+	memory_usage Float64,
+	disk_usage Float64,
+	agent_version LowCardinality(String),
+	schema_version UInt8,
+	fleet LowCardinality(String)
+) ENGINE = MergeTree
+ORDER BY (server_id, ` + "`@timestamp`" + `)`
+
+// clickHouseSink inserts documents into ClickHouse over its HTTP interface,
+// one JSONEachRow INSERT per table and batch, so batch sizes follow the
+// delivery classes. Each document type goes to its own table.
+type clickHouseSink struct {
+	client   *http.Client
+	url      string
+	database string
+	username string
+	password string
+	tables   map[string]string // document type to table
+	retry    RetryPolicy
+}
+
+func newClickHouseSink(config config.Config) (*clickHouseSink, error) {
+	s := &clickHouseSink{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		url:      strings.TrimSuffix(config.ClickHouseURL, "/"),
+		database: config.ClickHouseDatabase,
+		username: config.ClickHouseUsername,
+		password: config.ClickHousePassword,
+		tables:   map[string]string{"metric": "server_metrics"},
+		retry:    RetryPolicy{MaxRetries: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second},
+	}
+	if s.url == "" {
+		s.url = "http://localhost:8123"
+	}
+	if s.database == "" {
+		s.database = "default"
+	}
+	for _, entry := range strings.Split(config.ClickHouseTables, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		docType, table, ok := strings.Cut(entry, "=")
+		if !ok || docType == "" {
+			return nil, fmt.Errorf("invalid ClickHouse table %q (want type=table)", entry)
+		}
+		if table == "" {
+			delete(s.tables, docType)
+			continue
+		}
+		s.tables[docType] = table
+	}
+	if len(s.tables) == 0 {
+		return nil, fmt.Errorf("CLICKHOUSE_TABLES maps no document type to a table")
+	}
+	return s, nil
+}
+
+// types returns the document types the sink has a table for.
+func (s *clickHouseSink) types() []string {
+	types := make([]string, 0, len(s.tables))
+	for t := range s.tables {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// table returns the quoted, database-qualified name of a table.
+func (s *clickHouseSink) table(name string) string {
+	quote := func(id string) string { return "`" + strings.ReplaceAll(id, "`", "\\`") + "`" }
+	return quote(s.database) + "." + quote(name)
+}
+
+// createMetricTable creates the metric table, if it does not exist yet.
+// Tables of other document types are left to the user.
+func (s *clickHouseSink) createMetricTable(ctx context.Context) error {
+	table, ok := s.tables["metric"]
+	if !ok {
+		return nil
+	}
+	return s.query(ctx, fmt.Sprintf(clickHouseMetricTable, s.table(table)), nil)
+}
+
+// Send inserts docs, one INSERT per table, retrying transient failures.
+func (s *clickHouseSink) Send(ctx context.Context, docs []Document) {
+	byTable := make(map[string][]Document)
+	var order []string
+	for _, doc := range docs {
+		table := s.tables[doc.Type]
+		if _, ok := byTable[table]; !ok {
+			order = append(order, table)
+		}
+		byTable[table] = append(byTable[table], doc)
+	}
+	for _, table := range order {
+		s.insert(ctx, table, byTable[table])
+	}
+}
+
+func (s *clickHouseSink) insert(ctx context.Context, table string, docs []Document) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	for _, doc := range docs {
+		gz.Write(doc.Body)
+		gz.Write([]byte{'\n'})
+	}
+	if err := gz.Close(); err != nil {
+		slog.Error("Error compressing ClickHouse insert", "table", table, "error", err)
+		recordFailed(ctx, int64(len(docs)))
+		return
+	}
+	query := "INSERT INTO " + s.table(table) + " FORMAT JSONEachRow"
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := s.query(ctx, query, bytes.NewReader(body.Bytes()))
+		telemetry.Stats.BatchDuration.Observe(time.Since(start))
+		if err == nil {
+			recordIndexed(ctx, int64(len(docs)))
+			return
+		}
+		if !IsRetryable(err) || attempt >= s.retry.MaxRetries {
+			slog.Error("Error inserting into ClickHouse", "table", table, "documents", len(docs), "attempt", attempt+1, "error", err)
+			recordFailed(ctx, int64(len(docs)))
+			return
+		}
+
+		telemetry.Stats.Retries.Add(int64(len(docs)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retry.Backoff(attempt)):
+		}
+	}
+}
+
+// query runs a statement, with gzip-compressed data for inserts.
+func (s *clickHouseSink) query(ctx context.Context, query string, data io.Reader) error {
+	params := url.Values{}
+	params.Set("database", s.database)
+	params.Set("input_format_skip_unknown_fields", "1")
+	params.Set("date_time_input_format", "best_effort")
+
+	var body io.Reader = strings.NewReader(query)
+	if data != nil {
+		params.Set("query", query)
+		body = data
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/?"+params.Encode(), body)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	s.authorize(req)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return &SendError{Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		reason, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return &SendError{Status: res.StatusCode, Reason: string(bytes.TrimSpace(reason))}
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+func (s *clickHouseSink) authorize(req *http.Request) {
+	if s.username != "" {
+		req.Header.Set("X-ClickHouse-User", s.username)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+}
+
+// Ping checks that the server answers queries with the given credentials.
+func (s *clickHouseSink) Ping(ctx context.Context) error {
+	return s.query(ctx, "SELECT 1", nil)
+}
@@ -0,0 +1,299 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// cloudwatchMaxDatums is the most metric datums PutMetricData accepts in
+// one call. The default batch size stays within the older limit of 20 per
+// call that some emulators still enforce.
+const (
+	cloudwatchMaxDatums     = 1000
+	cloudwatchMaxDimensions = 30
+	cloudwatchDefaultBatch  = 20
+)
+
+// CloudWatch rejects a whole PutMetricData call over a single value whose
+// magnitude is outside about 8.5e-109 to 1.2e108, so smaller values are
+// flushed to zero and larger ones left out.
+var (
+	cloudwatchMinMagnitude = math.Ldexp(1, -360)
+	cloudwatchMaxMagnitude = math.Ldexp(1, 360)
+)
+
+// cloudwatchDimension maps a CloudWatch dimension name to the document
+// field its value is taken from.
+type cloudwatchDimension struct {
+	Name  string
+	Field string
+}
+
+type cloudwatchDatum struct {
+	Name       string
+	Dimensions [][2]string
+	Timestamp  time.Time
+	Value      float64
+}
+
+// cloudwatchSink publishes every numeric field of a document as a metric
+// with PutMetricData, signing requests with AWS Signature Version 4.
+type cloudwatchSink struct {
+	client     *http.Client
+	endpoint   string
+	signer     awsSigner
+	namespace  string
+	dimensions []cloudwatchDimension
+	batchSize  int
+	retry      RetryPolicy
+}
+
+// parseCloudWatchDimensions parses a comma-separated list of Name=field
+// pairs, e.g. "InstanceId=server_id,Region=country".
+func parseCloudWatchDimensions(spec string) ([]cloudwatchDimension, error) {
+	var dims []cloudwatchDimension
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, field, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || field == "" {
+			return nil, fmt.Errorf("invalid dimension %q (want Name=field)", entry)
+		}
+		dims = append(dims, cloudwatchDimension{Name: name, Field: field})
+	}
+	if len(dims) > cloudwatchMaxDimensions {
+		return nil, fmt.Errorf("%d dimensions configured, CloudWatch allows at most %d", len(dims), cloudwatchMaxDimensions)
+	}
+	return dims, nil
+}
+
+func newCloudWatchSink(config config.Config) (*cloudwatchSink, error) {
+	if config.CloudWatchRegion == "" {
+		return nil, fmt.Errorf("AWS_REGION is required")
+	}
+	if config.CloudWatchAccessKeyID == "" || config.CloudWatchSecretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+
+	spec := config.CloudWatchDimensions
+	if spec == "" {
+		spec = "InstanceId=server_id,Region=country"
+	}
+	dims, err := parseCloudWatchDimensions(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize := config.CloudWatchBatchSize
+	if batchSize == 0 {
+		batchSize = cloudwatchDefaultBatch
+	}
+	if batchSize < 1 || batchSize > cloudwatchMaxDatums {
+		return nil, fmt.Errorf("CLOUDWATCH_BATCH_SIZE must be between 1 and %d", cloudwatchMaxDatums)
+	}
+
+	// A custom endpoint points at a VPC endpoint or a local emulator
+	endpoint := strings.TrimSuffix(config.CloudWatchEndpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://monitoring." + config.CloudWatchRegion + ".amazonaws.com"
+	}
+
+	s := &cloudwatchSink{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		endpoint: endpoint,
+		signer: awsSigner{
+			service:      "monitoring",
+			region:       config.CloudWatchRegion,
+			accessKey:    config.CloudWatchAccessKeyID,
+			secretKey:    config.CloudWatchSecretKey,
+			sessionToken: config.CloudWatchSessionToken,
+		},
+		namespace:  config.CloudWatchNamespace,
+		dimensions: dims,
+		batchSize:  batchSize,
+		retry:      RetryPolicy{MaxRetries: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second},
+	}
+	if s.namespace == "" {
+		s.namespace = "SampleMetricGenerator"
+	}
+	return s, nil
+}
+
+// datums converts doc into one datum per numeric field, with the
+// configured dimensions. Dimensions whose field is missing are left out.
+func (s *cloudwatchSink) datums(doc Document) ([]cloudwatchDatum, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(doc.Body, &fields); err != nil {
+		return nil, err
+	}
+
+	var dims [][2]string
+	for _, d := range s.dimensions {
+		if v, ok := fields[d.Field]; ok && v != nil && v != "" {
+			dims = append(dims, [2]string{d.Name, fmt.Sprint(v)})
+		}
+	}
+
+	numeric := numericFieldsOf(fields)
+	out := make([]cloudwatchDatum, 0, len(numeric))
+	for _, f := range numeric {
+		value, ok := cloudwatchValue(f.Value)
+		if !ok {
+			slog.Debug("Leaving out value CloudWatch can't store", "metric", f.Name, "value", f.Value, "server_id", doc.ServerID)
+			continue
+		}
+		out = append(out, cloudwatchDatum{
+			Name:       f.Name,
+			Dimensions: dims,
+			Timestamp:  doc.Timestamp,
+			Value:      value,
+		})
+	}
+	return out, nil
+}
+
+// cloudwatchValue returns v as CloudWatch can store it, flushing tiny
+// magnitudes such as subnormals to zero, and false if v is too large.
+func cloudwatchValue(v float64) (float64, bool) {
+	switch m := math.Abs(v); {
+	case m > cloudwatchMaxMagnitude:
+		return 0, false
+	case m < cloudwatchMinMagnitude:
+		return 0, true
+	}
+	return v, true
+}
+
+// Send packs whole documents into calls of at most batchSize datums. A
+// document with more numeric fields than that is split across calls and
+// counted with the call carrying its last datum.
+func (s *cloudwatchSink) Send(ctx context.Context, docs []Document) {
+	var batch []cloudwatchDatum
+	complete := 0
+	for _, doc := range docs {
+		datums, err := s.datums(doc)
+		if err != nil {
+			slog.Error("Error encoding CloudWatch metrics", "type", doc.Type, "server_id", doc.ServerID, "error", err)
+			recordFailed(ctx, 1)
+			continue
+		}
+		if len(batch) > 0 && len(batch)+len(datums) > s.batchSize {
+			s.sendBatch(ctx, batch, complete)
+			batch, complete = nil, 0
+		}
+		for len(datums) > 0 {
+			if len(batch) == s.batchSize {
+				s.sendBatch(ctx, batch, complete)
+				batch, complete = nil, 0
+			}
+			n := min(len(datums), s.batchSize-len(batch))
+			batch = append(batch, datums[:n]...)
+			datums = datums[n:]
+		}
+		complete++
+	}
+	if len(batch) > 0 {
+		s.sendBatch(ctx, batch, complete)
+	}
+}
+
+// sendBatch publishes datums in one call, retrying transient failures.
+// docs is the number of documents the call completes.
+func (s *cloudwatchSink) sendBatch(ctx context.Context, datums []cloudwatchDatum, docs int) {
+	form := s.putMetricDataForm(datums)
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := s.call(ctx, form)
+		telemetry.Stats.BatchDuration.Observe(time.Since(start))
+		if err == nil {
+			recordIndexed(ctx, int64(docs))
+			return
+		}
+		if !IsRetryable(err) || attempt >= s.retry.MaxRetries {
+			slog.Error("Error publishing to CloudWatch", "datums", len(datums), "documents", docs, "attempt", attempt+1, "error", err)
+			recordFailed(ctx, int64(docs))
+			return
+		}
+
+		telemetry.Stats.Retries.Add(int64(docs))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retry.Backoff(attempt)):
+		}
+	}
+}
+
+func (s *cloudwatchSink) putMetricDataForm(datums []cloudwatchDatum) url.Values {
+	form := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {s.namespace},
+	}
+	for i, d := range datums {
+		member := "MetricData.member." + strconv.Itoa(i+1) + "."
+		form.Set(member+"MetricName", d.Name)
+		form.Set(member+"Timestamp", d.Timestamp.UTC().Format(time.RFC3339))
+		form.Set(member+"Value", strconv.FormatFloat(d.Value, 'g', -1, 64))
+		for j, dim := range d.Dimensions {
+			prefix := member + "Dimensions.member." + strconv.Itoa(j+1) + "."
+			form.Set(prefix+"Name", dim[0])
+			form.Set(prefix+"Value", dim[1])
+		}
+	}
+	return form
+}
+
+// call performs a signed Query API request.
+func (s *cloudwatchSink) call(ctx context.Context, form url.Values) error {
+	body := []byte(form.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	s.signer.sign(req, body, time.Now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return &SendError{Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		reason, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		status := res.StatusCode
+		// CloudWatch reports throttling as a 400 with a Throttling code
+		if status == http.StatusBadRequest && bytes.Contains(reason, []byte("<Code>Throttling</Code>")) {
+			status = http.StatusTooManyRequests
+		}
+		return &SendError{Status: status, Reason: string(bytes.TrimSpace(reason))}
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+// Ping checks the endpoint and credentials by listing the namespace's
+// metrics.
+func (s *cloudwatchSink) Ping(ctx context.Context) error {
+	return s.call(ctx, url.Values{
+		"Action":    {"ListMetrics"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {s.namespace},
+	})
+}
@@ -0,0 +1,210 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// datadogDocsPerRequest keeps series payloads well below the API's 500 KB
+// compressed limit.
+const datadogDocsPerRequest = 500
+
+// datadogGauge is the series type of gauges in the v2 metrics API.
+const datadogGauge = 3
+
+type datadogPayload struct {
+	Series []datadogSeries `json:"series"`
+}
+
+type datadogSeries struct {
+	Metric    string            `json:"metric"`
+	Type      int               `json:"type"`
+	Points    []datadogPoint    `json:"points"`
+	Tags      []string          `json:"tags,omitempty"`
+	Resources []datadogResource `json:"resources"`
+}
+
+type datadogPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type datadogResource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// datadogSink submits every numeric field of a document as a gauge to the
+// Datadog metrics API, attributed to the document's host.
+type datadogSink struct {
+	client *http.Client
+	apiURL string
+	apiKey string
+	prefix string
+	tags   []string
+	retry  RetryPolicy
+}
+
+func newDatadogSink(config config.Config) (*datadogSink, error) {
+	if config.DatadogAPIKey == "" {
+		return nil, fmt.Errorf("DATADOG_API_KEY is required")
+	}
+	site := config.DatadogSite
+	if site == "" {
+		site = "datadoghq.com"
+	}
+	// A full URL points at a proxy or a local test endpoint instead
+	apiURL := "https://api." + site
+	if strings.Contains(site, "://") {
+		apiURL = strings.TrimSuffix(site, "/")
+	}
+	s := &datadogSink{
+		client: &http.Client{Timeout: 30 * time.Second},
+		apiURL: apiURL,
+		apiKey: config.DatadogAPIKey,
+		prefix: config.DatadogPrefix,
+		retry:  RetryPolicy{MaxRetries: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second},
+	}
+	if s.prefix == "" {
+		s.prefix = "server"
+	}
+	for _, tag := range strings.Split(config.DatadogTags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			s.tags = append(s.tags, tag)
+		}
+	}
+	return s, nil
+}
+
+// series converts doc into one gauge series per numeric field.
+func (s *datadogSink) series(doc Document) ([]datadogSeries, error) {
+	fields, err := doc.numericFields()
+	if err != nil {
+		return nil, err
+	}
+	tags := append(append([]string{}, s.tags...), "server_id:"+doc.ServerID, "role:"+doc.Role)
+	resources := []datadogResource{{Name: doc.Hostname, Type: "host"}}
+
+	series := make([]datadogSeries, 0, len(fields))
+	for _, f := range fields {
+		series = append(series, datadogSeries{
+			Metric:    s.prefix + "." + f.Name,
+			Type:      datadogGauge,
+			Points:    []datadogPoint{{Timestamp: doc.Timestamp.Unix(), Value: f.Value}},
+			Tags:      tags,
+			Resources: resources,
+		})
+	}
+	return series, nil
+}
+
+func (s *datadogSink) Send(ctx context.Context, docs []Document) {
+	for len(docs) > 0 {
+		n := min(len(docs), datadogDocsPerRequest)
+		s.sendChunk(ctx, docs[:n])
+		docs = docs[n:]
+	}
+}
+
+// sendChunk submits docs in one request, retrying transient failures.
+func (s *datadogSink) sendChunk(ctx context.Context, docs []Document) {
+	var payload datadogPayload
+	sent := 0
+	for _, doc := range docs {
+		series, err := s.series(doc)
+		if err != nil {
+			slog.Error("Error encoding Datadog series", "type", doc.Type, "server_id", doc.ServerID, "error", err)
+			recordFailed(ctx, 1)
+			continue
+		}
+		payload.Series = append(payload.Series, series...)
+		sent++
+	}
+	if len(payload.Series) == 0 {
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := s.submit(ctx, payload)
+		telemetry.Stats.BatchDuration.Observe(time.Since(start))
+		if err == nil {
+			recordIndexed(ctx, int64(sent))
+			return
+		}
+		if !IsRetryable(err) || attempt >= s.retry.MaxRetries {
+			slog.Error("Error submitting to Datadog", "documents", sent, "attempt", attempt+1, "error", err)
+			recordFailed(ctx, int64(sent))
+			return
+		}
+
+		telemetry.Stats.Retries.Add(int64(sent))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retry.Backoff(attempt)):
+		}
+	}
+}
+
+func (s *datadogSink) submit(ctx context.Context, payload datadogPayload) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(payload); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.apiURL+"/api/v2/series", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("DD-API-KEY", s.apiKey)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return &SendError{Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		reason, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return &SendError{Status: res.StatusCode, Reason: string(bytes.TrimSpace(reason))}
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+// Ping validates the API key.
+func (s *datadogSink) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.apiURL+"/api/v1/validate", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("DD-API-KEY", s.apiKey)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// datasetSink writes documents to CSV or Parquet files for analysis
+// without a database, partitioned by type and UTC day:
+//
+//	<dir>/<type>/date=2024-05-02/part-20240502T130000Z.parquet
+//
+// Bodies are flattened into columns, with nested objects joined by dots
+// and arrays kept as JSON. Each file's columns are those of the first
+// rows written to it.
+type datasetSink struct {
+	format       string // csv or parquet
+	dir          string
+	gzip         bool
+	rowGroupSize int
+	run          string // Names the part files of this run
+
+	mu    sync.Mutex
+	parts map[datasetKey]*datasetPart
+	seq   map[datasetKey]int
+}
+
+type datasetKey struct {
+	docType string
+	day     string
+}
+
+// datasetPart is one open file.
+type datasetPart struct {
+	path    string
+	file    *os.File
+	buf     *bufio.Writer
+	gz      *gzip.Writer
+	columns []string
+	dropped bool // Whether a field without a column was reported
+
+	csv     *csv.Writer
+	parquet *parquetWriter
+	pending []map[string]any // Rows waiting for a full Parquet row group
+}
+
+func newDatasetSink(format string, config config.Config) (*datasetSink, error) {
+	dir := config.DatasetDir
+	if dir == "" {
+		dir = "dataset"
+	}
+	rowGroupSize := config.ParquetRowGroupSize
+	if rowGroupSize <= 0 {
+		rowGroupSize = 10000
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &datasetSink{
+		format:       format,
+		dir:          dir,
+		gzip:         config.DatasetGzip,
+		rowGroupSize: rowGroupSize,
+		run:          time.Now().UTC().Format("20060102T150405Z"),
+		parts:        make(map[datasetKey]*datasetPart),
+		seq:          make(map[datasetKey]int),
+	}, nil
+}
+
+// Send appends docs to the files of their type and day. Files of days
+// older than the batch's latest are closed; should a late document come
+// for one, it goes to a new part file.
+func (s *datasetSink) Send(ctx context.Context, docs []Document) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byPart := make(map[datasetKey][]map[string]any)
+	var keys []datasetKey
+	latest := ""
+	for _, doc := range docs {
+		row, err := flattenDocument(doc.Body)
+		if err != nil {
+			slog.Error("Error decoding document for dataset", "type", doc.Type, "error", err)
+			recordFailed(ctx, 1)
+			continue
+		}
+		key := datasetKey{docType: doc.Type, day: doc.Timestamp.UTC().Format("2006-01-02")}
+		if _, ok := byPart[key]; !ok {
+			keys = append(keys, key)
+		}
+		byPart[key] = append(byPart[key], row)
+		latest = max(latest, key.day)
+	}
+
+	for _, key := range keys {
+		rows := byPart[key]
+		start := time.Now()
+		err := s.write(ctx, key, rows)
+		telemetry.Stats.BatchDuration.Observe(time.Since(start))
+		if err != nil {
+			slog.Error("Error writing dataset", "type", key.docType, "day", key.day, "documents", len(rows), "error", err)
+			recordFailed(ctx, int64(len(rows)))
+			continue
+		}
+		if s.format == "csv" {
+			recordIndexed(ctx, int64(len(rows)))
+		}
+	}
+
+	for key, part := range s.parts {
+		if key.day < latest {
+			s.closePart(key, part)
+		}
+	}
+}
+
+func (s *datasetSink) write(ctx context.Context, key datasetKey, rows []map[string]any) error {
+	part, ok := s.parts[key]
+	if !ok {
+		var err error
+		if part, err = s.openPart(key, rows); err != nil {
+			return err
+		}
+		s.parts[key] = part
+	}
+	s.reportDropped(part, rows)
+
+	if s.format == "csv" {
+		record := make([]string, len(part.columns))
+		for _, row := range rows {
+			for i, col := range part.columns {
+				record[i] = csvCell(row[col])
+			}
+			if err := part.csv.Write(record); err != nil {
+				return err
+			}
+		}
+		part.csv.Flush()
+		if err := part.csv.Error(); err != nil {
+			return err
+		}
+		return part.flush()
+	}
+
+	part.pending = append(part.pending, rows...)
+	for len(part.pending) >= s.rowGroupSize {
+		group := part.pending[:s.rowGroupSize]
+		part.pending = part.pending[s.rowGroupSize:]
+		if err := s.writeRowGroup(ctx, part, group); err != nil {
+			slog.Error("Error writing Parquet row group", "file", part.path, "documents", len(group), "error", err)
+		}
+	}
+	return nil
+}
+
+// writeRowGroup writes rows buffered for part, counting them as indexed
+// or failed.
+func (s *datasetSink) writeRowGroup(ctx context.Context, part *datasetPart, rows []map[string]any) error {
+	if err := part.parquet.writeRowGroup(rows); err != nil {
+		recordFailed(ctx, int64(len(rows)))
+		return err
+	}
+	recordIndexed(ctx, int64(len(rows)))
+	return nil
+}
+
+// openPart creates the next part file for key, with the columns of rows.
+func (s *datasetSink) openPart(key datasetKey, rows []map[string]any) (*datasetPart, error) {
+	dir := filepath.Join(s.dir, key.docType, "date="+key.day)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := "part-" + s.run
+	if n := s.seq[key]; n > 0 {
+		name += fmt.Sprintf("-%d", n)
+	}
+	s.seq[key]++
+	name += "." + s.format
+	if s.gzip && s.format == "csv" {
+		name += ".gz"
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	part := &datasetPart{path: f.Name(), file: f, buf: bufio.NewWriterSize(f, 256*1024), columns: datasetColumns(rows)}
+	var w io.Writer = part.buf
+
+	if s.format == "csv" {
+		if s.gzip {
+			part.gz = gzip.NewWriter(part.buf)
+			w = part.gz
+		}
+		part.csv = csv.NewWriter(w)
+		if err := part.csv.Write(part.columns); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return part, nil
+	}
+
+	if part.parquet, err = newParquetWriter(w, parquetSchema(part.columns, rows), s.gzip); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return part, nil
+}
+
+// reportDropped warns once per file about fields that came after its
+// columns were fixed.
+func (s *datasetSink) reportDropped(part *datasetPart, rows []map[string]any) {
+	if part.dropped {
+		return
+	}
+	known := make(map[string]bool, len(part.columns))
+	for _, col := range part.columns {
+		known[col] = true
+	}
+	for _, row := range rows {
+		for field := range row {
+			if !known[field] {
+				slog.Warn("Dropping field without a column in the dataset file", "file", part.path, "field", field)
+				part.dropped = true
+				return
+			}
+		}
+	}
+}
+
+func (p *datasetPart) flush() error {
+	if p.gz != nil {
+		if err := p.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return p.buf.Flush()
+}
+
+// closePart writes what is pending and closes the file.
+func (s *datasetSink) closePart(key datasetKey, part *datasetPart) error {
+	delete(s.parts, key)
+	var err error
+	if part.parquet != nil {
+		if len(part.pending) > 0 {
+			err = s.writeRowGroup(context.Background(), part, part.pending)
+			part.pending = nil
+		}
+		if err == nil {
+			err = part.parquet.close()
+		}
+	}
+	if part.gz != nil && err == nil {
+		err = part.gz.Close()
+	}
+	if err == nil {
+		err = part.buf.Flush()
+	}
+	if cerr := part.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		slog.Error("Error closing dataset file", "file", part.path, "error", err)
+	}
+	return err
+}
+
+// Ping checks that the dataset directory is still there.
+func (s *datasetSink) Ping(ctx context.Context) error {
+	info, err := os.Stat(s.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", s.dir)
+	}
+	return nil
+}
+
+// Close finishes every open file; Parquet files are unreadable until
+// their footer is written here.
+func (s *datasetSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for key, part := range s.parts {
+		if err := s.closePart(key, part); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// datasetColumns returns the fields of rows, "@timestamp" first and the
+// rest sorted.
+func datasetColumns(rows []map[string]any) []string {
+	seen := make(map[string]bool)
+	var columns []string
+	for _, row := range rows {
+		for field := range row {
+			if !seen[field] {
+				seen[field] = true
+				columns = append(columns, field)
+			}
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		if columns[i] == "@timestamp" || columns[j] == "@timestamp" {
+			return columns[i] == "@timestamp"
+		}
+		return columns[i] < columns[j]
+	})
+	return columns
+}
+
+// flattenDocument decodes a JSON body into a flat row. Nested objects
+// become dotted fields and arrays stay JSON text.
+func flattenDocument(body []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	row := make(map[string]any, len(doc))
+	flattenInto(row, "", doc)
+	return row, nil
+}
+
+func flattenInto(row map[string]any, prefix string, obj map[string]any) {
+	for k, v := range obj {
+		switch v := v.(type) {
+		case map[string]any:
+			flattenInto(row, prefix+k+".", v)
+		case []any:
+			text, _ := json.Marshal(v)
+			row[prefix+k] = string(text)
+		default:
+			row[prefix+k] = v
+		}
+	}
+}
+
+func csvCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	}
+	return strings.TrimSpace(fmt.Sprint(v))
+}
@@ -0,0 +1,317 @@
+// Package sink batches generated documents and delivers them to
+// Elasticsearch and the other supported backends.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Document is an encoded document ready for delivery.
+type Document struct {
+	// Type selects the delivery class, e.g. "metric", "heartbeat" or "event".
+	Type      string
+	ServerID  string
+	Hostname  string
+	Role      string
+	Timestamp time.Time
+	Index     string
+	ID        string
+	Body      []byte
+}
+
+// nonMetricFields are numeric fields that describe a server rather than
+// measure it, so metrics-oriented sinks leave them out.
+var nonMetricFields = map[string]bool{
+	"latitude":       true,
+	"longitude":      true,
+	"schema_version": true,
+}
+
+// numericField is a numeric top-level field of a document.
+type numericField struct {
+	Name  string
+	Value float64
+}
+
+// numericFields decodes the document body and returns its numeric top-level
+// fields, sorted by name, for sinks that store plain time series.
+func (d Document) numericFields() ([]numericField, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(d.Body, &fields); err != nil {
+		return nil, err
+	}
+	return numericFieldsOf(fields), nil
+}
+
+// numericFieldsOf returns the numeric metric fields of a decoded document,
+// sorted by name.
+func numericFieldsOf(fields map[string]interface{}) []numericField {
+	out := make([]numericField, 0, len(fields))
+	for name, v := range fields {
+		if f, ok := v.(float64); ok && !nonMetricFields[name] {
+			out = append(out, numericField{Name: name, Value: f})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// expandFieldTemplate replaces the {field} placeholders of pattern with
+// the document's top-level fields, plus {type} and {role}, passing values
+// through clean. Missing fields expand to "unknown".
+func expandFieldTemplate(pattern string, doc Document, clean *strings.Replacer) (string, error) {
+	if !strings.Contains(pattern, "{") {
+		return pattern, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(doc.Body, &fields); err != nil {
+		return "", err
+	}
+	fields["type"] = doc.Type
+	fields["role"] = doc.Role
+
+	var b strings.Builder
+	rest := pattern
+	for {
+		start := strings.IndexByte(rest, '{')
+		end := -1
+		if start >= 0 {
+			end = strings.IndexByte(rest[start+1:], '}')
+		}
+		if end < 0 {
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		b.WriteString(rest[:start])
+		name := rest[start+1 : start+1+end]
+		if v, ok := fields[name]; ok && v != nil {
+			b.WriteString(clean.Replace(fmt.Sprint(v)))
+		} else {
+			b.WriteString("unknown")
+		}
+		rest = rest[start+end+2:]
+	}
+}
+
+// Sink receives delivered documents. Send handles retries and failure
+// reporting itself.
+type Sink interface {
+	Send(ctx context.Context, docs []Document)
+	Ping(ctx context.Context) error
+}
+
+// typeFilterSink passes only documents of the given types to the sink it
+// wraps, for sinks that store a single kind of data.
+type typeFilterSink struct {
+	Sink
+	types map[string]bool
+}
+
+func withDocumentTypes(sink Sink, types ...string) Sink {
+	f := &typeFilterSink{Sink: sink, types: make(map[string]bool, len(types))}
+	for _, t := range types {
+		f.types[t] = true
+	}
+	return f
+}
+
+func (f *typeFilterSink) Send(ctx context.Context, docs []Document) {
+	out := make([]Document, 0, len(docs))
+	for _, doc := range docs {
+		if f.types[doc.Type] {
+			out = append(out, doc)
+		}
+	}
+	if len(out) > 0 {
+		f.Sink.Send(ctx, out)
+	}
+}
+
+// DeliveryClass controls how documents of one type reach the sink.
+// Immediate documents are sent on their own as soon as they are
+// submitted; batched documents are buffered until Size documents are
+// pending or FlushInterval has passed.
+type DeliveryClass struct {
+	Batch         bool
+	Size          int
+	FlushInterval time.Duration
+}
+
+// defaultDeliveryClasses batches bulk metrics aggressively and delivers
+// low-volume, latency-sensitive types right away.
+var defaultDeliveryClasses = map[string]DeliveryClass{
+	"metric":    {Batch: true, Size: 1000, FlushInterval: 10 * time.Second},
+	"heartbeat": {},
+	"event":     {},
+	"log":       {Batch: true, Size: 1000, FlushInterval: 10 * time.Second},
+	"security":  {Batch: true, Size: 1000, FlushInterval: 10 * time.Second},
+	"service":   {Batch: true, Size: 1000, FlushInterval: 10 * time.Second},
+	"queue":     {Batch: true, Size: 1000, FlushInterval: 10 * time.Second},
+	"latency":   {Batch: true, Size: 1000, FlushInterval: 10 * time.Second},
+	"pod":       {Batch: true, Size: 1000, FlushInterval: 10 * time.Second},
+	"container": {Batch: true, Size: 1000, FlushInterval: 10 * time.Second},
+	"docker":    {Batch: true, Size: 1000, FlushInterval: 10 * time.Second},
+	"gpu":       {Batch: true, Size: 1000, FlushInterval: 10 * time.Second},
+}
+
+// ParseDeliveryClasses parses DELIVERY_CLASSES, a comma-separated list of
+// "type=immediate" or "type=batch[:size[:flush interval]]" entries that
+// override the defaults, e.g. "metric=batch:5000:30s,event=immediate".
+func ParseDeliveryClasses(spec string) (map[string]DeliveryClass, error) {
+	return overrideDeliveryClasses(defaultDeliveryClasses, spec)
+}
+
+// overrideDeliveryClasses returns a copy of base with the entries of spec,
+// in the format of DELIVERY_CLASSES, applied on top.
+func overrideDeliveryClasses(base map[string]DeliveryClass, spec string) (map[string]DeliveryClass, error) {
+	classes := make(map[string]DeliveryClass, len(base))
+	for name, class := range base {
+		classes[name] = class
+	}
+	if strings.TrimSpace(spec) == "" {
+		return classes, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid delivery class %q (want type=immediate or type=batch[:size[:interval]])", entry)
+		}
+
+		parts := strings.Split(value, ":")
+		switch parts[0] {
+		case "immediate":
+			classes[name] = DeliveryClass{}
+		case "batch":
+			class := DeliveryClass{Batch: true, Size: 1000, FlushInterval: 10 * time.Second}
+			if len(parts) > 1 {
+				size, err := strconv.Atoi(parts[1])
+				if err != nil || size < 1 {
+					return nil, fmt.Errorf("invalid batch size in delivery class %q", entry)
+				}
+				class.Size = size
+			}
+			if len(parts) > 2 {
+				interval, err := time.ParseDuration(parts[2])
+				if err != nil || interval <= 0 {
+					return nil, fmt.Errorf("invalid flush interval in delivery class %q", entry)
+				}
+				class.FlushInterval = interval
+			}
+			classes[name] = class
+		default:
+			return nil, fmt.Errorf("invalid delivery class %q (want immediate or batch)", entry)
+		}
+	}
+	return classes, nil
+}
+
+// Dispatcher routes submitted documents to the sink according to their
+// delivery class.
+type Dispatcher struct {
+	sink    Sink
+	classes map[string]DeliveryClass
+
+	mu      sync.Mutex
+	pending map[string][]Document
+}
+
+func NewDispatcher(sink Sink, classes map[string]DeliveryClass) *Dispatcher {
+	return &Dispatcher{
+		sink:    sink,
+		classes: classes,
+		pending: make(map[string][]Document),
+	}
+}
+
+// Run flushes batched classes on their intervals until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for name, class := range d.classes {
+		if !class.Batch {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, interval time.Duration) {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					d.flush(ctx, name)
+				}
+			}
+		}(name, class.FlushInterval)
+	}
+	wg.Wait()
+}
+
+// Submit delivers docs immediately or queues them for the next batch,
+// taking the dispatcher lock once for all of them. Full batches are sent
+// on the caller's goroutine, which slows producers down when the sink
+// can't keep up.
+func (d *Dispatcher) Submit(ctx context.Context, docs ...Document) {
+	var immediate []Document
+	var batches [][]Document
+
+	d.mu.Lock()
+	for _, doc := range docs {
+		class, ok := d.classes[doc.Type]
+		if !ok || !class.Batch {
+			immediate = append(immediate, doc)
+			continue
+		}
+		d.pending[doc.Type] = append(d.pending[doc.Type], doc)
+		if len(d.pending[doc.Type]) >= class.Size {
+			batches = append(batches, d.pending[doc.Type])
+			d.pending[doc.Type] = nil
+		}
+	}
+	d.mu.Unlock()
+
+	for _, doc := range immediate {
+		d.sink.Send(ctx, []Document{doc})
+	}
+	for _, batch := range batches {
+		d.sink.Send(ctx, batch)
+	}
+}
+
+// flush sends whatever is pending for one document type.
+func (d *Dispatcher) flush(ctx context.Context, docType string) {
+	d.mu.Lock()
+	batch := d.pending[docType]
+	d.pending[docType] = nil
+	d.mu.Unlock()
+
+	if len(batch) > 0 {
+		d.sink.Send(ctx, batch)
+	}
+}
+
+// flushAll sends everything pending for every document type.
+func (d *Dispatcher) flushAll(ctx context.Context) {
+	d.mu.Lock()
+	types := make([]string, 0, len(d.pending))
+	for docType := range d.pending {
+		types = append(types, docType)
+	}
+	d.mu.Unlock()
+
+	for _, docType := range types {
+		d.flush(ctx, docType)
+	}
+	if q, ok := d.sink.(*queuedSink); ok {
+		q.drain()
+	}
+}
@@ -0,0 +1,209 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// deadLetter is one line of the dead-letter file.
+type deadLetter struct {
+	FailedAt  time.Time       `json:"failed_at"`
+	Reason    string          `json:"reason"`
+	Status    int             `json:"status,omitempty"`
+	Type      string          `json:"type"`
+	ServerID  string          `json:"server_id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Index     string          `json:"index"`
+	ID        string          `json:"id,omitempty"`
+	Document  json.RawMessage `json:"document"`
+}
+
+// deadLetterQueue appends permanently failed documents to an NDJSON file
+// so they can be inspected and resubmitted with replay-dlq.
+type deadLetterQueue struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openDeadLetterQueue(path string) (*deadLetterQueue, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetterQueue{f: f}, nil
+}
+
+// Write records doc with the error that made it fail. A nil queue only
+// logs, so callers don't need to check whether the DLQ is enabled.
+func (q *deadLetterQueue) Write(doc Document, err error) {
+	if q == nil {
+		return
+	}
+
+	entry := deadLetter{
+		FailedAt:  time.Now().UTC(),
+		Reason:    err.Error(),
+		Type:      doc.Type,
+		ServerID:  doc.ServerID,
+		Timestamp: doc.Timestamp,
+		Index:     doc.Index,
+		ID:        doc.ID,
+		Document:  doc.Body,
+	}
+	var se *SendError
+	if errors.As(err, &se) {
+		entry.Status = se.Status
+	}
+
+	line, merr := json.Marshal(entry)
+	if merr != nil {
+		slog.Error("Error encoding dead letter", "server_id", doc.ServerID, "error", merr)
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, werr := q.f.Write(append(line, '\n')); werr != nil {
+		slog.Error("Error writing dead letter", "server_id", doc.ServerID, "error", werr)
+		return
+	}
+	telemetry.Stats.DeadLetters.Add(1)
+}
+
+func (q *deadLetterQueue) Close() error {
+	if q == nil {
+		return nil
+	}
+	return q.f.Close()
+}
+
+// ReplayDLQCommand resubmits the documents of a dead-letter file. The file
+// is moved aside first, so documents that fail again land in a fresh
+// DLQ_FILE instead of the one being read. A file left aside by a replay
+// that was interrupted is resumed instead, and if the replay fails, the
+// dead letters go back into the file so they are kept for the next one.
+func ReplayDLQCommand(config config.Config, args []string) (err error) {
+	path := config.DLQFile
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		return fmt.Errorf("usage: replay-dlq <file> (or set DLQ_FILE)")
+	}
+
+	client, err := newElasticsearchClient(config)
+	if err != nil {
+		return err
+	}
+
+	var dlq *deadLetterQueue
+	if config.DLQFile != "" {
+		if dlq, err = openDeadLetterQueue(config.DLQFile); err != nil {
+			return err
+		}
+		defer dlq.Close()
+	}
+
+	replaying := path + ".replaying"
+	if _, serr := os.Stat(replaying); serr == nil {
+		slog.Warn("Resuming an interrupted replay; run replay-dlq again afterwards for newer dead letters", "file", replaying)
+	} else if err := os.Rename(path, replaying); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rerr := restoreDeadLetters(replaying, path); rerr != nil {
+			err = fmt.Errorf("%w (dead letters kept in %s: %v)", err, replaying, rerr)
+		}
+	}()
+
+	sink := &ESSink{
+		Client:   client,
+		opType:   esOpType(config),
+		pipeline: config.ESPipeline,
+		retry:    esRetryPolicy(config),
+		dlq:      dlq,
+	}
+
+	f, err := os.Open(replaying)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	const batchSize = 500
+	ctx := context.Background()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	var batch []Document
+	var replayed int
+	for scanner.Scan() {
+		var entry deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("line %d: %w", replayed+1, err)
+		}
+		batch = append(batch, Document{
+			Type:      entry.Type,
+			ServerID:  entry.ServerID,
+			Timestamp: entry.Timestamp,
+			Index:     rawIndexName(entry.Index),
+			ID:        entry.ID,
+			Body:      entry.Document,
+		})
+		replayed++
+
+		if len(batch) == batchSize {
+			sink.Send(ctx, batch)
+			batch = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		sink.Send(ctx, batch)
+	}
+
+	slog.Info("Replayed dead letters", "count", replayed, "file", path)
+	return os.Remove(replaying)
+}
+
+// restoreDeadLetters puts the dead letters of a failed replay back into
+// path, after any written there since the replay started.
+func restoreDeadLetters(replaying, path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return os.Rename(replaying, path)
+	}
+
+	src, err := os.Open(replaying)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(replaying)
+}
@@ -0,0 +1,108 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// DryRunSink stands in for a real backend with -dry-run. It counts what
+// would have been sent and optionally prints each document.
+type DryRunSink struct {
+	out io.Writer
+
+	mu      sync.Mutex
+	totals  map[dryRunKey]*dryRunTotal
+	servers map[string]struct{}
+}
+
+type dryRunKey struct {
+	Type  string
+	Index string
+}
+
+type dryRunTotal struct {
+	Docs    int
+	Bytes   int
+	Batches int
+}
+
+func NewDryRunSink(print bool) *DryRunSink {
+	s := &DryRunSink{
+		totals:  make(map[dryRunKey]*dryRunTotal),
+		servers: make(map[string]struct{}),
+	}
+	if print {
+		s.out = os.Stdout
+	}
+	return s
+}
+
+func (s *DryRunSink) Send(ctx context.Context, docs []Document) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batches := make(map[dryRunKey]bool)
+	for _, doc := range docs {
+		key := dryRunKey{Type: doc.Type, Index: doc.Index}
+		total := s.totals[key]
+		if total == nil {
+			total = &dryRunTotal{}
+			s.totals[key] = total
+		}
+		total.Docs++
+		total.Bytes += len(doc.Body)
+		if !batches[key] {
+			batches[key] = true
+			total.Batches++
+		}
+		if doc.ServerID != "" {
+			s.servers[doc.ServerID] = struct{}{}
+		}
+
+		if s.out != nil {
+			fmt.Fprintf(s.out, "%s\n", doc.Body)
+		}
+	}
+}
+
+// Ping always succeeds; there is nothing to reach.
+func (s *DryRunSink) Ping(ctx context.Context) error {
+	return nil
+}
+
+// WriteSummary prints what would have been sent, per document type and
+// index.
+func (s *DryRunSink) WriteSummary(w io.Writer, serverCount int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]dryRunKey, 0, len(s.totals))
+	for key := range s.totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Type != keys[j].Type {
+			return keys[i].Type < keys[j].Type
+		}
+		return keys[i].Index < keys[j].Index
+	})
+
+	fmt.Fprintf(w, "Dry run: %d servers configured, %d of them sent documents\n\n", serverCount, len(s.servers))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "type\tindex\tdocs\tbatches\tbytes\tbytes/doc")
+	var docs, bytes int
+	for _, key := range keys {
+		total := s.totals[key]
+		docs += total.Docs
+		bytes += total.Bytes
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%.1f\n",
+			key.Type, key.Index, total.Docs, total.Batches, total.Bytes, float64(total.Bytes)/float64(total.Docs))
+	}
+	fmt.Fprintf(tw, "total\t\t%d\t\t%d\t\n", docs, bytes)
+	return tw.Flush()
+}
@@ -0,0 +1,215 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// edgeTestValues are the values EDGE_VALUE_RATES emits.
+var edgeTestValues = []float64{0, 100, 5e-324, 1e-310, 2.225073858507201e-308}
+
+// edgeDocument returns a metric document with cpu_usage set to v.
+func edgeDocument(t *testing.T, v float64) Document {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"@timestamp": "2026-01-01T00:00:00Z",
+		"server_id":  "server-001",
+		"cpu_usage":  v,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Document{
+		Type:      "metric",
+		ServerID:  "server-001",
+		Hostname:  "web-01",
+		Role:      "web",
+		Timestamp: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Body:      body,
+	}
+}
+
+// parseEdgeFloat parses a serialized value, failing the test if it isn't
+// a float.
+func parseEdgeFloat(t *testing.T, s string) float64 {
+	t.Helper()
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", s, err)
+	}
+	return f
+}
+
+func TestEdgeValuesRoundTripSinks(t *testing.T) {
+	serializers := []struct {
+		name string
+		// want is what the sink should store for v, if not v itself
+		want      func(v float64) float64
+		roundTrip func(t *testing.T, doc Document) float64
+	}{
+		{
+			name: "elasticsearch",
+			roundTrip: func(t *testing.T, doc Document) float64 {
+				// Bulk bodies carry the document as generated
+				var fields map[string]any
+				if err := json.Unmarshal(doc.Body, &fields); err != nil {
+					t.Fatal(err)
+				}
+				return fields["cpu_usage"].(float64)
+			},
+		},
+		{
+			name: "graphite",
+			roundTrip: func(t *testing.T, doc Document) float64 {
+				out, err := (&graphiteSink{prefix: "servers.{server_id}"}).lines(nil, doc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fields := strings.Fields(string(out))
+				if fields[0] != "servers.server-001.cpu_usage" {
+					t.Fatalf("unexpected graphite path %q", fields[0])
+				}
+				return parseEdgeFloat(t, fields[1])
+			},
+		},
+		{
+			name: "statsd",
+			roundTrip: func(t *testing.T, doc Document) float64 {
+				out, err := (&statsdSink{prefix: "servers", tagFormat: "dogstatsd"}).lines(nil, doc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, rest, _ := strings.Cut(string(out), ":")
+				value, _, _ := strings.Cut(rest, "|")
+				return parseEdgeFloat(t, value)
+			},
+		},
+		{
+			name: "csv",
+			roundTrip: func(t *testing.T, doc Document) float64 {
+				row, err := flattenDocument(doc.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				w := csv.NewWriter(&buf)
+				w.Write([]string{csvCell(row["cpu_usage"])})
+				w.Flush()
+				record, err := csv.NewReader(&buf).Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return parseEdgeFloat(t, record[0])
+			},
+		},
+		{
+			name: "parquet",
+			roundTrip: func(t *testing.T, doc Document) float64 {
+				row, err := flattenDocument(doc.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var buf bytes.Buffer
+				columns := parquetSchema([]string{"cpu_usage"}, []map[string]any{row})
+				p, err := newParquetWriter(&buf, columns, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := p.writeRowGroup([]map[string]any{row}); err != nil {
+					t.Fatal(err)
+				}
+				// The one PLAIN value ends the page, before the footer
+				out := buf.Bytes()
+				return math.Float64frombits(binary.LittleEndian.Uint64(out[len(out)-8:]))
+			},
+		},
+		{
+			name: "cloudwatch",
+			// CloudWatch rejects calls over subnormals
+			want: func(v float64) float64 {
+				if v != 0 && math.Abs(v) < cloudwatchMinMagnitude {
+					return 0
+				}
+				return v
+			},
+			roundTrip: func(t *testing.T, doc Document) float64 {
+				s := &cloudwatchSink{namespace: "Test"}
+				datums, err := s.datums(doc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				form, err := url.ParseQuery(s.putMetricDataForm(datums).Encode())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if name := form.Get("MetricData.member.1.MetricName"); name != "cpu_usage" {
+					t.Fatalf("unexpected metric name %q", name)
+				}
+				return parseEdgeFloat(t, form.Get("MetricData.member.1.Value"))
+			},
+		},
+		{
+			name: "clickhouse",
+			roundTrip: func(t *testing.T, doc Document) float64 {
+				// JSONEachRow rows are the documents; the column must be
+				// wide enough to hold them
+				if !strings.Contains(clickHouseMetricTable, "cpu_usage Float64") {
+					t.Fatal("cpu_usage is not a Float64 column")
+				}
+				var row map[string]any
+				dec := json.NewDecoder(bytes.NewReader(doc.Body))
+				dec.UseNumber()
+				if err := dec.Decode(&row); err != nil {
+					t.Fatal(err)
+				}
+				return parseEdgeFloat(t, row["cpu_usage"].(json.Number).String())
+			},
+		},
+	}
+
+	for _, s := range serializers {
+		t.Run(s.name, func(t *testing.T) {
+			for _, v := range edgeTestValues {
+				want := v
+				if s.want != nil {
+					want = s.want(v)
+				}
+				if got := s.roundTrip(t, edgeDocument(t, v)); math.Float64bits(got) != math.Float64bits(want) {
+					t.Errorf("%g came back as %g, want %g", v, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCloudWatchValue(t *testing.T) {
+	tests := []struct {
+		in     float64
+		want   float64
+		wantOK bool
+	}{
+		{0, 0, true},
+		{100, 100, true},
+		{-42.5, -42.5, true},
+		{1e-100, 1e-100, true},
+		{5e-324, 0, true},
+		{-1e-310, 0, true},
+		{1e108, 1e108, true},
+		{1e200, 0, false},
+		{-1e200, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := cloudwatchValue(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("cloudwatchValue(%g) = %g, %v, want %g, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
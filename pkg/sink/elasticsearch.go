@@ -0,0 +1,421 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+func newElasticsearchClient(config config.Config) (*elasticsearch.Client, error) {
+	client, _, err := newFlavoredClient(config)
+	return client, err
+}
+
+// newFlavoredClient creates the client along with the transport that
+// adapts it to ES_FLAVOR, which knows the cluster's flavor once the client
+// has made a request. The transport is nil for Elasticsearch 8 and later.
+func newFlavoredClient(config config.Config) (*elasticsearch.Client, *flavorTransport, error) {
+	flavor, err := parseESFlavor(config.ESFlavor)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := elasticsearch.Config{
+		Username: config.ESUsername,
+		Password: config.ESPassword,
+		// An API key takes precedence over username/password
+		APIKey: config.ESAPIKey,
+		// Indexing retries are handled by the generator, which knows
+		// which failures are worth repeating.
+		DisableRetry: true,
+
+		CompressRequestBody:      config.ESCompress,
+		CompressRequestBodyLevel: config.ESCompressLevel,
+		PoolCompressor:           true,
+	}
+	// The client rejects a Cloud ID combined with explicit addresses
+	if config.ESCloudID != "" {
+		cfg.CloudID = config.ESCloudID
+	} else {
+		cfg.Addresses = []string{config.ESServer}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	cfg.Transport = transport
+	var flavored *flavorTransport
+	if flavor != flavorElasticsearch {
+		flavored = newFlavorTransport(transport, flavor)
+		cfg.Transport = flavored
+	}
+
+	client, err := elasticsearch.NewClient(cfg)
+	return client, flavored, err
+}
+
+// newTLSConfig builds the TLS settings for HTTPS clusters from ES_CA_CERT,
+// ES_CERT_FINGERPRINT, ES_CLIENT_CERT/ES_CLIENT_KEY and
+// ES_INSECURE_SKIP_VERIFY. It returns nil when none are set, leaving the
+// system defaults in place.
+func newTLSConfig(config config.Config) (*tls.Config, error) {
+	if config.ESCACert == "" && config.ESCertFingerprint == "" && config.ESClientCert == "" && !config.ESInsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.ESInsecureSkipVerify,
+	}
+
+	if config.ESCACert != "" {
+		pem, err := os.ReadFile(config.ESCACert)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.ESCACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	// Trust a chain holding the certificate with the fingerprint instead of
+	// verifying it against a CA, as the client itself does. Doing it here
+	// keeps it working when the transport is wrapped for ES_FLAVOR.
+	if config.ESCertFingerprint != "" {
+		fingerprint, err := hex.DecodeString(strings.ReplaceAll(config.ESCertFingerprint, ":", ""))
+		if err != nil || len(fingerprint) != sha256.Size {
+			return nil, fmt.Errorf("invalid certificate fingerprint %q (want SHA-256 hex)", config.ESCertFingerprint)
+		}
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, cert := range state.PeerCertificates {
+				if digest := sha256.Sum256(cert.Raw); bytes.Equal(digest[:], fingerprint) {
+					return nil
+				}
+			}
+			return fmt.Errorf("no server certificate matches fingerprint %s", config.ESCertFingerprint)
+		}
+	}
+
+	if config.ESClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.ESClientCert, config.ESClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// setupElasticsearch creates the client, installs the ILM policy and index
+// template the configuration asks for, starts rolling the write alias over
+// and returns the sink documents are indexed through.
+func setupElasticsearch(ctx context.Context, config config.Config) (*ESSink, error) {
+	client, flavored, err := newFlavoredClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %w", err)
+	}
+
+	// Create the pipeline documents are indexed through
+	if config.ESPipeline != "" && config.ESPipelineCreate {
+		if err := putSamplePipeline(ctx, client, config.ESPipeline); err != nil {
+			return nil, fmt.Errorf("creating ingest pipeline: %w", err)
+		}
+		slog.Info("Ingest pipeline installed", "pipeline", config.ESPipeline)
+	}
+
+	// Create the lifecycle policy before the template that references it
+	if config.ESILMPolicy != "" {
+		// OpenSearch manages lifecycles with ISM instead
+		if flavored != nil {
+			if _, err := client.Info(client.Info.WithContext(ctx)); err != nil {
+				return nil, fmt.Errorf("detecting cluster: %w", err)
+			}
+			if flavored.Flavor() == flavorOpenSearch {
+				return nil, fmt.Errorf("ES_ILM_POLICY needs Elasticsearch's ILM, which OpenSearch lacks")
+			}
+		}
+		opts := ilmPolicyOptions{
+			RolloverMaxAge: config.ESILMRolloverMaxAge,
+			WarmAfter:      config.ESILMWarmAfter,
+			DeleteAfter:    config.ESILMDeleteAfter,
+		}
+		if err := putILMPolicy(ctx, client, config.ESILMPolicy, opts); err != nil {
+			return nil, fmt.Errorf("creating ILM policy: %w", err)
+		}
+		slog.Info("ILM policy installed", "policy", config.ESILMPolicy)
+	}
+
+	// Install the index template before the first document creates the index.
+	// Data streams cannot be created without a matching template, and the
+	// template is what attaches the ILM policy.
+	if config.ESBootstrapTemplate || config.ESDataStream || config.ESILMPolicy != "" {
+		opts := indexTemplateOptions{
+			DataStream: config.ESDataStream,
+			ILMPolicy:  config.ESILMPolicy,
+		}
+		if config.ESILMPolicy != "" && config.ESILMRolloverMaxAge != "" && !config.ESDataStream {
+			opts.RolloverAlias = config.ESIndex
+		}
+		if err := putIndexTemplate(ctx, client, config.ESIndex, opts); err != nil {
+			return nil, fmt.Errorf("creating index template: %w", err)
+		}
+		slog.Info("Index template installed", "index", indexBase(config.ESIndex)+"*")
+	}
+
+	// Roll the write alias over in the background while generating
+	if config.ESRolloverInterval > 0 {
+		go rolloverPeriodically(ctx, client, config.ESIndex, config.ESRolloverInterval)
+	}
+
+	// Keep documents that can't be indexed instead of dropping them
+	var dlq *deadLetterQueue
+	if config.DLQFile != "" {
+		if dlq, err = openDeadLetterQueue(config.DLQFile); err != nil {
+			return nil, fmt.Errorf("opening dead-letter file: %w", err)
+		}
+	}
+
+	return &ESSink{
+		Client:   client,
+		opType:   esOpType(config),
+		pipeline: config.ESPipeline,
+		retry:    esRetryPolicy(config),
+		dlq:      dlq,
+	}, nil
+}
+
+// ESSink indexes documents into Elasticsearch, one at a time or through
+// the bulk API, retrying transient failures.
+type ESSink struct {
+	Client   *elasticsearch.Client
+	opType   string
+	pipeline string // Ingest pipeline documents go through, if any
+	retry    RetryPolicy
+	dlq      *deadLetterQueue
+}
+
+// esOpType returns the op type documents are indexed with. Data streams
+// are append-only and only accept "create".
+func esOpType(config config.Config) string {
+	if config.ESDataStream {
+		return "create"
+	}
+	return ""
+}
+
+func esRetryPolicy(config config.Config) RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:     config.ESMaxRetries,
+		InitialBackoff: config.ESRetryInitialBackoff,
+		MaxBackoff:     config.ESRetryMaxBackoff,
+	}
+}
+
+// Send delivers docs, retrying transient failures according to the retry
+// policy. Documents that fail permanently or run out of retries are logged
+// and written to the dead-letter queue.
+func (s *ESSink) Send(ctx context.Context, docs []Document) {
+	for attempt := 0; len(docs) > 0; attempt++ {
+		var failed []Document
+		var permanent int
+		var err error
+
+		start := time.Now()
+		if len(docs) == 1 {
+			if err = s.index(ctx, docs[0]); err != nil {
+				failed, permanent = s.triage(ctx, docs, err)
+			}
+		} else {
+			failed, permanent, err = s.bulk(ctx, docs)
+		}
+		telemetry.Stats.BatchDuration.Observe(time.Since(start))
+		recordIndexed(ctx, int64(len(docs)-len(failed)-permanent))
+
+		if len(failed) == 0 {
+			return
+		}
+		if attempt >= s.retry.MaxRetries {
+			for _, doc := range failed {
+				slog.Error("Error indexing document, giving up", "type", doc.Type, "server_id", doc.ServerID, "index", doc.Index, "attempt", attempt+1, "error", err)
+				s.dlq.Write(doc, err)
+			}
+			recordFailed(ctx, int64(len(failed)))
+			return
+		}
+
+		docs = failed
+		telemetry.Stats.Retries.Add(int64(len(docs)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retry.Backoff(attempt)):
+		}
+	}
+}
+
+// Close closes the dead-letter file.
+func (s *ESSink) Close() error {
+	return s.dlq.Close()
+}
+
+// Ping checks that the cluster answers.
+func (s *ESSink) Ping(ctx context.Context) error {
+	res, err := s.Client.Ping(s.Client.Ping.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("%s", res.Status())
+	}
+	return nil
+}
+
+// triage dead-letters docs as permanently failed unless err is retryable,
+// in which case they are returned for another attempt. It also returns
+// the number of permanently failed documents.
+func (s *ESSink) triage(ctx context.Context, docs []Document, err error) ([]Document, int) {
+	if IsRetryable(err) {
+		return docs, 0
+	}
+	for _, doc := range docs {
+		slog.Error("Error indexing document, not retryable", "type", doc.Type, "server_id", doc.ServerID, "index", doc.Index, "error", err)
+		s.dlq.Write(doc, err)
+	}
+	recordFailed(ctx, int64(len(docs)))
+	return nil, len(docs)
+}
+
+// index performs a single index request.
+func (s *ESSink) index(ctx context.Context, doc Document) error {
+	req := esapi.IndexRequest{
+		// Date-math names need escaping in the path, not in bulk bodies
+		Index:      url.PathEscape(doc.Index),
+		DocumentID: doc.ID,
+		OpType:     s.opType,
+		Pipeline:   s.pipeline,
+		Body:       bytes.NewReader(doc.Body),
+	}
+
+	res, err := req.Do(ctx, s.Client)
+	if err != nil {
+		return &SendError{Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		reason, _ := io.ReadAll(res.Body)
+		return &SendError{Status: res.StatusCode, Reason: string(bytes.TrimSpace(reason))}
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+type bulkItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// bulk sends docs in a single bulk request and returns the documents
+// that failed with a retryable status, the number of documents that failed
+// permanently and the last error.
+func (s *ESSink) bulk(ctx context.Context, docs []Document) ([]Document, int, error) {
+	action := "index"
+	if s.opType != "" {
+		action = s.opType
+	}
+
+	var body bytes.Buffer
+	for _, doc := range docs {
+		target := map[string]string{"_index": doc.Index}
+		// Documents without an ID get one from Elasticsearch
+		if doc.ID != "" {
+			target["_id"] = doc.ID
+		}
+		meta, err := json.Marshal(map[string]map[string]string{action: target})
+		if err != nil {
+			return nil, 0, err
+		}
+		body.Write(meta)
+		body.WriteByte('\n')
+		body.Write(doc.Body)
+		body.WriteByte('\n')
+	}
+
+	req := esapi.BulkRequest{Body: &body, Pipeline: s.pipeline}
+	res, err := req.Do(ctx, s.Client)
+	if err != nil {
+		err = &SendError{Err: err}
+		failed, permanent := s.triage(ctx, docs, err)
+		return failed, permanent, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		reason, _ := io.ReadAll(res.Body)
+		err = &SendError{Status: res.StatusCode, Reason: string(bytes.TrimSpace(reason))}
+		failed, permanent := s.triage(ctx, docs, err)
+		return failed, permanent, err
+	}
+
+	var result bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		// The request went through but its outcome is unknown; retrying
+		// could duplicate documents indexed with generated IDs.
+		slog.Error("Error decoding bulk response", "documents", len(docs), "error", err)
+		return nil, 0, nil
+	}
+	if !result.Errors {
+		return nil, 0, nil
+	}
+
+	var failed []Document
+	var permanent int
+	var lastErr error
+	for i, item := range result.Items {
+		if i >= len(docs) {
+			break
+		}
+		for _, r := range item {
+			if r.Status < 300 {
+				continue
+			}
+			err := &SendError{Status: r.Status, Reason: string(r.Error)}
+			retry, n := s.triage(ctx, docs[i:i+1], err)
+			failed = append(failed, retry...)
+			permanent += n
+			lastErr = err
+		}
+	}
+	return failed, permanent, lastErr
+}
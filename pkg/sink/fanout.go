@@ -0,0 +1,316 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+)
+
+// ParseSinkList parses SINK, a comma-separated list of sink names. An
+// empty spec selects Elasticsearch.
+func ParseSinkList(spec string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, n := range config.SinkNames {
+			known = known || n == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown sink %q (want %s or %s)", name,
+				strings.Join(config.SinkNames[:len(config.SinkNames)-1], ", "), config.SinkNames[len(config.SinkNames)-1])
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("sink %q listed twice", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		names = append(names, "elasticsearch")
+	}
+	return names, nil
+}
+
+// sinkRoute is one sink of a fan-out with its own dispatcher, so each sink
+// batches on its own delivery classes and keeps its own pending documents.
+type sinkRoute struct {
+	name     string
+	delivery *Dispatcher
+	closer   io.Closer
+}
+
+// FanOut delivers every submitted document to each configured sink.
+// Sinks handle their own retries and failures, so one sink being down
+// costs the others nothing but time: submissions to all sinks run
+// concurrently, and generation waits for the slowest.
+type FanOut struct {
+	routes []sinkRoute
+}
+
+func (f *FanOut) Add(name string, delivery *Dispatcher, closer io.Closer) {
+	f.routes = append(f.routes, sinkRoute{name: name, delivery: delivery, closer: closer})
+}
+
+// Open opens the named sinks, each with its own transformations, rate
+// limit and delivery classes: those of DELIVERY_CLASSES_<SINK> applied on
+// top of classes.
+func (f *FanOut) Open(ctx context.Context, names []string, config config.Config, classes map[string]DeliveryClass) error {
+	for _, name := range names {
+		sink, closer, err := openSink(ctx, name, config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		// Reshape documents for the sink's schema
+		sink, err = WithTransforms(sink, Transforms(config, name))
+		if err != nil {
+			return fmt.Errorf("%s: configuring transforms: %w", name, err)
+		}
+
+		// Throttle delivery to spare shared clusters
+		sink = withRateLimit(sink, config.RateLimit, config.RateLimitBurst)
+
+		// Stop hammering a sink that keeps failing, keeping what it misses
+		// on disk when asked to
+		var spill *spillQueue
+		if config.SpillDir != "" {
+			if config.CircuitBreakerFailures <= 0 {
+				return fmt.Errorf("%s: SPILL_DIR needs the circuit breaker (CIRCUIT_BREAKER_FAILURES > 0)", name)
+			}
+			if spill, err = openSpillQueue(filepath.Join(config.SpillDir, name), int64(config.SpillMaxMB)<<20); err != nil {
+				return fmt.Errorf("%s: opening spill directory: %w", name, err)
+			}
+		}
+		sink = withCircuitBreaker(sink, name, config.CircuitBreakerFailures, config.CircuitBreakerProbeInterval, spill)
+
+		// Buffer what the sink can't take yet instead of stalling generation
+		overflow, err := parseQueueOverflow(config.SendQueueOverflow)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		sink = withSendQueue(sink, name, config.SendQueueSize, overflow)
+
+		sinkClasses, err := overrideDeliveryClasses(classes, config.SinkDeliveryClasses[name])
+		if err != nil {
+			return fmt.Errorf("%s: configuring delivery classes: %w", name, err)
+		}
+		f.Add(name, NewDispatcher(sink, sinkClasses), closer)
+	}
+	return nil
+}
+
+// Elasticsearch returns the Elasticsearch sink, if one is configured.
+func (f *FanOut) Elasticsearch() *ESSink {
+	for _, r := range f.routes {
+		if es, ok := r.closer.(*ESSink); ok {
+			return es
+		}
+	}
+	return nil
+}
+
+// each calls fn for every route, concurrently when there are several.
+func (f *FanOut) each(fn func(r sinkRoute)) {
+	if len(f.routes) == 1 {
+		fn(f.routes[0])
+		return
+	}
+	var wg sync.WaitGroup
+	for _, r := range f.routes {
+		wg.Add(1)
+		go func(r sinkRoute) {
+			defer wg.Done()
+			fn(r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// Submit hands docs to every sink's dispatcher.
+func (f *FanOut) Submit(ctx context.Context, docs ...Document) {
+	f.each(func(r sinkRoute) { r.delivery.Submit(ctx, docs...) })
+}
+
+// Run flushes every sink's batched classes on their intervals until ctx is
+// cancelled.
+func (f *FanOut) Run(ctx context.Context) {
+	f.each(func(r sinkRoute) { r.delivery.Run(ctx) })
+}
+
+// FlushAll sends everything pending for every sink.
+func (f *FanOut) FlushAll(ctx context.Context) {
+	f.each(func(r sinkRoute) { r.delivery.flushAll(ctx) })
+}
+
+// Ping checks every sink, reporting each that fails by name.
+func (f *FanOut) Ping(ctx context.Context) error {
+	var mu sync.Mutex
+	var errs []error
+	f.each(func(r sinkRoute) {
+		if err := r.delivery.sink.Ping(ctx); err != nil {
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, err))
+			mu.Unlock()
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Close closes the sinks that hold connections.
+func (f *FanOut) Close() error {
+	var errs []error
+	for _, r := range f.routes {
+		if r.closer == nil {
+			continue
+		}
+		if err := r.closer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Transforms returns SINK_TRANSFORMS_<SINK> for the named sink.
+func Transforms(config config.Config, name string) string {
+	switch name {
+	case "graphite":
+		return config.GraphiteTransforms
+	case "statsd":
+		return config.StatsDTransforms
+	case "datadog":
+		return config.DatadogTransforms
+	case "cloudwatch":
+		return config.CloudWatchTransforms
+	case "loki":
+		return config.LokiTransforms
+	case "clickhouse":
+		return config.ClickHouseTransforms
+	case "mqtt":
+		return config.MQTTTransforms
+	case "nats":
+		return config.NATSTransforms
+	case "rabbitmq":
+		return config.RabbitMQTransforms
+	case "redis":
+		return config.RedisTransforms
+	case "webhook":
+		return config.WebhookTransforms
+	case "csv":
+		return config.CSVTransforms
+	case "parquet":
+		return config.ParquetTransforms
+	case "s3":
+		return config.S3Transforms
+	case "gcs":
+		return config.GCSTransforms
+	}
+	return config.ESTransforms
+}
+
+// openSink configures the named sink, returning it restricted to the
+// document types it stores and, for sinks holding connections, the closer
+// to call on shutdown.
+func openSink(ctx context.Context, name string, config config.Config) (Sink, io.Closer, error) {
+	switch name {
+	case "graphite":
+		graphite, err := newGraphiteSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring Graphite: %w", err)
+		}
+		return withDocumentTypes(graphite, "metric"), graphite, nil
+	case "statsd":
+		statsd, err := newStatsDSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring StatsD: %w", err)
+		}
+		return withDocumentTypes(statsd, "metric"), statsd, nil
+	case "datadog":
+		datadog, err := newDatadogSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring Datadog: %w", err)
+		}
+		return withDocumentTypes(datadog, "metric"), nil, nil
+	case "cloudwatch":
+		cloudwatch, err := newCloudWatchSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring CloudWatch: %w", err)
+		}
+		return withDocumentTypes(cloudwatch, "metric"), nil, nil
+	case "loki":
+		if config.ServerLogRate == 0 {
+			return nil, nil, errors.New("the Loki sink needs SERVER_LOG_RATE to generate logs")
+		}
+		loki, err := newLokiSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring Loki: %w", err)
+		}
+		return withDocumentTypes(loki, "log"), nil, nil
+	case "clickhouse":
+		clickhouse, err := newClickHouseSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring ClickHouse: %w", err)
+		}
+		if err := clickhouse.createMetricTable(ctx); err != nil {
+			return nil, nil, fmt.Errorf("creating ClickHouse metric table: %w", err)
+		}
+		return withDocumentTypes(clickhouse, clickhouse.types()...), nil, nil
+	case "mqtt":
+		mqtt, err := newMQTTSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring MQTT: %w", err)
+		}
+		return withDocumentTypes(mqtt, "metric"), mqtt, nil
+	case "nats":
+		nats, err := newNATSSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring NATS: %w", err)
+		}
+		return withDocumentTypes(nats, "metric"), nats, nil
+	case "rabbitmq":
+		rabbitmq, err := newRabbitMQSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring RabbitMQ: %w", err)
+		}
+		return withDocumentTypes(rabbitmq, "metric"), rabbitmq, nil
+	case "redis":
+		redis, err := newRedisStreamSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring Redis: %w", err)
+		}
+		return redis, redis, nil
+	case "webhook":
+		webhook, err := newWebhookSink(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring webhook: %w", err)
+		}
+		return webhook, nil, nil
+	case "csv", "parquet":
+		dataset, err := newDatasetSink(name, config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring %s dataset: %w", name, err)
+		}
+		return dataset, dataset, nil
+	case "s3", "gcs":
+		store, err := newObjectStoreSink(name, config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configuring %s: %w", strings.ToUpper(name), err)
+		}
+		return store, nil, nil
+	}
+	es, err := setupElasticsearch(ctx, config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("setting up Elasticsearch: %w", err)
+	}
+	return es, es, nil
+}
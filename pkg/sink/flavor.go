@@ -0,0 +1,117 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Cluster flavors ES_FLAVOR can name. The v8 client speaks the APIs the
+// generator uses to all of them; only its product check, which requires
+// the X-Elastic-Product header Elasticsearch 7.14 and later send, and ILM,
+// which OpenSearch replaces with ISM, tell them apart.
+const (
+	flavorAuto            = "auto"
+	flavorElasticsearch   = "elasticsearch"
+	flavorElasticsearch7  = "elasticsearch7"
+	flavorOpenSearch      = "opensearch"
+	elasticProductHeader  = "X-Elastic-Product"
+	elasticProductGenuine = "Elasticsearch"
+)
+
+// parseESFlavor validates ES_FLAVOR, defaulting to auto-detection.
+func parseESFlavor(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return flavorAuto, nil
+	case flavorAuto, flavorElasticsearch, flavorElasticsearch7, flavorOpenSearch:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid ES_FLAVOR %q (want auto, elasticsearch, elasticsearch7 or opensearch)", s)
+}
+
+// flavorTransport lets the v8 client talk to Elasticsearch 7.x and
+// OpenSearch by adding the product header their responses lack. In auto
+// mode it asks the cluster what it is before the first request.
+type flavorTransport struct {
+	base http.RoundTripper
+
+	mu     sync.Mutex
+	flavor string
+}
+
+func newFlavorTransport(base http.RoundTripper, flavor string) *flavorTransport {
+	return &flavorTransport{base: base, flavor: flavor}
+}
+
+func (t *flavorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	flavor, err := t.detect(req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if flavor != flavorElasticsearch && res.Header.Get(elasticProductHeader) == "" {
+		res.Header.Set(elasticProductHeader, elasticProductGenuine)
+	}
+	return res, nil
+}
+
+// detect returns the cluster's flavor, asking the cluster's root endpoint
+// with req's credentials the first time in auto mode. A failed request is
+// retried with the next one.
+func (t *flavorTransport) detect(req *http.Request) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.flavor != flavorAuto {
+		return t.flavor, nil
+	}
+
+	root := req.Clone(req.Context())
+	root.Method, root.Body, root.ContentLength = http.MethodGet, nil, 0
+	root.URL.Path, root.URL.RawPath, root.URL.RawQuery = "/", "", ""
+	root.Header.Del("Content-Type")
+	root.Header.Del("Content-Encoding")
+	res, err := t.base.RoundTrip(root)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if res.StatusCode != http.StatusOK || json.NewDecoder(res.Body).Decode(&info) != nil {
+		// Leave the verdict to the client's own product check
+		t.flavor = flavorElasticsearch
+		return t.flavor, nil
+	}
+	major, _ := strconv.Atoi(strings.Split(info.Version.Number, ".")[0])
+	switch {
+	case info.Version.Distribution == "opensearch":
+		t.flavor = flavorOpenSearch
+	case major < 8:
+		t.flavor = flavorElasticsearch7
+	default:
+		t.flavor = flavorElasticsearch
+	}
+	slog.Info("Detected cluster", "flavor", t.flavor, "version", info.Version.Number)
+	return t.flavor, nil
+}
+
+// Flavor returns the cluster's flavor, flavorAuto until the first request
+// has been made.
+func (t *flavorTransport) Flavor() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.flavor
+}
@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// graphiteSink writes documents to Graphite/Carbon in the plaintext
+// protocol, one "<prefix>.<field> <value> <unix seconds>" line per numeric
+// field.
+type graphiteSink struct {
+	out    lineWriter
+	prefix string
+}
+
+func newGraphiteSink(config config.Config) (*graphiteSink, error) {
+	if config.GraphiteAddr == "" {
+		return nil, fmt.Errorf("GRAPHITE_ADDR is required")
+	}
+	network := config.GraphiteProtocol
+	if network == "" {
+		network = "tcp"
+	}
+	if network != "tcp" && network != "udp" {
+		return nil, fmt.Errorf("invalid Graphite protocol %q (want tcp or udp)", network)
+	}
+	prefix := config.GraphitePrefix
+	if prefix == "" {
+		prefix = "servers.{role}.{hostname}"
+	}
+	return &graphiteSink{out: lineWriter{network: network, addr: config.GraphiteAddr}, prefix: prefix}, nil
+}
+
+// metricPath expands the {server_id}, {hostname} and {role} placeholders of
+// the prefix. Dots and spaces in the values would add path levels, so they
+// are replaced with underscores.
+func (s *graphiteSink) metricPath(doc Document) string {
+	clean := strings.NewReplacer(".", "_", " ", "_")
+	return strings.NewReplacer(
+		"{server_id}", clean.Replace(doc.ServerID),
+		"{hostname}", clean.Replace(doc.Hostname),
+		"{role}", clean.Replace(doc.Role),
+	).Replace(s.prefix)
+}
+
+// lines renders doc in the plaintext protocol.
+func (s *graphiteSink) lines(buf []byte, doc Document) ([]byte, error) {
+	fields, err := doc.numericFields()
+	if err != nil {
+		return buf, err
+	}
+
+	path := s.metricPath(doc)
+	ts := strconv.FormatInt(doc.Timestamp.Unix(), 10)
+	for _, f := range fields {
+		buf = append(buf, path...)
+		buf = append(buf, '.')
+		buf = append(buf, f.Name...)
+		buf = append(buf, ' ')
+		buf = strconv.AppendFloat(buf, f.Value, 'g', -1, 64)
+		buf = append(buf, ' ')
+		buf = append(buf, ts...)
+		buf = append(buf, '\n')
+	}
+	return buf, nil
+}
+
+func (s *graphiteSink) Send(ctx context.Context, docs []Document) {
+	var buf []byte
+	sent := 0
+	for _, doc := range docs {
+		var err error
+		if buf, err = s.lines(buf, doc); err != nil {
+			slog.Error("Error encoding Graphite lines", "type", doc.Type, "server_id", doc.ServerID, "error", err)
+			recordFailed(ctx, 1)
+			continue
+		}
+		sent++
+	}
+
+	start := time.Now()
+	err := s.out.write(buf)
+	telemetry.Stats.BatchDuration.Observe(time.Since(start))
+	if err != nil {
+		slog.Error("Error writing to Graphite", "addr", s.out.addr, "documents", sent, "error", err)
+		recordFailed(ctx, int64(sent))
+		return
+	}
+	recordIndexed(ctx, int64(sent))
+}
+
+// Ping checks that Carbon accepts connections.
+func (s *graphiteSink) Ping(ctx context.Context) error {
+	return s.out.ping(ctx)
+}
+
+func (s *graphiteSink) Close() error {
+	return s.out.close()
+}
@@ -0,0 +1,71 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ilmPolicyOptions configures the phases of the generated ILM policy.
+// Ages use Elasticsearch time units ("12h", "7d"); an empty age skips the
+// corresponding phase or action.
+type ilmPolicyOptions struct {
+	RolloverMaxAge string
+	WarmAfter      string
+	DeleteAfter    string
+}
+
+// putILMPolicy creates or replaces a hot/warm/delete lifecycle policy.
+func putILMPolicy(ctx context.Context, client *elasticsearch.Client, name string, opts ilmPolicyOptions) error {
+	phases := map[string]interface{}{}
+
+	hotActions := map[string]interface{}{
+		"set_priority": map[string]int{"priority": 100},
+	}
+	if opts.RolloverMaxAge != "" {
+		hotActions["rollover"] = map[string]string{
+			"max_age":                opts.RolloverMaxAge,
+			"max_primary_shard_size": "50gb",
+		}
+	}
+	phases["hot"] = map[string]interface{}{"actions": hotActions}
+
+	if opts.WarmAfter != "" {
+		phases["warm"] = map[string]interface{}{
+			"min_age": opts.WarmAfter,
+			"actions": map[string]interface{}{
+				"set_priority": map[string]int{"priority": 50},
+				"forcemerge":   map[string]int{"max_num_segments": 1},
+			},
+		}
+	}
+
+	if opts.DeleteAfter != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": opts.DeleteAfter,
+			"actions": map[string]interface{}{
+				"delete": map[string]interface{}{},
+			},
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"policy": map[string]interface{}{"phases": phases},
+	})
+	if err != nil {
+		return err
+	}
+
+	req := esapi.ILMPutLifecycleRequest{
+		Policy: name,
+		Body:   bytes.NewReader(body),
+	}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	return checkResponse(res)
+}
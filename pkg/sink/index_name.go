@@ -0,0 +1,105 @@
+package sink
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// jodaLayouts maps the Joda-style tokens accepted inside %{...} to Go
+// time layouts. Longer tokens come first so "yyyy" wins over "yy".
+var jodaLayouts = []struct{ token, layout string }{
+	{"yyyy", "2006"},
+	{"yy", "06"},
+	{"MM", "01"},
+	{"dd", "02"},
+	{"HH", "15"},
+	{"mm", "04"},
+	{"ss", "05"},
+}
+
+// IndexNamer resolves the target index for a document timestamp.
+type IndexNamer func(t time.Time) string
+
+// NewIndexNamer supports three forms of ES_INDEX:
+//
+//   - a plain name, used as-is;
+//   - a Logstash-style pattern such as "server-metrics-%{yyyy.MM.dd}",
+//     resolved from each document's UTC timestamp;
+//   - an Elasticsearch date-math name such as "<server-metrics-{now/d}>",
+//     resolved by the cluster. It is kept as-is and only escaped where it
+//     becomes part of a URL path.
+func NewIndexNamer(pattern string) IndexNamer {
+	if strings.HasPrefix(pattern, "<") && strings.HasSuffix(pattern, ">") {
+		return func(time.Time) string { return pattern }
+	}
+
+	start := strings.Index(pattern, "%{")
+	if start < 0 {
+		return func(time.Time) string { return pattern }
+	}
+	end := strings.Index(pattern[start:], "}")
+	if end < 0 {
+		return func(time.Time) string { return pattern }
+	}
+	end += start
+
+	prefix, suffix := pattern[:start], pattern[end+1:]
+	layout := jodaToLayout(pattern[start+2 : end])
+	return func(t time.Time) string {
+		return prefix + t.UTC().Format(layout) + suffix
+	}
+}
+
+func jodaToLayout(format string) string {
+	var b strings.Builder
+	for len(format) > 0 {
+		matched := false
+		for _, j := range jodaLayouts {
+			if strings.HasPrefix(format, j.token) {
+				b.WriteString(j.layout)
+				format = format[len(j.token):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(format[0])
+			format = format[1:]
+		}
+	}
+	return b.String()
+}
+
+// rawIndexName undoes the URL escaping earlier versions applied to
+// date-math names before writing them to dead-letter and spill files.
+func rawIndexName(name string) string {
+	if strings.HasPrefix(name, "%3C") {
+		if raw, err := url.PathUnescape(name); err == nil {
+			return raw
+		}
+	}
+	return name
+}
+
+// indexBase returns the static prefix of an index pattern, so that
+// "<base>*" matches every index the pattern can produce.
+func indexBase(pattern string) string {
+	if strings.HasPrefix(pattern, "<") {
+		pattern = strings.TrimPrefix(pattern, "<")
+		if i := strings.Index(pattern, "{"); i >= 0 {
+			return pattern[:i]
+		}
+		return strings.TrimSuffix(pattern, ">")
+	}
+	if i := strings.Index(pattern, "%{"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// indexBaseName is indexBase without trailing separators, suitable for
+// naming templates and snapshots.
+func indexBaseName(pattern string) string {
+	return strings.TrimRight(indexBase(pattern), "-_.")
+}
@@ -0,0 +1,91 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxDatagram keeps UDP packets below a typical MTU.
+const maxDatagram = 1400
+
+// lineWriter sends newline-terminated text protocols such as Graphite and
+// StatsD over a shared TCP or UDP connection.
+type lineWriter struct {
+	network string
+	addr    string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// write sends buf, reconnecting once if the connection has gone away. UDP
+// payloads are split on line boundaries.
+func (w *lineWriter) write(buf []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if w.conn, err = net.DialTimeout(w.network, w.addr, 5*time.Second); err != nil {
+				return err
+			}
+		}
+		if err = w.writePayload(buf); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+func (w *lineWriter) writePayload(buf []byte) error {
+	w.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if w.network == "tcp" {
+		_, err := w.conn.Write(buf)
+		return err
+	}
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > maxDatagram {
+			n = bytes.LastIndexByte(buf[:maxDatagram], '\n') + 1
+			if n == 0 {
+				n = bytes.IndexByte(buf, '\n') + 1
+			}
+		}
+		if _, err := w.conn.Write(buf[:n]); err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// ping checks that the address accepts TCP connections. UDP has nothing
+// to check.
+func (w *lineWriter) ping(ctx context.Context) error {
+	if w.network != "tcp" {
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", w.addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (w *lineWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,191 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiSink pushes log documents to Grafana Loki, one stream per server
+// labelled with its role, hostname and environment. Lines are the
+// documents' JSON bodies, so LogQL's json parser exposes every field.
+type lokiSink struct {
+	client   *http.Client
+	url      string
+	tenant   string
+	username string
+	password string
+	labels   map[string]string
+	retry    RetryPolicy
+}
+
+func newLokiSink(config config.Config) (*lokiSink, error) {
+	if config.LokiURL == "" {
+		return nil, fmt.Errorf("LOKI_URL is required")
+	}
+
+	labels := map[string]string{"environment": config.LokiEnvironment}
+	if labels["environment"] == "" {
+		labels["environment"] = "demo"
+	}
+	for _, entry := range strings.Split(config.LokiLabels, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid Loki label %q (want name=value)", entry)
+		}
+		labels[name] = value
+	}
+
+	return &lokiSink{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		url:      strings.TrimSuffix(config.LokiURL, "/"),
+		tenant:   config.LokiTenant,
+		username: config.LokiUsername,
+		password: config.LokiPassword,
+		labels:   labels,
+		retry:    RetryPolicy{MaxRetries: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second},
+	}, nil
+}
+
+// streams groups docs into one stream per server, each ordered by time as
+// older Loki versions require.
+func (s *lokiSink) streams(docs []Document) []lokiStream {
+	sorted := append([]Document(nil), docs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	var streams []lokiStream
+	index := make(map[string]int)
+	for _, doc := range sorted {
+		i, ok := index[doc.ServerID]
+		if !ok {
+			labels := make(map[string]string, len(s.labels)+2)
+			for name, value := range s.labels {
+				labels[name] = value
+			}
+			labels["role"] = doc.Role
+			labels["hostname"] = doc.Hostname
+			i = len(streams)
+			index[doc.ServerID] = i
+			streams = append(streams, lokiStream{Stream: labels})
+		}
+		ts := strconv.FormatInt(doc.Timestamp.UnixNano(), 10)
+		streams[i].Values = append(streams[i].Values, [2]string{ts, string(doc.Body)})
+	}
+	return streams
+}
+
+// Send pushes docs in one request, retrying transient failures.
+func (s *lokiSink) Send(ctx context.Context, docs []Document) {
+	if len(docs) == 0 {
+		return
+	}
+	payload := lokiPushRequest{Streams: s.streams(docs)}
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := s.push(ctx, payload)
+		telemetry.Stats.BatchDuration.Observe(time.Since(start))
+		if err == nil {
+			recordIndexed(ctx, int64(len(docs)))
+			return
+		}
+		if !IsRetryable(err) || attempt >= s.retry.MaxRetries {
+			slog.Error("Error pushing to Loki", "documents", len(docs), "attempt", attempt+1, "error", err)
+			recordFailed(ctx, int64(len(docs)))
+			return
+		}
+
+		telemetry.Stats.Retries.Add(int64(len(docs)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retry.Backoff(attempt)):
+		}
+	}
+}
+
+func (s *lokiSink) push(ctx context.Context, payload lokiPushRequest) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(payload); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/loki/api/v1/push", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	s.authorize(req)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return &SendError{Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		reason, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return &SendError{Status: res.StatusCode, Reason: string(bytes.TrimSpace(reason))}
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+// authorize adds the tenant header and basic auth credentials, if any.
+func (s *lokiSink) authorize(req *http.Request) {
+	if s.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", s.tenant)
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+}
+
+// Ping checks that Loki is ready to accept pushes.
+func (s *lokiSink) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/ready", nil)
+	if err != nil {
+		return err
+	}
+	s.authorize(req)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return nil
+}
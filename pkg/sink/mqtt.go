@@ -0,0 +1,369 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// MQTT 3.1.1 control packet types, shifted into the fixed header's upper
+// nibble.
+const (
+	mqttConnect    = 1 << 4
+	mqttConnack    = 2 << 4
+	mqttPublish    = 3 << 4
+	mqttPuback     = 4 << 4
+	mqttPubrec     = 5 << 4
+	mqttPubrel     = 6<<4 | 2 // PUBREL has reserved flags 0010
+	mqttPubcomp    = 7 << 4
+	mqttDisconnect = 14 << 4
+)
+
+// mqttConnackErrors describes the CONNACK return codes of refused
+// connections.
+var mqttConnackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// mqttSink publishes each document to an MQTT broker as JSON, on a topic
+// expanded from the document's fields. It speaks MQTT 3.1.1 over a single
+// connection and, with QoS 1 or 2, waits for the broker to acknowledge
+// every message of a batch.
+type mqttSink struct {
+	addr     string
+	tls      bool
+	topic    string
+	qos      byte
+	retain   bool
+	clientID string
+	username string
+	password string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	nextID uint16
+}
+
+func newMQTTSink(config config.Config) (*mqttSink, error) {
+	if config.MQTTBroker == "" {
+		return nil, fmt.Errorf("MQTT_BROKER is required")
+	}
+	s := &mqttSink{
+		addr:     config.MQTTBroker,
+		topic:    config.MQTTTopic,
+		qos:      byte(config.MQTTQoS),
+		retain:   config.MQTTRetain,
+		clientID: config.MQTTClientID,
+		username: config.MQTTUsername,
+		password: config.MQTTPassword,
+	}
+	if scheme, addr, ok := strings.Cut(s.addr, "://"); ok {
+		switch scheme {
+		case "tcp", "mqtt":
+		case "tls", "ssl", "mqtts":
+			s.tls = true
+		default:
+			return nil, fmt.Errorf("invalid MQTT broker scheme %q (want tcp or tls)", scheme)
+		}
+		s.addr = addr
+	}
+	if _, _, err := net.SplitHostPort(s.addr); err != nil {
+		port := "1883"
+		if s.tls {
+			port = "8883"
+		}
+		s.addr = net.JoinHostPort(s.addr, port)
+	}
+	if config.MQTTQoS < 0 || config.MQTTQoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d (want 0, 1 or 2)", config.MQTTQoS)
+	}
+	if s.topic == "" {
+		s.topic = "metrics/{country}/{server_id}"
+	}
+	if s.clientID == "" {
+		s.clientID = "sample-metric-generator"
+	}
+	return s, nil
+}
+
+// topicFor expands the topic pattern for doc. Characters with a meaning in
+// topics are replaced with underscores.
+func (s *mqttSink) topicFor(doc Document) (string, error) {
+	return expandFieldTemplate(s.topic, doc, strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_"))
+}
+
+// Send publishes docs, reconnecting once if the connection has gone away.
+func (s *mqttSink) Send(ctx context.Context, docs []Document) {
+	type message struct {
+		topic   string
+		payload []byte
+	}
+	messages := make([]message, 0, len(docs))
+	for _, doc := range docs {
+		topic, err := s.topicFor(doc)
+		if err != nil {
+			slog.Error("Error expanding MQTT topic", "type", doc.Type, "server_id", doc.ServerID, "error", err)
+			recordFailed(ctx, 1)
+			continue
+		}
+		messages = append(messages, message{topic: topic, payload: doc.Body})
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := time.Now()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err = s.connect(ctx); err != nil {
+				break
+			}
+		}
+		err = s.publishAll(len(messages), func(i int) (string, []byte) {
+			return messages[i].topic, messages[i].payload
+		})
+		if err == nil {
+			break
+		}
+		s.drop()
+	}
+	telemetry.Stats.BatchDuration.Observe(time.Since(start))
+	if err != nil {
+		slog.Error("Error publishing to MQTT", "broker", s.addr, "documents", len(messages), "error", err)
+		recordFailed(ctx, int64(len(messages)))
+		return
+	}
+	recordIndexed(ctx, int64(len(messages)))
+}
+
+// connect dials the broker and completes the MQTT handshake. The caller
+// must hold s.mu.
+func (s *mqttSink) connect(ctx context.Context) error {
+	d := net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if s.tls {
+		host, _, _ := net.SplitHostPort(s.addr)
+		conn, err = (&tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn, s.r, s.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+
+	// Clean session, no keep-alive: the broker never drops an idle
+	// publisher, and the connection is redialled when a write fails
+	var flags byte = 0x02
+	var payload []byte
+	payload = mqttAppendString(payload, s.clientID)
+	if s.username != "" {
+		flags |= 0x80
+		payload = mqttAppendString(payload, s.username)
+		if s.password != "" {
+			flags |= 0x40
+			payload = mqttAppendString(payload, s.password)
+		}
+	}
+	header := mqttAppendString(nil, "MQTT")
+	header = append(header, 4, flags, 0, 0)
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	if err := s.writePacket(mqttConnect, append(header, payload...)); err != nil {
+		s.drop()
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		s.drop()
+		return err
+	}
+	kind, body, err := s.readPacket()
+	if err != nil {
+		s.drop()
+		return err
+	}
+	if kind != mqttConnack || len(body) != 2 {
+		s.drop()
+		return fmt.Errorf("unexpected packet 0x%02x in reply to CONNECT", kind)
+	}
+	if code := body[1]; code != 0 {
+		s.drop()
+		if reason, ok := mqttConnackErrors[code]; ok {
+			return fmt.Errorf("connection refused: %s", reason)
+		}
+		return fmt.Errorf("connection refused with code %d", code)
+	}
+	return nil
+}
+
+// publishAll writes n PUBLISH packets, then waits for their
+// acknowledgements at QoS 1 and 2. The caller must hold s.mu.
+func (s *mqttSink) publishAll(n int, message func(i int) (string, []byte)) error {
+	s.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer s.conn.SetDeadline(time.Time{})
+
+	pending := make(map[uint16]bool)
+	header := byte(mqttPublish) | s.qos<<1
+	if s.retain {
+		header |= 1
+	}
+	for i := 0; i < n; i++ {
+		topic, payload := message(i)
+		body := mqttAppendString(nil, topic)
+		if s.qos > 0 {
+			id := s.packetID()
+			pending[id] = true
+			body = binary.BigEndian.AppendUint16(body, id)
+		}
+		if err := s.writePacket(header, append(body, payload...)); err != nil {
+			return err
+		}
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	for len(pending) > 0 {
+		kind, body, err := s.readPacket()
+		if err != nil {
+			return err
+		}
+		if len(body) < 2 {
+			return fmt.Errorf("malformed packet 0x%02x", kind)
+		}
+		id := binary.BigEndian.Uint16(body)
+		switch kind {
+		case mqttPuback, mqttPubcomp:
+			delete(pending, id)
+		case mqttPubrec:
+			// QoS 2: release the message, then wait for PUBCOMP
+			if err := s.writePacket(mqttPubrel, body[:2]); err != nil {
+				return err
+			}
+			if err := s.w.Flush(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected packet 0x%02x", kind)
+		}
+	}
+	return nil
+}
+
+// packetID returns the next non-zero packet identifier.
+func (s *mqttSink) packetID() uint16 {
+	s.nextID++
+	if s.nextID == 0 {
+		s.nextID = 1
+	}
+	return s.nextID
+}
+
+// writePacket buffers one control packet.
+func (s *mqttSink) writePacket(header byte, body []byte) error {
+	s.w.WriteByte(header)
+	// Remaining length, seven bits per byte
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		s.w.WriteByte(b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := s.w.Write(body)
+	return err
+}
+
+// readPacket reads one control packet, returning its type and flags and
+// its body.
+func (s *mqttSink) readPacket() (byte, []byte, error) {
+	header, err := s.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n, shift int
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, fmt.Errorf("malformed remaining length")
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(s.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// drop closes the connection after an error. The caller must hold s.mu.
+func (s *mqttSink) drop() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn, s.r, s.w = nil, nil, nil
+}
+
+// mqttAppendString appends a length-prefixed UTF-8 string.
+func mqttAppendString(buf []byte, v string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(v)))
+	return append(buf, v...)
+}
+
+// Ping connects to the broker, if not connected yet, to check the address
+// and credentials.
+func (s *mqttSink) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		return nil
+	}
+	return s.connect(ctx)
+}
+
+// Close sends DISCONNECT and closes the connection.
+func (s *mqttSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	s.writePacket(mqttDisconnect, nil)
+	s.w.Flush()
+	err := s.conn.Close()
+	s.conn, s.r, s.w = nil, nil, nil
+	return err
+}
@@ -0,0 +1,396 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// natsSink publishes each document to NATS as JSON, on a subject expanded
+// from the document's fields. It speaks the NATS client protocol over a
+// single connection. With JetStream, every message is published with a
+// reply inbox and counts as delivered once its stream acknowledges it;
+// otherwise a PING round trip confirms the server has processed a batch.
+type natsSink struct {
+	addr      string
+	tls       bool
+	subject   string
+	jetStream bool
+	username  string
+	password  string
+	token     string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	inbox  string
+	nextID int
+}
+
+// errJetStreamRejected marks messages JetStream did not store, such as
+// those published to a subject no stream captures.
+var errJetStreamRejected = errors.New("JetStream rejected message")
+
+// natsAck is a JetStream publish acknowledgement.
+type natsAck struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+func newNATSSink(config config.Config) (*natsSink, error) {
+	raw := config.NATSURL
+	if raw == "" {
+		raw = "nats://localhost:4222"
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "nats://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS URL: %w", err)
+	}
+	s := &natsSink{
+		addr:      u.Host,
+		subject:   config.NATSSubject,
+		jetStream: config.NATSJetStream,
+		username:  config.NATSUsername,
+		password:  config.NATSPassword,
+		token:     config.NATSToken,
+	}
+	switch u.Scheme {
+	case "nats":
+	case "tls":
+		s.tls = true
+	default:
+		return nil, fmt.Errorf("invalid NATS URL scheme %q (want nats or tls)", u.Scheme)
+	}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil && s.username == "" {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if s.subject == "" {
+		s.subject = "metrics.{role}.{country}"
+	}
+	return s, nil
+}
+
+// subjectFor expands the subject pattern for doc. Dots would add subject
+// tokens and wildcards and whitespace are not allowed, so they are
+// replaced with underscores.
+func (s *natsSink) subjectFor(doc Document) (string, error) {
+	return expandFieldTemplate(s.subject, doc, strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "\t", "_"))
+}
+
+// Send publishes docs, reconnecting once if the connection has gone away.
+func (s *natsSink) Send(ctx context.Context, docs []Document) {
+	var subjects []string
+	var payloads [][]byte
+	for _, doc := range docs {
+		subject, err := s.subjectFor(doc)
+		if err != nil {
+			slog.Error("Error expanding NATS subject", "type", doc.Type, "server_id", doc.ServerID, "error", err)
+			recordFailed(ctx, 1)
+			continue
+		}
+		subjects = append(subjects, subject)
+		payloads = append(payloads, doc.Body)
+	}
+	if len(subjects) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := time.Now()
+	var acked int
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err = s.connect(ctx); err != nil {
+				break
+			}
+		}
+		// Only a batch lost entirely to a broken connection is published
+		// again, so nothing is published twice
+		acked, err = s.publishAll(subjects, payloads)
+		if err == nil || errors.Is(err, errJetStreamRejected) {
+			break
+		}
+		// The reader may have stopped mid-message, so the connection
+		// can't be trusted even when some acks came back
+		s.drop()
+		if acked > 0 {
+			break
+		}
+	}
+	telemetry.Stats.BatchDuration.Observe(time.Since(start))
+	if err != nil {
+		slog.Error("Error publishing to NATS", "addr", s.addr, "documents", len(subjects)-acked, "error", err)
+		recordFailed(ctx, int64(len(subjects)-acked))
+	}
+	recordIndexed(ctx, int64(acked))
+}
+
+// connect dials the server, upgrading to TLS after its INFO if asked to,
+// and sends CONNECT. With JetStream it subscribes to the reply inbox. The
+// caller must hold s.mu.
+func (s *natsSink) connect(ctx context.Context) error {
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	s.conn, s.r = conn, bufio.NewReader(conn)
+
+	line, err := s.readLine()
+	if err != nil {
+		s.drop()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		s.drop()
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	json.Unmarshal([]byte(line[len("INFO "):]), &info)
+	if s.tls || info.TLSRequired {
+		host, _, _ := net.SplitHostPort(s.addr)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			s.drop()
+			return err
+		}
+		s.conn, s.r = tlsConn, bufio.NewReader(tlsConn)
+	}
+	s.w = bufio.NewWriter(s.conn)
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "sample-metric-generator",
+		"lang":     "go",
+		"version":  "1.0.0",
+		"protocol": 1,
+	}
+	if s.username != "" {
+		options["user"], options["pass"] = s.username, s.password
+	}
+	if s.token != "" {
+		options["auth_token"] = s.token
+	}
+	if s.jetStream {
+		// Get a 503 status, rather than no reply at all, when no stream
+		// captures a subject
+		options["headers"], options["no_responders"] = true, true
+	}
+	connect, _ := json.Marshal(options)
+	fmt.Fprintf(s.w, "CONNECT %s\r\n", connect)
+	if s.jetStream {
+		id := make([]byte, 8)
+		rand.Read(id)
+		s.inbox = "_INBOX." + hex.EncodeToString(id)
+		fmt.Fprintf(s.w, "SUB %s.* 1\r\n", s.inbox)
+	}
+	if err := s.flush(); err != nil {
+		s.drop()
+		return err
+	}
+	s.conn.SetDeadline(time.Time{})
+	return nil
+}
+
+// publishAll publishes the messages and waits for the server to confirm
+// them, returning how many were delivered. The caller must hold s.mu.
+func (s *natsSink) publishAll(subjects []string, payloads [][]byte) (int, error) {
+	s.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer s.conn.SetDeadline(time.Time{})
+
+	pending := make(map[string]bool)
+	for i, subject := range subjects {
+		if s.jetStream {
+			s.nextID++
+			reply := s.inbox + "." + strconv.Itoa(s.nextID)
+			pending[reply] = true
+			fmt.Fprintf(s.w, "PUB %s %s %d\r\n", subject, reply, len(payloads[i]))
+		} else {
+			fmt.Fprintf(s.w, "PUB %s %d\r\n", subject, len(payloads[i]))
+		}
+		s.w.Write(payloads[i])
+		s.w.WriteString("\r\n")
+	}
+	if !s.jetStream {
+		if err := s.flush(); err != nil {
+			return 0, err
+		}
+		return len(subjects), nil
+	}
+	if err := s.w.Flush(); err != nil {
+		return 0, err
+	}
+
+	acked := 0
+	var rejected error
+	for len(pending) > 0 {
+		reply, payload, err := s.readMsg()
+		if err != nil {
+			return acked, err
+		}
+		if !pending[reply] {
+			continue
+		}
+		delete(pending, reply)
+		var ack natsAck
+		if err := json.Unmarshal(payload, &ack); err != nil {
+			rejected = fmt.Errorf("%w: malformed ack: %v", errJetStreamRejected, err)
+			continue
+		}
+		if ack.Error != nil {
+			rejected = fmt.Errorf("%w: %s (%d)", errJetStreamRejected, ack.Error.Description, ack.Error.Code)
+			continue
+		}
+		acked++
+	}
+	return acked, rejected
+}
+
+// flush writes the buffered commands followed by a PING and waits for the
+// PONG, by which time the server has processed them. The caller must hold
+// s.mu.
+func (s *natsSink) flush() error {
+	s.w.WriteString("PING\r\n")
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	for {
+		line, err := s.readControl()
+		if err != nil {
+			return err
+		}
+		if line == "PONG" {
+			return nil
+		}
+	}
+}
+
+// readMsg returns the subject and payload of the next MSG or HMSG. A
+// header-only "503" status, the server's answer to a request nobody
+// receives, is returned as a JetStream error payload.
+func (s *natsSink) readMsg() (string, []byte, error) {
+	for {
+		line, err := s.readControl()
+		if err != nil {
+			return "", nil, err
+		}
+		// MSG <subject> <sid> [reply-to] <#bytes> or
+		// HMSG <subject> <sid> [reply-to] <#header bytes> <#total bytes>
+		fields := strings.Fields(line)
+		if len(fields) < 4 || (fields[0] != "MSG" && fields[0] != "HMSG") {
+			continue
+		}
+		size, err := strconv.Atoi(fields[len(fields)-1])
+		headerSize := 0
+		if err == nil && fields[0] == "HMSG" {
+			headerSize, err = strconv.Atoi(fields[len(fields)-2])
+		}
+		if err != nil || headerSize > size {
+			return "", nil, fmt.Errorf("malformed %s %q", fields[0], line)
+		}
+		payload := make([]byte, size+2)
+		if _, err := io.ReadFull(s.r, payload); err != nil {
+			return "", nil, err
+		}
+		if status := strings.Fields(string(payload[:headerSize])); len(status) > 1 && status[1] == "503" {
+			return fields[1], []byte(`{"error":{"code":503,"description":"no stream captures the subject"}}`), nil
+		}
+		return fields[1], payload[headerSize:size], nil
+	}
+}
+
+// readControl reads the next protocol line, answering server PINGs and
+// turning -ERR into an error.
+func (s *natsSink) readControl() (string, error) {
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case line == "PING":
+			s.w.WriteString("PONG\r\n")
+			if err := s.w.Flush(); err != nil {
+				return "", err
+			}
+		case line == "+OK", strings.HasPrefix(line, "INFO "):
+		case strings.HasPrefix(line, "-ERR"):
+			return "", fmt.Errorf("server error: %s", strings.Trim(line[len("-ERR"):], " '"))
+		default:
+			return line, nil
+		}
+	}
+}
+
+func (s *natsSink) readLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// drop closes the connection after an error. The caller must hold s.mu.
+func (s *natsSink) drop() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn, s.r, s.w = nil, nil, nil
+}
+
+// Ping connects to the server, if not connected yet, to check the address
+// and credentials.
+func (s *natsSink) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		return nil
+	}
+	return s.connect(ctx)
+}
+
+func (s *natsSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	s.w.Flush()
+	err := s.conn.Close()
+	s.conn, s.r, s.w = nil, nil, nil
+	return err
+}
@@ -0,0 +1,286 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// objectStoreSink uploads each batch as one object per document type and
+// time partition to S3 or Google Cloud Storage, for data lakes that
+// ingest files from a bucket:
+//
+//	<prefix>/<type>/date=2024-05-02/hour=13/part-20240502T130000Z-000042.ndjson.gz
+//
+// Objects are gzip-compressed NDJSON, or Parquet with gzip pages.
+type objectStoreSink struct {
+	client    *http.Client
+	provider  string // s3 or gcs
+	endpoint  string
+	bucket    string
+	pathStyle bool // Whether the bucket is part of the path rather than the host
+	prefix    string
+	format    string
+	partition string
+	signer    *awsSigner
+	token     string
+	run       string
+	seq       atomic.Int64
+	retry     RetryPolicy
+}
+
+func newObjectStoreSink(provider string, config config.Config) (*objectStoreSink, error) {
+	if config.ObjectStoreBucket == "" {
+		return nil, fmt.Errorf("OBJECT_STORE_BUCKET is required")
+	}
+	s := &objectStoreSink{
+		client:    &http.Client{Timeout: 60 * time.Second},
+		provider:  provider,
+		bucket:    config.ObjectStoreBucket,
+		prefix:    strings.Trim(config.ObjectStorePrefix, "/"),
+		format:    config.ObjectStoreFormat,
+		partition: config.ObjectStorePartition,
+		run:       time.Now().UTC().Format("20060102T150405Z"),
+		retry:     RetryPolicy{MaxRetries: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second},
+	}
+	switch s.format {
+	case "":
+		s.format = "ndjson"
+	case "ndjson", "parquet":
+	default:
+		return nil, fmt.Errorf("invalid object store format %q (want ndjson or parquet)", s.format)
+	}
+	switch s.partition {
+	case "":
+		s.partition = "day"
+	case "day", "hour", "none":
+	default:
+		return nil, fmt.Errorf("invalid object store partitioning %q (want day, hour or none)", s.partition)
+	}
+
+	// A custom endpoint points at MinIO, LocalStack or another
+	// S3-compatible store, addressed path-style
+	s.endpoint = strings.TrimSuffix(config.ObjectStoreEndpoint, "/")
+	s.pathStyle = s.endpoint != ""
+
+	if provider == "gcs" {
+		if s.endpoint == "" {
+			s.endpoint = "https://storage.googleapis.com"
+			s.pathStyle = true
+		}
+		switch {
+		case config.GCSAccessToken != "":
+			s.token = config.GCSAccessToken
+		case config.GCSHMACAccessKey != "" && config.GCSHMACSecret != "":
+			s.signer = &awsSigner{service: "s3", region: "auto", accessKey: config.GCSHMACAccessKey, secretKey: config.GCSHMACSecret}
+		default:
+			return nil, fmt.Errorf("GCS_ACCESS_TOKEN or GCS_HMAC_ACCESS_KEY and GCS_HMAC_SECRET are required")
+		}
+		return s, nil
+	}
+
+	// S3 shares the AWS credentials with CloudWatch
+	if config.CloudWatchRegion == "" {
+		return nil, fmt.Errorf("AWS_REGION is required")
+	}
+	if config.CloudWatchAccessKeyID == "" || config.CloudWatchSecretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://" + s.bucket + ".s3." + config.CloudWatchRegion + ".amazonaws.com"
+	}
+	s.signer = &awsSigner{
+		service:      "s3",
+		region:       config.CloudWatchRegion,
+		accessKey:    config.CloudWatchAccessKeyID,
+		secretKey:    config.CloudWatchSecretKey,
+		sessionToken: config.CloudWatchSessionToken,
+	}
+	return s, nil
+}
+
+// Send uploads docs as one object per type and partition.
+func (s *objectStoreSink) Send(ctx context.Context, docs []Document) {
+	groups := make(map[string][]Document)
+	var order []string
+	for _, doc := range docs {
+		dir := s.objectDir(doc)
+		if _, ok := groups[dir]; !ok {
+			order = append(order, dir)
+		}
+		groups[dir] = append(groups[dir], doc)
+	}
+	for _, dir := range order {
+		s.upload(ctx, dir, groups[dir])
+	}
+}
+
+// objectDir returns the key prefix of the object doc goes into.
+func (s *objectStoreSink) objectDir(doc Document) string {
+	parts := []string{doc.Type}
+	if s.prefix != "" {
+		parts = append([]string{s.prefix}, parts...)
+	}
+	ts := doc.Timestamp.UTC()
+	switch s.partition {
+	case "day":
+		parts = append(parts, "date="+ts.Format("2006-01-02"))
+	case "hour":
+		parts = append(parts, "date="+ts.Format("2006-01-02"), "hour="+ts.Format("15"))
+	}
+	return strings.Join(parts, "/")
+}
+
+// upload encodes docs and puts them in a new object under dir, retrying
+// transient failures.
+func (s *objectStoreSink) upload(ctx context.Context, dir string, docs []Document) {
+	body, contentType, ext, err := s.encode(docs)
+	if err != nil {
+		slog.Error("Error encoding object", "provider", s.provider, "type", docs[0].Type, "error", err)
+		recordFailed(ctx, int64(len(docs)))
+		return
+	}
+	key := fmt.Sprintf("%s/part-%s-%06d.%s", dir, s.run, s.seq.Add(1), ext)
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := s.put(ctx, key, body, contentType)
+		telemetry.Stats.BatchDuration.Observe(time.Since(start))
+		if err == nil {
+			recordIndexed(ctx, int64(len(docs)))
+			return
+		}
+		if !IsRetryable(err) || attempt >= s.retry.MaxRetries {
+			slog.Error("Error uploading object", "provider", s.provider, "key", key, "documents", len(docs), "attempt", attempt+1, "error", err)
+			recordFailed(ctx, int64(len(docs)))
+			return
+		}
+
+		telemetry.Stats.Retries.Add(int64(len(docs)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retry.Backoff(attempt)):
+		}
+	}
+}
+
+// encode renders docs as gzip-compressed NDJSON or as a Parquet file,
+// returning the body, its content type and the object's extension.
+func (s *objectStoreSink) encode(docs []Document) ([]byte, string, string, error) {
+	var buf bytes.Buffer
+	if s.format == "parquet" {
+		rows := make([]map[string]any, 0, len(docs))
+		for _, doc := range docs {
+			row, err := flattenDocument(doc.Body)
+			if err != nil {
+				return nil, "", "", err
+			}
+			rows = append(rows, row)
+		}
+		w, err := newParquetWriter(&buf, parquetSchema(datasetColumns(rows), rows), true)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if err := w.writeRowGroup(rows); err != nil {
+			return nil, "", "", err
+		}
+		if err := w.close(); err != nil {
+			return nil, "", "", err
+		}
+		return buf.Bytes(), "application/vnd.apache.parquet", "parquet", nil
+	}
+
+	gz := gzip.NewWriter(&buf)
+	for _, doc := range docs {
+		gz.Write(doc.Body)
+		gz.Write([]byte{'\n'})
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", "", err
+	}
+	return buf.Bytes(), "application/gzip", "ndjson.gz", nil
+}
+
+// objectURL returns the URL of key, or of the bucket if key is empty.
+func (s *objectStoreSink) objectURL(key string) (*url.URL, error) {
+	path := "/" + key
+	if s.pathStyle {
+		path = "/" + s.bucket + path
+	}
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path += path
+	u.RawPath = awsEscapePath(u.Path)
+	return u, nil
+}
+
+func (s *objectStoreSink) put(ctx context.Context, key string, body []byte, contentType string) error {
+	req, err := s.request(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return s.do(req, body)
+}
+
+func (s *objectStoreSink) request(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	return http.NewRequestWithContext(ctx, method, u.String(), r)
+}
+
+// do authorizes and performs req.
+func (s *objectStoreSink) do(req *http.Request, body []byte) error {
+	if s.signer != nil {
+		payloadHash := sha256.Sum256(body)
+		req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+		s.signer.sign(req, body, time.Now())
+	} else {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return &SendError{Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		reason, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		// S3 throttles with 503 SlowDown, retried like any 503
+		return &SendError{Status: res.StatusCode, Reason: string(bytes.TrimSpace(reason))}
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+// Ping checks that the bucket exists and the credentials can reach it.
+func (s *objectStoreSink) Ping(ctx context.Context) error {
+	req, err := s.request(ctx, http.MethodHead, "", nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, nil)
+}
@@ -0,0 +1,385 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+)
+
+// A minimal Parquet writer: flat schemas of optional columns, one PLAIN
+// encoded data page per column and row group, uncompressed or gzip. The
+// footer is written in the Thrift compact protocol.
+
+// Parquet physical types, converted types and other enum values used.
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+
+	parquetOptional = 1
+	parquetPlain    = 0
+	parquetRLE      = 3
+	parquetDataPage = 0
+
+	parquetUncompressed = 0
+	parquetGzip         = 2
+)
+
+var parquetMagic = []byte("PAR1")
+
+// parquetColumn is a column's name and physical type. Timestamp columns
+// are INT64 microseconds.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	timestamp bool
+}
+
+// parquetColumnFor picks the column type for a field from a sample value.
+func parquetColumnFor(name string, sample any) parquetColumn {
+	switch sample.(type) {
+	case json.Number:
+		return parquetColumn{name: name, typ: parquetDouble}
+	case bool:
+		return parquetColumn{name: name, typ: parquetBoolean}
+	}
+	if name == "@timestamp" {
+		return parquetColumn{name: name, typ: parquetInt64, timestamp: true}
+	}
+	return parquetColumn{name: name, typ: parquetByteArray}
+}
+
+// parquetSchema types the named columns from the first non-null value
+// each has in rows.
+func parquetSchema(names []string, rows []map[string]any) []parquetColumn {
+	columns := make([]parquetColumn, len(names))
+	for i, name := range names {
+		var sample any
+		for _, row := range rows {
+			if v, ok := row[name]; ok && v != nil {
+				sample = v
+				break
+			}
+		}
+		columns[i] = parquetColumnFor(name, sample)
+	}
+	return columns
+}
+
+// parquetWriter writes row groups to w as they come and the footer on
+// close.
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	columns   []parquetColumn
+	gzip      bool
+	rowGroups []parquetRowGroup
+	numRows   int64
+}
+
+type parquetRowGroup struct {
+	numRows  int64
+	byteSize int64
+	chunks   []parquetChunk
+}
+
+type parquetChunk struct {
+	offset           int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn, gzip bool) (*parquetWriter, error) {
+	if _, err := w.Write(parquetMagic); err != nil {
+		return nil, err
+	}
+	return &parquetWriter{w: w, offset: int64(len(parquetMagic)), columns: columns, gzip: gzip}, nil
+}
+
+// writeRowGroup writes rows as one row group. Values whose type doesn't
+// match their column are written as nulls.
+func (p *parquetWriter) writeRowGroup(rows []map[string]any) error {
+	group := parquetRowGroup{numRows: int64(len(rows))}
+	for _, col := range p.columns {
+		page, err := p.page(col, rows)
+		if err != nil {
+			return err
+		}
+		if _, err := p.w.Write(page.data); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, parquetChunk{
+			offset:           p.offset,
+			numValues:        int64(len(rows)),
+			uncompressedSize: page.uncompressedSize,
+			compressedSize:   int64(len(page.data)),
+		})
+		group.byteSize += page.uncompressedSize
+		p.offset += int64(len(page.data))
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.numRows += group.numRows
+	return nil
+}
+
+type parquetPage struct {
+	data             []byte // Header and body
+	uncompressedSize int64  // Header and uncompressed body
+}
+
+// page encodes one column of rows as a data page with its header.
+func (p *parquetWriter) page(col parquetColumn, rows []map[string]any) (parquetPage, error) {
+	levels := make([]bool, len(rows))
+	var values bytes.Buffer
+	var bits []bool
+	for i, row := range rows {
+		switch v := row[col.name].(type) {
+		case json.Number:
+			if col.typ != parquetDouble {
+				continue
+			}
+			f, err := v.Float64()
+			if err != nil {
+				continue
+			}
+			binary.Write(&values, binary.LittleEndian, math.Float64bits(f))
+		case bool:
+			if col.typ != parquetBoolean {
+				continue
+			}
+			bits = append(bits, v)
+		case string:
+			switch {
+			case col.timestamp:
+				t, err := time.Parse(time.RFC3339Nano, v)
+				if err != nil {
+					continue
+				}
+				binary.Write(&values, binary.LittleEndian, t.UnixMicro())
+			case col.typ == parquetByteArray:
+				binary.Write(&values, binary.LittleEndian, uint32(len(v)))
+				values.WriteString(v)
+			default:
+				continue
+			}
+		default:
+			continue
+		}
+		levels[i] = true
+	}
+	if col.typ == parquetBoolean {
+		packed := make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			if b {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		values.Write(packed)
+	}
+
+	// Definition levels are RLE runs with a bit width of one, prefixed
+	// with their length
+	var rle []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		rle = binary.AppendUvarint(rle, uint64(j-i)<<1)
+		if levels[i] {
+			rle = append(rle, 1)
+		} else {
+			rle = append(rle, 0)
+		}
+		i = j
+	}
+	body := binary.LittleEndian.AppendUint32(nil, uint32(len(rle)))
+	body = append(body, rle...)
+	body = append(body, values.Bytes()...)
+
+	compressed := body
+	if p.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return parquetPage{}, err
+		}
+		if err := zw.Close(); err != nil {
+			return parquetPage{}, err
+		}
+		compressed = buf.Bytes()
+	}
+
+	var h thriftCompact
+	h.i32(1, parquetDataPage)
+	h.i32(2, int32(len(body)))
+	h.i32(3, int32(len(compressed)))
+	h.beginStruct(5)
+	h.i32(1, int32(len(rows)))
+	h.i32(2, parquetPlain)
+	h.i32(3, parquetRLE)
+	h.i32(4, parquetRLE)
+	h.endStruct()
+	h.stop()
+
+	return parquetPage{
+		data:             append(h.b, compressed...),
+		uncompressedSize: int64(len(h.b) + len(body)),
+	}, nil
+}
+
+// close writes the footer. The underlying writer is left open.
+func (p *parquetWriter) close() error {
+	codec := int32(parquetUncompressed)
+	if p.gzip {
+		codec = parquetGzip
+	}
+
+	var m thriftCompact
+	m.i32(1, 1)
+	m.list(2, thriftStruct, len(p.columns)+1)
+	m.beginElement()
+	m.str(4, "schema")
+	m.i32(5, int32(len(p.columns)))
+	m.endStruct()
+	for _, col := range p.columns {
+		m.beginElement()
+		m.i32(1, col.typ)
+		m.i32(3, parquetOptional)
+		m.str(4, col.name)
+		switch {
+		case col.timestamp:
+			m.i32(6, parquetTimestampMicros)
+		case col.typ == parquetByteArray:
+			m.i32(6, parquetUTF8)
+		}
+		m.endStruct()
+	}
+	m.i64(3, p.numRows)
+	m.list(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		m.beginElement()
+		m.list(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			col := p.columns[i]
+			m.beginElement()
+			m.i64(2, chunk.offset)
+			m.beginStruct(3)
+			m.i32(1, col.typ)
+			m.list(2, thriftI32, 2)
+			m.i32Element(parquetPlain)
+			m.i32Element(parquetRLE)
+			m.list(3, thriftBinary, 1)
+			m.strElement(col.name)
+			m.i32(4, codec)
+			m.i64(5, chunk.numValues)
+			m.i64(6, chunk.uncompressedSize)
+			m.i64(7, chunk.compressedSize)
+			m.i64(9, chunk.offset)
+			m.endStruct()
+			m.endStruct()
+		}
+		m.i64(2, group.byteSize)
+		m.i64(3, group.numRows)
+		m.endStruct()
+	}
+	m.str(6, "sample-metric-generator")
+	m.stop()
+
+	footer := binary.LittleEndian.AppendUint32(m.b, uint32(len(m.b)))
+	footer = append(footer, parquetMagic...)
+	_, err := p.w.Write(footer)
+	return err
+}
+
+// Thrift compact protocol type IDs.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftCompact encodes a struct in the Thrift compact protocol.
+type thriftCompact struct {
+	b     []byte
+	last  int16   // Last field ID written in the current struct
+	outer []int16 // Last field IDs of the enclosing structs
+}
+
+func (t *thriftCompact) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.b = append(t.b, byte(delta)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.b = binary.AppendVarint(t.b, int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftCompact) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.b = binary.AppendVarint(t.b, int64(v))
+}
+
+func (t *thriftCompact) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.b = binary.AppendVarint(t.b, v)
+}
+
+func (t *thriftCompact) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.strElement(s)
+}
+
+func (t *thriftCompact) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|elem)
+		return
+	}
+	t.b = append(t.b, 0xf0|elem)
+	t.b = binary.AppendUvarint(t.b, uint64(n))
+}
+
+func (t *thriftCompact) i32Element(v int32) {
+	t.b = binary.AppendVarint(t.b, int64(v))
+}
+
+func (t *thriftCompact) strElement(s string) {
+	t.b = binary.AppendUvarint(t.b, uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+// beginStruct starts a struct-valued field; beginElement starts a struct
+// in a list. Both are closed with endStruct.
+func (t *thriftCompact) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+}
+
+func (t *thriftCompact) beginElement() {
+	t.outer = append(t.outer, t.last)
+	t.last = 0
+}
+
+func (t *thriftCompact) endStruct() {
+	t.stop()
+	t.last = t.outer[len(t.outer)-1]
+	t.outer = t.outer[:len(t.outer)-1]
+}
+
+// stop ends the current struct.
+func (t *thriftCompact) stop() {
+	t.b = append(t.b, 0)
+}
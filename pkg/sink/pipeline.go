@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// samplePipeline enriches metrics the way a typical ingest setup would:
+// it stamps the ingest time, looks up the public address in the GeoIP
+// database, classifies the load and records failures instead of rejecting
+// the document. Every processor tolerates the fields other document types
+// lack.
+var samplePipeline = map[string]interface{}{
+	"description": "Sample enrichment for generated server metrics",
+	"processors": []interface{}{
+		map[string]interface{}{
+			"set": map[string]interface{}{
+				"field": "event_ingested",
+				"value": "{{{_ingest.timestamp}}}",
+			},
+		},
+		map[string]interface{}{
+			"geoip": map[string]interface{}{
+				"field":          "public_ip",
+				"target_field":   "public_geo",
+				"ignore_missing": true,
+			},
+		},
+		map[string]interface{}{
+			"script": map[string]interface{}{
+				"lang":   "painless",
+				"if":     "ctx.cpu_usage != null",
+				"source": "ctx.load_level = ctx.cpu_usage >= 85 ? 'critical' : ctx.cpu_usage >= 60 ? 'high' : ctx.cpu_usage >= 20 ? 'normal' : 'idle'",
+			},
+		},
+	},
+	"on_failure": []interface{}{
+		map[string]interface{}{
+			"set": map[string]interface{}{
+				"field": "pipeline_error",
+				"value": "{{ _ingest.on_failure_message }}",
+			},
+		},
+	},
+}
+
+// putSamplePipeline creates or replaces the ingest pipeline name with
+// samplePipeline.
+func putSamplePipeline(ctx context.Context, client *elasticsearch.Client, name string) error {
+	body, err := json.Marshal(samplePipeline)
+	if err != nil {
+		return err
+	}
+
+	req := esapi.IngestPutPipelineRequest{
+		PipelineID: name,
+		Body:       bytes.NewReader(body),
+	}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	return checkResponse(res)
+}
@@ -0,0 +1,83 @@
+package sink
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows rate events per second on average with bursts of up
+// to burst events.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = int(rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait blocks until n tokens are available or ctx is done. Requests
+// larger than the burst are served in burst-sized installments.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	for remaining := float64(n); remaining > 0; {
+		take := min(remaining, b.burst)
+
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		var delay time.Duration
+		if b.tokens >= take {
+			b.tokens -= take
+			remaining -= take
+		} else {
+			delay = time.Duration((take - b.tokens) / b.rate * float64(time.Second))
+		}
+		b.mu.Unlock()
+
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+	}
+	return nil
+}
+
+// rateLimitedSink throttles delivery to RATE_LIMIT documents per second.
+// Since full batches are sent on the producers' goroutines, waiting here
+// also slows generation down instead of queueing without bound.
+type rateLimitedSink struct {
+	Sink
+	bucket *tokenBucket
+}
+
+// withRateLimit wraps sink in a token bucket allowing rate documents per
+// second with bursts of up to burst documents. A rate of zero or less
+// returns sink unchanged.
+func withRateLimit(sink Sink, rate float64, burst int) Sink {
+	if rate <= 0 {
+		return sink
+	}
+	return &rateLimitedSink{Sink: sink, bucket: newTokenBucket(rate, burst)}
+}
+
+func (s *rateLimitedSink) Send(ctx context.Context, docs []Document) {
+	if err := s.bucket.wait(ctx, len(docs)); err != nil {
+		return
+	}
+	s.Sink.Send(ctx, docs)
+}
@@ -0,0 +1,117 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+)
+
+// expiredQuery matches documents whose expires_at lies in the past.
+const expiredQuery = `{"query":{"range":{"expires_at":{"lt":"now"}}}}`
+
+// ReapCommand deletes documents stamped with an expires_at in the past
+// from every index the generator writes to, so shared clusters stay clean
+// even when nobody remembers to clean up after a demo.
+func ReapCommand(config config.Config, args []string) error {
+	fs := flag.NewFlagSet("reap", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only count expired documents")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := newElasticsearchClient(config)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	indices := reapIndices(config)
+	index := strings.Join(indices, ",")
+
+	if *dryRun {
+		res, err := client.Count(
+			client.Count.WithContext(ctx),
+			client.Count.WithIndex(indices...),
+			client.Count.WithBody(strings.NewReader(expiredQuery)),
+		)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return checkResponse(res)
+		}
+		var result struct {
+			Count int64 `json:"count"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			return err
+		}
+		slog.Info("Counted expired documents", "count", result.Count, "index", index)
+		return nil
+	}
+
+	res, err := client.DeleteByQuery(
+		indices,
+		strings.NewReader(expiredQuery),
+		client.DeleteByQuery.WithContext(ctx),
+		client.DeleteByQuery.WithConflicts("proceed"),
+		client.DeleteByQuery.WithWaitForCompletion(true),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return checkResponse(res)
+	}
+
+	var result struct {
+		Deleted  int64             `json:"deleted"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+	if len(result.Failures) > 0 {
+		return fmt.Errorf("deleted %d documents with %d failures, first: %s", result.Deleted, len(result.Failures), result.Failures[0])
+	}
+
+	slog.Info("Deleted expired documents", "count", result.Deleted, "index", index)
+	return nil
+}
+
+// reapIndices returns a pattern for ES_INDEX and for the index of every
+// other document type, so expired documents of types that are switched
+// off now but were generated before are reaped too.
+func reapIndices(config config.Config) []string {
+	var indices []string
+	seen := make(map[string]bool)
+	for _, index := range []string{
+		config.ESIndex,
+		config.HeartbeatIndex,
+		config.ServerLogIndex,
+		config.SecurityIndex,
+		config.ChangeIndex,
+		config.ServiceMetricsIndex,
+		config.QueueIndex,
+		config.NetworkLatencyIndex,
+		config.KubernetesIndex,
+		config.DockerIndex,
+		config.GPUIndex,
+	} {
+		if index == "" {
+			continue
+		}
+		pattern := indexBase(index) + "*"
+		if !seen[pattern] {
+			seen[pattern] = true
+			indices = append(indices, pattern)
+		}
+	}
+	return indices
+}
@@ -0,0 +1,293 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// redisStreamSink appends documents to Redis Streams with XADD, on a key
+// expanded from the document's fields. Each entry holds the document's
+// type, server and JSON body. The commands of a batch are pipelined over a
+// single connection, and streams are trimmed to about REDIS_STREAM_MAXLEN
+// entries as they grow.
+type redisStreamSink struct {
+	addr     string
+	tls      bool
+	username string
+	password string
+	db       int
+	key      string
+	maxLen   int
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// redisError is an error reply.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+func newRedisStreamSink(config config.Config) (*redisStreamSink, error) {
+	raw := config.RedisURL
+	if raw == "" {
+		raw = "redis://localhost:6379"
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	s := &redisStreamSink{
+		addr:   u.Host,
+		key:    config.RedisStreamKey,
+		maxLen: config.RedisStreamMaxLen,
+	}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		s.tls = true
+	default:
+		return nil, fmt.Errorf("invalid Redis URL scheme %q (want redis or rediss)", u.Scheme)
+	}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	if s.maxLen < 0 {
+		return nil, fmt.Errorf("invalid REDIS_STREAM_MAXLEN %d", s.maxLen)
+	}
+	if s.key == "" {
+		s.key = "metricgen:{type}"
+	}
+	return s, nil
+}
+
+// command returns the XADD command for doc.
+func (s *redisStreamSink) command(doc Document) ([]string, error) {
+	key, err := expandFieldTemplate(s.key, doc, strings.NewReplacer(" ", "_"))
+	if err != nil {
+		return nil, err
+	}
+	cmd := []string{"XADD", key}
+	if s.maxLen > 0 {
+		// Approximate trimming lets Redis drop whole nodes, which is far
+		// cheaper than trimming to the exact length on every XADD
+		cmd = append(cmd, "MAXLEN", "~", strconv.Itoa(s.maxLen))
+	}
+	return append(cmd, "*",
+		"type", doc.Type,
+		"server_id", doc.ServerID,
+		"hostname", doc.Hostname,
+		"data", string(doc.Body),
+	), nil
+}
+
+// Send appends docs, reconnecting once if the connection has gone away.
+func (s *redisStreamSink) Send(ctx context.Context, docs []Document) {
+	commands := make([][]string, 0, len(docs))
+	for _, doc := range docs {
+		cmd, err := s.command(doc)
+		if err != nil {
+			slog.Error("Error expanding Redis stream key", "type", doc.Type, "server_id", doc.ServerID, "error", err)
+			recordFailed(ctx, 1)
+			continue
+		}
+		commands = append(commands, cmd)
+	}
+	if len(commands) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := time.Now()
+	var added int
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err = s.connect(ctx); err != nil {
+				break
+			}
+		}
+		// Only a batch lost entirely to a broken connection is sent
+		// again, so no entry is added twice
+		added, err = s.pipeline(commands)
+		if _, rejected := err.(redisError); err == nil || rejected {
+			break
+		}
+		s.drop()
+		if added > 0 {
+			break
+		}
+	}
+	telemetry.Stats.BatchDuration.Observe(time.Since(start))
+	if err != nil {
+		slog.Error("Error adding to Redis stream", "addr", s.addr, "documents", len(commands)-added, "error", err)
+		recordFailed(ctx, int64(len(commands)-added))
+	}
+	recordIndexed(ctx, int64(added))
+}
+
+// connect dials Redis, authenticates and selects the database. The caller
+// must hold s.mu.
+func (s *redisStreamSink) connect(ctx context.Context) error {
+	d := net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if s.tls {
+		host, _, _ := net.SplitHostPort(s.addr)
+		conn, err = (&tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn, s.r, s.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+
+	var setup [][]string
+	switch {
+	case s.username != "" && s.password != "":
+		setup = append(setup, []string{"AUTH", s.username, s.password})
+	case s.password != "":
+		setup = append(setup, []string{"AUTH", s.password})
+	case s.username != "":
+		// redis://password@host puts the password in the user name
+		setup = append(setup, []string{"AUTH", s.username})
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	setup = append(setup, []string{"PING"})
+	if _, err := s.pipeline(setup); err != nil {
+		s.drop()
+		return err
+	}
+	return nil
+}
+
+// pipeline writes the commands and reads their replies, returning how many
+// succeeded and the first error. The caller must hold s.mu.
+func (s *redisStreamSink) pipeline(commands [][]string) (int, error) {
+	s.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer s.conn.SetDeadline(time.Time{})
+
+	for _, cmd := range commands {
+		fmt.Fprintf(s.w, "*%d\r\n", len(cmd))
+		for _, arg := range cmd {
+			fmt.Fprintf(s.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := s.w.Flush(); err != nil {
+		return 0, err
+	}
+
+	ok := 0
+	var first error
+	for range commands {
+		if err := s.readReply(); err != nil {
+			if _, rejected := err.(redisError); !rejected {
+				return ok, err
+			}
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		ok++
+	}
+	return ok, first
+}
+
+// readReply reads and discards one reply, returning error replies as
+// redisError.
+func (s *redisStreamSink) readReply() error {
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("malformed reply %q", line)
+		}
+		if n >= 0 {
+			_, err = io.CopyN(io.Discard, s.r, int64(n)+2)
+		}
+		return err
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("malformed reply %q", line)
+		}
+		for i := 0; i < n; i++ {
+			if err := s.readReply(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected reply %q", line)
+}
+
+// drop closes the connection after an error. The caller must hold s.mu.
+func (s *redisStreamSink) drop() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn, s.r, s.w = nil, nil, nil
+}
+
+// Ping connects to Redis, if not connected yet, to check the address and
+// credentials.
+func (s *redisStreamSink) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		return nil
+	}
+	return s.connect(ctx)
+}
+
+func (s *redisStreamSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.r, s.w = nil, nil, nil
+	return err
+}
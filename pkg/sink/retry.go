@@ -0,0 +1,62 @@
+package sink
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy describes how failed indexing requests are retried: up to
+// MaxRetries times, with exponential backoff capped at MaxBackoff and full
+// jitter so that thousands of failing goroutines don't retry in lockstep.
+type RetryPolicy struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Backoff returns the delay before retry number attempt (starting at 0).
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	ceiling := p.InitialBackoff << attempt
+	if ceiling <= 0 || ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// SendError is a failed indexing attempt. Status is 0 for transport
+// errors, where no response was received.
+type SendError struct {
+	Status int
+	Reason string
+	Err    error
+}
+
+func (e *SendError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return http.StatusText(e.Status) + ": " + e.Reason
+}
+
+func (e *SendError) Unwrap() error { return e.Err }
+
+// IsRetryable reports whether a failed attempt may succeed if repeated.
+// Network errors, throttling and unavailable nodes are transient; anything
+// else (mapping conflicts, bad requests, auth failures) is permanent.
+func IsRetryable(err error) bool {
+	var se *SendError
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.Status {
+	case 0, http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/fleet"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// runMetadata is the document describing one run in the run metadata
+// index. It is written when the run starts and overwritten when it ends,
+// so anyone inspecting the cluster later can trace how the data was made.
+type runMetadata struct {
+	RunID       string            `json:"run_id"`
+	Version     string            `json:"version"`
+	Host        string            `json:"host"`
+	Status      string            `json:"status"`
+	Index       string            `json:"index"`
+	Seed        int64             `json:"seed"`
+	ServerCount int               `json:"server_count"`
+	ConfigHash  string            `json:"config_hash"`
+	Config      map[string]string `json:"config"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  *time.Time        `json:"finished_at,omitempty"`
+	DataFrom    time.Time         `json:"data_from"`
+	DataTo      *time.Time        `json:"data_to,omitempty"`
+	Documents   runDocCounts      `json:"documents"`
+}
+
+type runDocCounts struct {
+	Generated int64 `json:"generated"`
+	Indexed   int64 `json:"indexed"`
+	Failed    int64 `json:"failed"`
+}
+
+// RunRecorder writes a run's metadata document to RUN_METADATA_INDEX.
+type RunRecorder struct {
+	client *elasticsearch.Client
+	index  string
+	meta   runMetadata
+}
+
+func NewRunRecorder(client *elasticsearch.Client, index, version string, config config.Config, servers []fleet.ServerConfig, dataFrom time.Time) (*RunRecorder, error) {
+	// The manifest's view of the configuration leaves out credentials
+	settings := fleet.NewManifest(config, servers).Config
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+
+	return &RunRecorder{
+		client: client,
+		index:  index,
+		meta: runMetadata{
+			RunID:       hex.EncodeToString(id[:]),
+			Version:     version,
+			Host:        host,
+			Status:      "running",
+			Index:       config.ESIndex,
+			Seed:        config.Seed,
+			ServerCount: len(servers),
+			ConfigHash:  hex.EncodeToString(sum[:]),
+			Config:      settings,
+			StartedAt:   time.Now().UTC(),
+			DataFrom:    dataFrom.UTC(),
+		},
+	}, nil
+}
+
+// Finish records the outcome of the run.
+func (r *RunRecorder) Finish(ctx context.Context, status string, dataTo time.Time, generated int) error {
+	finished := time.Now().UTC()
+	dataTo = dataTo.UTC()
+	r.meta.Status = status
+	r.meta.FinishedAt = &finished
+	r.meta.DataTo = &dataTo
+	r.meta.Documents = runDocCounts{
+		Generated: int64(generated),
+		Indexed:   telemetry.Stats.Indexed.Load(),
+		Failed:    telemetry.Stats.Failed.Load(),
+	}
+	return r.Write(ctx)
+}
+
+// Write indexes the metadata under the run ID, replacing earlier versions.
+func (r *RunRecorder) Write(ctx context.Context) error {
+	body, err := json.Marshal(r.meta)
+	if err != nil {
+		return err
+	}
+	req := esapi.IndexRequest{
+		Index:      r.index,
+		DocumentID: r.meta.RunID,
+		Body:       bytes.NewReader(body),
+		Refresh:    "true",
+	}
+	res, err := req.Do(ctx, r.client)
+	if err != nil {
+		return err
+	}
+	return checkResponse(res)
+}
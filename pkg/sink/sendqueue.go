@@ -0,0 +1,139 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// Overflow policies of the send queue.
+const (
+	overflowBlock      = "block"
+	overflowDropOldest = "drop-oldest"
+	overflowDropNewest = "drop-newest"
+)
+
+// parseQueueOverflow validates SEND_QUEUE_OVERFLOW, defaulting to block.
+func parseQueueOverflow(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return overflowBlock, nil
+	case overflowBlock, overflowDropOldest, overflowDropNewest:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid SEND_QUEUE_OVERFLOW %q (want block, drop-oldest or drop-newest)", s)
+}
+
+// queuedSink hands batches to the sink it wraps from a goroutine of its
+// own, buffering up to size documents while the sink is slow or backing
+// off, so generation carries on through short stalls. What happens once
+// the queue is full depends on the overflow policy: block waits for room,
+// slowing generation down, while drop-oldest and drop-newest discard
+// batches and count them as dropped.
+type queuedSink struct {
+	Sink
+	name     string
+	size     int
+	overflow string
+
+	mu       sync.Mutex
+	changed  *sync.Cond // signalled whenever the queue or busy changes
+	batches  [][]Document
+	depth    int  // documents queued
+	busy     bool // a batch is being sent
+	dropping bool // documents were dropped to queue the last batch
+}
+
+// withSendQueue wraps sink in a queue of size documents drained by a
+// background goroutine. A size of zero or less returns sink unchanged.
+func withSendQueue(sink Sink, name string, size int, overflow string) Sink {
+	if size <= 0 {
+		return sink
+	}
+	q := &queuedSink{Sink: sink, name: name, size: size, overflow: overflow}
+	q.changed = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// Send queues docs for delivery. A batch larger than the whole queue is
+// accepted once the queue is empty.
+func (q *queuedSink) Send(ctx context.Context, docs []Document) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	full := func() bool { return q.depth > 0 && q.depth+len(docs) > q.size }
+	dropped := false
+	switch q.overflow {
+	case overflowBlock:
+		for full() {
+			q.changed.Wait()
+		}
+	case overflowDropNewest:
+		if full() {
+			q.drop(len(docs))
+			return
+		}
+	case overflowDropOldest:
+		// The batch being sent can't be taken back
+		for full() && len(q.batches) > 0 {
+			dropped = true
+			q.drop(len(q.batches[0]))
+			q.depth -= len(q.batches[0])
+			telemetry.Stats.QueueDepth.Add(-int64(len(q.batches[0])))
+			q.batches = q.batches[1:]
+		}
+	}
+
+	q.dropping = q.dropping && dropped
+	q.batches = append(q.batches, docs)
+	q.depth += len(docs)
+	telemetry.Stats.QueueDepth.Add(int64(len(docs)))
+	q.changed.Broadcast()
+}
+
+// drop counts n documents discarded because the queue is full, warning
+// when the queue starts overflowing.
+func (q *queuedSink) drop(n int) {
+	telemetry.Stats.Dropped.Add(int64(n))
+	if !q.dropping {
+		q.dropping = true
+		slog.Warn("Send queue full, dropping documents", "sink", q.name, "policy", q.overflow, "size", q.size)
+	}
+}
+
+// run sends queued batches one at a time, oldest first.
+func (q *queuedSink) run() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		for len(q.batches) == 0 {
+			q.changed.Wait()
+		}
+		batch := q.batches[0]
+		q.batches = q.batches[1:]
+		q.busy = true
+		q.mu.Unlock()
+
+		q.Sink.Send(context.Background(), batch)
+
+		q.mu.Lock()
+		q.busy = false
+		q.depth -= len(batch)
+		telemetry.Stats.QueueDepth.Add(-int64(len(batch)))
+		q.changed.Broadcast()
+	}
+}
+
+// drain waits until everything queued has been sent.
+func (q *queuedSink) drain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.batches) > 0 || q.busy {
+		q.changed.Wait()
+	}
+}
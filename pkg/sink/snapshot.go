@@ -0,0 +1,194 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+)
+
+// SnapshotCommand snapshots every index matching "<ES_INDEX>*" (the static
+// prefix of ES_INDEX when it is time-based) into the
+// repository named by ES_SNAPSHOT_REPO. The repository has to be
+// registered on the cluster beforehand.
+func SnapshotCommand(config config.Config, args []string) error {
+	if config.ESSnapshotRepo == "" {
+		return fmt.Errorf("ES_SNAPSHOT_REPO is not set")
+	}
+
+	name := fmt.Sprintf("%s-%s", indexBaseName(config.ESIndex), time.Now().UTC().Format("20060102-150405"))
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	client, err := newElasticsearchClient(config)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"indices":              indexBase(config.ESIndex) + "*",
+		"include_global_state": false,
+	})
+	if err != nil {
+		return err
+	}
+
+	waitForCompletion := true
+	req := esapi.SnapshotCreateRequest{
+		Repository:        config.ESSnapshotRepo,
+		Snapshot:          name,
+		Body:              bytes.NewReader(body),
+		WaitForCompletion: &waitForCompletion,
+	}
+	res, err := req.Do(context.Background(), client)
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+
+	slog.Info("Created snapshot", "repository", config.ESSnapshotRepo, "snapshot", name, "index", indexBase(config.ESIndex)+"*")
+	return nil
+}
+
+// ExportCommand scrolls through every document matching "<ES_INDEX>*"
+// and writes them to a file in bulk API format, so the dataset can be
+// loaded into another cluster with a plain _bulk request. Each action
+// names the index the document came from, or its data stream.
+func ExportCommand(config config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: export <file.ndjson>")
+	}
+
+	client, err := newElasticsearchClient(config)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	ctx := context.Background()
+	const keepAlive = time.Minute
+
+	res, err := client.Search(
+		client.Search.WithContext(ctx),
+		client.Search.WithIndex(indexBase(config.ESIndex)+"*"),
+		client.Search.WithSort("_doc"),
+		client.Search.WithSize(1000),
+		client.Search.WithScroll(keepAlive),
+	)
+	if err != nil {
+		return err
+	}
+
+	var exported int
+	for {
+		page, err := decodeScrollPage(res)
+		if err != nil {
+			return err
+		}
+		if len(page.Hits.Hits) == 0 {
+			if page.ScrollID != "" {
+				clearScroll(ctx, client, page.ScrollID)
+			}
+			break
+		}
+
+		for _, hit := range page.Hits.Hits {
+			action, err := json.Marshal(map[string]interface{}{
+				"create": map[string]string{"_index": exportIndex(hit.Index), "_id": hit.ID},
+			})
+			if err != nil {
+				return err
+			}
+			w.Write(action)
+			w.WriteByte('\n')
+			w.Write(hit.Source)
+			w.WriteByte('\n')
+		}
+		exported += len(page.Hits.Hits)
+
+		res, err = client.Scroll(
+			client.Scroll.WithContext(ctx),
+			client.Scroll.WithScrollID(page.ScrollID),
+			client.Scroll.WithScroll(keepAlive),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	slog.Info("Exported documents", "count", exported, "index", indexBase(config.ESIndex)+"*", "file", args[0])
+	return nil
+}
+
+type scrollPage struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []struct {
+			Index  string          `json:"_index"`
+			ID     string          `json:"_id"`
+			Source json.RawMessage `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// exportIndex returns the index to load a document exported from index
+// into: the data stream for a backing index named
+// ".ds-<data stream>-<yyyy.MM.dd>-<generation>", as backing indices don't
+// accept writes, and index itself otherwise.
+func exportIndex(index string) string {
+	stream, ok := strings.CutPrefix(index, ".ds-")
+	if !ok {
+		return index
+	}
+	for range 2 {
+		i := strings.LastIndex(stream, "-")
+		if i < 0 {
+			return index
+		}
+		stream = stream[:i]
+	}
+	return stream
+}
+
+func decodeScrollPage(res *esapi.Response) (scrollPage, error) {
+	var page scrollPage
+	if res.IsError() {
+		return page, checkResponse(res)
+	}
+	defer res.Body.Close()
+	err := json.NewDecoder(res.Body).Decode(&page)
+	return page, err
+}
+
+func clearScroll(ctx context.Context, client *elasticsearch.Client, scrollID string) {
+	res, err := client.ClearScroll(
+		client.ClearScroll.WithContext(ctx),
+		client.ClearScroll.WithScrollID(scrollID),
+	)
+	if err != nil {
+		slog.Warn("Error clearing scroll", "error", err)
+		return
+	}
+	res.Body.Close()
+}
@@ -0,0 +1,316 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+const (
+	// spillSegmentBytes is the size at which a new segment file is started.
+	spillSegmentBytes = 16 << 20
+	// spillReplayBatch is how many spilled documents are sent at a time.
+	spillReplayBatch = 500
+)
+
+// spillQueue keeps documents that can't be delivered while a sink is down
+// in NDJSON segment files, in the dead-letter format, until they can be
+// replayed. Segments survive restarts, so documents spilled by one run are
+// delivered by the next.
+type spillQueue struct {
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	f         *os.File // segment being written, if any
+	written   int64    // bytes in f
+	size      int64    // bytes in all segments
+	seq       int
+	full      bool // documents have been dropped since the queue filled up
+	replaying bool
+}
+
+// openSpillQueue opens the spill directory dir, creating it if needed and
+// picking up the segments left by earlier runs.
+func openSpillQueue(dir string, maxBytes int64) (*spillQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	q := &spillQueue{dir: dir, maxBytes: maxBytes}
+	segments, err := q.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range segments {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		q.size += info.Size()
+		var seq int
+		fmt.Sscanf(filepath.Base(path), "segment-%d.ndjson", &seq)
+		q.seq = max(q.seq, seq)
+	}
+	telemetry.Stats.SpillBytes.Add(q.size)
+	if len(segments) > 0 {
+		slog.Info("Found spilled documents", "dir", dir, "segments", len(segments), "bytes", q.size)
+	}
+	return q, nil
+}
+
+// segments returns the segment files, oldest first.
+func (q *spillQueue) segments() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(q.dir, "segment-*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		var a, b int
+		fmt.Sscanf(filepath.Base(paths[i]), "segment-%d.ndjson", &a)
+		fmt.Sscanf(filepath.Base(paths[j]), "segment-%d.ndjson", &b)
+		return a < b
+	})
+	return paths, nil
+}
+
+// pending reports whether there are spilled documents to replay.
+func (q *spillQueue) pending() bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size > 0
+}
+
+// write appends docs to the current segment. Documents that would take
+// the queue past its size limit are dropped.
+func (q *spillQueue) write(ctx context.Context, docs []Document) {
+	now := time.Now().UTC()
+	var buf strings.Builder
+	for _, doc := range docs {
+		line, err := json.Marshal(deadLetter{
+			FailedAt:  now,
+			Reason:    "sink unavailable",
+			Type:      doc.Type,
+			ServerID:  doc.ServerID,
+			Timestamp: doc.Timestamp,
+			Index:     doc.Index,
+			ID:        doc.ID,
+			Document:  doc.Body,
+		})
+		if err != nil {
+			slog.Error("Error encoding spilled document", "server_id", doc.ServerID, "error", err)
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.size+int64(buf.Len()) > q.maxBytes {
+		telemetry.Stats.Dropped.Add(int64(len(docs)))
+		if !q.full {
+			q.full = true
+			slog.Warn("Spill directory full, dropping documents", "dir", q.dir, "bytes", q.size)
+		}
+		return
+	}
+	q.full = false
+
+	if q.f == nil || q.written >= spillSegmentBytes {
+		if err := q.rotate(); err != nil {
+			slog.Error("Error creating spill segment", "dir", q.dir, "error", err)
+			recordFailed(ctx, int64(len(docs)))
+			return
+		}
+	}
+	n, err := q.f.WriteString(buf.String())
+	q.written += int64(n)
+	q.size += int64(n)
+	telemetry.Stats.SpillBytes.Add(int64(n))
+	if err != nil {
+		slog.Error("Error writing spill segment", "file", q.f.Name(), "error", err)
+		recordFailed(ctx, int64(len(docs)))
+		return
+	}
+	telemetry.Stats.Spilled.Add(int64(len(docs)))
+}
+
+// rotate closes the current segment and starts the next one.
+func (q *spillQueue) rotate() error {
+	q.seal()
+	q.seq++
+	f, err := os.OpenFile(filepath.Join(q.dir, fmt.Sprintf("segment-%06d.ndjson", q.seq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	q.f, q.written = f, 0
+	return nil
+}
+
+// seal closes the current segment, if any, so it can be replayed.
+func (q *spillQueue) seal() {
+	if q.f == nil {
+		return
+	}
+	if err := q.f.Close(); err != nil {
+		slog.Error("Error closing spill segment", "file", q.f.Name(), "error", err)
+	}
+	q.f = nil
+}
+
+// replay sends the spilled documents, oldest first, until they are all
+// delivered or send reports a batch failed outright. A segment is removed
+// once delivered; an interrupted one is rewritten with what is left of
+// it. Only one replay runs at a time.
+func (q *spillQueue) replay(send func([]Document) bool) {
+	q.mu.Lock()
+	if q.replaying {
+		q.mu.Unlock()
+		return
+	}
+	q.replaying = true
+	q.seal()
+	segments, err := q.segments()
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.replaying = false
+		q.mu.Unlock()
+	}()
+	if err != nil {
+		slog.Error("Error listing spill segments", "dir", q.dir, "error", err)
+		return
+	}
+
+	var replayed int
+	for _, path := range segments {
+		n, done, err := q.replaySegment(path, send)
+		replayed += n
+		if err != nil {
+			slog.Error("Error replaying spill segment", "file", path, "error", err)
+			return
+		}
+		if !done {
+			slog.Info("Replay of spilled documents interrupted", "dir", q.dir, "replayed", replayed)
+			return
+		}
+	}
+	if replayed > 0 {
+		slog.Info("Replayed spilled documents", "dir", q.dir, "replayed", replayed)
+	}
+}
+
+// replaySegment sends the documents of one segment, returning how many
+// were sent and whether all of them were.
+func (q *spillQueue) replaySegment(path string, send func([]Document) bool) (int, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	var batch []Document
+	var lines [][]byte
+	var sent int
+	flush := func() bool {
+		if len(batch) == 0 || send(batch) {
+			sent += len(batch)
+			batch, lines = nil, nil
+			return true
+		}
+		return false
+	}
+	for scanner.Scan() {
+		var entry deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return sent, false, fmt.Errorf("line %d: %w", sent+len(batch)+1, err)
+		}
+		batch = append(batch, Document{
+			Type:      entry.Type,
+			ServerID:  entry.ServerID,
+			Timestamp: entry.Timestamp,
+			Index:     rawIndexName(entry.Index),
+			ID:        entry.ID,
+			Body:      entry.Document,
+		})
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+		if len(batch) == spillReplayBatch && !flush() {
+			return sent, false, q.rewrite(path, info.Size(), lines, scanner)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return sent, false, err
+	}
+	if !flush() {
+		return sent, false, q.rewrite(path, info.Size(), lines, scanner)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return sent, false, err
+	}
+	q.shrink(info.Size())
+	return sent, true, nil
+}
+
+// rewrite replaces the segment at path, of size bytes, with the unsent
+// lines and what remains to be scanned.
+func (q *spillQueue) rewrite(path string, size int64, lines [][]byte, rest *bufio.Scanner) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	for rest.Scan() {
+		w.Write(rest.Bytes())
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	q.shrink(size - info.Size())
+	return nil
+}
+
+// shrink accounts for n bytes of segments replayed.
+func (q *spillQueue) shrink(n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.size -= n
+	telemetry.Stats.SpillBytes.Add(-n)
+}
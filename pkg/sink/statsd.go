@@ -0,0 +1,140 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// statsdSink emits every numeric field of a document as a StatsD gauge,
+// tagged with the server in DogStatsD or Telegraf (InfluxDB) style, so
+// the generator can feed Datadog agents, Telegraf or statsd_exporter.
+type statsdSink struct {
+	out       lineWriter
+	prefix    string
+	tagFormat string
+	tags      []string // static "key:value" tags
+}
+
+func newStatsDSink(config config.Config) (*statsdSink, error) {
+	if config.StatsDAddr == "" {
+		return nil, fmt.Errorf("STATSD_ADDR is required")
+	}
+	network := config.StatsDProtocol
+	if network == "" {
+		network = "udp"
+	}
+	if network != "tcp" && network != "udp" {
+		return nil, fmt.Errorf("invalid StatsD protocol %q (want udp or tcp)", network)
+	}
+
+	s := &statsdSink{
+		out:       lineWriter{network: network, addr: config.StatsDAddr},
+		prefix:    config.StatsDPrefix,
+		tagFormat: config.StatsDTagFormat,
+	}
+	if s.prefix == "" {
+		s.prefix = "server"
+	}
+	switch s.tagFormat {
+	case "":
+		s.tagFormat = "dogstatsd"
+	case "dogstatsd", "influx", "none":
+	default:
+		return nil, fmt.Errorf("invalid StatsD tag format %q (want dogstatsd, influx or none)", s.tagFormat)
+	}
+	for _, tag := range strings.Split(config.StatsDTags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			if !strings.Contains(tag, ":") {
+				return nil, fmt.Errorf("invalid StatsD tag %q (want key:value)", tag)
+			}
+			s.tags = append(s.tags, tag)
+		}
+	}
+	return s, nil
+}
+
+// docTags returns the static tags followed by the server's own.
+func (s *statsdSink) docTags(doc Document) []string {
+	tags := append([]string{}, s.tags...)
+	return append(tags, "host:"+doc.Hostname, "server_id:"+doc.ServerID, "role:"+doc.Role)
+}
+
+// lines renders doc as one gauge per numeric field. Gauges with a leading
+// sign are relative in StatsD, so negative values are set by first
+// resetting the gauge to zero.
+func (s *statsdSink) lines(buf []byte, doc Document) ([]byte, error) {
+	fields, err := doc.numericFields()
+	if err != nil {
+		return buf, err
+	}
+	tags := s.docTags(doc)
+
+	for _, f := range fields {
+		name := s.prefix + "." + f.Name
+		if f.Value < 0 {
+			buf = s.gauge(buf, name, 0, tags)
+		}
+		buf = s.gauge(buf, name, f.Value, tags)
+	}
+	return buf, nil
+}
+
+func (s *statsdSink) gauge(buf []byte, name string, value float64, tags []string) []byte {
+	buf = append(buf, name...)
+	if s.tagFormat == "influx" {
+		for _, tag := range tags {
+			k, v, _ := strings.Cut(tag, ":")
+			buf = append(buf, ',')
+			buf = append(buf, k...)
+			buf = append(buf, '=')
+			buf = append(buf, v...)
+		}
+	}
+	buf = append(buf, ':')
+	buf = strconv.AppendFloat(buf, value, 'g', -1, 64)
+	buf = append(buf, "|g"...)
+	if s.tagFormat == "dogstatsd" {
+		buf = append(buf, "|#"...)
+		buf = append(buf, strings.Join(tags, ",")...)
+	}
+	return append(buf, '\n')
+}
+
+func (s *statsdSink) Send(ctx context.Context, docs []Document) {
+	var buf []byte
+	sent := 0
+	for _, doc := range docs {
+		var err error
+		if buf, err = s.lines(buf, doc); err != nil {
+			slog.Error("Error encoding StatsD gauges", "type", doc.Type, "server_id", doc.ServerID, "error", err)
+			recordFailed(ctx, 1)
+			continue
+		}
+		sent++
+	}
+
+	start := time.Now()
+	err := s.out.write(buf)
+	telemetry.Stats.BatchDuration.Observe(time.Since(start))
+	if err != nil {
+		slog.Error("Error writing to StatsD", "addr", s.out.addr, "documents", sent, "error", err)
+		recordFailed(ctx, int64(sent))
+		return
+	}
+	recordIndexed(ctx, int64(sent))
+}
+
+func (s *statsdSink) Ping(ctx context.Context) error {
+	return s.out.ping(ctx)
+}
+
+func (s *statsdSink) Close() error {
+	return s.out.close()
+}
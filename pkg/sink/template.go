@@ -0,0 +1,195 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// metricMappings describes every field of MetricData explicitly so that
+// dynamic mapping never guesses: coordinates become a geo_point and the
+// usage metrics are doubles.
+var metricMappings = map[string]interface{}{
+	"dynamic": true,
+	"properties": map[string]interface{}{
+		"@timestamp":   map[string]string{"type": "date"},
+		"server_id":    map[string]string{"type": "keyword"},
+		"hostname":     map[string]string{"type": "keyword"},
+		"ip_address":   map[string]string{"type": "ip"},
+		"country":      map[string]string{"type": "keyword"},
+		"city":         map[string]string{"type": "keyword"},
+		"latitude":     map[string]string{"type": "double"},
+		"longitude":    map[string]string{"type": "double"},
+		"location":     map[string]string{"type": "geo_point"},
+		"cpu_usage":    map[string]string{"type": "double"},
+		"memory_usage": map[string]string{"type": "double"},
+		"disk_usage":   map[string]string{"type": "double"},
+
+		"agent_version":  map[string]string{"type": "keyword"},
+		"schema_version": map[string]string{"type": "integer"},
+		// Field names used by schema version 1 agents
+		"host":     map[string]string{"type": "keyword"},
+		"cpu_pct":  map[string]string{"type": "double"},
+		"mem_pct":  map[string]string{"type": "double"},
+		"disk_pct": map[string]string{"type": "double"},
+
+		// Added by the sample ingest pipeline
+		"event_ingested": map[string]string{"type": "date"},
+		"load_level":     map[string]string{"type": "keyword"},
+		"pipeline_error": map[string]string{"type": "keyword"},
+		"public_geo": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"location": map[string]string{"type": "geo_point"},
+			},
+		},
+
+		"fleet": map[string]string{"type": "keyword"},
+
+		"os_family":      map[string]string{"type": "keyword"},
+		"os_name":        map[string]string{"type": "keyword"},
+		"os_version":     map[string]string{"type": "keyword"},
+		"kernel_version": map[string]string{"type": "keyword"},
+		"architecture":   map[string]string{"type": "keyword"},
+
+		"cloud_provider":          map[string]string{"type": "keyword"},
+		"cloud_account_id":        map[string]string{"type": "keyword"},
+		"cloud_region":            map[string]string{"type": "keyword"},
+		"cloud_availability_zone": map[string]string{"type": "keyword"},
+		"cloud_instance_type":     map[string]string{"type": "keyword"},
+		"cloud_instance_id":       map[string]string{"type": "keyword"},
+
+		"clock_offset_ms": map[string]string{"type": "long"},
+
+		"instance_type":    map[string]string{"type": "keyword"},
+		"power_watts":      map[string]string{"type": "double"},
+		"carbon_intensity": map[string]string{"type": "double"},
+		"co2e_grams":       map[string]string{"type": "double"},
+
+		"inlet_temperature_celsius": map[string]string{"type": "double"},
+		"cpu_temperature_celsius":   map[string]string{"type": "double"},
+		"fan_speed_rpm":             map[string]string{"type": "double"},
+
+		"uptime_seconds": map[string]string{"type": "long"},
+
+		"jvm_heap_used_bytes":      map[string]string{"type": "long"},
+		"jvm_heap_committed_bytes": map[string]string{"type": "long"},
+		"jvm_heap_max_bytes":       map[string]string{"type": "long"},
+		"jvm_gc_count":             map[string]string{"type": "integer"},
+		"jvm_gc_pause_ms":          map[string]string{"type": "double"},
+		"jvm_gc_pause_max_ms":      map[string]string{"type": "double"},
+		"jvm_threads":              map[string]string{"type": "integer"},
+
+		"db_role":                    map[string]string{"type": "keyword"},
+		"db_connections":             map[string]string{"type": "integer"},
+		"db_queries_per_second":      map[string]string{"type": "double"},
+		"db_replication_lag_seconds": map[string]string{"type": "double"},
+		"db_slow_queries":            map[string]string{"type": "integer"},
+		"db_buffer_cache_hit_ratio":  map[string]string{"type": "double"},
+
+		"http_requests_per_second":  map[string]string{"type": "double"},
+		"http_responses_4xx":        map[string]string{"type": "long"},
+		"http_responses_5xx":        map[string]string{"type": "long"},
+		"http_response_time_p50_ms": map[string]string{"type": "double"},
+		"http_response_time_p95_ms": map[string]string{"type": "double"},
+		"http_response_time_p99_ms": map[string]string{"type": "double"},
+
+		"cache_hit_ratio":         map[string]string{"type": "double"},
+		"cache_evictions":         map[string]string{"type": "integer"},
+		"cache_keys":              map[string]string{"type": "long"},
+		"cache_connected_clients": map[string]string{"type": "integer"},
+
+		"expires_at": map[string]string{"type": "date"},
+		"public_ip":  map[string]string{"type": "ip"},
+
+		"ipv6_address": map[string]string{"type": "ip"},
+		"public_ipv6":  map[string]string{"type": "ip"},
+		"geoip_truth": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"country_iso_code": map[string]string{"type": "keyword"},
+				"country_name":     map[string]string{"type": "keyword"},
+				"city_name":        map[string]string{"type": "keyword"},
+				"location":         map[string]string{"type": "geo_point"},
+			},
+		},
+
+		"locale":        map[string]string{"type": "keyword"},
+		"country_local": map[string]string{"type": "keyword"},
+		"city_local":    map[string]string{"type": "keyword"},
+		"host_label":    map[string]string{"type": "keyword"},
+
+		// High-cardinality fields; labels are left to dynamic mapping so
+		// new label keys grow the mapping
+		"container_id": map[string]string{"type": "keyword"},
+		"pod_name":     map[string]string{"type": "keyword"},
+		"request_id":   map[string]string{"type": "keyword"},
+	},
+}
+
+// indexTemplateOptions controls the optional parts of the index template.
+type indexTemplateOptions struct {
+	// DataStream makes indices matching the template data streams.
+	DataStream bool
+	// ILMPolicy attaches a lifecycle policy to every matching index.
+	ILMPolicy string
+	// RolloverAlias is required by ILM rollover for alias-based indices.
+	RolloverAlias string
+}
+
+// putIndexTemplate creates or replaces a composable index template
+// matching "<index>*" with the metric mappings. index may be a time-based
+// pattern, in which case its static prefix is used.
+func putIndexTemplate(ctx context.Context, client *elasticsearch.Client, index string, opts indexTemplateOptions) error {
+	settings := map[string]interface{}{}
+	if opts.ILMPolicy != "" {
+		settings["index.lifecycle.name"] = opts.ILMPolicy
+	}
+	if opts.RolloverAlias != "" {
+		settings["index.lifecycle.rollover_alias"] = opts.RolloverAlias
+	}
+
+	body := map[string]interface{}{
+		"index_patterns": []string{indexBase(index) + "*"},
+		// Above the built-in logs-*-*/metrics-*-* templates (priority 100)
+		// so names following the data stream naming scheme still match ours.
+		"priority": 200,
+		"template": map[string]interface{}{
+			"settings": settings,
+			"mappings": metricMappings,
+		},
+	}
+	if opts.DataStream {
+		body["data_stream"] = map[string]interface{}{}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req := esapi.IndicesPutIndexTemplateRequest{
+		Name: indexBaseName(index),
+		Body: bytes.NewReader(payload),
+	}
+
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	return checkResponse(res)
+}
+
+// checkResponse closes the response body and turns an error status into an error.
+func checkResponse(res *esapi.Response) error {
+	defer res.Body.Close()
+	if !res.IsError() {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(res.Body)
+	return fmt.Errorf("%s: %s", res.Status(), bytes.TrimSpace(msg))
+}
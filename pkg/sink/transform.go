@@ -0,0 +1,124 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// fieldTransform is a single step of a per-sink transformation.
+type fieldTransform struct {
+	op     string
+	field  string
+	target string
+	factor float64
+	value  interface{}
+}
+
+// parseTransforms parses a semicolon-separated list of transformation
+// steps, applied in order to every top-level document field:
+//
+//	rename:<field>=<new name>
+//	drop:<field>
+//	scale:<field>=<factor>   multiply a numeric field, e.g. percent to ratio
+//	set:<field>=<value>      add a constant (JSON value or plain string)
+func parseTransforms(spec string) ([]fieldTransform, error) {
+	var steps []fieldTransform
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		op, arg, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid transform %q (want op:args)", entry)
+		}
+		field, value, hasValue := strings.Cut(arg, "=")
+		step := fieldTransform{op: op, field: field}
+
+		switch op {
+		case "drop":
+		case "rename":
+			step.target = value
+		case "scale":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid factor in transform %q", entry)
+			}
+			step.factor = f
+		case "set":
+			if err := json.Unmarshal([]byte(value), &step.value); err != nil {
+				step.value = value
+			}
+		default:
+			return nil, fmt.Errorf("unknown transform %q (want rename, drop, scale or set)", op)
+		}
+		if field == "" || (op != "drop" && (!hasValue || value == "")) {
+			return nil, fmt.Errorf("invalid transform %q", entry)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func applyTransforms(doc map[string]interface{}, steps []fieldTransform) {
+	for _, step := range steps {
+		v, ok := doc[step.field]
+		switch step.op {
+		case "drop":
+			delete(doc, step.field)
+		case "rename":
+			if ok {
+				delete(doc, step.field)
+				doc[step.target] = v
+			}
+		case "scale":
+			if f, isNum := v.(float64); isNum {
+				doc[step.field] = f * step.factor
+			}
+		case "set":
+			doc[step.field] = step.value
+		}
+	}
+}
+
+// transformingSink reshapes documents for the sink it wraps.
+type transformingSink struct {
+	Sink
+	steps []fieldTransform
+}
+
+// WithTransforms wraps sink so that documents are transformed by spec
+// before delivery. An empty spec returns sink unchanged.
+func WithTransforms(sink Sink, spec string) (Sink, error) {
+	steps, err := parseTransforms(spec)
+	if err != nil || len(steps) == 0 {
+		return sink, err
+	}
+	return &transformingSink{Sink: sink, steps: steps}, nil
+}
+
+func (t *transformingSink) Send(ctx context.Context, docs []Document) {
+	out := make([]Document, 0, len(docs))
+	for _, doc := range docs {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(doc.Body, &fields); err != nil {
+			slog.Error("Error transforming document", "type", doc.Type, "server_id", doc.ServerID, "error", err)
+			continue
+		}
+		applyTransforms(fields, t.steps)
+
+		body, err := json.Marshal(fields)
+		if err != nil {
+			slog.Error("Error transforming document", "type", doc.Type, "server_id", doc.ServerID, "error", err)
+			continue
+		}
+		doc.Body = body
+		out = append(out, doc)
+	}
+	t.Sink.Send(ctx, out)
+}
@@ -0,0 +1,225 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nandasatria/sample-metric-generator/pkg/config"
+	"github.com/nandasatria/sample-metric-generator/pkg/telemetry"
+)
+
+// webhookSink POSTs batches of documents to an arbitrary URL, as a JSON
+// array or as NDJSON, so custom collectors can receive the data without a
+// sink of their own. Each request holds documents of a single type, named
+// in the X-Document-Type header.
+type webhookSink struct {
+	client    *http.Client
+	url       string
+	format    string
+	batchSize int
+	gzip      bool
+	headers   map[string]string
+	username  string
+	password  string
+	token     string
+	retry     RetryPolicy
+}
+
+func newWebhookSink(config config.Config) (*webhookSink, error) {
+	if config.WebhookURL == "" {
+		return nil, fmt.Errorf("WEBHOOK_URL is required")
+	}
+	s := &webhookSink{
+		client:    &http.Client{Timeout: 30 * time.Second},
+		url:       config.WebhookURL,
+		format:    config.WebhookFormat,
+		batchSize: config.WebhookBatchSize,
+		gzip:      config.WebhookGzip,
+		headers:   make(map[string]string),
+		username:  config.WebhookUsername,
+		password:  config.WebhookPassword,
+		token:     config.WebhookToken,
+		retry:     RetryPolicy{MaxRetries: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second},
+	}
+	switch s.format {
+	case "":
+		s.format = "json"
+	case "json", "ndjson":
+	default:
+		return nil, fmt.Errorf("invalid webhook format %q (want json or ndjson)", s.format)
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = 500
+	}
+	for _, entry := range strings.Split(config.WebhookHeaders, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid webhook header %q (want name=value)", entry)
+		}
+		s.headers[name] = value
+	}
+	return s, nil
+}
+
+// Send posts docs in requests of at most batchSize documents of one type.
+func (s *webhookSink) Send(ctx context.Context, docs []Document) {
+	byType := make(map[string][]Document)
+	var order []string
+	for _, doc := range docs {
+		if _, ok := byType[doc.Type]; !ok {
+			order = append(order, doc.Type)
+		}
+		byType[doc.Type] = append(byType[doc.Type], doc)
+	}
+	for _, docType := range order {
+		docs := byType[docType]
+		for len(docs) > 0 {
+			n := min(len(docs), s.batchSize)
+			s.sendBatch(ctx, docType, docs[:n])
+			docs = docs[n:]
+		}
+	}
+}
+
+// sendBatch posts docs in one request, retrying transient failures.
+func (s *webhookSink) sendBatch(ctx context.Context, docType string, docs []Document) {
+	body, err := s.encode(docs)
+	if err != nil {
+		slog.Error("Error encoding webhook batch", "type", docType, "error", err)
+		recordFailed(ctx, int64(len(docs)))
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := s.post(ctx, docType, body)
+		telemetry.Stats.BatchDuration.Observe(time.Since(start))
+		if err == nil {
+			recordIndexed(ctx, int64(len(docs)))
+			return
+		}
+		if !IsRetryable(err) || attempt >= s.retry.MaxRetries {
+			slog.Error("Error posting to webhook", "type", docType, "documents", len(docs), "attempt", attempt+1, "error", err)
+			recordFailed(ctx, int64(len(docs)))
+			return
+		}
+
+		telemetry.Stats.Retries.Add(int64(len(docs)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retry.Backoff(attempt)):
+		}
+	}
+}
+
+// encode renders docs as a JSON array or as NDJSON, gzip-compressed if
+// configured.
+func (s *webhookSink) encode(docs []Document) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if s.gzip {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+
+	if s.format == "ndjson" {
+		for _, doc := range docs {
+			w.Write(doc.Body)
+			w.Write([]byte{'\n'})
+		}
+	} else {
+		w.Write([]byte{'['})
+		for i, doc := range docs {
+			if i > 0 {
+				w.Write([]byte{','})
+			}
+			w.Write(doc.Body)
+		}
+		w.Write([]byte("]\n"))
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *webhookSink) post(ctx context.Context, docType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if s.format == "ndjson" {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("X-Document-Type", docType)
+	s.authorize(req)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return &SendError{Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		reason, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return &SendError{Status: res.StatusCode, Reason: string(bytes.TrimSpace(reason))}
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+// authorize adds the configured headers and credentials. The credential
+// settings take precedence over an Authorization header in
+// WEBHOOK_HEADERS.
+func (s *webhookSink) authorize(req *http.Request) {
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	switch {
+	case s.token != "":
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	case s.username != "":
+		req.SetBasicAuth(s.username, s.password)
+	}
+}
+
+// Ping checks that the URL is reachable. Any response short of a server
+// error counts, since webhooks rarely answer anything but POST.
+func (s *webhookSink) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.url, nil)
+	if err != nil {
+		return err
+	}
+	s.authorize(req)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"text/tabwriter"
+	"time"
+)
+
+// Benchmark measures a run for the report printed with -benchmark.
+type Benchmark struct {
+	start time.Time
+	cpu   time.Duration
+}
+
+// StartBenchmark starts measuring from now.
+func StartBenchmark() *Benchmark {
+	return &Benchmark{start: time.Now(), cpu: processCPUTime()}
+}
+
+// WriteReport writes the throughput, sink latency, error rate and the
+// generator's own CPU and memory use since the benchmark started.
+func (b *Benchmark) WriteReport(w io.Writer) error {
+	elapsed := time.Since(b.start)
+	secs := elapsed.Seconds()
+	cpu := processCPUTime() - b.cpu
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	generated, indexed := Stats.Generated.Total(), Stats.Indexed.Load()
+	failed, retries := Stats.Failed.Load(), Stats.Retries.Load()
+	_, batches, _ := Stats.BatchDuration.snapshot()
+	errorRate := 0.0
+	if indexed+failed > 0 {
+		errorRate = float64(failed) / float64(indexed+failed)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "duration\t%s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(tw, "generated\t%d docs\t%.0f docs/sec\n", generated, float64(generated)/secs)
+	fmt.Fprintf(tw, "indexed\t%d docs\t%.0f docs/sec\n", indexed, float64(indexed)/secs)
+	fmt.Fprintf(tw, "failed\t%d docs\t%.2f%% error rate\n", failed, errorRate*100)
+	fmt.Fprintf(tw, "retries\t%d docs\n", retries)
+	fmt.Fprintf(tw, "sink requests\t%d\n", batches)
+	fmt.Fprintf(tw, "sink latency\tp50 %s\tp99 %s\n",
+		formatSeconds(Stats.BatchDuration.quantile(0.5)), formatSeconds(Stats.BatchDuration.quantile(0.99)))
+	fmt.Fprintf(tw, "tick duration\tp50 %s\tp99 %s\n",
+		formatSeconds(Stats.TickDuration.quantile(0.5)), formatSeconds(Stats.TickDuration.quantile(0.99)))
+	if cpu > 0 {
+		fmt.Fprintf(tw, "generator CPU\t%s\t%.2f cores\n", cpu.Round(time.Millisecond), cpu.Seconds()/secs)
+	}
+	if rss := peakRSS(); rss > 0 {
+		fmt.Fprintf(tw, "generator memory\t%.1f MiB peak RSS\t%.1f MiB heap\n", float64(rss)/(1<<20), float64(mem.HeapAlloc)/(1<<20))
+	} else {
+		fmt.Fprintf(tw, "generator memory\t%.1f MiB heap\n", float64(mem.HeapAlloc)/(1<<20))
+	}
+	return tw.Flush()
+}
+
+// formatSeconds renders a latency estimate, which is NaN without samples
+// and infinite above the histogram's largest bucket.
+func formatSeconds(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "-"
+	case math.IsInf(v, 1):
+		return "slowest bucket"
+	}
+	return time.Duration(v * float64(time.Second)).Round(10 * time.Microsecond).String()
+}
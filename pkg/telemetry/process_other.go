@@ -0,0 +1,11 @@
+//go:build !unix
+
+package telemetry
+
+import "time"
+
+// processCPUTime is unknown on this platform.
+func processCPUTime() time.Duration { return 0 }
+
+// peakRSS is unknown on this platform.
+func peakRSS() int64 { return 0 }
@@ -0,0 +1,221 @@
+// Package telemetry tracks the generator's own throughput and latency.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the generator's own telemetry. It is exposed in the
+// Prometheus text format on /metrics and summarized in the log.
+var Stats = newSelfMetrics()
+
+type selfMetrics struct {
+	Generated   counterVec
+	Indexed     atomic.Int64
+	Failed      atomic.Int64
+	Retries     atomic.Int64
+	DeadLetters atomic.Int64
+	Dropped     atomic.Int64
+	QueueDepth  atomic.Int64
+	Spilled     atomic.Int64
+	SpillBytes  atomic.Int64
+
+	BatchDuration *histogram
+	TickDuration  *histogram
+	LastTick      atomic.Int64
+}
+
+func newSelfMetrics() *selfMetrics {
+	return &selfMetrics{
+		BatchDuration: newHistogram(0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30),
+		TickDuration:  newHistogram(0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120),
+	}
+}
+
+// counterVec is a set of counters keyed by a single label value.
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func (c *counterVec) Add(label string, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = make(map[string]int64)
+	}
+	c.values[label] += n
+}
+
+// snapshot returns the counters sorted by label.
+func (c *counterVec) snapshot() ([]string, []int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	labels := make([]string, 0, len(c.values))
+	for label := range c.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	values := make([]int64, len(labels))
+	for i, label := range labels {
+		values[i] = c.values[label]
+	}
+	return labels, values
+}
+
+// Total returns the sum of all counters.
+func (c *counterVec) Total() int64 {
+	_, values := c.snapshot()
+	var sum int64
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+// histogram is a cumulative Prometheus-style histogram of seconds.
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]int64, len(bounds))}
+}
+
+func (h *histogram) Observe(d time.Duration) {
+	v := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) snapshot() (buckets []int64, count int64, sum float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]int64(nil), h.buckets...), h.count, h.sum
+}
+
+// quantile estimates the q-quantile from the bucket counts, interpolating
+// linearly within the bucket it falls in like Prometheus'
+// histogram_quantile.
+func (h *histogram) quantile(q float64) float64 {
+	buckets, count, _ := h.snapshot()
+	if count == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(count)
+	var lower, below float64
+	for i, c := range buckets {
+		if float64(c) >= rank && float64(c) > below {
+			return lower + (h.bounds[i]-lower)*(rank-below)/(float64(c)-below)
+		}
+		lower, below = h.bounds[i], float64(c)
+	}
+	return math.Inf(1)
+}
+
+// writePrometheus writes all metrics in the Prometheus text format.
+func (m *selfMetrics) writePrometheus(w io.Writer) {
+	labels, values := m.Generated.snapshot()
+	fmt.Fprintln(w, "# HELP metricgen_documents_generated_total Documents generated, by type.")
+	fmt.Fprintln(w, "# TYPE metricgen_documents_generated_total counter")
+	for i, label := range labels {
+		fmt.Fprintf(w, "metricgen_documents_generated_total{type=%q} %d\n", label, values[i])
+	}
+
+	writeCounter(w, "metricgen_documents_indexed_total", "Documents accepted by the sink.", m.Indexed.Load())
+	writeCounter(w, "metricgen_documents_failed_total", "Documents that failed permanently, ran out of retries or were rejected by an open circuit breaker.", m.Failed.Load())
+	writeCounter(w, "metricgen_retries_total", "Document delivery retries.", m.Retries.Load())
+	writeCounter(w, "metricgen_dead_letters_total", "Documents written to the dead-letter file.", m.DeadLetters.Load())
+	writeCounter(w, "metricgen_documents_dropped_total", "Documents dropped because a send queue or the spill directory was full.", m.Dropped.Load())
+
+	writeCounter(w, "metricgen_documents_spilled_total", "Documents spilled to disk while a sink was unavailable.", m.Spilled.Load())
+
+	fmt.Fprintln(w, "# HELP metricgen_spill_bytes Bytes of spilled documents waiting on disk.")
+	fmt.Fprintln(w, "# TYPE metricgen_spill_bytes gauge")
+	fmt.Fprintf(w, "metricgen_spill_bytes %d\n", m.SpillBytes.Load())
+
+	fmt.Fprintln(w, "# HELP metricgen_send_queue_depth Documents waiting in the send queues, including those being sent.")
+	fmt.Fprintln(w, "# TYPE metricgen_send_queue_depth gauge")
+	fmt.Fprintf(w, "metricgen_send_queue_depth %d\n", m.QueueDepth.Load())
+
+	writeHistogram(w, "metricgen_batch_duration_seconds", "Duration of sink requests.", m.BatchDuration)
+	writeHistogram(w, "metricgen_tick_duration_seconds", "Duration of a generation tick across the fleet.", m.TickDuration)
+
+	fmt.Fprintln(w, "# HELP metricgen_last_tick_timestamp_seconds Completion time of the last generation tick.")
+	fmt.Fprintln(w, "# TYPE metricgen_last_tick_timestamp_seconds gauge")
+	fmt.Fprintf(w, "metricgen_last_tick_timestamp_seconds %d\n", m.LastTick.Load())
+}
+
+func writeCounter(w io.Writer, name, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+func writeHistogram(w io.Writer, name, help string, h *histogram) {
+	buckets, count, sum := h.snapshot()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, sum, name, count)
+}
+
+func (m *selfMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writePrometheus(w)
+}
+
+// LogSummaries logs throughput since the previous summary every interval,
+// so it is obvious from the log alone whether the generator keeps up.
+func (m *selfMetrics) LogSummaries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prevGenerated, prevIndexed, prevFailed, prevRetries, prevDropped int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		generated, indexed := m.Generated.Total(), m.Indexed.Load()
+		failed, retries, dropped := m.Failed.Load(), m.Retries.Load(), m.Dropped.Load()
+		secs := interval.Seconds()
+
+		slog.Info("Stats",
+			"generated", generated-prevGenerated,
+			"generated_per_sec", math.Round(float64(generated-prevGenerated)/secs*10)/10,
+			"indexed", indexed-prevIndexed,
+			"indexed_per_sec", math.Round(float64(indexed-prevIndexed)/secs*10)/10,
+			"failed", failed-prevFailed,
+			"retries", retries-prevRetries,
+			"dropped", dropped-prevDropped,
+			"queue_depth", m.QueueDepth.Load(),
+			"batch_p50_seconds", m.BatchDuration.quantile(0.5),
+			"batch_p99_seconds", m.BatchDuration.quantile(0.99),
+			"tick_p99_seconds", m.TickDuration.quantile(0.99))
+
+		prevGenerated, prevIndexed, prevFailed, prevRetries, prevDropped = generated, indexed, failed, retries, dropped
+	}
+}